@@ -0,0 +1,312 @@
+// backend/cmd/easy-pgs/main.go
+// -----------------------------------------------------------------------------
+// Command-line front end to the scoring pipeline used by the server.
+//
+//   easy-pgs score [flags] <kit file>...
+//
+// Scores one or more raw 23andMe / AncestryDNA kits (or a directory holding a
+// multi-sample .pgen/.pvar/.psam set) against PGS Catalog scores, and writes
+// one row per sample and score: raw score, z-score and percentile against
+// the matching 1000G reference panel, and coverage.
+//
+// Usage examples (run from repo root, after reference setup):
+//   go run ./backend/cmd/easy-pgs score --pgs PGS000001,PGS000002 kit.txt
+//   go run ./backend/cmd/easy-pgs score --trait EFO_0001645 --format json a.txt b.txt
+//   go run ./backend/cmd/easy-pgs score --pfile cohort/ --kit-type ancestry --pgs PGS000001 --out scores.tsv
+// -----------------------------------------------------------------------------
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "math"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+
+    "github.com/adamwestgate/easy-pgs/backend/data"
+    "github.com/adamwestgate/easy-pgs/backend/pipeline"
+    "github.com/adamwestgate/easy-pgs/backend/preprocessing/kit_convert"
+)
+
+// Row is one sample's result for one score.
+type Row struct {
+    Source     string   `json:"source"` // kit file or pfile directory
+    Sample     string   `json:"sample"`
+    PGSID      string   `json:"pgsId"`
+    Trait      string   `json:"trait"`
+    Score      *float64 `json:"score"`
+    Z          *float64 `json:"z"`
+    Percentile *float64 `json:"percentile"`
+    Coverage   *float64 `json:"coverage"` // % of score variants with a call
+    Error      string   `json:"error,omitempty"`
+}
+
+// fileset is one scoring input: a directory holding a pfile and its chip type.
+type fileset struct {
+    source, dir, kitType string
+}
+
+func main() {
+    log.SetFlags(0)
+    if len(os.Args) < 2 {
+        usage()
+    }
+    switch os.Args[1] {
+    case "score":
+        if err := runScore(os.Args[2:]); err != nil {
+            log.Fatalf("easy-pgs score: %v", err)
+        }
+    case "-h", "--help", "help":
+        usage()
+    default:
+        log.Printf("unknown command %q", os.Args[1])
+        usage()
+    }
+}
+
+func usage() {
+    fmt.Fprintln(os.Stderr, "usage: easy-pgs score [flags] <kit file>...")
+    fmt.Fprintln(os.Stderr, "run 'easy-pgs score -h' for flags")
+    os.Exit(2)
+}
+
+func runScore(args []string) error {
+    fs := flag.NewFlagSet("score", flag.ExitOnError)
+    pgsFlag := fs.String("pgs", "", "Comma-separated PGS IDs")
+    traitFlag := fs.String("trait", "", "Comma-separated trait (ontology) IDs; scores every PGS attached to them")
+    descendants := fs.Bool("descendants", false, "With --trait, also include PGS of descendant traits")
+    pfile := fs.String("pfile", "", "Directory holding a (multi-sample) .pgen/.pvar/.psam set, instead of kit files")
+    kitType := fs.String("kit-type", "", "Chip of --pfile: ancestry | 23andme")
+    impute := fs.String("impute", "reference", "Missing genotypes: reference | none | ancestry:<AFR|AMR|EAS|EUR|SAS>")
+    format := fs.String("format", "tsv", "Output format: tsv | json")
+    out := fs.String("out", "", "Output file (default stdout)")
+    fs.Parse(args)
+
+    if *format != "tsv" && *format != "json" {
+        return fmt.Errorf("unknown format %q", *format)
+    }
+    imp, err := pipeline.ParseImputation(*impute)
+    if err != nil {
+        return err
+    }
+    if *pfile == "" && fs.NArg() == 0 {
+        return fmt.Errorf("no kit files or --pfile given")
+    }
+    if *pfile != "" && *kitType != "ancestry" && *kitType != "23andme" {
+        return fmt.Errorf("--pfile needs --kit-type ancestry or 23andme")
+    }
+
+    // Catalog metadata resolves FTP links, trait IDs and labels
+    if err := data.LoadMetadata(); err != nil {
+        return fmt.Errorf("could not load scores metadata: %w", err)
+    }
+    if *descendants {
+        if err := data.LoadOntology(); err != nil {
+            return fmt.Errorf("ontology hierarchy unavailable: %w", err)
+        }
+    }
+    pgsIDs, err := resolvePGS(*pgsFlag, *traitFlag, *descendants)
+    if err != nil {
+        return err
+    }
+
+    // Open the output before doing any work. Progress goes to the log and
+    // plink2's console output to stderr, so stdout carries results only.
+    var w io.Writer = os.Stdout
+    if *out != "" {
+        f, err := os.Create(*out)
+        if err != nil {
+            return err
+        }
+        defer f.Close()
+        w = f
+    }
+
+    ctx := context.Background()
+
+    // 1) score files
+    norms := map[string]string{}
+    for _, id := range pgsIDs {
+        norm, err := pipeline.EnsureScoreFile(ctx, id, nil)
+        if err != nil {
+            log.Printf("%s: %v", id, err)
+            continue
+        }
+        norms[id] = norm
+    }
+    if len(norms) == 0 {
+        return fmt.Errorf("no score files available")
+    }
+    normList := make([]string, 0, len(norms))
+    for _, p := range norms {
+        normList = append(normList, p)
+    }
+    sort.Strings(normList)
+
+    // 2) inputs
+    var sets []fileset
+    if *pfile != "" {
+        sets = append(sets, fileset{*pfile, *pfile, *kitType})
+    } else {
+        tmp, err := os.MkdirTemp("", "easy-pgs-")
+        if err != nil {
+            return err
+        }
+        defer os.RemoveAll(tmp)
+        for i, raw := range fs.Args() {
+            dir := filepath.Join(tmp, fmt.Sprintf("kit%d", i))
+            kt, err := kit_convert.ConvertFileToPgen(raw, dir)
+            if err != nil {
+                return fmt.Errorf("%s: %w", raw, err)
+            }
+            sets = append(sets, fileset{raw, dir, kt})
+        }
+    }
+
+    // 3) score each input and its reference panel
+    var rows []Row
+    for _, s := range sets {
+        log.Printf("scoring %s (%s) on %d scores", s.source, s.kitType, len(normList))
+        ws, err := pipeline.NewWorkspace("cli")
+        if err != nil {
+            return err
+        }
+        res := pipeline.ScoreKit(ctx, s.dir, s.kitType, normList, imp, ws, nil)
+        rows = append(rows, tidy(s, pgsIDs, norms, res)...)
+        ws.Remove()
+    }
+
+    if *format == "json" {
+        enc := json.NewEncoder(w)
+        enc.SetIndent("", "  ")
+        return enc.Encode(rows)
+    }
+    return writeTSV(w, rows)
+}
+
+// resolvePGS merges --pgs and the PGS of every --trait into a sorted, distinct list.
+func resolvePGS(pgsList, traitList string, descendants bool) ([]string, error) {
+    seen := map[string]bool{}
+    var ids []string
+    add := func(id string) {
+        id = pipeline.CanonicalID(strings.TrimSpace(id))
+        if id != "" && !seen[id] {
+            seen[id] = true
+            ids = append(ids, id)
+        }
+    }
+    for _, id := range strings.Split(pgsList, ",") {
+        add(id)
+    }
+    for _, tid := range strings.Split(traitList, ",") {
+        tid = strings.TrimSpace(tid)
+        if tid == "" {
+            continue
+        }
+        var pgs []string
+        if descendants {
+            pgs = data.RolledUpPGS(tid)
+        } else if t, ok := data.TraitByID(tid); ok {
+            pgs = t.PGSFiles
+        }
+        if len(pgs) == 0 {
+            return nil, fmt.Errorf("no scores for trait %s", tid)
+        }
+        for _, id := range pgs {
+            add(id)
+        }
+    }
+    if len(ids) == 0 {
+        return nil, fmt.Errorf("no PGS IDs given (use --pgs and/or --trait)")
+    }
+    sort.Strings(ids)
+    return ids, nil
+}
+
+// tidy turns one input's results into per-sample rows, in PGS order.
+func tidy(s fileset, pgsIDs []string, norms map[string]string, res *pipeline.Results) []Row {
+    var rows []Row
+    for _, id := range pgsIDs {
+        base := Row{Source: s.source, PGSID: id, Trait: pipeline.TraitLabel(id)}
+        norm, ok := norms[id]
+        if !ok {
+            base.Error = "score file unavailable"
+            rows = append(rows, base)
+            continue
+        }
+        br := res.User[id]
+        if br.Err != nil || br.ScorePath == "" {
+            base.Error = fmt.Sprint("scoring failed: ", br.Err)
+            rows = append(rows, base)
+            continue
+        }
+        samples, err := pipeline.ReadSampleScores(br.ScorePath)
+        if err != nil {
+            base.Error = err.Error()
+            rows = append(rows, base)
+            continue
+        }
+
+        var pop *float64
+        var sd float64
+        if pb := res.Pop[id]; pb.Err == nil && pb.ScorePath != "" {
+            if st, err := pipeline.ParseSscoreStats(pb.ScorePath); err == nil {
+                pop, sd = &st.Mean, st.SD
+            }
+        }
+        total := pipeline.CountDataLines(norm)
+
+        for _, smp := range samples {
+            r := base
+            r.Sample = smp.IID
+            r.Score = finite(smp.Avg)
+            if pop != nil && sd > 0 {
+                z := (smp.Avg - *pop) / sd
+                r.Z = finite(z)
+                r.Percentile = finite(pipeline.Percentile(z))
+            }
+            if total > 0 {
+                r.Coverage = finite(smp.AlleleCt / 2 / float64(total) * 100)
+            }
+            rows = append(rows, r)
+        }
+    }
+    return rows
+}
+
+// writeTSV writes rows with a header line; missing values are "NA".
+func writeTSV(w io.Writer, rows []Row) error {
+    if _, err := fmt.Fprintln(w, "source\tsample\tpgs_id\ttrait\tscore\tz\tpercentile\tcoverage\terror"); err != nil {
+        return err
+    }
+    num := func(v *float64) string {
+        if v == nil {
+            return "NA"
+        }
+        return strconv.FormatFloat(*v, 'g', 8, 64)
+    }
+    for _, r := range rows {
+        _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+            r.Source, r.Sample, r.PGSID, r.Trait,
+            num(r.Score), num(r.Z), num(r.Percentile), num(r.Coverage), r.Error)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// finite returns &v, or nil for NaN/Inf (which JSON cannot encode).
+func finite(v float64) *float64 {
+    if math.IsNaN(v) || math.IsInf(v, 0) {
+        return nil
+    }
+    return &v
+}
@@ -4,22 +4,22 @@ package config
 // These are currently hard-coded and can later be externalized into environment variables.
 const (
 
-    // HTTP server address
-    ServerHost = "localhost"
-    ServerPort = "8080"
-	
-    // DataDir is where kits.db and other local data live
-    DataDir = "backend/data"
+	// HTTP server address
+	ServerHost = "localhost"
+	ServerPort = "8080"
 
-    // FrontendOrigin is the allowed origin for CORS
-    FrontendOrigin = "http://localhost:3000"
+	// DataDir is where kits.db and other local data live
+	DataDir = "backend/data"
+
+	// FrontendOrigin is the allowed origin for CORS
+	FrontendOrigin = "http://localhost:3000"
 
 	// Upload handler directories
 	UploadRawDir       = "uploads/user_kits/raw"
 	UploadProcessedDir = "uploads/user_kits/processed"
 
 	// Download handler directory
-	PGSDownloadDir     = "backend/data/pgs_files"
+	PGSDownloadDir = "backend/data/pgs_files"
 
 	// Directories for population reference genomes
 	ReferenceAncestryDir = "backend/data/reference_genomes/1000G/ancestry"
@@ -27,28 +27,79 @@ const (
 
 	// Frequency files for reference genomes
 	ReferenceFreqAncestry = ReferenceAncestryDir + "/ancestry.afreq"
-	ReferenceFreq23andme  = Reference23andmeDir  + "/23andme.afreq"
+	ReferenceFreq23andme  = Reference23andmeDir + "/23andme.afreq"
 	ReferenceFreqFiltered = "backend/data/reference_genomes/1000G_filtered/1000G_chip_qc.afreq"
 
 	// Download dir for PGS files
-	PGSFilesDir          = "backend/data/pgs_files"
+	PGSFilesDir = "backend/data/pgs_files"
 
 	// DNA Kit manifest directories
 	ChipManifestAncestryDir = "backend/data/dna_chip_manifests/ancestry_v2"
 	ChipManifestV5Dir       = "backend/data/dna_chip_manifests/23andme_v5"
 
+	// Scratch space for multi-kit comparisons (one temp dir per request)
+	CompareWorkDir = "backend/data/compare"
+
+	// Per-run scratch space for scoring outputs (one directory per run);
+	// failed runs' directories are kept this long for inspection
+	WorkspaceDir            = "backend/data/workspaces"
+	WorkspaceRetentionHours = 24
+
+	// Cached reference-panel dosages per score (<dir>/<panel>/<PGS ID>.dosage),
+	// built on a score's first use so later population scoring skips the .pgen
+	DosageCacheDir = "backend/data/dosage_cache"
+
+	// Memory the dosage matrices loaded for population scoring may keep;
+	// the least recently used are dropped beyond it
+	DosageMatrixCacheBytes = 1 << 30
+
+	// Per-superpopulation allele frequencies of the reference panels
+	// (<dir>/<panel>/<SUPERPOP>.afreq), built when a run first imputes from them
+	FreqCacheDir = "backend/data/freq_cache"
+
+	// Posterior probability from which a kit's inferred superpopulation is
+	// used as its default comparison group
+	AncestryMinProbability = 0.8
+
+	// Principal components the ancestry-adjusted z-scores regress on
+	AncestryAdjustPCs = 4
+
+	// Score the reference panels from the cached dosage matrices, falling
+	// back to the engine selected below when a matrix cannot be built
+	CachedPopulationScoring = true
+
 	// Score output subdirectory within each kit folder
 	ScoreOutputDirName = "scores"
 
+	// Score user kits and the reference panels with the in-process Go engine
+	// instead of plink2. With both set, plink2 is not needed at runtime.
+	NativeUserScoring       = true
+	NativePopulationScoring = true
+
+	// Number of scoring jobs run concurrently; further jobs wait in the queue
+	JobWorkers = 2
+	// Finished, failed and cancelled jobs stay queryable this long
+	JobRetentionHours = 1
+
 	// External tool binaries
-	Plink1Cmd = "plink1"
 	Plink2Cmd = "plink2"
 
 	// Catalog file names
-	OntologyTraitsFile = "ontology_traits.json"
-  	ScoresMetadataFile = "scores_metadata.json"
+	OntologyTraitsFile     = "ontology_traits.json"
+	ScoresMetadataFile     = "scores_metadata.json"
+	PublicationsFile       = "publications.json"
+	PerformanceMetricsFile = "performance_metrics.json"
+
+	// Local ontology export (OBO format, e.g. efo.obo) used for trait hierarchy browsing
+	OntologyHierarchyFile = "efo.obo"
+
+	// GRCh37 gene coordinates (gene_name, chr, start, end) for gene → variant lookups
+	GeneAnnotationFile = "genes_grch37.tsv"
 
 	// Bolt DB
-	BoltDBName     = "kits.db"
-  	BoltBucketName = "kits"
+	BoltDBName           = "kits.db"
+	BoltBucketName       = "kits"
+	BoltScoreIndexBucket = "score_index"
+	BoltResultsBucket    = "results"
+	BoltRunsBucket       = "runs"
 )
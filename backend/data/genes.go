@@ -0,0 +1,68 @@
+package data
+
+import (
+    "bufio"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+
+    "github.com/adamwestgate/easy-pgs/backend/config"
+)
+
+// GeneAnnotationPath points at the local GRCh37 gene table
+// (tab-separated: gene_name, chr, start, end; header optional).
+var GeneAnnotationPath = filepath.Join(config.DataDir, config.GeneAnnotationFile)
+
+// Gene is one row of the gene annotation table.
+type Gene struct {
+    Name  string `json:"name"`
+    Chr   string `json:"chr"`
+    Start int    `json:"start"`
+    End   int    `json:"end"`
+}
+
+// genesByName indexes the annotation table by upper-cased gene name.
+var genesByName = map[string]Gene{}
+
+// LoadGenes reads the gene annotation table into memory.
+// Returns an error if the file is missing or unreadable.
+func LoadGenes() error {
+    f, err := os.Open(GeneAnnotationPath)
+    if err != nil {
+        return fmt.Errorf("unable to open %s: %w", GeneAnnotationPath, err)
+    }
+    defer f.Close()
+
+    genes := map[string]Gene{}
+    sc := bufio.NewScanner(f)
+    for sc.Scan() {
+        line := strings.TrimSpace(sc.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        cols := strings.Split(line, "\t")
+        if len(cols) < 4 {
+            continue
+        }
+        start, err1 := strconv.Atoi(cols[2])
+        end, err2 := strconv.Atoi(cols[3])
+        if err1 != nil || err2 != nil {
+            continue // header row
+        }
+        g := Gene{Name: cols[0], Chr: strings.TrimPrefix(cols[1], "chr"), Start: start, End: end}
+        genes[strings.ToUpper(g.Name)] = g
+    }
+    if err := sc.Err(); err != nil {
+        return fmt.Errorf("unable to parse %s: %w", GeneAnnotationPath, err)
+    }
+    genesByName = genes
+    return nil
+}
+
+// GeneByName looks up a gene symbol, case-insensitively.
+func GeneByName(name string) (Gene, bool) {
+    g, ok := genesByName[strings.ToUpper(strings.TrimSpace(name))]
+    return g, ok
+}
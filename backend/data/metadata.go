@@ -1,7 +1,10 @@
 package data
 
 import (
+    "crypto/sha256"
+    "encoding/hex"
     "encoding/json"
+    "errors"
     "fmt"
     "os"
     "path/filepath"
@@ -13,6 +16,8 @@ import (
 var (
   OntologyTraitsPath = filepath.Join(config.DataDir, config.OntologyTraitsFile)
   ScoresMetadataPath = filepath.Join(config.DataDir, config.ScoresMetadataFile)
+  PublicationsPath   = filepath.Join(config.DataDir, config.PublicationsFile)
+  PerformancePath    = filepath.Join(config.DataDir, config.PerformanceMetricsFile)
 )
 
 // OntologyTrait mirrors one entry in ontology_traits.json
@@ -28,24 +33,35 @@ type OntologyTrait struct {
 // LoadedScores holds the parsed JSON from scores_metadata.json
 var LoadedScores []map[string]interface{}
 
+// CatalogVersion identifies the loaded scores_metadata.json: the first 12 hex
+// digits of its SHA-256, so results can record which catalog they came from.
+var CatalogVersion string
+
 // LoadedTraits holds the parsed JSON from ontology_traits.json
 var LoadedTraits []OntologyTrait
 
+// LoadedPublications holds the parsed JSON from publications.json
+var LoadedPublications []map[string]interface{}
+
+// LoadedPerformance holds the parsed JSON from performance_metrics.json
+var LoadedPerformance []map[string]interface{}
+
 // LoadScores reads and parses the scores metadata JSON file into LoadedScores.
 // Returns an error if opening or parsing the file fails.
 func LoadScores() error {
-    f, err := os.Open(ScoresMetadataPath)
+    raw, err := os.ReadFile(ScoresMetadataPath)
     if err != nil {
         return fmt.Errorf("unable to open %s: %w", ScoresMetadataPath, err)
     }
-    defer f.Close()
 
     var tmp []map[string]interface{}
-    if err := json.NewDecoder(f).Decode(&tmp); err != nil {
+    if err := json.Unmarshal(raw, &tmp); err != nil {
         return fmt.Errorf("unable to parse %s: %w", ScoresMetadataPath, err)
     }
 
+    sum := sha256.Sum256(raw)
     LoadedScores = tmp
+    CatalogVersion = hex.EncodeToString(sum[:])[:12]
     return nil
 }
 
@@ -64,6 +80,47 @@ func LoadTraits() error {
     }
 
     LoadedTraits = tmp
+    traitsByID = make(map[string]OntologyTrait, len(tmp))
+    for _, t := range tmp {
+        traitsByID[t.ID] = t
+    }
+    return nil
+}
+
+// LoadPublications reads the optional publications and performance metrics
+// JSON files into LoadedPublications and LoadedPerformance. The two files are
+// loaded independently: one missing or broken file leaves the other usable.
+// Returns the errors of every file that could not be loaded.
+func LoadPublications() error {
+    var errs []error
+    for _, src := range []struct {
+        path string
+        dst  *[]map[string]interface{}
+    }{
+        {PublicationsPath, &LoadedPublications},
+        {PerformancePath, &LoadedPerformance},
+    } {
+        if err := loadJSONRows(src.path, src.dst); err != nil {
+            errs = append(errs, err)
+        }
+    }
+    return errors.Join(errs...)
+}
+
+// loadJSONRows decodes the JSON array at path into dst, leaving dst
+// untouched on error.
+func loadJSONRows(path string, dst *[]map[string]interface{}) error {
+    f, err := os.Open(path)
+    if err != nil {
+        return fmt.Errorf("unable to open %s: %w", path, err)
+    }
+    defer f.Close()
+
+    var tmp []map[string]interface{}
+    if err := json.NewDecoder(f).Decode(&tmp); err != nil {
+        return fmt.Errorf("unable to parse %s: %w", path, err)
+    }
+    *dst = tmp
     return nil
 }
 
@@ -0,0 +1,256 @@
+package data
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+
+    "github.com/adamwestgate/easy-pgs/backend/config"
+)
+
+// OntologyHierarchyPath points at the local OBO export (EFO, HPO or MONDO)
+// used to build parent/child relationships between traits.
+var OntologyHierarchyPath = filepath.Join(config.DataDir, config.OntologyHierarchyFile)
+
+// OntologyTerm is one node of the trait hierarchy. IDs use the catalog's
+// underscore form (EFO_0000319) regardless of how the OBO file spells them.
+type OntologyTerm struct {
+    ID       string
+    Label    string
+    Parents  []string
+    Children []string
+}
+
+var (
+    // ontologyTerms holds every term parsed from the hierarchy file.
+    ontologyTerms = map[string]*OntologyTerm{}
+    // traitsByID indexes LoadedTraits for direct lookups.
+    traitsByID = map[string]OntologyTrait{}
+    // rolledUpPGS holds, per hierarchy term, the sorted PGS IDs of the term
+    // and all of its descendants. Built once by LoadOntology.
+    rolledUpPGS = map[string][]string{}
+)
+
+// LoadOntology reads the OBO hierarchy file into memory. Only "is_a"
+// relationships are used; obsolete terms are skipped. The rolled-up PGS sets
+// are computed here from LoadedTraits, so load traits first.
+// Returns an error if the file is missing or cannot be parsed; callers may
+// treat that as "no hierarchy" since the flat trait list still works.
+func LoadOntology() error {
+    f, err := os.Open(OntologyHierarchyPath)
+    if err != nil {
+        return fmt.Errorf("unable to open %s: %w", OntologyHierarchyPath, err)
+    }
+    defer f.Close()
+
+    terms, err := parseOBO(f)
+    if err != nil {
+        return fmt.Errorf("unable to parse %s: %w", OntologyHierarchyPath, err)
+    }
+    ontologyTerms = terms
+    rolledUpPGS = rollUpPGS(terms)
+    return nil
+}
+
+// parseOBO reads the [Term] stanzas of an OBO file and links each term to
+// its children.
+func parseOBO(r io.Reader) (map[string]*OntologyTerm, error) {
+    terms := map[string]*OntologyTerm{}
+    var (
+        cur      *OntologyTerm
+        obsolete bool
+        inTerm   bool
+    )
+    flush := func() {
+        if cur != nil && !obsolete {
+            terms[cur.ID] = cur
+        }
+        cur, obsolete = nil, false
+    }
+
+    sc := bufio.NewScanner(r)
+    sc.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+    for sc.Scan() {
+        line := strings.TrimSpace(sc.Text())
+        if strings.HasPrefix(line, "[") {
+            flush()
+            inTerm = line == "[Term]"
+            continue
+        }
+        if !inTerm || line == "" {
+            continue
+        }
+        key, val, ok := strings.Cut(line, ":")
+        if !ok {
+            continue
+        }
+        val = strings.TrimSpace(val)
+        switch key {
+        case "id":
+            cur = &OntologyTerm{ID: normalizeTermID(val)}
+        case "name":
+            if cur != nil {
+                cur.Label = val
+            }
+        case "is_a":
+            if cur != nil {
+                // "is_a: EFO:0000319 ! cardiovascular disease"
+                parent, _, _ := strings.Cut(val, "!")
+                if f := strings.Fields(parent); len(f) > 0 {
+                    cur.Parents = append(cur.Parents, normalizeTermID(f[0]))
+                }
+            }
+        case "is_obsolete":
+            obsolete = val == "true"
+        }
+    }
+    flush()
+    if err := sc.Err(); err != nil {
+        return nil, err
+    }
+
+    // link children to their parents
+    for id, t := range terms {
+        for _, p := range t.Parents {
+            if parent, ok := terms[p]; ok {
+                parent.Children = append(parent.Children, id)
+            }
+        }
+    }
+    for _, t := range terms {
+        sort.Strings(t.Children)
+    }
+    return terms, nil
+}
+
+// rollUpPGS computes the rolled-up PGS set of every term from its full
+// descendant closure. Each closure is walked with its own visited set, so a
+// cycle in a malformed file cannot leave a partial set behind.
+func rollUpPGS(terms map[string]*OntologyTerm) map[string][]string {
+    out := make(map[string][]string, len(terms))
+    for id := range terms {
+        set := map[string]bool{}
+        for _, tid := range append([]string{id}, walkTerms(terms, id, func(t *OntologyTerm) []string { return t.Children })...) {
+            for _, pid := range traitsByID[tid].PGSFiles {
+                set[pid] = true
+            }
+        }
+        ids := make([]string, 0, len(set))
+        for pid := range set {
+            ids = append(ids, pid)
+        }
+        sort.Strings(ids)
+        out[id] = ids
+    }
+    return out
+}
+
+// normalizeTermID converts "EFO:0000319" or an ontology URL to "EFO_0000319".
+func normalizeTermID(raw string) string {
+    if i := strings.LastIndexByte(raw, '/'); i >= 0 {
+        raw = raw[i+1:]
+    }
+    return strings.Replace(raw, ":", "_", 1)
+}
+
+// TraitByID returns the catalog trait with the given ontology ID.
+func TraitByID(id string) (OntologyTrait, bool) {
+    t, ok := traitsByID[normalizeTermID(id)]
+    return t, ok
+}
+
+// OntologyTermByID returns the hierarchy node for id, if the hierarchy
+// file contained it.
+func OntologyTermByID(id string) (*OntologyTerm, bool) {
+    t, ok := ontologyTerms[normalizeTermID(id)]
+    return t, ok
+}
+
+// TermLabel returns the catalog label for id, falling back to the ontology name.
+func TermLabel(id string) string {
+    if t, ok := TraitByID(id); ok {
+        return t.Label
+    }
+    if t, ok := OntologyTermByID(id); ok {
+        return t.Label
+    }
+    return ""
+}
+
+// MatchOntologyTerms returns the hierarchy terms whose ID or label contains
+// q (lower-case), sorted by ID.
+func MatchOntologyTerms(q string) []*OntologyTerm {
+    var out []*OntologyTerm
+    for id, t := range ontologyTerms {
+        if strings.Contains(strings.ToLower(id), q) || strings.Contains(strings.ToLower(t.Label), q) {
+            out = append(out, t)
+        }
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+    return out
+}
+
+// Ancestors returns every transitive parent of id, nearest first.
+func Ancestors(id string) []string {
+    return walkOntology(normalizeTermID(id), func(t *OntologyTerm) []string { return t.Parents })
+}
+
+// Descendants returns every transitive child of id, nearest first.
+func Descendants(id string) []string {
+    return walkOntology(normalizeTermID(id), func(t *OntologyTerm) []string { return t.Children })
+}
+
+// walkOntology runs a breadth-first search from id along next, excluding id itself.
+func walkOntology(id string, next func(*OntologyTerm) []string) []string {
+    return walkTerms(ontologyTerms, id, next)
+}
+
+// walkTerms is walkOntology over the given terms.
+func walkTerms(terms map[string]*OntologyTerm, id string, next func(*OntologyTerm) []string) []string {
+    seen := map[string]bool{id: true}
+    var out []string
+    queue := []string{id}
+    for len(queue) > 0 {
+        t, ok := terms[queue[0]]
+        queue = queue[1:]
+        if !ok {
+            continue
+        }
+        for _, n := range next(t) {
+            if !seen[n] {
+                seen[n] = true
+                out = append(out, n)
+                queue = append(queue, n)
+            }
+        }
+    }
+    return out
+}
+
+// RolledUpPGS returns the distinct PGS IDs attached to id and all of its
+// descendant traits, sorted. The slice is shared; callers must not modify it.
+func RolledUpPGS(id string) []string {
+    id = normalizeTermID(id)
+    if ids, ok := rolledUpPGS[id]; ok {
+        return ids
+    }
+    // outside the hierarchy a trait only rolls up its own scores
+    t, ok := traitsByID[id]
+    if !ok {
+        return nil
+    }
+    seen := map[string]bool{}
+    var out []string
+    for _, pid := range t.PGSFiles {
+        if !seen[pid] {
+            seen[pid] = true
+            out = append(out, pid)
+        }
+    }
+    sort.Strings(out)
+    return out
+}
@@ -0,0 +1,150 @@
+package data
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+// testOBO is a small hierarchy: disease > cardiovascular disease > {coronary
+// artery disease, hypertension}, plus an obsolete term and a typedef stanza.
+const testOBO = `format-version: 1.2
+ontology: efo
+
+[Term]
+id: EFO:0000408
+name: disease
+
+[Term]
+id: EFO:0000319
+name: cardiovascular disease
+is_a: EFO:0000408 ! disease
+
+[Term]
+id: EFO:0001645
+name: coronary artery disease
+is_a: EFO:0000319 ! cardiovascular disease
+
+[Term]
+id: http://purl.obolibrary.org/obo/EFO_0000537
+name: hypertension
+is_a: EFO:0000319 ! cardiovascular disease
+
+[Term]
+id: EFO:0009999
+name: old term
+is_a: EFO:0000319 ! cardiovascular disease
+is_obsolete: true
+
+[Typedef]
+id: part_of
+name: part of
+`
+
+func loadTestOntology(t *testing.T) {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), "efo.obo")
+    if err := os.WriteFile(path, []byte(testOBO), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    oldPath, oldTerms, oldTraits, oldRolled := OntologyHierarchyPath, ontologyTerms, traitsByID, rolledUpPGS
+    t.Cleanup(func() {
+        OntologyHierarchyPath, ontologyTerms, traitsByID, rolledUpPGS = oldPath, oldTerms, oldTraits, oldRolled
+    })
+
+    OntologyHierarchyPath = path
+    traitsByID = map[string]OntologyTrait{
+        "EFO_0000319": {ID: "EFO_0000319", PGSFiles: []string{"PGS000010"}},
+        "EFO_0001645": {ID: "EFO_0001645", PGSFiles: []string{"PGS000018", "PGS000010"}},
+        "EFO_0000537": {ID: "EFO_0000537", PGSFiles: []string{"PGS000301"}},
+        "EFO_1111111": {ID: "EFO_1111111", PGSFiles: []string{"PGS000002", "PGS000001", "PGS000002"}},
+    }
+    if err := LoadOntology(); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestParseOBO(t *testing.T) {
+    terms, err := parseOBO(strings.NewReader(testOBO))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(terms) != 4 {
+        t.Fatalf("got %d terms, want 4 (obsolete and typedef skipped)", len(terms))
+    }
+    if _, ok := terms["EFO_0009999"]; ok {
+        t.Error("obsolete term was kept")
+    }
+    cvd := terms["EFO_0000319"]
+    if cvd == nil || cvd.Label != "cardiovascular disease" {
+        t.Fatalf("EFO_0000319 = %+v", cvd)
+    }
+    if !reflect.DeepEqual(cvd.Parents, []string{"EFO_0000408"}) {
+        t.Errorf("parents = %v", cvd.Parents)
+    }
+    if want := []string{"EFO_0000537", "EFO_0001645"}; !reflect.DeepEqual(cvd.Children, want) {
+        t.Errorf("children = %v, want %v", cvd.Children, want)
+    }
+    if _, ok := terms["EFO_0000537"]; !ok {
+        t.Error("URL-form id not normalized")
+    }
+}
+
+func TestRolledUpPGS(t *testing.T) {
+    loadTestOntology(t)
+
+    for _, tc := range []struct {
+        id   string
+        want []string
+    }{
+        // disease has no scores of its own but inherits every descendant's
+        {"EFO_0000408", []string{"PGS000010", "PGS000018", "PGS000301"}},
+        {"EFO:0000319", []string{"PGS000010", "PGS000018", "PGS000301"}},
+        {"EFO_0001645", []string{"PGS000010", "PGS000018"}},
+        {"EFO_0000537", []string{"PGS000301"}},
+        // a catalog trait missing from the hierarchy keeps its own scores
+        {"EFO_1111111", []string{"PGS000001", "PGS000002"}},
+        {"EFO_0000000", nil},
+    } {
+        if got := RolledUpPGS(tc.id); !reflect.DeepEqual(got, tc.want) {
+            t.Errorf("RolledUpPGS(%s) = %v, want %v", tc.id, got, tc.want)
+        }
+    }
+
+    if got, want := Descendants("EFO_0000408"), []string{"EFO_0000319", "EFO_0000537", "EFO_0001645"}; !reflect.DeepEqual(got, want) {
+        t.Errorf("Descendants = %v, want %v", got, want)
+    }
+    if got, want := Ancestors("EFO_0001645"), []string{"EFO_0000319", "EFO_0000408"}; !reflect.DeepEqual(got, want) {
+        t.Errorf("Ancestors = %v, want %v", got, want)
+    }
+}
+
+func TestRolledUpPGSCycle(t *testing.T) {
+    // a malformed file where A is_a B and B is_a A: both roll up both scores
+    terms, err := parseOBO(strings.NewReader(`[Term]
+id: EFO:1
+is_a: EFO:2
+
+[Term]
+id: EFO:2
+is_a: EFO:1
+`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    oldTraits := traitsByID
+    t.Cleanup(func() { traitsByID = oldTraits })
+    traitsByID = map[string]OntologyTrait{
+        "EFO_1": {ID: "EFO_1", PGSFiles: []string{"PGS000001"}},
+        "EFO_2": {ID: "EFO_2", PGSFiles: []string{"PGS000002"}},
+    }
+    rolled := rollUpPGS(terms)
+    want := []string{"PGS000001", "PGS000002"}
+    for _, id := range []string{"EFO_1", "EFO_2"} {
+        if !reflect.DeepEqual(rolled[id], want) {
+            t.Errorf("%s rolls up %v, want %v", id, rolled[id], want)
+        }
+    }
+}
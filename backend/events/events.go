@@ -0,0 +1,155 @@
+// Package events is an in-process publish/subscribe bus for pipeline
+// progress. Producers (download, normalisation, scoring) emit Events through
+// an Emitter bound to a job; the SSE endpoint subscribes and forwards them.
+package events
+
+import (
+    "sync"
+    "time"
+)
+
+// Event types, roughly in pipeline order.
+const (
+    JobStage          = "job_stage"         // Stage: job entered a new stage
+    DownloadProgress  = "download_progress" // Done/Total: bytes fetched
+    DownloadDone      = "download_done"
+    NormalizeProgress = "normalize_progress" // Done: rows written so far
+    NormalizeDone     = "normalize_done"     // Done: total rows
+    ScoreStart        = "score_start"        // Stage: "user" or "population"; Done/Total: scores started / requested
+    ScoreDone         = "score_done"
+    ScoreFailed       = "score_failed" // Error
+    PGSFailed         = "pgs_failed"   // Error: the PGS was dropped from the job
+    JobDone           = "job_done"
+    JobFailed         = "job_failed" // Error
+    JobCancelled      = "job_cancelled"
+)
+
+// Event is one progress notification.
+type Event struct {
+    Job   string    `json:"jobId,omitempty"`
+    Type  string    `json:"type"`
+    PGSID string    `json:"pgsId,omitempty"`
+    Stage string    `json:"stage,omitempty"`
+    Done  int64     `json:"done,omitempty"`
+    Total int64     `json:"total,omitempty"`
+    Error string    `json:"error,omitempty"`
+    Time  time.Time `json:"time"`
+}
+
+// Terminal reports whether typ ends a job: job_done, job_failed or
+// job_cancelled.
+func Terminal(typ string) bool {
+    return typ == JobDone || typ == JobFailed || typ == JobCancelled
+}
+
+// Bus fans events out to subscribers. Slow subscribers lose progress events
+// rather than blocking the pipeline, but never a terminal one: that
+// displaces the oldest event still queued for them.
+type Bus struct {
+    mu   sync.RWMutex
+    subs map[chan Event]struct{}
+}
+
+// NewBus returns an empty bus.
+func NewBus() *Bus {
+    return &Bus{subs: make(map[chan Event]struct{})}
+}
+
+// Default is the process-wide bus used by the server.
+var Default = NewBus()
+
+// Publish delivers e to every subscriber, stamping the time if unset.
+func (b *Bus) Publish(e Event) {
+    if e.Time.IsZero() {
+        e.Time = time.Now()
+    }
+    keep := Terminal(e.Type)
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+    for ch := range b.subs {
+        deliver(ch, e, keep)
+    }
+}
+
+// deliver sends e on ch without blocking. If ch is full, e is dropped, or
+// with keep set the oldest queued event is discarded to make room.
+func deliver(ch chan Event, e Event, keep bool) {
+    for {
+        select {
+        case ch <- e:
+            return
+        default:
+        }
+        if !keep {
+            return // subscriber is behind; drop
+        }
+        select {
+        case <-ch:
+        default:
+        }
+    }
+}
+
+// Subscribe returns a channel receiving every subsequent event and a function
+// that unsubscribes and closes it.
+func (b *Bus) Subscribe(buffer int) (<-chan Event, func()) {
+    ch := make(chan Event, buffer)
+    b.mu.Lock()
+    b.subs[ch] = struct{}{}
+    b.mu.Unlock()
+
+    var once sync.Once
+    return ch, func() {
+        once.Do(func() {
+            b.mu.Lock()
+            delete(b.subs, ch)
+            b.mu.Unlock()
+            close(ch)
+        })
+    }
+}
+
+// Emitter publishes events on behalf of one job (and optionally one PGS).
+// A nil Emitter discards everything, so producers can emit unconditionally.
+type Emitter func(Event)
+
+// ForJob returns an Emitter that tags events with jobID and publishes them on b.
+func (b *Bus) ForJob(jobID string) Emitter {
+    return func(e Event) {
+        e.Job = jobID
+        b.Publish(e)
+    }
+}
+
+// ForPGS returns an Emitter that also tags events with pgsID.
+func (em Emitter) ForPGS(pgsID string) Emitter {
+    if em == nil {
+        return nil
+    }
+    return func(e Event) {
+        if e.PGSID == "" {
+            e.PGSID = pgsID
+        }
+        em(e)
+    }
+}
+
+// ForStage returns an Emitter that tags events with stage where they have none.
+func (em Emitter) ForStage(stage string) Emitter {
+    if em == nil {
+        return nil
+    }
+    return func(e Event) {
+        if e.Stage == "" {
+            e.Stage = stage
+        }
+        em(e)
+    }
+}
+
+// Emit publishes e unless em is nil.
+func (em Emitter) Emit(e Event) {
+    if em != nil {
+        em(e)
+    }
+}
@@ -0,0 +1,97 @@
+// backend/pipeline/ancestry.go
+package pipeline
+
+import (
+    "fmt"
+    "path/filepath"
+    "sort"
+
+    "github.com/adamwestgate/easy-pgs/backend/config"
+    "github.com/adamwestgate/easy-pgs/backend/preprocessing/ancestry"
+    "github.com/adamwestgate/easy-pgs/backend/store"
+)
+
+// InferAncestry projects the kit in pfileDir onto the PCA of kitType's
+// reference panel and classifies its superpopulation. Panels set up before
+// the ancestry model existed return an error.
+func InferAncestry(pfileDir, kitType string) (store.Ancestry, error) {
+    panel, err := pfilePrefix(PanelDir(kitType))
+    if err != nil {
+        return store.Ancestry{}, err
+    }
+    m, err := ancestry.Load(panel)
+    if err != nil {
+        return store.Ancestry{}, fmt.Errorf("ancestry model: %w", err)
+    }
+    kit, err := pfilePrefix(pfileDir)
+    if err != nil {
+        return store.Ancestry{}, err
+    }
+    projs, err := m.Project(kit)
+    if err != nil {
+        return store.Ancestry{}, err
+    }
+    if len(projs) == 0 || projs[0].Variants == 0 {
+        return store.Ancestry{}, fmt.Errorf("no PCA variants in %s", pfileDir)
+    }
+    p := projs[0]
+    probs, top := m.Classify(p.PCs)
+    return store.Ancestry{
+        SuperPop:      top,
+        Probabilities: probs,
+        PCs:           p.PCs,
+        Variants:      p.Variants,
+        Loadings:      len(m.Loadings),
+    }, nil
+}
+
+// ConfidentSuperPop returns a's superpopulation if it was inferred with at
+// least config.AncestryMinProbability, else "".
+func ConfidentSuperPop(a store.Ancestry) string {
+    if a.Probabilities[a.SuperPop] < config.AncestryMinProbability {
+        return ""
+    }
+    return a.SuperPop
+}
+
+// AdjustedZ returns the z-score of user against the panel scores in
+// popSscore after regressing their mean and variance on the first
+// config.AncestryAdjustPCs principal components (see ancestry.Adjustment),
+// for a kit at pcs.
+func AdjustedZ(kitType, popSscore string, user float64, pcs []float64) (float64, error) {
+    panel, err := pfilePrefix(PanelDir(kitType))
+    if err != nil {
+        return 0, err
+    }
+    m, err := ancestry.Load(panel)
+    if err != nil {
+        return 0, fmt.Errorf("ancestry model: %w", err)
+    }
+    samples, err := ReadSampleScores(popSscore)
+    if err != nil {
+        return 0, err
+    }
+    scores := make(map[string]float64, len(samples))
+    for _, s := range samples {
+        scores[s.IID] = s.Avg
+    }
+    n := config.AncestryAdjustPCs
+    if n > m.PCs {
+        n = m.PCs
+    }
+    adj, err := ancestry.FitAdjustment(m.References, scores, n)
+    if err != nil {
+        return 0, err
+    }
+    return adj.Z(user, pcs), nil
+}
+
+// pfilePrefix returns the prefix of the (first) .pgen fileset in dir.
+func pfilePrefix(dir string) (string, error) {
+    paths, _ := filepath.Glob(filepath.Join(dir, "*.pgen"))
+    if len(paths) == 0 {
+        return "", fmt.Errorf("no .pgen in %s", dir)
+    }
+    sort.Strings(paths)
+    return paths[0][:len(paths[0])-len(".pgen")], nil
+}
@@ -0,0 +1,289 @@
+// backend/pipeline/download.go
+package pipeline
+
+import (
+    "compress/gzip"
+    "context"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+
+    "github.com/adamwestgate/easy-pgs/backend/config"
+    "github.com/adamwestgate/easy-pgs/backend/data"
+    "github.com/adamwestgate/easy-pgs/backend/events"
+    "github.com/adamwestgate/easy-pgs/backend/preprocessing/pgs_convert"
+    "github.com/adamwestgate/easy-pgs/backend/store"
+)
+
+// EnsureScoreFile downloads the scoring file for pgsID if it is not cached yet,
+// then normalizes it to <PGSDownloadDir>/<id>/<id>.norm.tsv.
+// Returns the path of the normalized file.
+func EnsureScoreFile(ctx context.Context, pgsID string, ev events.Emitter) (string, error) {
+    gzPath, err := DownloadScoreFile(ctx, pgsID, ev)
+    if err != nil {
+        return "", err
+    }
+    return NormalizeScoreFile(ctx, pgsID, gzPath, ev)
+}
+
+// DownloadScoreFile fetches the scoring file for pgsID unless it is already
+// on disk, and returns its path. Byte progress is reported to ev (may be nil).
+func DownloadScoreFile(ctx context.Context, pgsID string, ev events.Emitter) (string, error) {
+    link := FindScoreURL(pgsID)
+    if link == "" {
+        return "", fmt.Errorf("no FTP link for %s", pgsID)
+    }
+    if strings.HasPrefix(link, "ftp://") {
+        link = "http://" + strings.TrimPrefix(link, "ftp://")
+    }
+
+    // Use config for download directory
+    dir := filepath.Join(config.PGSDownloadDir, pgsID)
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return "", fmt.Errorf("mkdir %s: %w", dir, err)
+    }
+
+    // Download if missing; concurrent requests for the same file share one fetch
+    fname := filepath.Base(link)
+    gzPath := filepath.Join(dir, fname)
+    _, _, err := downloads.Do(ctx, pgsID, func() (interface{}, error) {
+        if _, err := os.Stat(gzPath); os.IsNotExist(err) {
+            if err := fetchFile(ctx, link, gzPath, ev); err != nil {
+                return nil, fmt.Errorf("fetchFile: %w", err)
+            }
+        }
+        return nil, nil
+    })
+    if err != nil {
+        return "", err
+    }
+    ev.Emit(events.Event{Type: events.DownloadDone})
+    return gzPath, nil
+}
+
+// downloads and normalizations de-duplicate concurrent work per PGS ID, so
+// two jobs never write the same .txt.gz or .norm.tsv at once.
+var downloads, normalizations flightGroup
+
+// NormalizeScoreFile decompresses and normalizes a downloaded scoring file next
+// to it, updating the score index. Row counts are reported to ev (may be nil).
+// Callers normalizing the same PGS concurrently share one run; the .norm.tsv
+// is replaced atomically, so readers of the previous one are unaffected.
+// A cancelled or failed run leaves no partial file.
+// Returns the .norm.tsv path.
+func NormalizeScoreFile(ctx context.Context, pgsID, gzPath string, ev events.Emitter) (string, error) {
+    val, shared, err := normalizations.Do(ctx, pgsID, func() (interface{}, error) {
+        return normalizeScoreFile(ctx, pgsID, gzPath, ev)
+    })
+    if err != nil {
+        return "", err
+    }
+    if shared {
+        ev.Emit(events.Event{Type: events.NormalizeDone})
+    }
+    return val.(string), nil
+}
+
+// normalizeScoreFile does the work of NormalizeScoreFile. A .norm.tsv newer
+// than the scoring file, indexed with its current stamp, is reused as is.
+func normalizeScoreFile(ctx context.Context, pgsID, gzPath string, ev events.Emitter) (string, error) {
+    normPath := strings.TrimSuffix(gzPath, ".txt.gz") + ".norm.tsv"
+    if normUpToDate(pgsID, gzPath, normPath) {
+        ev.Emit(events.Event{Type: events.NormalizeDone})
+        return normPath, nil
+    }
+
+    src, err := os.Open(gzPath)
+    if err != nil {
+        return "", err
+    }
+    defer src.Close()
+    gzReader, err := gzip.NewReader(src)
+    if err != nil {
+        return "", err
+    }
+    defer gzReader.Close()
+
+    part := normPath + ".part"
+    out, err := os.Create(part)
+    if err != nil {
+        return "", err
+    }
+    defer out.Close()
+
+    var variants []store.IndexedVariant
+    opts := pgs_convert.Options{Events: ev}
+    if scoreIndex != nil {
+        opts.OnVariant = func(v pgs_convert.Variant) { variants = append(variants, IndexedVariant(v)) }
+    }
+    if err := pgs_convert.NormalizeContext(ctx, gzReader, out, opts); err != nil {
+        out.Close()
+        os.Remove(part)
+        return "", fmt.Errorf("normalize: %w", err)
+    }
+    if err := out.Close(); err != nil {
+        os.Remove(part)
+        return "", err
+    }
+    if err := os.Rename(part, normPath); err != nil {
+        os.Remove(part)
+        return "", err
+    }
+    if scoreIndex != nil {
+        if err := scoreIndex.IndexScore(pgsID, FileStamp(normPath), variants); err != nil {
+            log.Printf("NormalizeScoreFile: indexing %s failed: %v", pgsID, err)
+        }
+    }
+    return normPath, nil
+}
+
+// normUpToDate reports whether normPath was written after gzPath and, when
+// there is a score index, was the file pgsID was last indexed from.
+func normUpToDate(pgsID, gzPath, normPath string) bool {
+    gz, err := os.Stat(gzPath)
+    if err != nil {
+        return false
+    }
+    norm, err := os.Stat(normPath)
+    if err != nil || norm.ModTime().Before(gz.ModTime()) {
+        return false
+    }
+    if scoreIndex == nil {
+        return true
+    }
+    stamp, ok := scoreIndex.IndexStamp(pgsID)
+    return ok && stamp == FileStamp(normPath)
+}
+
+// FileStamp identifies one version of the file at path by its size and
+// modification time, or returns "" if it cannot be stat'ed.
+func FileStamp(path string) string {
+    st, err := os.Stat(path)
+    if err != nil {
+        return ""
+    }
+    return fmt.Sprintf("%d-%d", st.Size(), st.ModTime().UnixNano())
+}
+
+// IndexedVariant converts a normalised score row to an index entry.
+func IndexedVariant(v pgs_convert.Variant) store.IndexedVariant {
+    pos, _ := strconv.Atoi(v.Pos)
+    return store.IndexedVariant{
+        RSID:         v.RSID,
+        Chr:          v.Chr,
+        Pos:          pos,
+        EffectAllele: v.EffectAllele,
+        Weight:       v.Weight,
+    }
+}
+
+// ScoreFileGz returns the path of the downloaded scoring file for pgsID,
+// or "" if it has not been downloaded.
+func ScoreFileGz(pgsID string) string {
+    link := FindScoreURL(pgsID)
+    if link == "" {
+        return ""
+    }
+    p := filepath.Join(config.PGSDownloadDir, pgsID, filepath.Base(link))
+    if _, err := os.Stat(p); err != nil {
+        return ""
+    }
+    return p
+}
+
+// NormalizedFile returns the .norm.tsv of pgsID, or "" if it has not been
+// downloaded and normalised.
+func NormalizedFile(pgsID string) string {
+    gz := ScoreFileGz(pgsID)
+    if gz == "" {
+        return ""
+    }
+    norm := strings.TrimSuffix(gz, ".txt.gz") + ".norm.tsv"
+    if _, err := os.Stat(norm); err != nil {
+        return ""
+    }
+    return norm
+}
+
+// fetchFile downloads a file via HTTP GET, reporting bytes received to ev (may be nil).
+// The body is written to dest+".part" and only renamed to dest once complete,
+// so a cancelled or failed download never leaves a truncated file behind.
+func fetchFile(ctx context.Context, url, dest string, ev events.Emitter) error {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+    if err != nil {
+        return err
+    }
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("GET %s: %s", url, resp.Status)
+    }
+
+    part := dest + ".part"
+    f, err := os.Create(part)
+    if err != nil {
+        return err
+    }
+
+    var src io.Reader = resp.Body
+    if ev != nil {
+        src = &progressReader{r: resp.Body, total: resp.ContentLength, ev: ev}
+    }
+    _, err = io.Copy(f, src)
+    if cerr := f.Close(); err == nil {
+        err = cerr
+    }
+    if err != nil {
+        os.Remove(part)
+        return err
+    }
+    return os.Rename(part, dest)
+}
+
+// progressReader emits DownloadProgress events at most every progressStep bytes.
+type progressReader struct {
+    r           io.Reader
+    done, total int64
+    last        int64
+    ev          events.Emitter
+}
+
+const progressStep = 256 << 10
+
+func (p *progressReader) Read(b []byte) (int, error) {
+    n, err := p.r.Read(b)
+    p.done += int64(n)
+    if p.done-p.last >= progressStep || (err == io.EOF && p.done > p.last) {
+        p.last = p.done
+        p.ev.Emit(events.Event{Type: events.DownloadProgress, Done: p.done, Total: p.total})
+    }
+    return n, err
+}
+
+// FindScoreURL looks up the FTP link for a PGS ID in LoadedScores
+func FindScoreURL(id string) string {
+    if m := FindScoreMeta(id); m != nil {
+        if link, ok := m["FTP link"].(string); ok {
+            return link
+        }
+    }
+    return ""
+}
+
+// FindScoreMeta returns the catalog metadata row for a PGS ID, or nil.
+func FindScoreMeta(id string) map[string]interface{} {
+    for _, m := range data.LoadedScores {
+        if sid, ok := m["Polygenic Score (PGS) ID"].(string); ok && sid == id {
+            return m
+        }
+    }
+    return nil
+}
@@ -0,0 +1,84 @@
+// backend/pipeline/flight.go
+package pipeline
+
+import (
+    "context"
+    "errors"
+    "sync"
+)
+
+// flightGroup de-duplicates concurrent work on the same resource: the first
+// caller for a key (the leader) does the work, and callers arriving while it
+// runs wait for and share its result instead of repeating it.
+type flightGroup struct {
+    mu    sync.Mutex
+    calls map[string]*flightCall
+}
+
+// flightCall is one in-progress piece of work.
+type flightCall struct {
+    done chan struct{}
+    val  interface{}
+    err  error
+}
+
+// Do runs fn once for all concurrent callers with the same key and returns
+// its result; shared is true for callers that did not run fn themselves.
+// A waiting caller whose own ctx ends returns ctx.Err(). If the leader was
+// cancelled, a caller whose ctx is still live runs the work itself.
+func (g *flightGroup) Do(ctx context.Context, key string, fn func() (interface{}, error)) (val interface{}, shared bool, err error) {
+    for {
+        c, leader := g.begin(key)
+        if leader {
+            val, err = fn()
+            g.finish(key, c, val, err)
+            return val, false, err
+        }
+        val, err = c.wait(ctx)
+        if leaderCancelled(ctx, err) {
+            continue
+        }
+        return val, true, err
+    }
+}
+
+// begin registers the caller for key. It returns a new call and true if the
+// caller leads and must later call finish, or the running call and false.
+func (g *flightGroup) begin(key string) (*flightCall, bool) {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    if c, ok := g.calls[key]; ok {
+        return c, false
+    }
+    if g.calls == nil {
+        g.calls = map[string]*flightCall{}
+    }
+    c := &flightCall{done: make(chan struct{})}
+    g.calls[key] = c
+    return c, true
+}
+
+// finish publishes the leader's result and releases the waiters.
+func (g *flightGroup) finish(key string, c *flightCall, val interface{}, err error) {
+    g.mu.Lock()
+    delete(g.calls, key)
+    g.mu.Unlock()
+    c.val, c.err = val, err
+    close(c.done)
+}
+
+// wait blocks until the call finishes or ctx is done.
+func (c *flightCall) wait(ctx context.Context) (interface{}, error) {
+    select {
+    case <-c.done:
+        return c.val, c.err
+    case <-ctx.Done():
+        return nil, ctx.Err()
+    }
+}
+
+// leaderCancelled reports whether err is the leader's cancellation rather
+// than a failure the waiting caller (whose ctx is still live) should see.
+func leaderCancelled(ctx context.Context, err error) bool {
+    return ctx.Err() == nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded))
+}
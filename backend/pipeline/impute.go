@@ -0,0 +1,103 @@
+// backend/pipeline/impute.go
+package pipeline
+
+import (
+    "context"
+    "fmt"
+    "path/filepath"
+    "strings"
+
+    "github.com/adamwestgate/easy-pgs/backend/config"
+    "github.com/adamwestgate/easy-pgs/backend/preprocessing/scoring"
+)
+
+// Missing-genotype handling modes.
+const (
+    ImputeReference = "reference" // mean-impute from the chip's reference panel frequencies
+    ImputeNone      = "none"      // leave missing calls out (plink2 no-mean-imputation)
+    ImputeAncestry  = "ancestry"  // mean-impute from one 1000G superpopulation's frequencies
+)
+
+// superPops are the 1000 Genomes superpopulations ImputeAncestry accepts.
+var superPops = map[string]bool{"AFR": true, "AMR": true, "EAS": true, "EUR": true, "SAS": true}
+
+// Imputation selects how a run scores missing genotypes. The kit and its
+// reference panel are always scored the same way, so z-scores stay comparable.
+// The zero value means ImputeReference.
+type Imputation struct {
+    Mode     string
+    SuperPop string // ImputeAncestry only, e.g. "EUR"
+}
+
+// ParseImputation parses "reference", "none" or "ancestry:<SUPERPOP>"; ""
+// is ImputeReference.
+func ParseImputation(s string) (Imputation, error) {
+    mode, pop, _ := strings.Cut(strings.TrimSpace(s), ":")
+    switch strings.ToLower(mode) {
+    case "", ImputeReference:
+        return Imputation{Mode: ImputeReference}, nil
+    case ImputeNone:
+        return Imputation{Mode: ImputeNone}, nil
+    case ImputeAncestry:
+        pop = strings.ToUpper(pop)
+        if !superPops[pop] {
+            return Imputation{}, fmt.Errorf("imputation %q: superpopulation must be one of AFR, AMR, EAS, EUR, SAS", s)
+        }
+        return Imputation{Mode: ImputeAncestry, SuperPop: pop}, nil
+    default:
+        return Imputation{}, fmt.Errorf("imputation %q: want reference, none or ancestry:<SUPERPOP>", s)
+    }
+}
+
+// String returns the form ParseImputation accepts, as recorded with a run.
+func (i Imputation) String() string {
+    switch i.Mode {
+    case ImputeNone:
+        return ImputeNone
+    case ImputeAncestry:
+        return ImputeAncestry + ":" + i.SuperPop
+    default:
+        return ImputeReference
+    }
+}
+
+// superPopFreqs shares concurrent builds of one superpopulation frequency
+// file, keyed by its path.
+var superPopFreqs flightGroup
+
+// FreqFile returns the allele frequencies a run under i imputes from (and
+// estimates unscored variants with), without building them.
+func (i Imputation) FreqFile(kitType string) string {
+    if i.Mode == ImputeAncestry {
+        return filepath.Join(config.FreqCacheDir, filepath.Base(PanelDir(kitType)), i.SuperPop+".afreq")
+    }
+    return scoring.RefFreqFor(kitType)
+}
+
+// ScoringOptions returns the missing-genotype options of a run under i, for
+// scoring calls outside a run (such as per-variant contributions), building
+// the superpopulation frequencies on first use.
+func (i Imputation) ScoringOptions(ctx context.Context, kitType string) (scoring.Options, error) {
+    var opt scoring.Options
+    err := i.apply(ctx, kitType, &opt)
+    return opt, err
+}
+
+// apply sets the scoring options for i, building the superpopulation
+// frequencies on first use.
+func (i Imputation) apply(ctx context.Context, kitType string, opt *scoring.Options) error {
+    switch i.Mode {
+    case ImputeNone:
+        opt.NoMeanImpute = true
+    case ImputeAncestry:
+        freq := i.FreqFile(kitType)
+        _, _, err := superPopFreqs.Do(ctx, freq, func() (interface{}, error) {
+            return nil, scoring.EnsureSuperPopFreqs(ctx, PanelDir(kitType), i.SuperPop, freq)
+        })
+        if err != nil {
+            return fmt.Errorf("%s frequencies: %w", i.SuperPop, err)
+        }
+        opt.FreqFile = freq
+    }
+    return nil
+}
@@ -0,0 +1,69 @@
+// Package pipeline is the scoring pipeline shared by the HTTP server and the
+// command-line tool: fetch and normalise PGS Catalog scoring files, score a
+// genotype fileset against them, score the matching reference panel on the
+// variants actually used, and turn the .sscore outputs into z-scores,
+// percentiles and coverage.
+package pipeline
+
+import (
+    "strings"
+
+    "github.com/adamwestgate/easy-pgs/backend/config"
+    "github.com/adamwestgate/easy-pgs/backend/data"
+    "github.com/adamwestgate/easy-pgs/backend/store"
+)
+
+// scoreIndex, when set, is updated with the variants of every normalised file.
+var scoreIndex store.ScoreIndex
+
+// SetScoreIndex sets the reverse variant index kept up to date by NormalizeScoreFile.
+func SetScoreIndex(si store.ScoreIndex) {
+    scoreIndex = si
+}
+
+// PanelDir returns the reference panel directory matching a kit type.
+func PanelDir(kitType string) string {
+    if strings.ToLower(kitType) == "23andme" {
+        return config.Reference23andmeDir
+    }
+    return config.ReferenceAncestryDir
+}
+
+// ManifestDir returns the chip manifest directory matching a kit type.
+func ManifestDir(kitType string) string {
+    if strings.ToLower(kitType) == "23andme" {
+        return config.ChipManifestV5Dir
+    }
+    return config.ChipManifestAncestryDir
+}
+
+// CanonicalID strips any suffix after the first "." in a PGS ID string.
+func CanonicalID(raw string) string {
+    if dot := strings.IndexByte(raw, '.'); dot >= 0 {
+        return raw[:dot]
+    }
+    return raw
+}
+
+// TraitLabel looks up a PGS ID in loaded data to find its reported trait label.
+func TraitLabel(pgsID string) string {
+    idClean := CanonicalID(pgsID)
+    for _, m := range data.LoadedScores {
+        if id, _ := m["Polygenic Score (PGS) ID"].(string); id == idClean {
+            if trait, ok := m["Reported Trait"].(string); ok {
+                return trait
+            }
+            if trait, ok := m["Reported trait"].(string); ok {
+                return trait
+            }
+        }
+    }
+    for _, tr := range data.LoadedTraits {
+        for _, pid := range tr.PGSFiles {
+            if pid == idClean {
+                return tr.Label
+            }
+        }
+    }
+    return ""
+}
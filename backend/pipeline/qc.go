@@ -0,0 +1,90 @@
+// backend/pipeline/qc.go
+package pipeline
+
+import (
+    "fmt"
+    "path/filepath"
+    "strings"
+
+    "github.com/adamwestgate/easy-pgs/backend/preprocessing/kit_convert"
+    "github.com/adamwestgate/easy-pgs/backend/preprocessing/pgen"
+)
+
+// KitQC summarises the genotype calls of a single-sample kit fileset.
+type KitQC struct {
+    KitType          string         `json:"kitType"`
+    Variants         int            `json:"variants"`         // variants in the converted kit
+    ManifestVariants int            `json:"manifestVariants"` // variants on the chip manifest
+    Called           int            `json:"called"`           // non-missing calls
+    CallRate         float64        `json:"callRate"`         // Called / Variants
+    ManifestRate     float64        `json:"manifestRate"`     // Variants / ManifestVariants
+    Heterozygosity   float64        `json:"heterozygosity"`   // het calls / autosomal calls
+    ChrXHet          float64        `json:"chrXHet"`          // het calls / chrX calls
+    InferredSex      string         `json:"inferredSex"`      // "male", "female" or "" when unclear
+    ByChrom          map[string]int `json:"byChrom"`
+}
+
+// QC reads the first sample of the fileset in pfileDir and summarises its
+// call rate, heterozygosity and chromosome coverage against the chip manifest.
+func QC(pfileDir, kitType string) (KitQC, error) {
+    q := KitQC{KitType: kitType, ByChrom: map[string]int{}}
+
+    pgens, _ := filepath.Glob(filepath.Join(pfileDir, "*.pgen"))
+    if len(pgens) == 0 {
+        return q, fmt.Errorf("no .pgen in %s", pfileDir)
+    }
+    pf, err := pgen.OpenPfile(strings.TrimSuffix(pgens[0], ".pgen"))
+    if err != nil {
+        return q, err
+    }
+    defer pf.Close()
+    if pf.NumSamples() == 0 {
+        return q, fmt.Errorf("%s: no samples", pgens[0])
+    }
+
+    var auto, autoHet, x, xHet int
+    var geno []byte
+    for i, v := range pf.Variants {
+        geno, err = pf.Genotypes(i, geno)
+        if err != nil {
+            return q, err
+        }
+        q.Variants++
+        q.ByChrom[v.Chr]++
+        g := geno[0]
+        if g == pgen.Missing {
+            continue
+        }
+        q.Called++
+        switch v.Chr {
+        case "X", "23":
+            x++
+            if g == 1 {
+                xHet++
+            }
+        case "Y", "24", "MT", "26", "XY", "25":
+        default:
+            auto++
+            if g == 1 {
+                autoHet++
+            }
+        }
+    }
+
+    if q.Variants > 0 {
+        q.CallRate = float64(q.Called) / float64(q.Variants)
+    }
+    if auto > 0 {
+        q.Heterozygosity = float64(autoHet) / float64(auto)
+    }
+    if x > 0 {
+        q.ChrXHet = float64(xHet) / float64(x)
+        // the rule ConvertFileToPgen writes into the kit's .psam
+        q.InferredSex = kit_convert.SexFromXHet(x, xHet)
+    }
+    if n := countLines(filepath.Join(ManifestDir(kitType), strings.ToLower(kitType)+".snplist")); n > 0 {
+        q.ManifestVariants = n
+        q.ManifestRate = float64(q.Variants) / float64(n)
+    }
+    return q, nil
+}
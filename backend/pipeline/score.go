@@ -0,0 +1,465 @@
+// backend/pipeline/score.go
+package pipeline
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "io"
+    "log"
+    "math"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+
+    "github.com/adamwestgate/easy-pgs/backend/config"
+    "github.com/adamwestgate/easy-pgs/backend/events"
+    "github.com/adamwestgate/easy-pgs/backend/preprocessing/scoring"
+    "github.com/adamwestgate/easy-pgs/backend/store"
+)
+
+// Results holds the per-PGS scoring outputs of a fileset and of its
+// reference panel, keyed by canonical PGS ID.
+type Results struct {
+    User map[string]scoring.BatchResult `json:"user"`
+    Pop  map[string]scoring.BatchResult `json:"pop"`
+
+    // how the run was scored, for Flatten's unscored-variant estimates
+    KitType    string     `json:"-"`
+    KitDir     string     `json:"-"`
+    Imputation Imputation `json:"-"`
+    KitPCs     []float64  `json:"-"` // the kit's projected PCs, for ancestry-adjusted z-scores
+}
+
+// ScoreKit performs batch scoring of a fileset against the given PGS weight files.
+// 1. Score the fileset & get the list of snps scored
+// 2. Score the population on the list of snps scored in the fileset
+// Missing genotypes are handled as imp says, for both. All outputs are
+// written to ws. Scoring stops when ctx is done; progress is reported to ev
+// (may be nil).
+func ScoreKit(ctx context.Context, pfileDir, kitType string, norm []string, imp Imputation, ws *Workspace, ev events.Emitter) *Results {
+    user := ScoreUser(ctx, pfileDir, kitType, norm, imp, ws, ev)
+    return &Results{
+        User:       user,
+        Pop:        ScorePopulation(ctx, kitType, user, imp, ws, ev),
+        KitType:    kitType,
+        KitDir:     pfileDir,
+        Imputation: imp,
+    }
+}
+
+// ScoreUser scores the fileset in pfileDir against the normalized weight
+// files, handling missing genotypes as imp says and writing to
+// ws.UserDir(). Returns the per-PGS results keyed by canonical ID.
+func ScoreUser(ctx context.Context, pfileDir, kitType string, norm []string, imp Imputation, ws *Workspace, ev events.Emitter) map[string]scoring.BatchResult {
+    opt := scoring.Options{
+        Native: config.NativeUserScoring,
+        Events: ev.ForStage("user"),
+        OutDir: ws.UserDir(),
+    }
+    if err := imp.apply(ctx, kitType, &opt); err != nil {
+        user := make(map[string]scoring.BatchResult, len(norm))
+        for _, p := range norm {
+            user[CanonicalID(filepath.Base(p))] = scoring.BatchResult{Err: err}
+        }
+        return user
+    }
+    userRaw := scoring.BatchScoreOpts(ctx, pfileDir, kitType, norm, "", opt)
+    user := make(map[string]scoring.BatchResult, len(userRaw))
+    for k, v := range userRaw {
+        user[CanonicalID(k)] = v
+    }
+    return user
+}
+
+// ScorePopulation scores the chip's reference panel on the variants each
+// user score actually used (its .sscore.vars), writing to ws.PopDir(). The
+// panel directory itself is only read. With config.CachedPopulationScoring
+// the panel is scored from its cached dosage matrix for the PGS (built on
+// first use) unless imp imputes from superpopulation frequencies; scores
+// without one go through the regular engine. A PGS whose
+// panel scoring on the same variants is already running for another job
+// waits for and copies that result. Scoring stops when ctx is done; progress
+// is reported to ev (may be nil).
+func ScorePopulation(ctx context.Context, kitType string, user map[string]scoring.BatchResult, imp Imputation, ws *Workspace, ev events.Emitter) map[string]scoring.BatchResult {
+    weights := map[string]string{}
+    extract := map[string]string{}
+    for id, br := range user {
+        if br.Err != nil || br.ScorePath == "" {
+            continue
+        }
+        // the rsID-mapped score file written next to the user's .sscore
+        rsidScore := filepath.Join(filepath.Dir(br.ScorePath), id+".norm.rsid.score")
+        if _, err := os.Stat(rsidScore); err != nil {
+            continue
+        }
+        weights[id] = rsidScore
+        if vars := br.ScorePath + ".vars"; fileExists(vars) {
+            extract[id] = vars
+        }
+    }
+
+    // lead the panel scoring of each PGS, or follow a job already doing it
+    keys := map[string]string{}
+    lead := map[string]*flightCall{}
+    follow := map[string]*flightCall{}
+    mine := map[string]string{}
+    for id, p := range weights {
+        key := populationKey(kitType, imp, p, extract[id])
+        if key == "" {
+            mine[id] = p
+            continue
+        }
+        keys[id] = key
+        if c, leader := populations.begin(key); leader {
+            lead[id] = c
+            mine[id] = p
+        } else {
+            follow[id] = c
+        }
+    }
+
+    pop := scorePanel(ctx, kitType, mine, extract, imp, ws, ev)
+    for id, c := range lead {
+        out, err := readPanelOutput(pop[id])
+        populations.finish(keys[id], c, out, err)
+    }
+
+    retry := map[string]string{}
+    for id, c := range follow {
+        val, err := c.wait(ctx)
+        if leaderCancelled(ctx, err) {
+            retry[id] = weights[id]
+            continue
+        }
+        if err != nil {
+            pop[id] = scoring.BatchResult{Err: err}
+            continue
+        }
+        pop[id] = val.(*panelOutput).writeTo(ws.PopDir())
+    }
+    for id, br := range scorePanel(ctx, kitType, retry, extract, imp, ws, ev) {
+        pop[id] = br
+    }
+    return pop
+}
+
+// populations de-duplicates concurrent panel scoring of the same PGS on the
+// same variants; see populationKey.
+var populations flightGroup
+
+// populationKey identifies a panel scoring run by kit type, imputation and
+// the contents of its weight file and variant list. Runs without a variant
+// list are not shared and get "".
+func populationKey(kitType string, imp Imputation, weights, extract string) string {
+    if extract == "" {
+        return ""
+    }
+    h := sha256.New()
+    for _, p := range []string{weights, extract} {
+        f, err := os.Open(p)
+        if err != nil {
+            return ""
+        }
+        _, err = io.Copy(h, f)
+        f.Close()
+        if err != nil {
+            return ""
+        }
+        h.Write([]byte{0})
+    }
+    return strings.ToLower(kitType) + "/" + imp.String() + "/" + hex.EncodeToString(h.Sum(nil))
+}
+
+// panelOutput is a finished panel scoring run, held in memory so another
+// job can copy it after the leader's workspace is gone.
+type panelOutput struct {
+    name         string // base name of the .sscore
+    sscore, vars []byte
+}
+
+// readPanelOutput loads the .sscore and .sscore.vars of br.
+func readPanelOutput(br scoring.BatchResult) (*panelOutput, error) {
+    if br.Err != nil {
+        return nil, br.Err
+    }
+    if br.ScorePath == "" {
+        return nil, errors.New("no population score")
+    }
+    sscore, err := os.ReadFile(br.ScorePath)
+    if err != nil {
+        return nil, err
+    }
+    vars, err := os.ReadFile(br.ScorePath + ".vars")
+    if err != nil {
+        return nil, err
+    }
+    return &panelOutput{name: filepath.Base(br.ScorePath), sscore: sscore, vars: vars}, nil
+}
+
+// writeTo writes the output into dir and returns it as a BatchResult.
+func (o *panelOutput) writeTo(dir string) scoring.BatchResult {
+    path := filepath.Join(dir, o.name)
+    if err := os.MkdirAll(dir, 0o755); err != nil {
+        return scoring.BatchResult{Err: err}
+    }
+    if err := os.WriteFile(path, o.sscore, 0o644); err != nil {
+        return scoring.BatchResult{Err: err}
+    }
+    if err := os.WriteFile(path+".vars", o.vars, 0o644); err != nil {
+        return scoring.BatchResult{Err: err}
+    }
+    return scoring.BatchResult{ScorePath: path}
+}
+
+// scorePanel scores the reference panel against the weight files (PGS ID →
+// path), each restricted to its extract list, from the dosage matrices where
+// possible and with the configured engine otherwise. The matrices hold
+// reference-frequency imputed dosages, so ancestry imputation skips them.
+func scorePanel(ctx context.Context, kitType string, weights, extract map[string]string, imp Imputation, ws *Workspace, ev events.Emitter) map[string]scoring.BatchResult {
+    pop := map[string]scoring.BatchResult{}
+    if len(weights) == 0 {
+        return pop
+    }
+    opt := scoring.Options{
+        Native:  config.NativePopulationScoring,
+        Events:  ev.ForStage("population"),
+        OutDir:  ws.PopDir(),
+        Extract: extract,
+    }
+    if err := imp.apply(ctx, kitType, &opt); err != nil {
+        for id := range weights {
+            pop[id] = scoring.BatchResult{Err: err}
+        }
+        return pop
+    }
+    if config.CachedPopulationScoring && imp.Mode != ImputeAncestry {
+        pop = scoreFromMatrices(ctx, kitType, pick(extract, weights), opt.NoMeanImpute, ws, ev.ForStage("population"))
+    }
+    var popWeights []string
+    for id, p := range weights {
+        if _, done := pop[id]; !done {
+            popWeights = append(popWeights, p)
+        }
+    }
+    sort.Strings(popWeights)
+
+    popRaw := scoring.BatchScoreOpts(ctx, PanelDir(kitType), kitType, popWeights, "", opt)
+    for k, v := range popRaw {
+        pop[CanonicalID(k)] = v
+    }
+    return pop
+}
+
+// pick returns the entries of m whose key is in keep.
+func pick(m, keep map[string]string) map[string]string {
+    out := make(map[string]string, len(keep))
+    for k := range keep {
+        if v, ok := m[k]; ok {
+            out[k] = v
+        }
+    }
+    return out
+}
+
+// matrices shares concurrent builds of one dosage matrix, keyed by its path,
+// so builds of different matrices run in parallel.
+var matrices flightGroup
+
+// DosageMatrixPath returns where the reference panel dosages of pgsID for
+// kitType's panel are cached.
+func DosageMatrixPath(kitType, pgsID string) string {
+    return filepath.Join(config.DosageCacheDir, filepath.Base(PanelDir(kitType)), pgsID+".dosage")
+}
+
+// scoreFromMatrices scores the reference panel from the cached dosage
+// matrix of each PGS in extract, building missing matrices from the
+// normalized score files; noImpute leaves missing calls out. Only successes
+// are returned; the caller scores the rest the slow way.
+func scoreFromMatrices(ctx context.Context, kitType string, extract map[string]string, noImpute bool, ws *Workspace, ev events.Emitter) map[string]scoring.BatchResult {
+    ids := make([]string, 0, len(extract))
+    for id := range extract {
+        ids = append(ids, id)
+    }
+    sort.Strings(ids)
+
+    out := map[string]scoring.BatchResult{}
+    total := int64(len(ids))
+    for i, id := range ids {
+        if ctx.Err() != nil {
+            break
+        }
+        norm := NormalizedFile(id)
+        if norm == "" {
+            continue
+        }
+        matrix := DosageMatrixPath(kitType, id)
+        _, _, err := matrices.Do(ctx, matrix, func() (interface{}, error) {
+            return nil, scoring.EnsureDosageMatrix(ctx, PanelDir(kitType), kitType, norm, matrix)
+        })
+        if err != nil {
+            log.Printf("ScorePopulation: dosage matrix for %s: %v", id, err)
+            continue
+        }
+        pev := ev.ForPGS(id)
+        pev.Emit(events.Event{Type: events.ScoreStart, Done: int64(i + 1), Total: total})
+        sscore, err := scoring.ScoreDosageMatrix(matrix, extract[id], filepath.Join(ws.PopDir(), id+".norm.rsid"), noImpute)
+        if err != nil {
+            log.Printf("ScorePopulation: scoring %s from its dosage matrix: %v", id, err)
+            continue
+        }
+        pev.Emit(events.Event{Type: events.ScoreDone, Done: int64(i + 1), Total: total})
+        out[id] = scoring.BatchResult{ScorePath: sscore}
+    }
+    return out
+}
+
+// fileExists reports whether path exists.
+func fileExists(path string) bool {
+    _, err := os.Stat(path)
+    return err == nil
+}
+
+// Flatten turns Results into the per-PGS values shown on the results page:
+// population mean, user score, z-score, percentile, trait label and
+// coverage. It also returns the raw user and population .sscore stats.
+func Flatten(r *Results) (store.Results, map[string]store.ScoreStats, map[string]store.ScoreStats) {
+    flat := store.Results{
+        Population:    map[string]float64{},
+        User:          map[string]float64{},
+        Z:             map[string]float64{},
+        Pct:           map[string]float64{},
+        Trait:         map[string]string{},
+        PctSnpsScored: map[string]float64{}, // "Coverage" on the results page
+    }
+
+    // a) population means & SDs
+    popStats := map[string]store.ScoreStats{}
+    userStats := map[string]store.ScoreStats{}
+    for id, br := range r.Pop {
+        if br.Err == nil && br.ScorePath != "" {
+            if st, err := ParseSscoreStats(br.ScorePath); err == nil {
+                popStats[id] = st
+                flat.Population[id] = st.Mean
+            }
+        }
+        if _, exist := flat.Trait[id]; !exist {
+            flat.Trait[id] = TraitLabel(id)
+        }
+    }
+
+    // b) user + z / pct
+    for id, br := range r.User {
+        if br.Err == nil && br.ScorePath != "" {
+            if stU, err := ParseSscoreStats(br.ScorePath); err == nil {
+                userStats[id] = stU
+                flat.User[id] = stU.Mean
+                if stP, ex := popStats[id]; ex && stP.SD > 0 {
+                    z := (stU.Mean - stP.Mean) / stP.SD
+                    flat.Z[id] = z
+                    flat.Pct[id] = Percentile(z)
+                }
+            }
+        }
+        if _, exist := flat.Trait[id]; !exist {
+            flat.Trait[id] = TraitLabel(id)
+        }
+    }
+
+    // b2) empirical percentiles per superpopulation and sex
+    if r.KitType != "" {
+        if samples, err := panelSamples(r.KitType); err != nil {
+            log.Printf("Flatten: reference samples: %v", err)
+        } else {
+            flat.Groups = map[string]map[string]store.GroupStats{}
+            flat.Comparison = ComparisonAll
+            for id, user := range flat.User {
+                pb := r.Pop[id]
+                if pb.Err != nil || pb.ScorePath == "" {
+                    continue
+                }
+                if g, err := stratify(pb.ScorePath, samples, user); err == nil {
+                    flat.Groups[id] = g
+                }
+            }
+        }
+    }
+
+    // b3) ancestry-adjusted z / pct
+    if len(r.KitPCs) > 0 && r.KitType != "" {
+        flat.ZAdjusted = map[string]float64{}
+        flat.PctAdjusted = map[string]float64{}
+        for id, user := range flat.User {
+            pb := r.Pop[id]
+            if pb.Err != nil || pb.ScorePath == "" {
+                continue
+            }
+            z, err := AdjustedZ(r.KitType, pb.ScorePath, user, r.KitPCs)
+            if err != nil {
+                log.Printf("Flatten: adjusting %s for ancestry: %v", id, err)
+                continue
+            }
+            flat.ZAdjusted[id] = z
+            flat.PctAdjusted[id] = Percentile(z)
+        }
+    }
+
+    // c) cleanup NaN/Inf
+    for _, m := range []map[string]float64{flat.Population, flat.User, flat.Z, flat.Pct, flat.ZAdjusted, flat.PctAdjusted} {
+        for k, v := range m {
+            if math.IsNaN(v) || math.IsInf(v, 0) {
+                delete(m, k)
+            }
+        }
+    }
+
+    // d) percent SNPs scored
+    for id := range flat.User {
+        snpList := r.User[id].ScorePath + ".vars"
+        tsv := filepath.Join(config.PGSFilesDir, id, id+".norm.tsv")
+        flat.PctSnpsScored[id] = SNPRetentionPercent(snpList, tsv)
+    }
+
+    // e) expected contribution of the unscored variants
+    if r.KitDir != "" {
+        flat.Imputation = r.Imputation.String()
+        flat.UnscoredMean = map[string]float64{}
+        flat.UnscoredSD = map[string]float64{}
+        flat.UnscoredShare = map[string]float64{}
+        freq := r.Imputation.FreqFile(r.KitType)
+        for id := range flat.User {
+            u, err := unscoredOnUserScale(r, id, freq)
+            if err != nil {
+                log.Printf("Flatten: unscored variants of %s: %v", id, err)
+                continue
+            }
+            flat.UnscoredMean[id], flat.UnscoredSD[id], flat.UnscoredShare[id] = u.Mean, u.SD, u.Share
+        }
+    }
+    return flat, userStats, popStats
+}
+
+// unscoredOnUserScale estimates the unscored variants of id, scaled like the
+// user's score (SCORE1_SUM over ALLELE_CT).
+func unscoredOnUserScale(r *Results, id, freq string) (scoring.Unscored, error) {
+    sscore := r.User[id].ScorePath
+    tsv := filepath.Join(config.PGSFilesDir, id, id+".norm.tsv")
+    u, err := scoring.UnscoredContribution(tsv, r.KitDir, PanelDir(r.KitType), freq, sscore+".vars")
+    if err != nil {
+        return u, err
+    }
+    samples, err := ReadSampleScores(sscore)
+    if err != nil || len(samples) == 0 || samples[0].AlleleCt <= 0 {
+        return u, errors.New("no allele count")
+    }
+    u.Mean /= samples[0].AlleleCt
+    u.SD /= samples[0].AlleleCt
+    return u, nil
+}
+
+// Percentile converts a z-score to a standard normal percentile in [0, 1].
+func Percentile(z float64) float64 {
+    return 0.5 * (1 + math.Erf(z/math.Sqrt2))
+}
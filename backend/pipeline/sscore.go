@@ -0,0 +1,180 @@
+// backend/pipeline/sscore.go
+package pipeline
+
+import (
+    "bufio"
+    "errors"
+    "math"
+    "os"
+    "strconv"
+    "strings"
+
+    "github.com/adamwestgate/easy-pgs/backend/store"
+)
+
+// SampleScore is one sample's row of a .sscore file.
+type SampleScore struct {
+    IID      string
+    AlleleCt float64
+    Avg      float64 // SCORE1_AVG, or SCORE1_SUM / ALLELE_CT
+    Sum      float64 // SCORE1_SUM, or 0 when absent
+}
+
+// ReadSampleScores reads every sample of a .sscore file, in file order.
+// It looks for columns SCORE1_AVG (preferred) or SCORE1_SUM/ALLELE_CT.
+func ReadSampleScores(path string) ([]SampleScore, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    sc := bufio.NewScanner(f)
+    if !sc.Scan() {
+        return nil, errors.New("empty .sscore")
+    }
+    header := strings.Fields(sc.Text())
+
+    idxIID, idxAvg, idxSum, idxCt := -1, -1, -1, -1
+    for i, h := range header {
+        switch strings.TrimPrefix(h, "#") {
+        case "IID":
+            idxIID = i
+        case "SCORE1_AVG":
+            idxAvg = i
+        case "SCORE1_SUM":
+            idxSum = i
+        case "ALLELE_CT":
+            idxCt = i
+        }
+    }
+    if idxAvg == -1 && (idxSum == -1 || idxCt == -1) {
+        return nil, errors.New("missing columns")
+    }
+
+    num := func(fields []string, i int) (float64, bool) {
+        if i < 0 || i >= len(fields) {
+            return 0, false
+        }
+        v, err := strconv.ParseFloat(fields[i], 64)
+        return v, err == nil
+    }
+
+    var out []SampleScore
+    for sc.Scan() {
+        fields := strings.Fields(sc.Text())
+        var s SampleScore
+        if idxIID >= 0 && idxIID < len(fields) {
+            s.IID = fields[idxIID]
+        }
+        s.AlleleCt, _ = num(fields, idxCt)
+        s.Sum, _ = num(fields, idxSum)
+        if v, ok := num(fields, idxAvg); ok {
+            s.Avg = v
+        } else if s.AlleleCt != 0 && idxSum >= 0 {
+            s.Avg = s.Sum / s.AlleleCt
+        } else {
+            continue
+        }
+        out = append(out, s)
+    }
+    return out, sc.Err()
+}
+
+// histBins is the number of bins of ScoreStats.Hist.
+const histBins = 40
+
+// ParseSscoreStats reads a .sscore file at the given path and computes the
+// mean, SD and histogram of per-variant averages.
+func ParseSscoreStats(path string) (store.ScoreStats, error) {
+    samples, err := ReadSampleScores(path)
+    if err != nil {
+        return store.ScoreStats{}, err
+    }
+    if len(samples) == 0 {
+        return store.ScoreStats{}, errors.New("no rows")
+    }
+
+    var sum, sumSq float64
+    for _, s := range samples {
+        sum += s.Avg
+        sumSq += s.Avg * s.Avg
+    }
+    n := float64(len(samples))
+    mean := sum / n
+    var sd float64
+    if len(samples) > 1 {
+        variance := (sumSq - sum*sum/n) / (n - 1)
+        if variance > 0 {
+            sd = math.Sqrt(variance)
+        }
+    }
+    st := store.ScoreStats{Mean: mean, SD: sd}
+    if sd > 0 {
+        st.Hist = make([]int, histBins)
+        for _, s := range samples {
+            z := (s.Avg - mean) / sd
+            if math.IsNaN(z) {
+                continue
+            }
+            b := int(math.Floor((z + store.HistRange) / (2 * store.HistRange) * histBins))
+            if b < 0 {
+                b = 0
+            } else if b >= histBins {
+                b = histBins - 1
+            }
+            st.Hist[b]++
+        }
+    }
+    return st, nil
+}
+
+// SNPRetentionPercent calculates the percentage of SNPs in snpList versus lines in TSV (minus header). This is to get the Coverage stat for the results page.
+func SNPRetentionPercent(snplistPath, tsvPath string) float64 {
+    snpCount := countLines(snplistPath)
+    tsvCount := countLines(tsvPath)
+    if snpCount < 0 || tsvCount <= 1 {
+        return 0
+    }
+    return float64(snpCount) / float64(tsvCount-1) * 100.0
+}
+
+// CountDataLines counts the lines of a normalised score file minus its header.
+func CountDataLines(path string) int {
+    if n := countLines(path); n > 0 {
+        return n - 1
+    }
+    return 0
+}
+
+// countLines returns the number of lines in path, or -1 if it cannot be read.
+func countLines(path string) int {
+    f, err := os.Open(path)
+    if err != nil {
+        return -1
+    }
+    defer f.Close()
+    n := 0
+    sc := bufio.NewScanner(f)
+    for sc.Scan() {
+        n++
+    }
+    return n
+}
+
+// ReadIDList reads a one-ID-per-line file such as .sscore.vars.
+func ReadIDList(path string) (map[string]bool, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    set := map[string]bool{}
+    sc := bufio.NewScanner(f)
+    for sc.Scan() {
+        if id := strings.TrimSpace(sc.Text()); id != "" {
+            set[id] = true
+        }
+    }
+    return set, sc.Err()
+}
@@ -0,0 +1,99 @@
+// backend/pipeline/strata.go
+package pipeline
+
+import (
+    "math"
+    "sort"
+    "strings"
+
+    "github.com/adamwestgate/easy-pgs/backend/preprocessing/pgen"
+    "github.com/adamwestgate/easy-pgs/backend/store"
+)
+
+// ComparisonAll is the comparison group of every reference sample pooled.
+const ComparisonAll = "ALL"
+
+// ComparisonGroup returns the key of a reference comparison group:
+// ComparisonAll or a superpopulation code, optionally followed by ":" and a
+// sex, e.g. "EUR", "EUR:female", "ALL:male".
+func ComparisonGroup(superPop, sex string) string {
+    if superPop == "" {
+        superPop = ComparisonAll
+    }
+    if sex == "" {
+        return superPop
+    }
+    return superPop + ":" + sex
+}
+
+// panelSamples reads the .psam of kitType's reference panel, keyed by IID.
+func panelSamples(kitType string) (map[string]pgen.Sample, error) {
+    prefix, err := pfilePrefix(PanelDir(kitType))
+    if err != nil {
+        return nil, err
+    }
+    samples, err := pgen.ReadPsam(prefix + ".psam")
+    if err != nil {
+        return nil, err
+    }
+    out := make(map[string]pgen.Sample, len(samples))
+    for _, s := range samples {
+        out[s.IID] = s
+    }
+    return out, nil
+}
+
+// EmpiricalPercentile returns the share (0–1) of sorted below x, counting
+// ties as half, so the percentile follows the reference distribution
+// whatever its shape.
+func EmpiricalPercentile(sorted []float64, x float64) float64 {
+    if len(sorted) == 0 {
+        return math.NaN()
+    }
+    below := sort.SearchFloat64s(sorted, x)
+    above := sort.Search(len(sorted), func(i int) bool { return sorted[i] > x })
+    return (float64(below) + float64(above-below)/2) / float64(len(sorted))
+}
+
+// stratify places user within the panel scores of popSscore, for all
+// samples and per superpopulation, each also split by sex. Groups without
+// samples are left out.
+func stratify(popSscore string, samples map[string]pgen.Sample, user float64) (map[string]store.GroupStats, error) {
+    scores, err := ReadSampleScores(popSscore)
+    if err != nil {
+        return nil, err
+    }
+    groups := map[string][]float64{}
+    for _, sc := range scores {
+        s := samples[sc.IID]
+        pops := []string{ComparisonAll}
+        if sp := strings.ToUpper(s.SuperPop()); sp != "" {
+            pops = append(pops, sp)
+        }
+        for _, pop := range pops {
+            groups[ComparisonGroup(pop, "")] = append(groups[ComparisonGroup(pop, "")], sc.Avg)
+            if sex := s.Sex(); sex != "" {
+                groups[ComparisonGroup(pop, sex)] = append(groups[ComparisonGroup(pop, sex)], sc.Avg)
+            }
+        }
+    }
+
+    out := make(map[string]store.GroupStats, len(groups))
+    for key, vals := range groups {
+        sort.Float64s(vals)
+        var sum, sumSq float64
+        for _, v := range vals {
+            sum += v
+            sumSq += v * v
+        }
+        n := float64(len(vals))
+        g := store.GroupStats{N: len(vals), Mean: sum / n, Pct: EmpiricalPercentile(vals, user)}
+        if len(vals) > 1 {
+            if variance := (sumSq - sum*sum/n) / (n - 1); variance > 0 {
+                g.SD = math.Sqrt(variance)
+            }
+        }
+        out[key] = g
+    }
+    return out, nil
+}
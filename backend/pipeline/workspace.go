@@ -0,0 +1,66 @@
+// backend/pipeline/workspace.go
+package pipeline
+
+import (
+    "log"
+    "os"
+    "path/filepath"
+    "time"
+
+    "github.com/adamwestgate/easy-pgs/backend/config"
+)
+
+// Workspace is the private working directory of one scoring run. Everything
+// a run writes (rsID-mapped score files, .sscore outputs and the variant
+// lists the reference panel is restricted to) goes here, so concurrent runs
+// never share files and the reference panels are only ever read.
+//
+// Cleanup policy: a run removes its workspace once its results are stored;
+// failed and cancelled runs leave theirs for inspection, and SweepWorkspaces
+// removes any workspace older than config.WorkspaceRetentionHours.
+type Workspace struct {
+    Dir string
+}
+
+// NewWorkspace creates a fresh workspace under config.WorkspaceDir whose
+// name starts with label (e.g. a job ID).
+func NewWorkspace(label string) (*Workspace, error) {
+    if err := os.MkdirAll(config.WorkspaceDir, 0o755); err != nil {
+        return nil, err
+    }
+    dir, err := os.MkdirTemp(config.WorkspaceDir, label+"-")
+    if err != nil {
+        return nil, err
+    }
+    return &Workspace{Dir: dir}, nil
+}
+
+// UserDir holds the outputs of scoring the kit (or cohort) itself.
+func (w *Workspace) UserDir() string { return filepath.Join(w.Dir, "user") }
+
+// PopDir holds the outputs of scoring the reference panel.
+func (w *Workspace) PopDir() string { return filepath.Join(w.Dir, "pop") }
+
+// Remove deletes the workspace and everything in it.
+func (w *Workspace) Remove() error {
+    return os.RemoveAll(w.Dir)
+}
+
+// SweepWorkspaces removes every workspace not modified for maxAge.
+func SweepWorkspaces(maxAge time.Duration) {
+    entries, err := os.ReadDir(config.WorkspaceDir)
+    if err != nil {
+        return
+    }
+    cutoff := time.Now().Add(-maxAge)
+    for _, e := range entries {
+        info, err := e.Info()
+        if err != nil || !e.IsDir() || info.ModTime().After(cutoff) {
+            continue
+        }
+        dir := filepath.Join(config.WorkspaceDir, e.Name())
+        if err := os.RemoveAll(dir); err != nil {
+            log.Printf("SweepWorkspaces: removing %s: %v", dir, err)
+        }
+    }
+}
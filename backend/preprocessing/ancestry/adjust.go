@@ -0,0 +1,134 @@
+package ancestry
+
+import (
+    "errors"
+    "fmt"
+    "math"
+)
+
+// Adjustment removes the drift of a score's mean and variance along the
+// principal components, as in pgsc_calc: the reference scores are regressed
+// on the first PCs (ordinary least squares for the mean, a Gamma GLM with log
+// link on the squared residuals for the variance), and a sample is placed by
+// its residual over the SD predicted at its own PCs.
+type Adjustment struct {
+    PCs  int       // principal components used
+    Mean []float64 // intercept, then one coefficient per PC
+    Var  []float64 // log-variance intercept and coefficients
+}
+
+// maxGLMIterations bounds the IRLS fit of the variance model.
+const maxGLMIterations = 50
+
+// FitAdjustment fits the mean and variance models on the references that
+// have a score (IID → score), using their first pcs components.
+func FitAdjustment(refs []Reference, scores map[string]float64, pcs int) (*Adjustment, error) {
+    var (
+        x [][]float64
+        y []float64
+    )
+    for _, r := range refs {
+        s, ok := scores[r.IID]
+        if !ok || len(r.PCs) < pcs || math.IsNaN(s) {
+            continue
+        }
+        x = append(x, design(r.PCs, pcs))
+        y = append(y, s)
+    }
+    if len(y) < pcs+10 {
+        return nil, fmt.Errorf("only %d scored reference samples", len(y))
+    }
+    a := &Adjustment{PCs: pcs}
+    var err error
+    if a.Mean, err = ols(x, y); err != nil {
+        return nil, err
+    }
+
+    // Gamma GLM, log link, on squared residuals; with this link the IRLS
+    // weights are 1, so each step is an OLS fit of the working response
+    sq := make([]float64, len(y))
+    var mean float64
+    for i := range y {
+        r := y[i] - dot(a.Mean, x[i])
+        sq[i] = r * r
+        mean += sq[i] / float64(len(y))
+    }
+    if mean == 0 {
+        return nil, errors.New("reference scores do not vary")
+    }
+    for i := range sq {
+        sq[i] = math.Max(sq[i], mean*1e-9) // Gamma needs y > 0
+    }
+    a.Var = make([]float64, pcs+1)
+    a.Var[0] = math.Log(mean)
+    z := make([]float64, len(sq))
+    for it := 0; it < maxGLMIterations; it++ {
+        for i := range sq {
+            eta := dot(a.Var, x[i])
+            mu := math.Exp(eta)
+            z[i] = eta + (sq[i]-mu)/mu
+        }
+        next, err := ols(x, z)
+        if err != nil {
+            return nil, err
+        }
+        change := 0.0
+        for k := range next {
+            change = math.Max(change, math.Abs(next[k]-a.Var[k]))
+        }
+        a.Var = next
+        if change < 1e-8 {
+            break
+        }
+    }
+    return a, nil
+}
+
+// Z returns the adjusted z-score of score for a sample at pcs.
+func (a *Adjustment) Z(score float64, pcs []float64) float64 {
+    if len(pcs) < a.PCs {
+        return math.NaN()
+    }
+    row := design(pcs, a.PCs)
+    return (score - dot(a.Mean, row)) / math.Sqrt(math.Exp(dot(a.Var, row)))
+}
+
+// design returns the regression row [1, PC1, …, PCn].
+func design(pcs []float64, n int) []float64 {
+    return append([]float64{1}, pcs[:n]...)
+}
+
+func dot(a, b []float64) float64 {
+    var s float64
+    for i := range a {
+        s += a[i] * b[i]
+    }
+    return s
+}
+
+// ols solves the least-squares problem x·β ≈ y through the normal equations.
+func ols(x [][]float64, y []float64) ([]float64, error) {
+    k := len(x[0])
+    xtx := make([][]float64, k)
+    for i := range xtx {
+        xtx[i] = make([]float64, k)
+    }
+    xty := make([]float64, k)
+    for r, row := range x {
+        for i := range row {
+            xty[i] += row[i] * y[r]
+            for j := range row {
+                xtx[i][j] += row[i] * row[j]
+            }
+        }
+    }
+    inv, err := invert(xtx)
+    if err != nil {
+        return nil, err
+    }
+    beta := make([]float64, k)
+    for i := range inv {
+        beta[i] = dot(inv[i], xty)
+    }
+    return beta, nil
+}
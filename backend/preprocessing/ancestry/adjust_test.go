@@ -0,0 +1,71 @@
+package ancestry
+
+import (
+    "fmt"
+    "math"
+    "math/rand"
+    "testing"
+)
+
+// TestFitAdjustment simulates reference scores whose mean and log-variance
+// are linear in PC1 and unrelated to PC2, and checks that the fit recovers
+// both models and that the adjusted z-scores are standard normal on either
+// side of the PC1 range, where the raw scores differ in mean and spread.
+func TestFitAdjustment(t *testing.T) {
+    mean := []float64{2, 1.5, 0}                // intercept, PC1, PC2
+    logVar := []float64{math.Log(0.25), 0.8, 0} // intercept, PC1, PC2
+
+    rng := rand.New(rand.NewSource(7))
+    const n = 4000
+    refs := make([]Reference, n)
+    scores := make(map[string]float64, n)
+    for i := range refs {
+        pcs := []float64{2*rng.Float64() - 1, rng.NormFloat64(), rng.NormFloat64()}
+        refs[i] = Reference{IID: fmt.Sprintf("r%d", i), PCs: pcs}
+        row := design(pcs, 2)
+        scores[refs[i].IID] = dot(mean, row) + math.Sqrt(math.Exp(dot(logVar, row)))*rng.NormFloat64()
+    }
+
+    a, err := FitAdjustment(refs, scores, 2)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if a.PCs != 2 || len(a.Mean) != 3 || len(a.Var) != 3 {
+        t.Fatalf("adjustment %+v, want 2 PCs", a)
+    }
+    for k := range mean {
+        if math.Abs(a.Mean[k]-mean[k]) > 0.05 {
+            t.Errorf("mean coefficient %d = %g, want %g", k, a.Mean[k], mean[k])
+        }
+        if math.Abs(a.Var[k]-logVar[k]) > 0.15 {
+            t.Errorf("log-variance coefficient %d = %g, want %g", k, a.Var[k], logVar[k])
+        }
+    }
+
+    // z moments of the references below and above PC1 = 0
+    var cnt, sum, sq [2]float64
+    for _, r := range refs {
+        z := a.Z(scores[r.IID], r.PCs)
+        side := 0
+        if r.PCs[0] > 0 {
+            side = 1
+        }
+        cnt[side]++
+        sum[side] += z
+        sq[side] += z * z
+    }
+    for side := range cnt {
+        m := sum[side] / cnt[side]
+        sd := math.Sqrt(sq[side]/cnt[side] - m*m)
+        if math.Abs(m) > 0.08 || math.Abs(sd-1) > 0.08 {
+            t.Errorf("PC1 side %d: z mean %g, SD %g, want about N(0,1)", side, m, sd)
+        }
+    }
+
+    if !math.IsNaN(a.Z(1, []float64{0.5})) {
+        t.Error("Z with too few PCs is not NaN")
+    }
+    if _, err := FitAdjustment(refs[:5], scores, 2); err == nil {
+        t.Error("fit on 5 references succeeded")
+    }
+}
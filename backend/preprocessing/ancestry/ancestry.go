@@ -0,0 +1,525 @@
+// Package ancestry infers a kit's genetic ancestry by projecting it onto
+// principal components computed from a 1000 Genomes reference panel and
+// classifying the projection against the panel's superpopulation labels.
+//
+// The reference setup runs plink2 --pca allele-wts on an LD-pruned subset of
+// each chip panel; Build turns the resulting allele weights into a model next
+// to the panel:
+//
+//	<prefix>.pca.loadings   CHROM POS ID REF ALT A1 A1_FREQ PC1 … PCn
+//	<prefix>.ancestry.json  reference sample projections and the classifier
+//
+// Kits and reference samples are projected the same way (Project), so their
+// coordinates are directly comparable.
+package ancestry
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+
+    "github.com/adamwestgate/easy-pgs/backend/preprocessing/pgen"
+)
+
+// File suffixes of a panel's ancestry model, after the panel prefix.
+const (
+    AlleleWeightsExt = ".pca.eigenvec.allele" // written by plink2 --pca allele-wts
+    LoadingsExt      = ".pca.loadings"
+    ModelExt         = ".ancestry.json"
+)
+
+// Loading is the weight of one variant on every principal component, for
+// the count of allele A1 standardised by its reference frequency.
+type Loading struct {
+    Chr      string
+    Pos      int
+    ID       string
+    Ref, Alt string
+    A1       string
+    Freq     float64 // A1 frequency in the reference panel
+    W        []float64
+}
+
+// Reference is one reference sample's projection and label.
+type Reference struct {
+    IID      string    `json:"iid"`
+    SuperPop string    `json:"superPop"`
+    Sex      string    `json:"sex,omitempty"`
+    PCs      []float64 `json:"pcs"`
+}
+
+// Class is one superpopulation of the classifier.
+type Class struct {
+    SuperPop string    `json:"superPop"`
+    Mean     []float64 `json:"mean"`
+    N        int       `json:"n"`
+}
+
+// Model is a panel's PCA projection and superpopulation classifier: linear
+// discriminant analysis with equal priors on the reference projections.
+type Model struct {
+    PCs        int         `json:"pcs"`
+    Classes    []Class     `json:"classes"`
+    Precision  [][]float64 `json:"precision"` // inverse pooled within-class covariance
+    References []Reference `json:"references"`
+
+    Loadings []Loading `json:"-"`
+}
+
+// Projection is a sample's position on the model's PCs.
+type Projection struct {
+    IID      string
+    PCs      []float64
+    Variants int // loading variants with a call
+}
+
+// Build projects every sample of the panel at prefix onto the allele weights
+// in <prefix>.pca.eigenvec.allele, fits the classifier on the samples'
+// SuperPop labels and writes the model files.
+func Build(prefix string) (*Model, error) {
+    pf, err := pgen.OpenPfile(prefix)
+    if err != nil {
+        return nil, err
+    }
+    defer pf.Close()
+    loadings, err := readAlleleWeights(prefix+AlleleWeightsExt, pf)
+    if err != nil {
+        return nil, err
+    }
+    if len(loadings) == 0 {
+        return nil, fmt.Errorf("%s: no usable allele weights", prefix+AlleleWeightsExt)
+    }
+    m := &Model{PCs: len(loadings[0].W), Loadings: loadings}
+
+    // reference A1 frequencies over every sample
+    var geno []byte
+    keep := m.Loadings[:0]
+    for _, l := range m.Loadings {
+        i, _ := pf.IndexOf(l.ID)
+        if geno, err = pf.Genotypes(i, geno); err != nil {
+            return nil, err
+        }
+        a1, obs := 0, 0
+        for _, g := range geno {
+            if g != pgen.Missing {
+                a1 += a1Count(g, l)
+                obs += 2
+            }
+        }
+        if obs == 0 {
+            continue
+        }
+        l.Freq = float64(a1) / float64(obs)
+        if l.Freq > 0 && l.Freq < 1 {
+            keep = append(keep, l)
+        }
+    }
+    m.Loadings = keep
+
+    projs, err := m.Project(prefix)
+    if err != nil {
+        return nil, err
+    }
+    for i, p := range projs {
+        s := pf.Samples[i]
+        m.References = append(m.References, Reference{IID: p.IID, SuperPop: strings.ToUpper(s.SuperPop()), Sex: s.Sex(), PCs: p.PCs})
+    }
+    if err := m.fit(); err != nil {
+        return nil, err
+    }
+    if err := m.writeLoadings(prefix + LoadingsExt); err != nil {
+        return nil, err
+    }
+    return m, writeJSON(prefix+ModelExt, m)
+}
+
+// readAlleleWeights reads plink2's .eigenvec.allele, placing each variant
+// through pf. Variants whose ID is not in pf, or whose A1 is neither allele,
+// are skipped.
+func readAlleleWeights(path string, pf *pgen.Pfile) ([]Loading, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    sc := bufio.NewScanner(f)
+    if !sc.Scan() {
+        return nil, fmt.Errorf("%s: empty", path)
+    }
+    idIdx, a1Idx := -1, -1
+    var pcIdx []int
+    for i, h := range strings.Fields(sc.Text()) {
+        switch h = strings.TrimPrefix(h, "#"); {
+        case h == "ID":
+            idIdx = i
+        case h == "A1":
+            a1Idx = i
+        case strings.HasPrefix(h, "PC"):
+            pcIdx = append(pcIdx, i)
+        }
+    }
+    if idIdx < 0 || a1Idx < 0 || len(pcIdx) == 0 {
+        return nil, fmt.Errorf("%s: want ID, A1 and PC columns", path)
+    }
+
+    var out []Loading
+    seen := map[string]bool{}
+    for sc.Scan() {
+        cols := strings.Fields(sc.Text())
+        if len(cols) <= pcIdx[len(pcIdx)-1] {
+            continue
+        }
+        i, ok := pf.IndexOf(cols[idIdx])
+        if !ok || seen[cols[idIdx]] {
+            continue
+        }
+        v := pf.Variants[i]
+        l := Loading{Chr: v.Chr, Pos: v.Pos, ID: v.ID, Ref: v.Ref, Alt: v.Alt, A1: cols[a1Idx], W: make([]float64, len(pcIdx))}
+        if l.A1 != v.Ref && l.A1 != v.Alt {
+            continue
+        }
+        for k, c := range pcIdx {
+            if l.W[k], err = strconv.ParseFloat(cols[c], 64); err != nil {
+                return nil, fmt.Errorf("%s: %s: bad weight %q", path, l.ID, cols[c])
+            }
+        }
+        seen[l.ID] = true
+        out = append(out, l)
+    }
+    return out, sc.Err()
+}
+
+// a1Count converts an ALT allele count to the count of l.A1.
+func a1Count(g byte, l Loading) int {
+    if l.A1 == l.Alt {
+        return int(g)
+    }
+    return 2 - int(g)
+}
+
+// Project places every sample of the fileset at prefix on the model's PCs.
+// Variants are matched to the loadings by position and alleles; missing
+// calls contribute nothing and the sum is scaled up by loadings/used, so a
+// kit missing some variants is not pulled towards the origin.
+func (m *Model) Project(prefix string) ([]Projection, error) {
+    pf, err := pgen.OpenPfile(prefix)
+    if err != nil {
+        return nil, err
+    }
+    defer pf.Close()
+
+    at := make(map[string]int, len(m.Loadings))
+    for i, l := range m.Loadings {
+        at[posKey(l.Chr, l.Pos)] = i
+    }
+    out := make([]Projection, len(pf.Samples))
+    for s := range out {
+        out[s] = Projection{IID: pf.Samples[s].IID, PCs: make([]float64, m.PCs)}
+    }
+    var geno []byte
+    for i, v := range pf.Variants {
+        li, ok := at[posKey(v.Chr, v.Pos)]
+        if !ok {
+            continue
+        }
+        l := m.Loadings[li]
+        if !(v.Ref == l.Ref && v.Alt == l.Alt) && !(v.Ref == l.Alt && v.Alt == l.Ref) {
+            continue
+        }
+        if geno, err = pf.Genotypes(i, geno); err != nil {
+            return nil, err
+        }
+        sd := math.Sqrt(2 * l.Freq * (1 - l.Freq))
+        for s, g := range geno {
+            if g == pgen.Missing {
+                continue
+            }
+            d := int(g) // ALT count in the kit's orientation
+            if v.Alt != l.A1 {
+                d = 2 - d
+            }
+            x := (float64(d) - 2*l.Freq) / sd
+            for k, w := range l.W {
+                out[s].PCs[k] += w * x
+            }
+            out[s].Variants++
+        }
+    }
+    for s := range out {
+        if out[s].Variants == 0 {
+            continue
+        }
+        scale := float64(len(m.Loadings)) / float64(out[s].Variants)
+        for k := range out[s].PCs {
+            out[s].PCs[k] *= scale
+        }
+    }
+    return out, nil
+}
+
+// posKey keys a variant by chromosome (without "chr") and position.
+func posKey(chr string, pos int) string {
+    return strings.TrimPrefix(chr, "chr") + ":" + strconv.Itoa(pos)
+}
+
+// Classify returns the posterior probability of each superpopulation for
+// the projection pcs, and the most probable one.
+func (m *Model) Classify(pcs []float64) (map[string]float64, string) {
+    scores := make([]float64, len(m.Classes))
+    best := math.Inf(-1)
+    for c, cl := range m.Classes {
+        // LDA with equal priors: -½ (x-μ)ᵀ Σ⁻¹ (x-μ)
+        scores[c] = -0.5 * mahalanobis(pcs, cl.Mean, m.Precision)
+        best = math.Max(best, scores[c])
+    }
+    var total float64
+    for c := range scores {
+        scores[c] = math.Exp(scores[c] - best)
+        total += scores[c]
+    }
+    probs := make(map[string]float64, len(m.Classes))
+    top, topP := "", -1.0
+    for c, cl := range m.Classes {
+        p := scores[c] / total
+        probs[cl.SuperPop] = p
+        if p > topP {
+            top, topP = cl.SuperPop, p
+        }
+    }
+    return probs, top
+}
+
+// fit estimates the class means and the inverse pooled covariance from the
+// labelled references.
+func (m *Model) fit() error {
+    byPop := map[string][]Reference{}
+    for _, r := range m.References {
+        if r.SuperPop != "" {
+            byPop[r.SuperPop] = append(byPop[r.SuperPop], r)
+        }
+    }
+    if len(byPop) < 2 {
+        return errors.New("need at least two labelled superpopulations (.psam SuperPop column)")
+    }
+    pops := make([]string, 0, len(byPop))
+    for p := range byPop {
+        pops = append(pops, p)
+    }
+    sort.Strings(pops)
+
+    k := m.PCs
+    cov := make([][]float64, k)
+    for i := range cov {
+        cov[i] = make([]float64, k)
+    }
+    n := 0
+    m.Classes = nil
+    for _, p := range pops {
+        refs := byPop[p]
+        mean := make([]float64, k)
+        for _, r := range refs {
+            for i, x := range r.PCs {
+                mean[i] += x / float64(len(refs))
+            }
+        }
+        for _, r := range refs {
+            for i := range cov {
+                for j := range cov[i] {
+                    cov[i][j] += (r.PCs[i] - mean[i]) * (r.PCs[j] - mean[j])
+                }
+            }
+        }
+        n += len(refs)
+        m.Classes = append(m.Classes, Class{SuperPop: p, Mean: mean, N: len(refs)})
+    }
+    for i := range cov {
+        for j := range cov[i] {
+            cov[i][j] /= float64(n - len(pops))
+        }
+        cov[i][i] += 1e-9 // keep the inverse defined for degenerate PCs
+    }
+    var err error
+    m.Precision, err = invert(cov)
+    return err
+}
+
+// mahalanobis returns (x-mu)ᵀ P (x-mu).
+func mahalanobis(x, mu []float64, p [][]float64) float64 {
+    var d float64
+    for i := range p {
+        for j := range p[i] {
+            d += (x[i] - mu[i]) * p[i][j] * (x[j] - mu[j])
+        }
+    }
+    return d
+}
+
+// invert returns the inverse of the square matrix a by Gauss-Jordan
+// elimination with partial pivoting.
+func invert(a [][]float64) ([][]float64, error) {
+    n := len(a)
+    aug := make([][]float64, n)
+    for i := range a {
+        aug[i] = make([]float64, 2*n)
+        copy(aug[i], a[i])
+        aug[i][n+i] = 1
+    }
+    for c := 0; c < n; c++ {
+        piv := c
+        for r := c + 1; r < n; r++ {
+            if math.Abs(aug[r][c]) > math.Abs(aug[piv][c]) {
+                piv = r
+            }
+        }
+        if math.Abs(aug[piv][c]) < 1e-300 {
+            return nil, errors.New("singular covariance matrix")
+        }
+        aug[c], aug[piv] = aug[piv], aug[c]
+        d := aug[c][c]
+        for j := range aug[c] {
+            aug[c][j] /= d
+        }
+        for r := 0; r < n; r++ {
+            if r == c || aug[r][c] == 0 {
+                continue
+            }
+            f := aug[r][c]
+            for j := range aug[r] {
+                aug[r][j] -= f * aug[c][j]
+            }
+        }
+    }
+    inv := make([][]float64, n)
+    for i := range aug {
+        inv[i] = aug[i][n:]
+    }
+    return inv, nil
+}
+
+// writeLoadings stores the loadings as a TSV, atomically.
+func (m *Model) writeLoadings(path string) error {
+    return writeAtomic(path, func(w *bufio.Writer) {
+        fmt.Fprint(w, "#CHROM\tPOS\tID\tREF\tALT\tA1\tA1_FREQ")
+        for k := 1; k <= m.PCs; k++ {
+            fmt.Fprintf(w, "\tPC%d", k)
+        }
+        fmt.Fprintln(w)
+        for _, l := range m.Loadings {
+            fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s", l.Chr, l.Pos, l.ID, l.Ref, l.Alt, l.A1, strconv.FormatFloat(l.Freq, 'g', 8, 64))
+            for _, x := range l.W {
+                fmt.Fprintf(w, "\t%s", strconv.FormatFloat(x, 'g', 8, 64))
+            }
+            fmt.Fprintln(w)
+        }
+    })
+}
+
+// readLoadings reads a file written by writeLoadings.
+func readLoadings(path string) ([]Loading, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    var out []Loading
+    sc := bufio.NewScanner(f)
+    for sc.Scan() {
+        cols := strings.Split(sc.Text(), "\t")
+        if strings.HasPrefix(cols[0], "#") || len(cols) < 8 {
+            continue
+        }
+        l := Loading{Chr: cols[0], ID: cols[2], Ref: cols[3], Alt: cols[4], A1: cols[5], W: make([]float64, len(cols)-7)}
+        pos, err1 := strconv.Atoi(cols[1])
+        freq, err2 := strconv.ParseFloat(cols[6], 64)
+        if err1 != nil || err2 != nil {
+            return nil, fmt.Errorf("%s: bad row for %s", path, l.ID)
+        }
+        l.Pos, l.Freq = pos, freq
+        for k := range l.W {
+            if l.W[k], err = strconv.ParseFloat(cols[7+k], 64); err != nil {
+                return nil, fmt.Errorf("%s: %s: bad weight %q", path, l.ID, cols[7+k])
+            }
+        }
+        out = append(out, l)
+    }
+    return out, sc.Err()
+}
+
+// writeJSON stores v as JSON at path, atomically.
+func writeJSON(path string, v interface{}) error {
+    var err error
+    werr := writeAtomic(path, func(w *bufio.Writer) {
+        err = json.NewEncoder(w).Encode(v)
+    })
+    if err != nil {
+        return err
+    }
+    return werr
+}
+
+// writeAtomic writes path through fill via a temporary file and a rename.
+func writeAtomic(path string, fill func(w *bufio.Writer)) error {
+    tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name()) // no-op after the rename
+    w := bufio.NewWriter(tmp)
+    fill(w)
+    if err := w.Flush(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), path)
+}
+
+var (
+    modelMu    sync.Mutex
+    modelCache = map[string]cachedModel{}
+)
+
+// cachedModel remembers a loaded model and the model file mtime it matches.
+type cachedModel struct {
+    m     *Model
+    mtime int64
+}
+
+// Load returns the ancestry model of the panel at prefix, read once per
+// version of its model file.
+func Load(prefix string) (*Model, error) {
+    st, err := os.Stat(prefix + ModelExt)
+    if err != nil {
+        return nil, err
+    }
+    mtime := st.ModTime().UnixNano()
+    modelMu.Lock()
+    defer modelMu.Unlock()
+    if c, ok := modelCache[prefix]; ok && c.mtime == mtime {
+        return c.m, nil
+    }
+
+    data, err := os.ReadFile(prefix + ModelExt)
+    if err != nil {
+        return nil, err
+    }
+    m := &Model{}
+    if err := json.Unmarshal(data, m); err != nil {
+        return nil, fmt.Errorf("%s: %w", prefix+ModelExt, err)
+    }
+    if m.Loadings, err = readLoadings(prefix + LoadingsExt); err != nil {
+        return nil, err
+    }
+    modelCache[prefix] = cachedModel{m: m, mtime: mtime} // one entry per panel
+    return m, nil
+}
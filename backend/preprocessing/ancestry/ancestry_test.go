@@ -0,0 +1,221 @@
+package ancestry
+
+import (
+    "fmt"
+    "math"
+    "math/rand"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "github.com/adamwestgate/easy-pgs/backend/preprocessing/pgen"
+)
+
+// clusterModel fits a two-PC classifier on three well-separated clusters of
+// references, 50 each, scattered with unit variance around their centres.
+func clusterModel(t *testing.T, centres map[string][2]float64) *Model {
+    t.Helper()
+    rng := rand.New(rand.NewSource(1))
+    m := &Model{PCs: 2}
+    for pop, c := range centres {
+        for i := 0; i < 50; i++ {
+            m.References = append(m.References, Reference{
+                IID:      fmt.Sprintf("%s%d", pop, i),
+                SuperPop: pop,
+                PCs:      []float64{c[0] + rng.NormFloat64(), c[1] + rng.NormFloat64()},
+            })
+        }
+    }
+    if err := m.fit(); err != nil {
+        t.Fatal(err)
+    }
+    return m
+}
+
+func TestClassify(t *testing.T) {
+    centres := map[string][2]float64{"AFR": {0, 0}, "EUR": {10, 0}, "EAS": {0, 10}}
+    m := clusterModel(t, centres)
+
+    if len(m.Classes) != 3 {
+        t.Fatalf("%d classes, want 3", len(m.Classes))
+    }
+    for _, cl := range m.Classes {
+        c := centres[cl.SuperPop]
+        if cl.N != 50 || math.Hypot(cl.Mean[0]-c[0], cl.Mean[1]-c[1]) > 0.5 {
+            t.Errorf("class %s: n %d, mean %v, want 50 around %v", cl.SuperPop, cl.N, cl.Mean, c)
+        }
+    }
+
+    for pop, c := range centres {
+        probs, top := m.Classify([]float64{c[0], c[1]})
+        if top != pop || probs[pop] < 0.999 {
+            t.Errorf("Classify(%v) = %v, %s; want %s", c, probs, top, pop)
+        }
+        var total float64
+        for _, p := range probs {
+            total += p
+        }
+        if math.Abs(total-1) > 1e-12 {
+            t.Errorf("Classify(%v): posteriors sum to %g", c, total)
+        }
+    }
+
+    // halfway between two class means the two are equally likely
+    afr, eur := m.Classes[0].Mean, m.Classes[2].Mean // sorted: AFR, EAS, EUR
+    mid := []float64{(afr[0] + eur[0]) / 2, (afr[1] + eur[1]) / 2}
+    probs, _ := m.Classify(mid)
+    if math.Abs(probs["AFR"]-probs["EUR"]) > 1e-9 || probs["EAS"] > 1e-6 {
+        t.Errorf("Classify(midpoint AFR-EUR) = %v", probs)
+    }
+}
+
+// testPanel is a small labelled panel with allele weights on its variants.
+// rs3 is monomorphic, so Build must drop it; rs2's weights are for its REF.
+var testPanel = struct {
+    samples  []string
+    pops     []string
+    variants []pgen.Variant
+    geno     [][]byte
+    weights  string
+}{
+    samples: []string{"a1", "a2", "a3", "e1", "e2", "e3"},
+    pops:    []string{"AFR", "AFR", "AFR", "EUR", "EUR", "EUR"},
+    variants: []pgen.Variant{
+        {Chr: "1", Pos: 100, ID: "rs1", Ref: "A", Alt: "G"},
+        {Chr: "1", Pos: 200, ID: "rs2", Ref: "C", Alt: "T"},
+        {Chr: "2", Pos: 300, ID: "rs3", Ref: "G", Alt: "A"},
+        {Chr: "3", Pos: 400, ID: "rs4", Ref: "T", Alt: "C"},
+    },
+    geno: [][]byte{
+        {2, 2, 1, 0, 0, 1},
+        {0, 1, 0, 2, 2, 1},
+        {0, 0, 0, 0, 0, 0},
+        {1, 2, 2, 0, 1, 0},
+    },
+    weights: "#CHROM\tID\tREF\tALT\tA1\tPC1\tPC2\n" +
+        "1\trs1\tA\tG\tG\t0.5\t0.1\n" +
+        "1\trs2\tC\tT\tC\t-0.4\t0.3\n" +
+        "2\trs3\tG\tA\tA\t0.2\t0.2\n" +
+        "3\trs4\tT\tC\tC\t0.3\t-0.6\n" +
+        "5\trs9\tA\tC\tC\t1\t1\n", // not in the panel
+}
+
+// writeFileset writes samples with genotypes geno at variants to prefix,
+// adding a SuperPop column to the .psam when pops is given.
+func writeFileset(t *testing.T, prefix string, samples, pops []string, variants []pgen.Variant, geno [][]byte) {
+    t.Helper()
+    ss := make([]pgen.Sample, len(samples))
+    for i, iid := range samples {
+        ss[i] = pgen.Sample{IID: iid}
+    }
+    w, err := pgen.Create(prefix, ss, false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i, v := range variants {
+        if err := w.WriteVariant(v, geno[i]); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := w.Close(); err != nil {
+        t.Fatal(err)
+    }
+    if pops == nil {
+        return
+    }
+    lines := []string{"#IID\tSEX\tSuperPop"}
+    for i, iid := range samples {
+        lines = append(lines, iid+"\tNA\t"+pops[i])
+    }
+    if err := os.WriteFile(prefix+".psam", []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+        t.Fatal(err)
+    }
+}
+
+// TestBuildProjection builds a model on testPanel, checks every reference
+// projection against one computed from the genotypes by hand, and projects
+// a kit holding one reference sample's calls, re-encoded, onto it.
+func TestBuildProjection(t *testing.T) {
+    p := testPanel
+    prefix := filepath.Join(t.TempDir(), "panel")
+    writeFileset(t, prefix, p.samples, p.pops, p.variants, p.geno)
+    if err := os.WriteFile(prefix+AlleleWeightsExt, []byte(p.weights), 0o644); err != nil {
+        t.Fatal(err)
+    }
+
+    m, err := Build(prefix)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if m.PCs != 2 || len(m.Loadings) != 3 {
+        t.Fatalf("model has %d PCs and %d loadings, want 2 and 3", m.PCs, len(m.Loadings))
+    }
+
+    // x = (A1 count - 2f) / sqrt(2f(1-f)), summed with the A1 weights
+    type load struct {
+        row   int
+        a1Alt bool
+        w     [2]float64
+    }
+    loads := []load{{0, true, [2]float64{0.5, 0.1}}, {1, false, [2]float64{-0.4, 0.3}}, {3, true, [2]float64{0.3, -0.6}}}
+    want := make([][2]float64, len(p.samples))
+    for _, l := range loads {
+        counts := make([]float64, len(p.samples))
+        var f float64
+        for s, g := range p.geno[l.row] {
+            counts[s] = float64(g)
+            if !l.a1Alt {
+                counts[s] = 2 - counts[s]
+            }
+            f += counts[s] / float64(2*len(p.samples))
+        }
+        sd := math.Sqrt(2 * f * (1 - f))
+        for s := range want {
+            for k := range want[s] {
+                want[s][k] += l.w[k] * (counts[s] - 2*f) / sd
+            }
+        }
+    }
+    for s, r := range m.References {
+        if r.IID != p.samples[s] || r.SuperPop != p.pops[s] {
+            t.Errorf("reference %d = %s/%s, want %s/%s", s, r.IID, r.SuperPop, p.samples[s], p.pops[s])
+        }
+        for k := range want[s] {
+            if math.Abs(r.PCs[k]-want[s][k]) > 1e-9 {
+                t.Errorf("%s PC%d = %g, want %g", r.IID, k+1, r.PCs[k], want[s][k])
+            }
+        }
+    }
+
+    // e2 as a kit: rs2 with REF and ALT swapped, variants in another order,
+    // plus a variant the model does not use
+    kit := filepath.Join(t.TempDir(), "kit")
+    writeFileset(t, kit, []string{"e2"}, nil,
+        []pgen.Variant{
+            {Chr: "3", Pos: 400, ID: "rs4", Ref: "T", Alt: "C"},
+            {Chr: "1", Pos: 100, ID: "rs1", Ref: "A", Alt: "G"},
+            {Chr: "1", Pos: 200, ID: "rs2", Ref: "T", Alt: "C"},
+            {Chr: "4", Pos: 500, ID: "rs5", Ref: "A", Alt: "T"},
+        },
+        [][]byte{{p.geno[3][4]}, {p.geno[0][4]}, {2 - p.geno[1][4]}, {1}},
+    )
+    loaded, err := Load(prefix)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for name, model := range map[string]*Model{"built": m, "loaded": loaded} {
+        projs, err := model.Project(kit)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(projs) != 1 || projs[0].Variants != 3 {
+            t.Fatalf("%s: projections %+v, want one over 3 variants", name, projs)
+        }
+        for k, x := range projs[0].PCs {
+            if math.Abs(x-m.References[4].PCs[k]) > 1e-6 {
+                t.Errorf("%s: kit e2 PC%d = %g, reference has %g", name, k+1, x, m.References[4].PCs[k])
+            }
+        }
+    }
+}
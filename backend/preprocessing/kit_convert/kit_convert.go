@@ -1,6 +1,7 @@
 // backend/preprocessing/kit_convert/kit_convert.go
 // Package kit_convert converts uploaded user genotype kits to PLINK2-compatible formats
 // (pgen, pvar, psam) for use in downstream polygenic risk scoring.
+// Conversion is pure Go; no PLINK binaries are required.
 package kit_convert
 
 import (
@@ -8,11 +9,13 @@ import (
     "fmt"
     "io"
     "os"
-    "os/exec"
     "path/filepath"
+    "sort"
+    "strconv"
     "strings"
 
     "github.com/adamwestgate/easy-pgs/backend/config"
+    "github.com/adamwestgate/easy-pgs/backend/preprocessing/pgen"
 )
 
 func isValidGenotype(g string) bool {
@@ -43,11 +46,13 @@ func normalizeChrom(raw string) string {
     }
 }
 
-// ConvertAncestry reads an AncestryDNA kit from src, strips comments,
-// normalizes chromosome names to PLINK’s conventions, filters out invalid
-// alleles, and writes a 4-column PLINK input (rsid, chrom, pos, genotype)
-// to dst.
-func ConvertAncestry(src io.Reader, dst io.Writer) error {
+// call is one parsed genotype row of a raw kit.
+type call struct {
+    rsid, chrom, pos, gt string
+}
+
+// parseAncestry streams the valid calls of an AncestryDNA kit to fn.
+func parseAncestry(src io.Reader, fn func(call)) error {
     sc := bufio.NewScanner(src)
     for sc.Scan() {
         line := strings.TrimSpace(sc.Text())
@@ -63,15 +68,13 @@ func ConvertAncestry(src io.Reader, dst io.Writer) error {
         if !isValidGenotype(gt) {
             continue
         }
-        fmt.Fprintf(dst, "%s\t%s\t%s\t%s\n", rsid, chrom, pos, gt)
+        fn(call{rsid, chrom, pos, gt})
     }
     return sc.Err()
 }
 
-// Convert23andMe reads a 23andMe kit from src, skips header/comments,
-// normalizes chromosomes, filters invalid genotypes, and writes the
-// 4-column PLINK input to dst.
-func Convert23andMe(src io.Reader, dst io.Writer) error {
+// parse23andMe streams the valid calls of a 23andMe kit to fn.
+func parse23andMe(src io.Reader, fn func(call)) error {
     sc := bufio.NewScanner(src)
     for sc.Scan() {
         line := strings.TrimSpace(sc.Text())
@@ -86,31 +89,56 @@ func Convert23andMe(src io.Reader, dst io.Writer) error {
         if !isValidGenotype(gt) {
             continue
         }
-        fmt.Fprintf(dst, "%s\t%s\t%s\t%s\n", rsid, chrom, pos, gt)
+        fn(call{rsid, chrom, pos, gt})
     }
     return sc.Err()
 }
 
+// ConvertAncestry reads an AncestryDNA kit from src, strips comments,
+// normalizes chromosome names to PLINK’s conventions, filters out invalid
+// alleles, and writes a 4-column PLINK input (rsid, chrom, pos, genotype)
+// to dst.
+func ConvertAncestry(src io.Reader, dst io.Writer) error {
+    return parseAncestry(src, func(c call) {
+        fmt.Fprintf(dst, "%s\t%s\t%s\t%s\n", c.rsid, c.chrom, c.pos, c.gt)
+    })
+}
+
+// Convert23andMe reads a 23andMe kit from src, skips header/comments,
+// normalizes chromosomes, filters invalid genotypes, and writes the
+// 4-column PLINK input to dst.
+func Convert23andMe(src io.Reader, dst io.Writer) error {
+    return parse23andMe(src, func(c call) {
+        fmt.Fprintf(dst, "%s\t%s\t%s\t%s\n", c.rsid, c.chrom, c.pos, c.gt)
+    })
+}
+
+// Options controls ConvertFileToPgenOpts.
+// WriteBed – also emit PLINK 1 .bed/.bim/.fam next to the pfile.
+type Options struct {
+    WriteBed bool
+}
+
 // ConvertFileToPgen converts a raw consumer‑DNA file into PLINK2 pgen/pvar/psam.
 // It returns the detected kitType ("ancestry" or "23andme").
 func ConvertFileToPgen(rawPath, processedDir string) (string, error) {
+    return ConvertFileToPgenOpts(rawPath, processedDir, Options{})
+}
+
+// ConvertFileToPgenOpts converts a raw kit in a single pass: calls on the chip
+// manifest's .snplist are oriented against its .refallele REF alleles and
+// written directly as pgen/pvar/psam (plus bed/bim/fam if requested).
+// Calls without a manifest REF, or with two different non-REF alleles, are dropped.
+func ConvertFileToPgenOpts(rawPath, processedDir string, opt Options) (string, error) {
     if err := os.MkdirAll(processedDir, 0755); err != nil {
         return "", fmt.Errorf("mkdir processedDir: %w", err)
     }
 
-    base := strings.TrimSuffix(filepath.Base(rawPath), filepath.Ext(rawPath))
-    tmp4 := filepath.Join(processedDir, base+"_4col.txt")
-
     in, err := os.Open(rawPath)
     if err != nil {
         return "", err
     }
     defer in.Close()
-    out, err := os.Create(tmp4)
-    if err != nil {
-        return "", err
-    }
-    defer out.Close()
 
     // sniff first non‑comment line to decide chip
     sc := bufio.NewScanner(in)
@@ -132,138 +160,222 @@ func ConvertFileToPgen(rawPath, processedDir string) (string, error) {
         return "", err
     }
 
-    var kitType string
+    var (
+        kitType string
+        parse   func(io.Reader, func(call)) error
+    )
     switch len(cols) {
     case 5:
-        kitType = "ancestry"
-        if err := ConvertAncestry(in, out); err != nil {
-            return "", err
-        }
+        kitType, parse = "ancestry", parseAncestry
     case 4:
-        kitType = "23andme"
-        if err := Convert23andMe(in, out); err != nil {
-            return "", err
-        }
+        kitType, parse = "23andme", parse23andMe
     default:
         return "", fmt.Errorf("unrecognized column count: %d", len(cols))
     }
 
     maniDir := map[string]string{"ancestry": config.ChipManifestAncestryDir, "23andme": config.ChipManifestV5Dir}[kitType]
-    snplist := filepath.Join(maniDir, kitType+".snplist")
-    refallele := filepath.Join(maniDir, kitType+".refallele")
-
-    outBase := filepath.Join(processedDir, base)
-
-    // PLINK1: 4‑col → .bed/.bim/.fam
-    cmd1 := exec.Command("plink1", "--23file", tmp4, "--allele-acgt", "--extract", snplist,
-        "--make-bed", "--out", outBase)
-    cmd1.Stdout, cmd1.Stderr = os.Stdout, os.Stderr
-    if err := cmd1.Run(); err != nil {
-        return "", fmt.Errorf("plink1: %w", err)
+    onChip, err := readSnplist(filepath.Join(maniDir, kitType+".snplist"))
+    if err != nil {
+        return "", err
     }
-
-    if err := patchBimWithRefAlleles(outBase+".bim", refallele); err != nil {
+    refs, err := readRefAlleles(filepath.Join(maniDir, kitType+".refallele"))
+    if err != nil {
         return "", err
     }
+    panelAlts := readPanelAlts(kitType, refs)
+
+    // orient every call against the manifest REF
+    var (
+        variants []pgen.Variant
+        genos    []byte
+        seen     = make(map[string]bool)
+    )
+    err = parse(in, func(c call) {
+        ref, ok := refs[c.rsid]
+        if !ok || !onChip[c.rsid] || seen[c.rsid] {
+            return
+        }
+        pos, err := strconv.Atoi(c.pos)
+        if err != nil {
+            return
+        }
+        alt, g, ok := orient(c.gt, ref, panelAlts[c.rsid])
+        if !ok {
+            return
+        }
+        seen[c.rsid] = true
+        variants = append(variants, pgen.Variant{Chr: c.chrom, Pos: pos, ID: c.rsid, Ref: ref, Alt: alt})
+        genos = append(genos, g)
+    })
+    if err != nil {
+        return "", err
+    }
+    if len(variants) == 0 {
+        return "", fmt.Errorf("no kit calls matched the %s manifest", kitType)
+    }
 
-    patched := outBase + "_patched"
-    _ = os.Rename(outBase+".bim.patched", patched+".bim")
-    _ = os.Rename(outBase+".bed", patched+".bed")
-    _ = os.Rename(outBase+".fam", patched+".fam")
-
-    // PLINK2: patched bed → pgen
-    cmd2 := exec.Command("plink2", "--bfile", patched, "--ref-allele", refallele, "--make-pgen", "--out", outBase)
-    cmd2.Stdout, cmd2.Stderr = os.Stdout, os.Stderr
-    if err := cmd2.Run(); err != nil {
-        return "", fmt.Errorf("plink2: %w", err)
+    order := make([]int, len(variants))
+    for i := range order {
+        order[i] = i
     }
+    sort.SliceStable(order, func(a, b int) bool {
+        va, vb := variants[order[a]], variants[order[b]]
+        if ca, cb := pgen.ChromRank(va.Chr), pgen.ChromRank(vb.Chr); ca != cb {
+            return ca < cb
+        }
+        return va.Pos < vb.Pos
+    })
 
-    _ = os.Remove(tmp4)
+    base := strings.TrimSuffix(filepath.Base(rawPath), filepath.Ext(rawPath))
+    outBase := filepath.Join(processedDir, base)
+    // the sex goes into the .psam so the kit's X/Y calls get the same
+    // ploidy as the reference panel's
+    user := pgen.Sample{IID: "user"}
+    if sex := InferSex(variants, genos); sex != "" {
+        user.Fields = map[string]string{"SEX": sex}
+    }
+    w, err := pgen.Create(outBase, []pgen.Sample{user}, opt.WriteBed)
+    if err != nil {
+        return "", err
+    }
+    for _, i := range order {
+        if err := w.WriteVariant(variants[i], genos[i:i+1]); err != nil {
+            w.Close()
+            return "", err
+        }
+    }
+    if err := w.Close(); err != nil {
+        return "", err
+    }
     return kitType, nil
 }
 
-// patchBimWithRefAlleles patches null alleles (“0”) in the BIM file at bimPath
-// by looking up reference alleles from the consumer chip manifest at refPath.
-// Any variants that still end up invalid or homozygous are written to a separate
-// exclude list (bimPath_without_ext + "_exclude.txt").
-func patchBimWithRefAlleles(bimPath, refPath string) error {
-	refMap := make(map[string]string)
-
-	refFile, err := os.Open(refPath)
-	if err != nil {
-		return err
-	}
-	defer refFile.Close()
-
-	sc := bufio.NewScanner(refFile)
-	for sc.Scan() {
-		parts := strings.Fields(sc.Text())
-		if len(parts) >= 2 {
-			refMap[parts[0]] = strings.ToUpper(parts[1])
-		}
-	}
-	if err := sc.Err(); err != nil {
-		return err
-	}
-
-	bimTmp := bimPath + ".patched"
-	excludePath := strings.TrimSuffix(bimPath, ".bim") + "_exclude.txt"
-
-	in, err := os.Open(bimPath)
-	if err != nil {
-		return err
-	}
-	defer in.Close()
-
-	out, err := os.Create(bimTmp)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
+// X-chromosome heterozygosity below/above which a kit is called male/female,
+// and the number of X calls needed to call it at all.
+const (
+    MaleXHet     = 0.02
+    FemaleXHet   = 0.10
+    MinXCallsSex = 100
+)
 
-	exclude, err := os.Create(excludePath)
-	if err != nil {
-		return err
-	}
-	defer exclude.Close()
+// InferSex calls a single-sample kit's sex from its chrX heterozygosity:
+// "male", "female", or "" when there are too few X calls or the rate falls
+// between the two thresholds. genos holds the kit's ALT counts.
+func InferSex(variants []pgen.Variant, genos []byte) string {
+    var x, het int
+    for i, v := range variants {
+        if (v.Chr != "X" && v.Chr != "23") || genos[i] == pgen.Missing {
+            continue
+        }
+        x++
+        if genos[i] == 1 {
+            het++
+        }
+    }
+    return SexFromXHet(x, het)
+}
 
-	sc = bufio.NewScanner(in)
-	for sc.Scan() {
-		fields := strings.Fields(sc.Text())
-		if len(fields) < 6 {
-			continue
-		}
-		rsid := fields[1]
-		ref := refMap[rsid]
+// SexFromXHet applies InferSex's thresholds to counts of called and
+// heterozygous chrX genotypes.
+func SexFromXHet(xCalls, xHets int) string {
+    if xCalls < MinXCallsSex {
+        return ""
+    }
+    switch rate := float64(xHets) / float64(xCalls); {
+    case rate < MaleXHet:
+        return "male"
+    case rate > FemaleXHet:
+        return "female"
+    }
+    return ""
+}
 
-		if fields[4] == "0" {
-			fields[4] = ref
-		}
-		if fields[5] == "0" {
-			fields[5] = ref
-		}
+// orient turns a two-letter call into (ALT, ALT allele count). "-" alleles make
+// the call missing. ALT comes from the call itself, else from the reference panel
+// (so hom-REF calls can still match effect alleles), else ".".
+// ok is false for calls with two different non-REF alleles.
+func orient(gt, ref, panelAlt string) (alt string, g byte, ok bool) {
+    alt = panelAlt
+    if gt[0] == '-' || gt[1] == '-' {
+        if alt == "" {
+            alt = "."
+        }
+        return alt, pgen.Missing, true
+    }
+    for _, a := range []string{gt[:1], gt[1:]} {
+        if a == ref {
+            continue
+        }
+        if g > 0 && a != alt {
+            return "", 0, false
+        }
+        alt = a
+        g++
+    }
+    if alt == "" {
+        alt = "."
+    }
+    return alt, g, true
+}
 
-		// if the REF allele matches A2 but not A1, swap
-		if fields[5] == ref && fields[4] != ref {
-			fields[4], fields[5] = fields[5], fields[4]
-		}
+// readSnplist reads the chip manifest's list of rsIDs.
+func readSnplist(path string) (map[string]bool, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
 
-		// If either allele is still 0 or they are identical, add to exclude
-		if fields[4] == "0" || fields[5] == "0" || fields[4] == fields[5] {
-			_, _ = fmt.Fprintln(exclude, rsid)
-		}
+    set := make(map[string]bool)
+    sc := bufio.NewScanner(f)
+    for sc.Scan() {
+        if id := strings.TrimSpace(sc.Text()); id != "" {
+            set[id] = true
+        }
+    }
+    return set, sc.Err()
+}
 
-        if fields[4] == fields[5] {
-        fields[5] = "0" // force one to be missing to avoid PLINK2 crash
-        fmt.Fprintln(exclude, rsid)
+// readRefAlleles reads the chip manifest's "rsID REF" table.
+func readRefAlleles(path string) (map[string]string, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
     }
+    defer f.Close()
 
-		_, _ = fmt.Fprintln(out, strings.Join(fields, "\t"))
-	}
-	if err := sc.Err(); err != nil {
-		return err
-	}
+    refs := make(map[string]string)
+    sc := bufio.NewScanner(f)
+    for sc.Scan() {
+        parts := strings.Fields(sc.Text())
+        if len(parts) >= 2 {
+            refs[parts[0]] = strings.ToUpper(parts[1])
+        }
+    }
+    return refs, sc.Err()
+}
 
-	_ = os.Rename(bimTmp, bimPath)
-	return nil
+// readPanelAlts returns rsID → ALT from the chip's reference panel variant
+// index for variants whose REF agrees with the manifest. Missing panels give
+// an empty map.
+func readPanelAlts(kitType string, refs map[string]string) map[string]string {
+    dir := config.ReferenceAncestryDir
+    if kitType == "23andme" {
+        dir = config.Reference23andmeDir
+    }
+    alts := make(map[string]string)
+    pvars, _ := filepath.Glob(filepath.Join(dir, "*.pvar"))
+    if len(pvars) == 0 {
+        return alts
+    }
+    idx, err := pgen.LoadIndex(strings.TrimSuffix(pvars[0], ".pvar"))
+    if err != nil {
+        return alts
+    }
+    for _, v := range idx.FileOrder() {
+        if refs[v.ID] == v.Ref && v.Alt != "." {
+            alts[v.ID] = v.Alt
+        }
+    }
+    return alts
 }
@@ -0,0 +1,312 @@
+package pgen
+
+import (
+    "bufio"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// Index is the variant table of a fileset sorted by chromosome and position,
+// stored next to it as <prefix>.vidx so the .pvar text is parsed once rather
+// than by every reader. On disk (little-endian):
+//
+//	"EPGSVX1\n"
+//	uint32 variants
+//	per variant: uint8 length, CHROM, uint32 POS, uint32 .pvar row,
+//	             uint16 length, ID, uint16 length, REF, uint16 length, ALT
+type Index struct {
+    variants []Variant // sorted by chromosome, position and .pvar row
+    rows     []int     // .pvar row of each entry of variants
+    byID     map[string]int
+}
+
+const indexMagic = "EPGSVX1\n"
+
+// IndexExt is the file extension of a fileset's variant index.
+const IndexExt = ".vidx"
+
+// NewIndex indexes variants given in .pvar order.
+func NewIndex(vars []Variant) *Index {
+    x := &Index{variants: make([]Variant, len(vars)), rows: make([]int, len(vars))}
+    order := make([]int, len(vars))
+    for i := range order {
+        order[i] = i
+    }
+    sort.SliceStable(order, func(a, b int) bool {
+        va, vb := vars[order[a]], vars[order[b]]
+        if ca, cb := ChromRank(va.Chr), ChromRank(vb.Chr); ca != cb {
+            return ca < cb
+        }
+        if va.Chr != vb.Chr {
+            return va.Chr < vb.Chr
+        }
+        return va.Pos < vb.Pos
+    })
+    for i, row := range order {
+        x.variants[i], x.rows[i] = vars[row], row
+    }
+    x.buildIDs()
+    return x
+}
+
+// buildIDs maps each ID (except ".") to its first variant in .pvar order.
+func (x *Index) buildIDs() {
+    x.byID = make(map[string]int, len(x.variants))
+    for i, v := range x.variants {
+        if v.ID == "." {
+            continue
+        }
+        if j, dup := x.byID[v.ID]; dup && x.rows[j] < x.rows[i] {
+            continue
+        }
+        x.byID[v.ID] = i
+    }
+}
+
+// Len returns the number of variants.
+func (x *Index) Len() int { return len(x.variants) }
+
+// Lookup returns the variants at chr:pos, in .pvar order. A "chr" prefix on
+// chr is ignored.
+func (x *Index) Lookup(chr string, pos int) []Variant {
+    chr = strings.TrimPrefix(chr, "chr")
+    rank := ChromRank(chr)
+    i := sort.Search(len(x.variants), func(i int) bool {
+        v := x.variants[i]
+        if r := ChromRank(v.Chr); r != rank {
+            return r > rank
+        }
+        if v.Chr != chr {
+            return v.Chr > chr
+        }
+        return v.Pos >= pos
+    })
+    j := i
+    for j < len(x.variants) && x.variants[j].Chr == chr && x.variants[j].Pos == pos {
+        j++
+    }
+    return x.variants[i:j]
+}
+
+// ID returns the ID of the first variant at chr:pos that has one.
+func (x *Index) ID(chr string, pos int) (string, bool) {
+    for _, v := range x.Lookup(chr, pos) {
+        if v.ID != "." {
+            return v.ID, true
+        }
+    }
+    return "", false
+}
+
+// ByID returns the first variant with the given ID.
+func (x *Index) ByID(id string) (Variant, bool) {
+    i, ok := x.byID[id]
+    if !ok {
+        return Variant{}, false
+    }
+    return x.variants[i], true
+}
+
+// FileOrder returns every variant in .pvar order.
+func (x *Index) FileOrder() []Variant {
+    out := make([]Variant, len(x.variants))
+    for i, v := range x.variants {
+        out[x.rows[i]] = v
+    }
+    return out
+}
+
+// Write stores the index at path, via a temporary file so readers never see
+// a partial index.
+func (x *Index) Write(path string) error {
+    tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name()) // no-op after the rename
+
+    w := bufio.NewWriter(tmp)
+    le := binary.LittleEndian
+    putString := func(s string, wide bool) {
+        if wide {
+            binary.Write(w, le, uint16(len(s)))
+        } else {
+            w.WriteByte(byte(len(s)))
+        }
+        w.WriteString(s)
+    }
+    w.WriteString(indexMagic)
+    binary.Write(w, le, uint32(len(x.variants)))
+    for i, v := range x.variants {
+        putString(v.Chr, false)
+        binary.Write(w, le, uint32(v.Pos))
+        binary.Write(w, le, uint32(x.rows[i]))
+        putString(v.ID, true)
+        putString(v.Ref, true)
+        putString(v.Alt, true)
+    }
+    if err := w.Flush(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), path)
+}
+
+// ReadIndex reads an index written by Write.
+func ReadIndex(path string) (*Index, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    r := bufio.NewReader(f)
+    le := binary.LittleEndian
+
+    magic := make([]byte, len(indexMagic))
+    if _, err := io.ReadFull(r, magic); err != nil || string(magic) != indexMagic {
+        return nil, fmt.Errorf("%s: not a variant index", path)
+    }
+    var n uint32
+    if err := binary.Read(r, le, &n); err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+    // reads after the first error are no-ops; rerr holds that error
+    var rerr error
+    u32 := func() uint32 {
+        var v uint32
+        if rerr == nil {
+            rerr = binary.Read(r, le, &v)
+        }
+        return v
+    }
+    str := func(wide bool) string {
+        if rerr != nil {
+            return ""
+        }
+        var l int
+        if wide {
+            var n16 uint16
+            rerr = binary.Read(r, le, &n16)
+            l = int(n16)
+        } else {
+            var b byte
+            b, rerr = r.ReadByte()
+            l = int(b)
+        }
+        b := make([]byte, l)
+        if rerr == nil {
+            _, rerr = io.ReadFull(r, b)
+        }
+        return string(b)
+    }
+
+    x := &Index{variants: make([]Variant, n), rows: make([]int, n)}
+    for i := range x.variants {
+        chr := str(false)
+        pos, row := u32(), u32()
+        v := Variant{Chr: chr, Pos: int(pos), ID: str(true), Ref: str(true), Alt: str(true)}
+        if rerr != nil {
+            return nil, fmt.Errorf("%s: truncated at variant %d: %w", path, i, rerr)
+        }
+        if int(row) >= len(x.rows) {
+            return nil, fmt.Errorf("%s: bad row %d", path, row)
+        }
+        x.variants[i], x.rows[i] = v, int(row)
+    }
+    x.buildIDs()
+    return x, nil
+}
+
+// BuildIndex indexes <prefix>.pvar and writes <prefix>.vidx.
+func BuildIndex(prefix string) (*Index, error) {
+    vars, err := ReadPvar(prefix + ".pvar")
+    if err != nil {
+        return nil, err
+    }
+    x := NewIndex(vars)
+    return x, x.Write(prefix + IndexExt)
+}
+
+var (
+    indexMu    sync.Mutex
+    indexCache = map[string]cachedIndex{}
+)
+
+// cachedIndex remembers a loaded index and the .pvar mtime it matches.
+type cachedIndex struct {
+    x     *Index
+    mtime int64
+}
+
+// maxCachedIndexes bounds the index cache: both reference panels plus a few kits.
+const maxCachedIndexes = 8
+
+// LoadIndex returns the variant index of the fileset at prefix, read once
+// per .pvar version. It only reads the fileset: a missing or stale
+// <prefix>.vidx is rebuilt from the .pvar in memory and never written back,
+// since reference panels are read-only at runtime. Setup writes the panels'
+// index with BuildIndex and Writer.Close writes the kits'.
+func LoadIndex(prefix string) (*Index, error) {
+    st, err := os.Stat(prefix + ".pvar")
+    if err != nil {
+        return nil, err
+    }
+    mtime := st.ModTime().UnixNano()
+
+    indexMu.Lock()
+    defer indexMu.Unlock()
+    if c, ok := indexCache[prefix]; ok && c.mtime == mtime {
+        return c.x, nil
+    }
+
+    var x *Index
+    if ist, err := os.Stat(prefix + IndexExt); err == nil && !ist.ModTime().Before(st.ModTime()) {
+        x, err = ReadIndex(prefix + IndexExt)
+        if err != nil {
+            x = nil
+        }
+    }
+    if x == nil {
+        vars, err := ReadPvar(prefix + ".pvar")
+        if err != nil {
+            return nil, err
+        }
+        x = NewIndex(vars)
+    }
+    if len(indexCache) >= maxCachedIndexes {
+        for k := range indexCache {
+            delete(indexCache, k)
+        }
+    }
+    indexCache[prefix] = cachedIndex{x: x, mtime: mtime}
+    return x, nil
+}
+
+// ChromRank orders chromosomes 1–22, X, Y, XY, MT, then anything else.
+func ChromRank(c string) int {
+    if n, err := strconv.Atoi(c); err == nil {
+        return n
+    }
+    switch c {
+    case "X":
+        return 23
+    case "Y":
+        return 24
+    case "XY":
+        return 25
+    case "MT":
+        return 26
+    default:
+        return 27
+    }
+}
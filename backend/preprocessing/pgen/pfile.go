@@ -0,0 +1,57 @@
+package pgen
+
+// Pfile bundles a .pgen reader with its variants and samples.
+type Pfile struct {
+    *Reader
+    Variants []Variant
+    Samples  []Sample
+
+    prefix string
+    byID   map[string]int
+}
+
+// OpenPfile opens <prefix>.pgen/.pvar/.psam, reading the variants through
+// the fileset's variant index.
+func OpenPfile(prefix string) (*Pfile, error) {
+    idx, err := LoadIndex(prefix)
+    if err != nil {
+        return nil, err
+    }
+    vars := idx.FileOrder()
+    samples, err := ReadPsam(prefix + ".psam")
+    if err != nil {
+        return nil, err
+    }
+    r, err := Open(prefix+".pgen", len(vars), len(samples))
+    if err != nil {
+        return nil, err
+    }
+    p := &Pfile{Reader: r, Variants: vars, Samples: samples, prefix: prefix, byID: make(map[string]int, len(vars))}
+    for i, v := range vars {
+        if _, dup := p.byID[v.ID]; !dup && v.ID != "." {
+            p.byID[v.ID] = i
+        }
+    }
+    return p, nil
+}
+
+// IndexOf returns the index of the first variant with the given ID.
+func (p *Pfile) IndexOf(id string) (int, bool) {
+    i, ok := p.byID[id]
+    return i, ok
+}
+
+// Reopen returns a Pfile that shares p's variant and sample tables but has its
+// own Reader, so large panels are parsed once and read from several goroutines.
+func (p *Pfile) Reopen() (*Pfile, error) {
+    r, err := Open(p.prefix+".pgen", len(p.Variants), len(p.Samples))
+    if err != nil {
+        return nil, err
+    }
+    q := *p
+    q.Reader = r
+    return &q, nil
+}
+
+// Prefix returns the fileset prefix the Pfile was opened from.
+func (p *Pfile) Prefix() string { return p.prefix }
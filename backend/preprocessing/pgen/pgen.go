@@ -0,0 +1,576 @@
+// Package pgen reads PLINK 2 binary genotype files (.pgen) together with their
+// .pvar variant and .psam sample tables, so genotypes can be used from Go
+// without shelling out to plink2.
+//
+// Supported .pgen storage modes:
+//
+//   0x01  fixed-width 2-bit hardcalls in PLINK 1 .bed encoding
+//   0x10  variable-width records (the plink2 --make-pgen default): hardcalls
+//         and dosages; phase tracks are skipped, and multiallelic variants
+//         read as all Missing (see Multiallelic)
+//
+// Genotypes are returned as ALT allele counts: 0, 1, 2, or Missing, and
+// Dosages as ALT allele dosages in [0, 2].
+// Writer produces mode 0x01 filesets (plus optional .bed/.bim/.fam).
+package pgen
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+    "math/bits"
+    "os"
+)
+
+// Missing is the genotype value for a missing call.
+const Missing = 3
+
+const (
+    modeFixedHardcall = 0x01
+    modeVariable      = 0x10
+    blockSize         = 1 << 16 // variants per index block
+)
+
+// Reader gives random access to the hardcalls of a .pgen file.
+// A Reader is not safe for concurrent use.
+type Reader struct {
+    f         *os.File
+    nVariants int
+    nSamples  int
+    vrtypes   []byte  // per-variant record type (variable-width mode only)
+    offsets   []int64 // record start offsets, len nVariants+1
+    multi     []bool  // per-variant "more than one ALT allele"; nil if none
+
+    // last non-LD-compressed record decoded, reused as LD base
+    baseIdx  int
+    baseGeno []byte
+}
+
+// Open opens a .pgen file. nVariants and nSamples come from the .pvar/.psam
+// and are checked against the header when the file stores its own counts.
+func Open(path string, nVariants, nSamples int) (*Reader, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    r := &Reader{f: f, nVariants: nVariants, nSamples: nSamples, baseIdx: -1}
+    if err := r.readIndex(); err != nil {
+        f.Close()
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+    return r, nil
+}
+
+// Close closes the underlying file.
+func (r *Reader) Close() error { return r.f.Close() }
+
+// NumVariants returns the number of variant records.
+func (r *Reader) NumVariants() int { return r.nVariants }
+
+// NumSamples returns the number of samples per record.
+func (r *Reader) NumSamples() int { return r.nSamples }
+
+// readIndex parses the header and builds the per-variant offset table.
+func (r *Reader) readIndex() error {
+    var hdr [12]byte
+    n, err := io.ReadFull(r.f, hdr[:])
+    if err != nil && !(errors.Is(err, io.ErrUnexpectedEOF) && n >= 3) {
+        return fmt.Errorf("read header: %w", err)
+    }
+    if hdr[0] != 0x6c || hdr[1] != 0x1b {
+        return errors.New("not a .pgen file")
+    }
+
+    switch hdr[2] {
+    case modeFixedHardcall:
+        width := int64(nypByteCount(r.nSamples))
+        r.offsets = make([]int64, r.nVariants+1)
+        for i := range r.offsets {
+            r.offsets[i] = 3 + int64(i)*width
+        }
+        return r.checkSize()
+    case modeVariable:
+        return r.readVariableIndex(hdr)
+    default:
+        return fmt.Errorf("unsupported storage mode 0x%02x", hdr[2])
+    }
+}
+
+// readVariableIndex parses the variable-width index: block offsets, then per
+// block the vrtypes, record lengths and optional allele-count/nonref sections.
+func (r *Reader) readVariableIndex(hdr [12]byte) error {
+    nv := int(binary.LittleEndian.Uint32(hdr[3:7]))
+    ns := int(binary.LittleEndian.Uint32(hdr[7:11]))
+    if nv != r.nVariants || ns != r.nSamples {
+        return fmt.Errorf("header has %d variants × %d samples, expected %d × %d", nv, ns, r.nVariants, r.nSamples)
+    }
+    ctrl := hdr[11]
+
+    // 0-3: 4-bit vrtypes (hardcall-only files), 4-7: 8-bit vrtypes
+    storage := int(ctrl & 15)
+    var vrtypeBits, lenBytes int
+    switch {
+    case storage < 4:
+        vrtypeBits, lenBytes = 4, storage+1
+    case storage < 8:
+        vrtypeBits, lenBytes = 8, storage-3
+    default:
+        return fmt.Errorf("unsupported vrtype storage %d", storage)
+    }
+    alleleBytes := int(ctrl >> 4 & 3) // 0 when every variant is biallelic
+    nonrefStored := ctrl>>6 == 3
+
+    blockCt := (nv + blockSize - 1) / blockSize
+    blockPos := make([]byte, 8*blockCt)
+    if _, err := io.ReadFull(r.f, blockPos); err != nil {
+        return fmt.Errorf("read block offsets: %w", err)
+    }
+
+    r.vrtypes = make([]byte, nv)
+    r.offsets = make([]int64, nv+1)
+    for b := 0; b < blockCt; b++ {
+        first := b * blockSize
+        cnt := nv - first
+        if cnt > blockSize {
+            cnt = blockSize
+        }
+
+        typeBytes := cnt
+        if vrtypeBits == 4 {
+            typeBytes = (cnt + 1) / 2
+        }
+        buf := make([]byte, typeBytes+cnt*lenBytes)
+        if _, err := io.ReadFull(r.f, buf); err != nil {
+            return fmt.Errorf("read block %d index: %w", b, err)
+        }
+        for i := 0; i < cnt; i++ {
+            if vrtypeBits == 8 {
+                r.vrtypes[first+i] = buf[i]
+            } else {
+                r.vrtypes[first+i] = (buf[i/2] >> (4 * uint(i%2))) & 15
+            }
+        }
+
+        pos := int64(binary.LittleEndian.Uint64(blockPos[8*b:]))
+        lens := buf[typeBytes:]
+        for i := 0; i < cnt; i++ {
+            r.offsets[first+i] = pos
+            var l int64
+            for k := lenBytes - 1; k >= 0; k-- {
+                l = l<<8 | int64(lens[i*lenBytes+k])
+            }
+            pos += l
+        }
+        r.offsets[first+cnt] = pos
+
+        if alleleBytes > 0 {
+            counts := make([]byte, cnt*alleleBytes)
+            if _, err := io.ReadFull(r.f, counts); err != nil {
+                return fmt.Errorf("read block %d allele counts: %w", b, err)
+            }
+            for i := 0; i < cnt; i++ {
+                n := 0
+                for k := alleleBytes - 1; k >= 0; k-- {
+                    n = n<<8 | int(counts[i*alleleBytes+k])
+                }
+                if n > 2 {
+                    if r.multi == nil {
+                        r.multi = make([]bool, nv)
+                    }
+                    r.multi[first+i] = true
+                }
+            }
+        }
+        if nonrefStored {
+            if _, err := r.f.Seek(int64((cnt+7)/8), io.SeekCurrent); err != nil {
+                return err
+            }
+        }
+    }
+    return r.checkSize()
+}
+
+// Multiallelic reports whether variant idx has more than one ALT allele.
+// Only its REF/ALT1 hardcalls are stored in the main track, so Genotypes
+// returns it as all Missing rather than miscount the other ALT alleles.
+func (r *Reader) Multiallelic(idx int) bool {
+    return r.multi != nil && r.multi[idx]
+}
+
+// checkSize guards against index layouts this reader misinterpreted.
+func (r *Reader) checkSize() error {
+    st, err := r.f.Stat()
+    if err != nil {
+        return err
+    }
+    if end := r.offsets[len(r.offsets)-1]; end > st.Size() {
+        return fmt.Errorf("records end at byte %d beyond file size %d", end, st.Size())
+    }
+    return nil
+}
+
+// Genotypes decodes the hardcalls of variant idx into dst (resized to
+// NumSamples) as ALT allele counts, with Missing for no-calls.
+func (r *Reader) Genotypes(idx int, dst []byte) ([]byte, error) {
+    dst, _, err := r.hardcalls(idx, dst)
+    return dst, err
+}
+
+// HasDosage reports whether variant idx stores dosages beyond its hardcalls.
+func (r *Reader) HasDosage(idx int) bool {
+    return r.vrtypes != nil && r.vrtypes[idx]&0x60 != 0 && !r.Multiallelic(idx)
+}
+
+// Dosages decodes variant idx into dst (resized to NumSamples) as ALT allele
+// dosages in [0, 2], with NaN for missing. Samples without a stored dosage
+// get their hardcall, as plink2 reads them.
+func (r *Reader) Dosages(idx int, dst []float64) ([]float64, error) {
+    geno, aux, err := r.hardcalls(idx, nil)
+    if err != nil {
+        return nil, err
+    }
+    if cap(dst) < r.nSamples {
+        dst = make([]float64, r.nSamples)
+    }
+    dst = dst[:r.nSamples]
+    for i, g := range geno {
+        if g == Missing {
+            dst[i] = math.NaN()
+        } else {
+            dst[i] = float64(g)
+        }
+    }
+    if !r.HasDosage(idx) {
+        return dst, nil
+    }
+    t := r.vrtypes[idx]
+    if t&0x08 != 0 {
+        return nil, fmt.Errorf("variant %d: dosages after a multiallelic hardcall track are not supported", idx)
+    }
+    if t&0x10 != 0 {
+        n, err := phaseTrackLen(aux, geno)
+        if err != nil {
+            return nil, fmt.Errorf("variant %d: %w", idx, err)
+        }
+        aux = aux[n:]
+    }
+    if err := readDosageTrack(aux, t&0x60, dst); err != nil {
+        return nil, fmt.Errorf("variant %d: %w", idx, err)
+    }
+    return dst, nil
+}
+
+// hardcalls decodes the main track of variant idx into dst and returns the
+// record bytes that follow it (the phase and dosage tracks).
+func (r *Reader) hardcalls(idx int, dst []byte) ([]byte, []byte, error) {
+    if idx < 0 || idx >= r.nVariants {
+        return nil, nil, fmt.Errorf("variant index %d out of range", idx)
+    }
+    if cap(dst) < r.nSamples {
+        dst = make([]byte, r.nSamples)
+    }
+    dst = dst[:r.nSamples]
+
+    if r.Multiallelic(idx) {
+        for i := range dst {
+            dst[i] = Missing
+        }
+        return dst, nil, nil
+    }
+
+    if r.vrtypes == nil {
+        rec, err := r.record(idx)
+        if err != nil {
+            return nil, nil, err
+        }
+        unpackNyp(rec, dst)
+        for i, g := range dst {
+            dst[i] = bedGeno[g]
+        }
+        return dst, nil, nil
+    }
+
+    if t := r.vrtypes[idx] & 7; t == 2 || t == 3 {
+        base := idx - 1
+        for base >= 0 && (r.vrtypes[base]&7 == 2 || r.vrtypes[base]&7 == 3) {
+            base--
+        }
+        if base < 0 {
+            return nil, nil, fmt.Errorf("variant %d: LD-compressed without a base record", idx)
+        }
+        if base != r.baseIdx {
+            g, _, err := r.decode(base, make([]byte, r.nSamples))
+            if err != nil {
+                return nil, nil, err
+            }
+            r.baseIdx, r.baseGeno = base, g
+        }
+        copy(dst, r.baseGeno)
+        rec, err := r.record(idx)
+        if err != nil {
+            return nil, nil, err
+        }
+        n, err := applyDifflist(rec, dst, r.nSamples)
+        if err != nil {
+            return nil, nil, fmt.Errorf("variant %d: %w", idx, err)
+        }
+        // an inverted record's difflist is relative to the base, so it is
+        // merged first and the result inverted
+        if t == 3 {
+            for i, g := range dst {
+                if g == 0 || g == 2 {
+                    dst[i] = 2 - g
+                }
+            }
+        }
+        return dst, rec[n:], nil
+    }
+
+    g, aux, err := r.decode(idx, dst)
+    if err == nil {
+        r.baseIdx, r.baseGeno = idx, append(r.baseGeno[:0], g...)
+    }
+    return g, aux, err
+}
+
+// decode handles the non-LD-compressed record types of the variable-width
+// format, returning the bytes after the main track.
+func (r *Reader) decode(idx int, dst []byte) ([]byte, []byte, error) {
+    rec, err := r.record(idx)
+    if err != nil {
+        return nil, nil, err
+    }
+    var n int
+    switch t := r.vrtypes[idx] & 7; t {
+    case 0: // raw 2-bit array
+        n = nypByteCount(r.nSamples)
+        if len(rec) < n {
+            return nil, nil, fmt.Errorf("variant %d: short record", idx)
+        }
+        unpackNyp(rec, dst)
+    case 1: // 1-bit array choosing between two genotypes, plus exceptions
+        bits := (r.nSamples + 7) / 8
+        if len(rec) < 1+bits {
+            return nil, nil, fmt.Errorf("variant %d: short record", idx)
+        }
+        lo := rec[0] / 4
+        hi := lo + rec[0]&3
+        for i := range dst {
+            if rec[1+i/8]>>(uint(i)%8)&1 == 1 {
+                dst[i] = hi
+            } else {
+                dst[i] = lo
+            }
+        }
+        d, err := applyDifflist(rec[1+bits:], dst, r.nSamples)
+        if err != nil {
+            return nil, nil, fmt.Errorf("variant %d: %w", idx, err)
+        }
+        n = 1 + bits + d
+    case 4, 6, 7: // one common genotype plus exceptions
+        common := byte(0)
+        if t == 6 {
+            common = 2
+        } else if t == 7 {
+            common = Missing
+        }
+        for i := range dst {
+            dst[i] = common
+        }
+        if n, err = applyDifflist(rec, dst, r.nSamples); err != nil {
+            return nil, nil, fmt.Errorf("variant %d: %w", idx, err)
+        }
+    default:
+        return nil, nil, fmt.Errorf("variant %d: unsupported record type %d", idx, t)
+    }
+    return dst, rec[n:], nil
+}
+
+// record reads the raw bytes of variant idx.
+func (r *Reader) record(idx int) ([]byte, error) {
+    buf := make([]byte, r.offsets[idx+1]-r.offsets[idx])
+    if _, err := r.f.ReadAt(buf, r.offsets[idx]); err != nil {
+        return nil, fmt.Errorf("read variant %d: %w", idx, err)
+    }
+    return buf, nil
+}
+
+// phaseTrackLen returns the length of a hardcall phase track, given the
+// variant's hardcalls. The first bit says whether a per-het "phase present"
+// bitarray follows; either way the first part holds 1+hets bits, and with
+// the flag set, one more phase bit per phased het follows byte-aligned.
+func phaseTrackLen(buf, geno []byte) (int, error) {
+    hets := 0
+    for _, g := range geno {
+        if g == 1 {
+            hets++
+        }
+    }
+    n := 1 + hets/8
+    if len(buf) < n {
+        return 0, errors.New("short phase track")
+    }
+    if buf[0]&1 == 0 {
+        return n, nil
+    }
+    phased := -1 // the flag bit itself
+    for _, b := range buf[:n] {
+        phased += bits.OnesCount8(b)
+    }
+    n += (phased + 7) / 8
+    if len(buf) < n {
+        return 0, errors.New("short phase track")
+    }
+    return n, nil
+}
+
+// dosageUnit is the stored dosage of one ALT allele; 65535 marks missing.
+const (
+    dosageUnit    = 16384
+    dosageMissing = 65535
+)
+
+// readDosageTrack overwrites dst with the dosages of a dosage track of the
+// given kind (vrtype bits 5-6):
+//
+//   0x20  a sample ID list (a difflist without genotypes), then a uint16 per listed sample
+//   0x40  a uint16 for every sample
+//   0x60  a bitarray of the samples with a dosage, then a uint16 per set bit
+func readDosageTrack(buf []byte, kind byte, dst []float64) error {
+    var samples []int
+    switch kind {
+    case 0x20:
+        n, err := parseDifflist(buf, len(dst), false, func(s int, _ byte) { samples = append(samples, s) })
+        if err != nil {
+            return err
+        }
+        buf = buf[n:]
+    case 0x40:
+        samples = make([]int, len(dst))
+        for i := range samples {
+            samples[i] = i
+        }
+    case 0x60:
+        n := (len(dst) + 7) / 8
+        if len(buf) < n {
+            return errors.New("short dosage track")
+        }
+        for i := range dst {
+            if buf[i/8]>>(uint(i)%8)&1 == 1 {
+                samples = append(samples, i)
+            }
+        }
+        buf = buf[n:]
+    }
+    if len(buf) < 2*len(samples) {
+        return errors.New("short dosage track")
+    }
+    for k, s := range samples {
+        v := binary.LittleEndian.Uint16(buf[2*k:])
+        if v == dosageMissing {
+            dst[s] = math.NaN()
+        } else {
+            dst[s] = float64(v) / dosageUnit
+        }
+    }
+    return nil
+}
+
+// applyDifflist overwrites dst entries listed in a difflist (see
+// parseDifflist). Returns the number of bytes consumed.
+func applyDifflist(buf, dst []byte, nSamples int) (int, error) {
+    return parseDifflist(buf, nSamples, true, func(s int, g byte) {
+        dst[s] = g
+    })
+}
+
+// parseDifflist calls fn for every entry of a difflist:
+//
+//   varint length L
+//   ⌈L/64⌉ group start sample IDs (fixed width)
+//   ⌈L/64⌉-1 group size bytes (unused here)
+//   ⌈L/4⌉ bytes of 2-bit genotypes, only if withGeno
+//   varint deltas for the remaining members of each group
+//
+// Without genotypes (the sample lists of dosage tracks) fn gets 0.
+// Returns the number of bytes consumed.
+func parseDifflist(buf []byte, nSamples int, withGeno bool, fn func(sample int, geno byte)) (int, error) {
+    l, p := binary.Uvarint(buf)
+    if p <= 0 {
+        return 0, errors.New("bad difflist length")
+    }
+    n := int(l)
+    if n == 0 {
+        return p, nil
+    }
+    idBytes := bytesToRepresent(nSamples)
+    groups := (n + 63) / 64
+    genoBytes := 0
+    if withGeno {
+        genoBytes = (n + 3) / 4
+    }
+    need := p + groups*idBytes + (groups - 1) + genoBytes
+    if len(buf) < need {
+        return 0, errors.New("short difflist")
+    }
+
+    starts := make([]int, groups)
+    for g := range starts {
+        v := 0
+        for k := idBytes - 1; k >= 0; k-- {
+            v = v<<8 | int(buf[p+g*idBytes+k])
+        }
+        starts[g] = v
+    }
+    p += groups*idBytes + (groups - 1)
+    geno := buf[p : p+genoBytes]
+    p += genoBytes
+
+    sample := 0
+    for i := 0; i < n; i++ {
+        if i%64 == 0 {
+            sample = starts[i/64]
+        } else {
+            d, k := binary.Uvarint(buf[p:])
+            if k <= 0 {
+                return 0, errors.New("bad difflist delta")
+            }
+            p += k
+            sample += int(d)
+        }
+        if sample >= nSamples {
+            return 0, fmt.Errorf("difflist sample %d out of range", sample)
+        }
+        var g byte
+        if withGeno {
+            g = geno[i/4] >> (2 * uint(i%4)) & 3
+        }
+        fn(sample, g)
+    }
+    return p, nil
+}
+
+// bedGeno maps .bed codes (A1 = ALT) to ALT allele counts:
+// 00 → 2, 01 → Missing, 10 → 1, 11 → 0.
+var bedGeno = [4]byte{2, Missing, 1, 0}
+
+// unpackNyp expands a packed 2-bit genotype array.
+func unpackNyp(rec, dst []byte) {
+    for i := range dst {
+        dst[i] = rec[i/4] >> (2 * uint(i%4)) & 3
+    }
+}
+
+func nypByteCount(n int) int { return (n + 3) / 4 }
+
+// bytesToRepresent returns how many bytes are needed to store n, which is
+// the width plink2 uses for sample IDs in difflists.
+func bytesToRepresent(n int) int {
+    b := 1
+    for v := n; v > 255; v >>= 8 {
+        b++
+    }
+    return b
+}
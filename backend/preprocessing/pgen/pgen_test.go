@@ -0,0 +1,458 @@
+package pgen
+
+import (
+    "bufio"
+    "encoding/binary"
+    "flag"
+    "math"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "testing"
+)
+
+// The tests below build variable-width (mode 0x10) .pgen files byte by byte
+// from the PGEN spec, independently of the reader, with every record type
+// the reader decodes. TestVariableWidthPlink2 checks files plink2 wrote.
+
+// diff is one difflist entry.
+type diff struct {
+    sample int
+    geno   byte
+}
+
+// encodeDifflist encodes entries (ascending samples, fewer than 64, under
+// 256 samples) as a difflist, or as a sample ID list without genotypes.
+func encodeDifflist(entries []diff, withGeno bool) []byte {
+    out := binary.AppendUvarint(nil, uint64(len(entries)))
+    if len(entries) == 0 {
+        return out
+    }
+    out = append(out, byte(entries[0].sample)) // one group: its start, no size bytes
+    if withGeno {
+        geno := make([]byte, (len(entries)+3)/4)
+        for i, e := range entries {
+            geno[i/4] |= e.geno << (2 * uint(i%4))
+        }
+        out = append(out, geno...)
+    }
+    for i := 1; i < len(entries); i++ {
+        out = binary.AppendUvarint(out, uint64(entries[i].sample-entries[i-1].sample))
+    }
+    return out
+}
+
+// packNyp packs 2-bit genotypes, first sample in the low bits.
+func packNyp(geno []byte) []byte {
+    out := make([]byte, (len(geno)+3)/4)
+    for i, g := range geno {
+        out[i/4] |= g << (2 * uint(i%4))
+    }
+    return out
+}
+
+// u16s encodes dosages in 1/16384 units, little-endian.
+func u16s(vals ...uint16) []byte {
+    out := make([]byte, 2*len(vals))
+    for i, v := range vals {
+        binary.LittleEndian.PutUint16(out[2*i:], v)
+    }
+    return out
+}
+
+// fixtureRecord is one variant record of a hand-built .pgen.
+type fixtureRecord struct {
+    vrtype byte
+    body   []byte
+}
+
+// writeVariableWidth writes a mode 0x10 .pgen with one variant block,
+// 1-byte record lengths and 4- or 8-bit vrtypes.
+func writeVariableWidth(t *testing.T, nSamples int, recs []fixtureRecord, eightBit bool) string {
+    t.Helper()
+    var types []byte
+    ctrl := byte(0) // 4-bit vrtypes, 1-byte lengths
+    if eightBit {
+        ctrl = 4
+        for _, r := range recs {
+            types = append(types, r.vrtype)
+        }
+    } else {
+        types = make([]byte, (len(recs)+1)/2)
+        for i, r := range recs {
+            types[i/2] |= r.vrtype << (4 * uint(i%2))
+        }
+    }
+
+    out := []byte{0x6c, 0x1b, 0x10}
+    out = binary.LittleEndian.AppendUint32(out, uint32(len(recs)))
+    out = binary.LittleEndian.AppendUint32(out, uint32(nSamples))
+    out = append(out, ctrl)
+    first := len(out) + 8 + len(types) + len(recs)
+    out = binary.LittleEndian.AppendUint64(out, uint64(first))
+    out = append(out, types...)
+    for _, r := range recs {
+        if len(r.body) > 255 {
+            t.Fatal("record too long for 1-byte lengths")
+        }
+        out = append(out, byte(len(r.body)))
+    }
+    for _, r := range recs {
+        out = append(out, r.body...)
+    }
+
+    path := filepath.Join(t.TempDir(), "fixture.pgen")
+    if err := os.WriteFile(path, out, 0o644); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+const M = Missing
+
+// hardcallRecords covers every hardcall record type, with the genotypes
+// each decodes to. Ten samples.
+var hardcallRecords = []struct {
+    rec  fixtureRecord
+    want []byte
+}{
+    // 0: raw 2-bit array
+    {fixtureRecord{0, packNyp([]byte{0, 1, 2, M, 0, 1, 2, 0, 0, 1})},
+        []byte{0, 1, 2, M, 0, 1, 2, 0, 0, 1}},
+    // 2: LD-compressed against variant 0
+    {fixtureRecord{2, encodeDifflist([]diff{{1, 2}, {3, 0}}, true)},
+        []byte{0, 2, 2, 0, 0, 1, 2, 0, 0, 1}},
+    // 3: inverted LD against variant 0 (the last non-LD record): difflist
+    // applied to the base, then 0 and 2 swapped
+    {fixtureRecord{3, encodeDifflist([]diff{{0, 1}}, true)},
+        []byte{1, 1, 0, M, 2, 1, 0, 2, 2, 1}},
+    // 4: all hom-ref plus exceptions
+    {fixtureRecord{4, encodeDifflist([]diff{{2, 2}, {7, 1}}, true)},
+        []byte{0, 0, 2, 0, 0, 0, 0, 1, 0, 0}},
+    // 6: all hom-alt plus exceptions
+    {fixtureRecord{6, encodeDifflist([]diff{{9, M}}, true)},
+        []byte{2, 2, 2, 2, 2, 2, 2, 2, 2, M}},
+    // 7: all missing plus exceptions
+    {fixtureRecord{7, encodeDifflist([]diff{{0, 0}, {5, 1}}, true)},
+        []byte{0, M, M, M, M, 1, M, M, M, M}},
+    // 1: 1-bit array over hom-ref (0) and het (1), plus exceptions
+    {fixtureRecord{1, append([]byte{0x01, 0b0001_1010, 0b00}, encodeDifflist([]diff{{8, 2}}, true)...)},
+        []byte{0, 1, 0, 1, 1, 0, 0, 0, 2, 0}},
+    // 2: LD-compressed against the 1-bit record, now the last non-LD one
+    {fixtureRecord{2, encodeDifflist([]diff{{0, 2}}, true)},
+        []byte{2, 1, 0, 1, 1, 0, 0, 0, 2, 0}},
+}
+
+func TestVariableWidthHardcalls(t *testing.T) {
+    recs := make([]fixtureRecord, len(hardcallRecords))
+    for i, r := range hardcallRecords {
+        recs[i] = r.rec
+    }
+    for _, eightBit := range []bool{false, true} {
+        path := writeVariableWidth(t, 10, recs, eightBit)
+        r, err := Open(path, len(recs), 10)
+        if err != nil {
+            t.Fatal(err)
+        }
+        // in order, then backwards so LD bases are decoded out of order
+        order := make([]int, 0, 2*len(recs))
+        for i := range recs {
+            order = append(order, i)
+        }
+        for i := len(recs) - 1; i >= 0; i-- {
+            order = append(order, i)
+        }
+        for _, i := range order {
+            got, err := r.Genotypes(i, nil)
+            if err != nil {
+                t.Fatalf("eightBit=%v variant %d: %v", eightBit, i, err)
+            }
+            if string(got) != string(hardcallRecords[i].want) {
+                t.Errorf("eightBit=%v variant %d: got %v, want %v", eightBit, i, got, hardcallRecords[i].want)
+            }
+            if r.HasDosage(i) {
+                t.Errorf("variant %d reports dosages", i)
+            }
+        }
+        r.Close()
+    }
+}
+
+func TestVariableWidthDosages(t *testing.T) {
+    nan := math.NaN()
+    cases := []struct {
+        rec  fixtureRecord
+        geno []byte
+        want []float64
+    }{
+        // raw hardcalls, phase track without a phase-present array (3 hets),
+        // dense dosages with one missing
+        {
+            fixtureRecord{0x10 | 0x40, concat(
+                packNyp([]byte{0, 1, 1, 2, M, 0, 0, 1, 2, 0}),
+                []byte{0b0000_0100},
+                u16s(0, 8192, 16384, 32768, 65535, 1638, 0, 24576, 32768, 100),
+            )},
+            []byte{0, 1, 1, 2, M, 0, 0, 1, 2, 0},
+            []float64{0, 0.5, 1, 2, nan, 1638.0 / 16384, 0, 1.5, 2, 100.0 / 16384},
+        },
+        // hom-ref difflist, phase track with a phase-present array (2 hets,
+        // 1 phased, so one more byte), dosage bitarray for samples 2 and 6
+        {
+            fixtureRecord{0x04 | 0x10 | 0x60, concat(
+                encodeDifflist([]diff{{2, 1}, {5, 1}}, true),
+                []byte{0b0000_0011, 0b0000_0001},
+                []byte{0b0100_0100, 0},
+                u16s(20480, 1638),
+            )},
+            []byte{0, 0, 1, 0, 0, 1, 0, 0, 0, 0},
+            []float64{0, 0, 1.25, 0, 0, 1, 1638.0 / 16384, 0, 0, 0},
+        },
+        // raw hardcalls with a missing call, dosage list for samples 3 and 7
+        {
+            fixtureRecord{0x20, concat(
+                packNyp([]byte{0, 0, 0, M, 0, 0, 0, 2, 0, 0}),
+                encodeDifflist([]diff{{3, 0}, {7, 0}}, false),
+                u16s(4096, 30000),
+            )},
+            []byte{0, 0, 0, M, 0, 0, 0, 2, 0, 0},
+            []float64{0, 0, 0, 0.25, 0, 0, 0, 30000.0 / 16384, 0, 0},
+        },
+        // LD-compressed against the record above, without dosages: the
+        // hardcalls only, with NaN for the missing call
+        {
+            fixtureRecord{2, encodeDifflist([]diff{{0, 1}}, true)},
+            []byte{1, 0, 0, M, 0, 0, 0, 2, 0, 0},
+            []float64{1, 0, 0, nan, 0, 0, 0, 2, 0, 0},
+        },
+    }
+    recs := make([]fixtureRecord, len(cases))
+    for i, c := range cases {
+        recs[i] = c.rec
+    }
+    path := writeVariableWidth(t, 10, recs, true)
+    r, err := Open(path, len(recs), 10)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer r.Close()
+
+    for i, c := range cases {
+        geno, err := r.Genotypes(i, nil)
+        if err != nil {
+            t.Fatalf("variant %d: %v", i, err)
+        }
+        if string(geno) != string(c.geno) {
+            t.Errorf("variant %d hardcalls: got %v, want %v", i, geno, c.geno)
+        }
+        if got, want := r.HasDosage(i), c.rec.vrtype&0x60 != 0; got != want {
+            t.Errorf("variant %d: HasDosage = %v, want %v", i, got, want)
+        }
+        got, err := r.Dosages(i, nil)
+        if err != nil {
+            t.Fatalf("variant %d: %v", i, err)
+        }
+        for s, w := range c.want {
+            if math.IsNaN(w) != math.IsNaN(got[s]) || (!math.IsNaN(w) && math.Abs(got[s]-w) > 1e-9) {
+                t.Errorf("variant %d sample %d: dosage %g, want %g", i, s, got[s], w)
+            }
+        }
+    }
+}
+
+func concat(parts ...[]byte) []byte {
+    var out []byte
+    for _, p := range parts {
+        out = append(out, p...)
+    }
+    return out
+}
+
+// updatePgen makes TestVariableWidthPlink2 save the filesets plink2 writes
+// into testdata/mode10, where TestVariableWidthCommitted reads them.
+var updatePgen = flag.Bool("update-pgen", false, "save plink2-written mode 0x10 fixtures to testdata/mode10")
+
+// plink2Fixtures are the VCFs in testdata/mode10, chosen so plink2 writes
+// LD-compressed (plain and inverted), 1-bit, difflist and phased records,
+// and dosage tracks. The expected values come from the VCFs themselves.
+var plink2Fixtures = []struct {
+    name    string
+    dosages bool
+}{
+    {"hardcalls", false},
+    {"dosages", true},
+}
+
+// TestVariableWidthPlink2 converts the fixture VCFs with plink2 and checks
+// the decoded records against the VCFs. Skipped when plink2 is not
+// installed, unless EASY_PGS_REQUIRE_PLINK2 is set.
+func TestVariableWidthPlink2(t *testing.T) {
+    exe := plink2Exe(t)
+    for _, fx := range plink2Fixtures {
+        t.Run(fx.name, func(t *testing.T) {
+            vcf := filepath.Join("testdata", "mode10", fx.name+".vcf")
+            out := filepath.Join(t.TempDir(), fx.name)
+            args := []string{"--vcf", vcf}
+            if fx.dosages {
+                args = append(args, "dosage=DS")
+            }
+            args = append(args, "--make-pgen", "--out", out)
+            if b, err := exec.Command(exe, args...).CombinedOutput(); err != nil {
+                t.Fatalf("plink2: %v\n%s", err, b)
+            }
+            checkAgainstVCF(t, out, vcf, fx.dosages)
+            if *updatePgen {
+                for _, ext := range []string{".pgen", ".pvar", ".psam"} {
+                    b, err := os.ReadFile(out + ext)
+                    if err != nil {
+                        t.Fatal(err)
+                    }
+                    if err := os.WriteFile(filepath.Join("testdata", "mode10", fx.name+ext), b, 0o644); err != nil {
+                        t.Fatal(err)
+                    }
+                }
+            }
+        })
+    }
+}
+
+// TestVariableWidthCommitted checks the plink2-written filesets saved with
+// -update-pgen, so the decoder is tested against them without plink2.
+func TestVariableWidthCommitted(t *testing.T) {
+    for _, fx := range plink2Fixtures {
+        t.Run(fx.name, func(t *testing.T) {
+            prefix := filepath.Join("testdata", "mode10", fx.name)
+            if _, err := os.Stat(prefix + ".pgen"); err != nil {
+                t.Skip("no plink2-written fixture; generate it with -update-pgen")
+            }
+            checkAgainstVCF(t, prefix, prefix+".vcf", fx.dosages)
+        })
+    }
+}
+
+// checkAgainstVCF decodes every variant of the fileset at prefix and
+// compares it with the GT (or, with dosages, DS) fields of vcf. It also
+// checks that the file uses the record types the fixture is meant to cover.
+func checkAgainstVCF(t *testing.T, prefix, vcf string, dosages bool) {
+    t.Helper()
+    want := readVCF(t, vcf)
+    pf, err := OpenPfile(prefix)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer pf.Close()
+    if len(pf.Variants) != len(want) {
+        t.Fatalf("%d variants, want %d", len(pf.Variants), len(want))
+    }
+
+    seen := map[byte]bool{}
+    for i, w := range want {
+        if pf.Variants[i].ID != w.id {
+            t.Fatalf("variant %d is %s, want %s", i, pf.Variants[i].ID, w.id)
+        }
+        seen[pf.vrtypes[i]&7] = true
+        if pf.vrtypes[i]&0x10 != 0 {
+            seen['p'] = true
+        }
+        if dosages {
+            got, err := pf.Dosages(i, nil)
+            if err != nil {
+                t.Fatal(err)
+            }
+            for s, d := range w.ds {
+                if math.IsNaN(d) != math.IsNaN(got[s]) || (!math.IsNaN(d) && math.Abs(got[s]-d) > 1.0/dosageUnit) {
+                    t.Errorf("%s sample %d: dosage %g, want %g", w.id, s, got[s], d)
+                }
+            }
+            if pf.HasDosage(i) {
+                seen['d'] = true
+            }
+            continue
+        }
+        got, err := pf.Genotypes(i, nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if string(got) != string(w.gt) {
+            t.Errorf("%s: got %v, want %v", w.id, got, w.gt)
+        }
+    }
+
+    if dosages {
+        if !seen['d'] {
+            t.Error("no variant has a dosage track")
+        }
+        return
+    }
+    if !seen[2] && !seen[3] {
+        t.Error("no LD-compressed record")
+    }
+    if !seen[1] {
+        t.Error("no 1-bit record")
+    }
+    if !seen[4] && !seen[6] && !seen[7] {
+        t.Error("no difflist record")
+    }
+    if !seen['p'] {
+        t.Error("no phased record")
+    }
+}
+
+// vcfRow is one variant of a fixture VCF.
+type vcfRow struct {
+    id string
+    gt []byte    // ALT counts, Missing for no-calls
+    ds []float64 // DS, NaN when missing; hardcall-derived when the row has no DS
+}
+
+// readVCF reads the biallelic GT and DS fields of a fixture VCF.
+func readVCF(t *testing.T, path string) []vcfRow {
+    t.Helper()
+    f, err := os.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    var rows []vcfRow
+    sc := bufio.NewScanner(f)
+    sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+    for sc.Scan() {
+        line := sc.Text()
+        if strings.HasPrefix(line, "#") {
+            continue
+        }
+        cols := strings.Split(line, "\t")
+        format := strings.Split(cols[8], ":")
+        dsIdx := -1
+        for i, k := range format {
+            if k == "DS" {
+                dsIdx = i
+            }
+        }
+        row := vcfRow{id: cols[2]}
+        for _, cell := range cols[9:] {
+            fields := strings.Split(cell, ":")
+            g := byte(Missing)
+            if a := fields[0]; a[0] != '.' {
+                g = a[0] - '0' + a[2] - '0'
+            }
+            row.gt = append(row.gt, g)
+            d := math.NaN()
+            if dsIdx >= 0 && fields[dsIdx] != "." {
+                if d, err = strconv.ParseFloat(fields[dsIdx], 64); err != nil {
+                    t.Fatal(err)
+                }
+            } else if g != Missing {
+                d = float64(g)
+            }
+            row.ds = append(row.ds, d)
+        }
+        rows = append(rows, row)
+    }
+    if err := sc.Err(); err != nil {
+        t.Fatal(err)
+    }
+    return rows
+}
@@ -0,0 +1,77 @@
+package pgen
+
+import (
+    "bufio"
+    "fmt"
+    "os"
+    "strings"
+)
+
+// Sample is one row of a .psam file. Fields holds every column by header name
+// (without the leading '#'), so optional columns such as SuperPop are available.
+type Sample struct {
+    FID    string
+    IID    string
+    Fields map[string]string
+}
+
+// ReadPsam reads every sample of a .psam (or headerless .fam) file, in file order.
+func ReadPsam(path string) ([]Sample, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    // default: .fam order
+    header := []string{"FID", "IID", "PAT", "MAT", "SEX", "PHENO1"}
+    var out []Sample
+    sc := bufio.NewScanner(f)
+    for sc.Scan() {
+        line := sc.Text()
+        if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "##") {
+            continue
+        }
+        cols := strings.Fields(line)
+        if strings.HasPrefix(line, "#") {
+            header = cols
+            header[0] = strings.TrimPrefix(header[0], "#")
+            continue
+        }
+        s := Sample{Fields: make(map[string]string, len(header))}
+        for i, h := range header {
+            if i < len(cols) {
+                s.Fields[h] = cols[i]
+            }
+        }
+        s.FID, s.IID = s.Fields["FID"], s.Fields["IID"]
+        if s.IID == "" {
+            return nil, fmt.Errorf("%s: row without IID: %q", path, line)
+        }
+        out = append(out, s)
+    }
+    return out, sc.Err()
+}
+
+// SuperPop returns the 1000 Genomes superpopulation code (AFR, AMR, EAS, EUR, SAS),
+// or "" when the .psam has no SuperPop column.
+func (s Sample) SuperPop() string {
+    return s.Fields["SuperPop"]
+}
+
+// Population returns the 1000 Genomes population code (e.g. GBR), or "".
+func (s Sample) Population() string {
+    return s.Fields["Population"]
+}
+
+// Sex returns "male", "female" or "" (unknown) from the SEX column.
+func (s Sample) Sex() string {
+    switch strings.ToLower(s.Fields["SEX"]) {
+    case "1", "m", "male":
+        return "male"
+    case "2", "f", "female":
+        return "female"
+    default:
+        return ""
+    }
+}
@@ -0,0 +1,79 @@
+package pgen
+
+import (
+    "bufio"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+)
+
+// Variant is one row of a .pvar file.
+type Variant struct {
+    Chr string
+    Pos int
+    ID  string
+    Ref string
+    Alt string
+}
+
+// ReadPvar reads every variant of a .pvar file, in file order.
+// Files without a #CHROM header are read in .bim column order.
+func ReadPvar(path string) ([]Variant, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    // default: headerless .pvar uses .bim order (CHROM ID CM POS ALT REF)
+    chrIdx, idIdx, posIdx, altIdx, refIdx := 0, 1, 3, 4, 5
+    headerSeen := false
+
+    var out []Variant
+    sc := bufio.NewScanner(f)
+    sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+    for sc.Scan() {
+        line := sc.Text()
+        if strings.HasPrefix(line, "##") || line == "" {
+            continue
+        }
+        cols := strings.Fields(line)
+        if strings.HasPrefix(line, "#CHROM") {
+            for i, c := range cols {
+                switch strings.TrimPrefix(c, "#") {
+                case "CHROM":
+                    chrIdx = i
+                case "POS":
+                    posIdx = i
+                case "ID":
+                    idIdx = i
+                case "REF":
+                    refIdx = i
+                case "ALT":
+                    altIdx = i
+                }
+            }
+            headerSeen = true
+            continue
+        }
+        if !headerSeen && len(cols) == 5 {
+            posIdx, altIdx, refIdx = 2, 3, 4 // .bim without CM column
+        }
+        if len(cols) <= refIdx || len(cols) <= altIdx {
+            return nil, fmt.Errorf("%s: malformed row %q", path, line)
+        }
+        pos, err := strconv.Atoi(cols[posIdx])
+        if err != nil {
+            return nil, fmt.Errorf("%s: bad position in %q", path, line)
+        }
+        out = append(out, Variant{
+            Chr: strings.TrimPrefix(cols[chrIdx], "chr"),
+            Pos: pos,
+            ID:  cols[idIdx],
+            Ref: strings.ToUpper(cols[refIdx]),
+            Alt: strings.ToUpper(cols[altIdx]),
+        })
+    }
+    return out, sc.Err()
+}
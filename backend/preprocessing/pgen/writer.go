@@ -0,0 +1,177 @@
+package pgen
+
+import (
+    "bufio"
+    "fmt"
+    "os"
+)
+
+// Writer emits a fixed-width hardcall fileset (storage mode 0x01, whose
+// records are PLINK 1 .bed records): <prefix>.pgen/.pvar/.psam and,
+// optionally, the PLINK 1 equivalents <prefix>.bed/.bim/.fam. Close also
+// writes the variant index <prefix>.vidx.
+type Writer struct {
+    prefix   string
+    vars     []Variant
+    nSamples int
+    files    []*os.File
+    pgen     *bufio.Writer
+    pvar     *bufio.Writer
+    bed      *bufio.Writer // nil unless PLINK 1 output was requested
+    bim      *bufio.Writer
+    rec      []byte
+}
+
+// Create opens the output files and writes the headers and sample tables.
+// Variants must then be written in the desired file order.
+func Create(prefix string, samples []Sample, withBed bool) (*Writer, error) {
+    w := &Writer{prefix: prefix, nSamples: len(samples), rec: make([]byte, nypByteCount(len(samples)))}
+    open := func(ext string) (*bufio.Writer, error) {
+        f, err := os.Create(prefix + ext)
+        if err != nil {
+            return nil, err
+        }
+        w.files = append(w.files, f)
+        return bufio.NewWriter(f), nil
+    }
+
+    var err error
+    if w.pgen, err = open(".pgen"); err != nil {
+        w.abort()
+        return nil, err
+    }
+    w.pgen.Write([]byte{0x6c, 0x1b, modeFixedHardcall})
+    if w.pvar, err = open(".pvar"); err != nil {
+        w.abort()
+        return nil, err
+    }
+    fmt.Fprintln(w.pvar, "#CHROM\tPOS\tID\tREF\tALT")
+
+    psam, err := open(".psam")
+    if err != nil {
+        w.abort()
+        return nil, err
+    }
+    fmt.Fprintln(psam, "#IID\tSEX")
+    for _, s := range samples {
+        fmt.Fprintf(psam, "%s\t%s\n", s.IID, sexCode(s.Sex(), "NA"))
+    }
+    if err := psam.Flush(); err != nil {
+        w.abort()
+        return nil, err
+    }
+
+    if withBed {
+        if w.bed, err = open(".bed"); err != nil {
+            w.abort()
+            return nil, err
+        }
+        w.bed.Write([]byte{0x6c, 0x1b, 0x01}) // SNP-major
+        if w.bim, err = open(".bim"); err != nil {
+            w.abort()
+            return nil, err
+        }
+        fam, err := open(".fam")
+        if err != nil {
+            w.abort()
+            return nil, err
+        }
+        for _, s := range samples {
+            fid := s.FID
+            if fid == "" {
+                fid = s.IID
+            }
+            fmt.Fprintf(fam, "%s\t%s\t0\t0\t%s\t-9\n", fid, s.IID, sexCode(s.Sex(), "0"))
+        }
+        if err := fam.Flush(); err != nil {
+            w.abort()
+            return nil, err
+        }
+    }
+    return w, nil
+}
+
+// WriteVariant appends one variant. geno holds one ALT allele count
+// (0, 1, 2 or Missing) per sample.
+func (w *Writer) WriteVariant(v Variant, geno []byte) error {
+    if len(geno) != w.nSamples {
+        return fmt.Errorf("variant %s: %d genotypes for %d samples", v.ID, len(geno), w.nSamples)
+    }
+    alt := v.Alt
+    if alt == "" {
+        alt = "."
+    }
+
+    // mode 0x01 records use the .bed encoding, so the .bed body is the same
+    for i := range w.rec {
+        w.rec[i] = 0
+    }
+    for i, g := range geno {
+        w.rec[i/4] |= bedCode[g&3] << (2 * uint(i%4))
+    }
+    if _, err := w.pgen.Write(w.rec); err != nil {
+        return err
+    }
+    if _, err := fmt.Fprintf(w.pvar, "%s\t%d\t%s\t%s\t%s\n", v.Chr, v.Pos, v.ID, v.Ref, alt); err != nil {
+        return err
+    }
+    v.Alt = alt
+    w.vars = append(w.vars, v)
+
+    if w.bed == nil {
+        return nil
+    }
+    if _, err := w.bed.Write(w.rec); err != nil {
+        return err
+    }
+    a1 := alt
+    if a1 == "." {
+        a1 = "0"
+    }
+    _, err := fmt.Fprintf(w.bim, "%s\t%s\t0\t%d\t%s\t%s\n", v.Chr, v.ID, v.Pos, a1, v.Ref)
+    return err
+}
+
+// Close flushes and closes every output file, then writes the variant index.
+func (w *Writer) Close() error {
+    var first error
+    for _, bw := range []*bufio.Writer{w.pgen, w.pvar, w.bed, w.bim} {
+        if bw != nil {
+            if err := bw.Flush(); err != nil && first == nil {
+                first = err
+            }
+        }
+    }
+    for _, f := range w.files {
+        if err := f.Close(); err != nil && first == nil {
+            first = err
+        }
+    }
+    if first != nil {
+        return first
+    }
+    return NewIndex(w.vars).Write(w.prefix + IndexExt)
+}
+
+// abort closes whatever was opened when Create fails part-way.
+func (w *Writer) abort() {
+    for _, f := range w.files {
+        f.Close()
+    }
+}
+
+// bedCode maps ALT allele counts to .bed codes with A1 = ALT:
+// 0 → 11 (two REF), 1 → 10 (het), 2 → 00 (two ALT), Missing → 01.
+var bedCode = [4]byte{3, 2, 0, 1}
+
+// sexCode maps "male"/"female" to PLINK's 1/2, else unknown.
+func sexCode(sex, unknown string) string {
+    switch sex {
+    case "male":
+        return "1"
+    case "female":
+        return "2"
+    default:
+        return unknown
+    }
+}
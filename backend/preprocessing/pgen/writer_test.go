@@ -0,0 +1,141 @@
+package pgen
+
+import (
+    "bufio"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "testing"
+
+    "github.com/adamwestgate/easy-pgs/backend/config"
+)
+
+// roundTripKit is a small fileset covering every genotype value, with a
+// sample count that leaves a partly filled last byte.
+var roundTripKit = struct {
+    samples  []Sample
+    variants []Variant
+    geno     [][]byte
+}{
+    samples: []Sample{
+        {IID: "s1", Fields: map[string]string{"SEX": "1"}},
+        {IID: "s2", Fields: map[string]string{"SEX": "2"}},
+        {IID: "s3"},
+        {IID: "s4"},
+        {IID: "s5"},
+    },
+    variants: []Variant{
+        {Chr: "1", Pos: 1000, ID: "rs1", Ref: "A", Alt: "G"},
+        {Chr: "1", Pos: 2000, ID: "rs2", Ref: "C", Alt: "T"},
+        {Chr: "2", Pos: 500, ID: "rs3", Ref: "G", Alt: "A"},
+    },
+    geno: [][]byte{
+        {0, 1, 2, Missing, 0},
+        {2, 2, 1, 0, Missing},
+        {Missing, 0, 0, 1, 2},
+    },
+}
+
+func writeRoundTripKit(t *testing.T) string {
+    t.Helper()
+    prefix := filepath.Join(t.TempDir(), "kit")
+    w, err := Create(prefix, roundTripKit.samples, true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i, v := range roundTripKit.variants {
+        if err := w.WriteVariant(v, roundTripKit.geno[i]); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := w.Close(); err != nil {
+        t.Fatal(err)
+    }
+    return prefix
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+    prefix := writeRoundTripKit(t)
+
+    pf, err := OpenPfile(prefix)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer pf.Close()
+    for i, want := range roundTripKit.geno {
+        got, err := pf.Genotypes(i, nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if string(got) != string(want) {
+            t.Errorf("variant %d: got %v, want %v", i, got, want)
+        }
+    }
+
+    // the .bed body must match the .pgen body byte for byte
+    pgenBytes, _ := os.ReadFile(prefix + ".pgen")
+    bedBytes, _ := os.ReadFile(prefix + ".bed")
+    if string(pgenBytes) != string(bedBytes) {
+        t.Error(".pgen and .bed differ")
+    }
+}
+
+// plink2Exe returns the plink2 binary, skipping the test when it is not
+// installed unless EASY_PGS_REQUIRE_PLINK2 is set (as in CI).
+func plink2Exe(t *testing.T) string {
+    t.Helper()
+    exe, err := exec.LookPath(config.Plink2Cmd)
+    if err != nil {
+        if os.Getenv("EASY_PGS_REQUIRE_PLINK2") != "" {
+            t.Fatalf("plink2 not found: %v", err)
+        }
+        t.Skip("plink2 not found")
+    }
+    return exe
+}
+
+// TestWriterPlink2Export checks that plink2 reads a written fileset with the
+// same ALT allele counts. Skipped when plink2 is not installed.
+func TestWriterPlink2Export(t *testing.T) {
+    exe := plink2Exe(t)
+    prefix := writeRoundTripKit(t)
+    out := prefix + "_export"
+    cmd := exec.Command(exe, "--pfile", prefix, "--export", "A", "--out", out)
+    if b, err := cmd.CombinedOutput(); err != nil {
+        t.Fatalf("plink2: %v\n%s", err, b)
+    }
+
+    f, err := os.Open(out + ".raw")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    sc := bufio.NewScanner(f)
+    sc.Scan() // FID IID PAT MAT SEX PHENOTYPE rs1_G ...
+    header := strings.Fields(sc.Text())
+    if len(header) != 6+len(roundTripKit.variants) {
+        t.Fatalf("unexpected .raw header %v", header)
+    }
+    for s := 0; sc.Scan(); s++ {
+        f := strings.Fields(sc.Text())
+        if f[1] != roundTripKit.samples[s].IID {
+            t.Fatalf("row %d is %s, want %s", s, f[1], roundTripKit.samples[s].IID)
+        }
+        for v, field := range f[6:] {
+            want := roundTripKit.geno[v][s]
+            got := byte(Missing)
+            if field != "NA" {
+                n, err := strconv.Atoi(field)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                got = byte(n)
+            }
+            if got != want {
+                t.Errorf("%s %s: plink2 reads %d, want %d", roundTripKit.samples[s].IID, header[6+v], got, want)
+            }
+        }
+    }
+}
@@ -21,19 +21,54 @@ package pgs_convert
 
 import (
     "bufio"
+    "context"
     "errors"
     "fmt"
     "io"
     "strconv"
     "strings"
+
+    "github.com/adamwestgate/easy-pgs/backend/events"
 )
 
 // Options lets a caller override defaults.
 // WeightCol – override the column name that contains the weights.  If empty,
 //             the first recognised default wins.
+// OnVariant – optional callback invoked for every emitted row, with the rsID
+//             kept from the source file when it has one (used for indexing).
+// Events    – optional progress emitter; receives row counts every
+//             progressEvery rows and once when the file is done.
 
 type Options struct {
     WeightCol string
+    OnVariant func(Variant)
+    Events    events.Emitter
+}
+
+// progressEvery is how many rows pass between NormalizeProgress events.
+const progressEvery = 10000
+
+// Variant is one normalised score row as reported to Options.OnVariant.
+// Chr/Pos are empty for 3-column rsID files; RSID is empty when the source
+// file has no rsID column.
+type Variant struct {
+    RSID         string
+    Chr          string
+    Pos          string
+    EffectAllele string
+    Weight       float64
+}
+
+// report passes a row to opt.OnVariant if set; rows with unparsable weights are skipped.
+func (opt Options) report(rsid, chr, pos, allele, weight string) {
+    if opt.OnVariant == nil {
+        return
+    }
+    w, err := strconv.ParseFloat(weight, 64)
+    if err != nil {
+        return
+    }
+    opt.OnVariant(Variant{RSID: rsid, Chr: strings.TrimPrefix(chr, "chr"), Pos: pos, EffectAllele: allele, Weight: w})
 }
 
 var defaultWeightNames = []string{"effect_weight", "beta", "weight", "or"}
@@ -42,9 +77,33 @@ var defaultWeightNames = []string{"effect_weight", "beta", "weight", "or"}
 // It emits **one** header (4-col layout) unless the file is already
 // a simple 3-col rsID score, in which case it passes through only those columns.
 func Normalize(r io.Reader, w io.Writer, opt Options) error {
+    return NormalizeContext(context.Background(), r, w, opt)
+}
+
+// NormalizeContext is Normalize that stops with ctx.Err() once ctx is done.
+// Output written so far is incomplete and should be discarded by the caller.
+func NormalizeContext(ctx context.Context, r io.Reader, w io.Writer, opt Options) (err error) {
+    if err := ctx.Err(); err != nil {
+        return err
+    }
     out := bufio.NewWriter(w)
     defer out.Flush()
 
+    var rows int64
+    tick := func() error {
+        rows++
+        if rows%progressEvery == 0 {
+            opt.Events.Emit(events.Event{Type: events.NormalizeProgress, Done: rows})
+            return ctx.Err()
+        }
+        return nil
+    }
+    defer func() {
+        if err == nil {
+            opt.Events.Emit(events.Event{Type: events.NormalizeDone, Done: rows})
+        }
+    }()
+
     scan := bufio.NewScanner(r)
     scan.Buffer(make([]byte, 0, 1024*1024), 10*1024*1024)
 
@@ -81,6 +140,10 @@ func Normalize(r io.Reader, w io.Writer, opt Options) error {
                 return fmt.Errorf("malformed row – expected >=3 cols: %q", line)
             }
             fmt.Fprintf(out, "%s\t%s\t%s\n", fields[0], fields[1], fields[2])
+            opt.report(fields[0], "", "", fields[1], fields[2])
+            if err := tick(); err != nil {
+                return err
+            }
         }
         return scan.Err()
     }
@@ -116,6 +179,14 @@ func Normalize(r io.Reader, w io.Writer, opt Options) error {
         return fmt.Errorf("missing required columns – header map: %v", colMap)
     }
 
+    // rsID column is optional; only used for OnVariant
+    rsIdx := -1
+    for _, k := range []string{"rsid", "hm_rsid"} {
+        if i, ok := colMap[k]; ok && rsIdx < 0 {
+            rsIdx = i
+        }
+    }
+
     // 6) Emit canonical 4-column header
     fmt.Fprintln(out, "chr_name\tchr_position\teffect_allele\teffect_weight")
 
@@ -136,6 +207,14 @@ func Normalize(r io.Reader, w io.Writer, opt Options) error {
             return fmt.Errorf("malformed row – weight column absent: %q", strings.Join(fields, "\t"))
         }
         _, err := fmt.Fprintf(out, "%s\t%s\t%s\t%s\n", fields[chrIdx], fields[posIdx], fields[a1Idx], fields[betaIdx])
+        if err == nil {
+            rsid := ""
+            if rsIdx >= 0 && rsIdx < len(fields) {
+                rsid = fields[rsIdx]
+            }
+            opt.report(rsid, fields[chrIdx], fields[posIdx], fields[a1Idx], fields[betaIdx])
+            err = tick()
+        }
         return err
     }
 
@@ -159,6 +238,30 @@ func Normalize(r io.Reader, w io.Writer, opt Options) error {
     return scan.Err()
 }
 
+// ReadHeader parses the "#key=value" metadata block at the top of a PGS
+// Catalog scoring file (format_version, pgs_id, genome_build, variants_number, …).
+// Reading stops at the first non-comment line; banner lines without "=" are skipped.
+func ReadHeader(r io.Reader) (map[string]string, error) {
+    hdr := make(map[string]string)
+    scan := bufio.NewScanner(r)
+    scan.Buffer(make([]byte, 0, 1024*1024), 10*1024*1024)
+    for scan.Scan() {
+        line := strings.TrimSpace(scan.Text())
+        if line == "" {
+            continue
+        }
+        if !strings.HasPrefix(line, "#") {
+            break
+        }
+        key, val, ok := strings.Cut(strings.TrimLeft(line, "#"), "=")
+        if !ok {
+            continue
+        }
+        hdr[strings.TrimSpace(key)] = strings.TrimSpace(val)
+    }
+    return hdr, scan.Err()
+}
+
 // isThreeColHeader returns true if header is exactly the three columns we pass through.
 func isThreeColHeader(cols []string) bool {
     if len(cols) != 3 {
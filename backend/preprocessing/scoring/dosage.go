@@ -0,0 +1,507 @@
+package scoring
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/adamwestgate/easy-pgs/backend/config"
+	"github.com/adamwestgate/easy-pgs/backend/preprocessing/pgen"
+)
+
+// A dosage matrix caches, for one score and one reference panel, every
+// panel sample's effect allele count at every score variant the panel has,
+// so the panel can be scored on any subset of those variants without
+// reading the .pgen again. On disk (little-endian):
+//
+//	"EPGSDM3\n"
+//	uint32 samples, uint32 variants
+//	per sample:  uint16 length, IID, uint8 male
+//	per variant: uint16 length, ID, uint8 chromosome rank, float64 weight, float64 imputed dosage,
+//	             uint8 1 if the variant has exact dosages
+//	per variant: ceil(samples/4) bytes, 2 bits per sample (0-2, 3 = missing)
+//	per variant with exact dosages: uint16 per sample, in 1/16384 units (65535 = missing)
+//
+// Variants are in score-file order and matched as NativeScore matches them;
+// the imputed dosage is the one NativeScore uses for missing diploid calls.
+// Sex and chromosome let score apply NativeScore's ploidy rules. Panels
+// with dosage tracks keep those variants' dosages exactly, since a 2-bit
+// code would round them.
+const dosageMagic = "EPGSDM3\n"
+
+// dosageMissing is the 2-bit code of a missing call.
+const dosageMissing = 3
+
+// Units of an exact dosage: one effect allele, and the missing value.
+const (
+	exactUnit    = 16384
+	exactMissing = 65535
+)
+
+// dosageMatrix is a loaded dosage matrix file.
+type dosageMatrix struct {
+	samples []string
+	males   []bool
+	ids     []string
+	chroms  []uint8 // chromRank of each variant
+	weights []float64
+	impute  []float64
+	packed  []byte     // len(ids) rows of stride bytes
+	exact   [][]uint16 // per variant, effect dosages in exactUnit; nil for hardcall-only variants
+	stride  int
+}
+
+// newDosageMatrix returns an empty matrix for the given sample IIDs and sexes.
+func newDosageMatrix(samples []string, males []bool) *dosageMatrix {
+	return &dosageMatrix{samples: samples, males: males, stride: (len(samples) + 3) / 4}
+}
+
+// add appends the matched variant mt on chromosome chr.
+func (m *dosageMatrix) add(id, chr string, weight, impute float64, mt match) {
+	row := make([]byte, m.stride)
+	for s, g := range mt.geno {
+		code := byte(dosageMissing)
+		if g != pgen.Missing {
+			code = byte(effectDosage(g, mt.effectIsAlt))
+		}
+		row[s/4] |= code << (2 * uint(s%4))
+	}
+	var exact []uint16
+	if mt.dosage != nil {
+		exact = make([]uint16, len(mt.dosage))
+		for s := range exact {
+			// ploidy 2 gives the unscaled effect dosage
+			if d, called := mt.sampleDosage(s, 2); called {
+				exact[s] = uint16(math.Round(d * exactUnit))
+			} else {
+				exact[s] = exactMissing
+			}
+		}
+	}
+	m.exact = append(m.exact, exact)
+	m.ids = append(m.ids, id)
+	m.chroms = append(m.chroms, uint8(chromRank(chr)))
+	m.weights = append(m.weights, weight)
+	m.impute = append(m.impute, impute)
+	m.packed = append(m.packed, row...)
+}
+
+// score computes every sample's totals over the variants in extract (all
+// variants if extract is nil), returning the IDs used in score-file order.
+// Missing calls, ALLELE_CT and haploid calls are handled as in scoreSamples.
+func (m *dosageMatrix) score(extract map[string]bool, noImpute bool) ([]sampleScore, []string) {
+	scores := make([]sampleScore, len(m.samples))
+	var used []string
+	for v, id := range m.ids {
+		if extract != nil && !extract[id] {
+			continue
+		}
+		row := m.packed[v*m.stride : (v+1)*m.stride]
+		w, impute, chr := m.weights[v], m.impute[v], int(m.chroms[v])
+		for s := range scores {
+			p := ploidy(chr, m.males[s])
+			var d float64
+			var called bool
+			if m.exact[v] != nil {
+				d, called = exactDosage(m.exact[v][s], p)
+			} else {
+				d, called = codeDosage(row[s/4]>>(2*uint(s%4))&3, p)
+			}
+			if called {
+				scores[s].alleleCt += p
+			} else if noImpute {
+				continue
+			} else {
+				d = impute * float64(p) / 2
+			}
+			scores[s].dosageSum += d
+			scores[s].sum += d * w
+		}
+		used = append(used, id)
+	}
+	return scores, used
+}
+
+// write stores the matrix at path, via a temporary file so readers never
+// see a partial matrix.
+func (m *dosageMatrix) write(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after the rename
+
+	w := bufio.NewWriter(tmp)
+	le := binary.LittleEndian
+	putString := func(s string) {
+		binary.Write(w, le, uint16(len(s)))
+		w.WriteString(s)
+	}
+	w.WriteString(dosageMagic)
+	binary.Write(w, le, uint32(len(m.samples)))
+	binary.Write(w, le, uint32(len(m.ids)))
+	for i, s := range m.samples {
+		putString(s)
+		binary.Write(w, le, m.males[i])
+	}
+	for i, id := range m.ids {
+		putString(id)
+		w.WriteByte(m.chroms[i])
+		binary.Write(w, le, m.weights[i])
+		binary.Write(w, le, m.impute[i])
+		binary.Write(w, le, m.exact[i] != nil)
+	}
+	w.Write(m.packed)
+	for _, exact := range m.exact {
+		if exact != nil {
+			binary.Write(w, le, exact)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readDosageMatrix loads a matrix written by write.
+func readDosageMatrix(path string) (*dosageMatrix, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	le := binary.LittleEndian
+
+	magic := make([]byte, len(dosageMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != dosageMagic {
+		return nil, fmt.Errorf("%s: not a dosage matrix", path)
+	}
+	var nSamples, nVariants uint32
+	if err := binary.Read(r, le, &nSamples); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, le, &nVariants); err != nil {
+		return nil, err
+	}
+	getString := func() (string, error) {
+		var n uint16
+		if err := binary.Read(r, le, &n); err != nil {
+			return "", err
+		}
+		b := make([]byte, n)
+		_, err := io.ReadFull(r, b)
+		return string(b), err
+	}
+
+	samples := make([]string, nSamples)
+	males := make([]bool, nSamples)
+	for i := range samples {
+		if samples[i], err = getString(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := binary.Read(r, le, &males[i]); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	m := newDosageMatrix(samples, males)
+	m.ids = make([]string, nVariants)
+	m.chroms = make([]uint8, nVariants)
+	m.weights = make([]float64, nVariants)
+	m.impute = make([]float64, nVariants)
+	m.exact = make([][]uint16, nVariants)
+	hasExact := make([]bool, nVariants)
+	for i := range m.ids {
+		if m.ids[i], err = getString(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if m.chroms[i], err = r.ReadByte(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := binary.Read(r, le, &m.weights[i]); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := binary.Read(r, le, &m.impute[i]); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := binary.Read(r, le, &hasExact[i]); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	m.packed = make([]byte, int(nVariants)*m.stride)
+	if _, err := io.ReadFull(r, m.packed); err != nil {
+		return nil, fmt.Errorf("%s: truncated genotypes: %w", path, err)
+	}
+	for i, ok := range hasExact {
+		if !ok {
+			continue
+		}
+		m.exact[i] = make([]uint16, nSamples)
+		if err := binary.Read(r, le, m.exact[i]); err != nil {
+			return nil, fmt.Errorf("%s: truncated dosages: %w", path, err)
+		}
+	}
+	return m, nil
+}
+
+var (
+	matrixMu    sync.Mutex
+	matrixCache = map[string]*cachedMatrix{}
+	matrixBytes int64  // sum of the cached matrices' sizes
+	matrixClock uint64 // ticks on every cache hit or insert, for LRU eviction
+
+	// matrixCacheLimit is config.DosageMatrixCacheBytes; tests lower it.
+	matrixCacheLimit int64 = config.DosageMatrixCacheBytes
+)
+
+// cachedMatrix remembers a loaded matrix, the file mtime it was read at and
+// when it was last used.
+type cachedMatrix struct {
+	m     *dosageMatrix
+	mtime int64
+	size  int64
+	used  uint64
+}
+
+// size estimates the memory m holds: the packed hardcalls and exact
+// dosages, the IDs and the per-variant and per-sample slices.
+func (m *dosageMatrix) size() int64 {
+	const strHeader, sliceHeader = 16, 24
+	n := int64(len(m.packed))
+	for _, id := range m.ids {
+		n += int64(len(id)) + strHeader
+	}
+	n += int64(len(m.ids)) * (1 + 8 + 8) // chroms, weights, impute
+	for _, e := range m.exact {
+		n += int64(2*len(e)) + sliceHeader
+	}
+	for _, s := range m.samples {
+		n += int64(len(s)) + strHeader + 1 // and males
+	}
+	return n
+}
+
+// EnsureDosageMatrix builds the dosage matrix of scorePath against the
+// reference panel in pfileDir at path, unless a matrix newer than the panel,
+// the score file and kitType's allele frequencies is already there (and in
+// the current format). The matrix is written atomically; callers that may
+// build the same path concurrently should share one build (the pipeline
+// keys its builds by path).
+func EnsureDosageMatrix(ctx context.Context, pfileDir, kitType, scorePath, path string) error {
+	prefix, err := findPfile(pfileDir)
+	if err != nil {
+		return err
+	}
+	inputs := []string{prefix + ".pgen", prefix + ".pvar", prefix + ".psam", scorePath, RefFreqFor(kitType)}
+
+	if upToDate(path, inputs) && hasDosageMagic(path) {
+		return nil
+	}
+
+	tmp, err := os.MkdirTemp("", "dosage-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	scPath, err := prepareScoreFile(scorePath, prefix, "", tmp)
+	if err != nil {
+		return err
+	}
+	rows, err := readScoreRows(scPath)
+	if err != nil {
+		return err
+	}
+	pf, err := openPfile(prefix)
+	if err != nil {
+		return err
+	}
+	defer pf.Close()
+	freqs, err := loadFreqs(RefFreqFor(kitType))
+	if err != nil {
+		return err
+	}
+
+	iids := make([]string, len(pf.Samples))
+	for i, s := range pf.Samples {
+		iids[i] = s.IID
+	}
+	m := newDosageMatrix(iids, sampleMales(pf.Samples))
+	err = eachMatch(ctx, pf, rows, nil, func(row scoreRow, mt match) {
+		impute, ok := imputedDosage(freqs, mt.variant, mt.effectIsAlt)
+		if !ok {
+			impute = mt.meanDosage()
+		}
+		m.add(row.id, mt.variant.Chr, row.weight, impute, mt)
+	})
+	if err != nil {
+		return err
+	}
+	if len(m.ids) == 0 {
+		return fmt.Errorf("no variants of %s in %s", filepath.Base(scorePath), pfileDir)
+	}
+	return m.write(path)
+}
+
+// ScoreDosageMatrix scores the reference panel of the matrix at path on the
+// variants listed in extractPath ("" for all of them) and writes
+// <outPrefix>.sscore and <outPrefix>.sscore.vars as NativeScore would.
+// Missing calls are imputed as the matrix was built, or with noImpute
+// skipped. Returns the .sscore path.
+func ScoreDosageMatrix(path, extractPath, outPrefix string, noImpute bool) (string, error) {
+	m, err := loadDosageMatrix(path)
+	if err != nil {
+		return "", err
+	}
+	var extract map[string]bool
+	if extractPath != "" {
+		if extract, err = readIDSet(extractPath); err != nil {
+			return "", err
+		}
+	}
+	scores, used := m.score(extract, noImpute)
+	if len(used) == 0 {
+		return "", fmt.Errorf("no valid variants in %s", path)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outPrefix), 0o755); err != nil {
+		return "", err
+	}
+	samples := make([]pgen.Sample, len(m.samples))
+	for i, iid := range m.samples {
+		samples[i] = pgen.Sample{IID: iid}
+	}
+	if err := writeSscore(outPrefix+".sscore", samples, scores); err != nil {
+		return "", err
+	}
+	if err := writeLines(outPrefix+".sscore.vars", used); err != nil {
+		return "", err
+	}
+	return outPrefix + ".sscore", nil
+}
+
+// loadDosageMatrix returns the matrix at path, read once per file version
+// while it stays in the cache. The cache holds at most
+// config.DosageMatrixCacheBytes, dropping the least recently used matrices;
+// a matrix larger than that on its own is returned without being cached.
+func loadDosageMatrix(path string) (*dosageMatrix, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	matrixMu.Lock()
+	defer matrixMu.Unlock()
+	matrixClock++
+	if c, ok := matrixCache[path]; ok {
+		if c.mtime == st.ModTime().UnixNano() {
+			c.used = matrixClock
+			return c.m, nil
+		}
+		delete(matrixCache, path)
+		matrixBytes -= c.size
+	}
+	m, err := readDosageMatrix(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &cachedMatrix{m: m, mtime: st.ModTime().UnixNano(), size: m.size(), used: matrixClock}
+	if c.size > matrixCacheLimit {
+		return m, nil
+	}
+	for matrixBytes+c.size > matrixCacheLimit {
+		evictLRUMatrix()
+	}
+	matrixCache[path] = c
+	matrixBytes += c.size
+	return m, nil
+}
+
+// evictLRUMatrix drops the least recently used matrix. matrixMu must be held.
+func evictLRUMatrix() {
+	var oldest string
+	var used uint64
+	for k, c := range matrixCache {
+		if oldest == "" || c.used < used {
+			oldest, used = k, c.used
+		}
+	}
+	if c, ok := matrixCache[oldest]; ok {
+		delete(matrixCache, oldest)
+		matrixBytes -= c.size
+	}
+}
+
+// hasDosageMagic reports whether path starts with the current dosageMagic,
+// so matrices of an older format are rebuilt.
+func hasDosageMagic(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, len(dosageMagic))
+	_, err = io.ReadFull(f, magic)
+	return err == nil && string(magic) == dosageMagic
+}
+
+// codeDosage is callDosage for a matrix code, which already counts the
+// effect allele.
+func codeDosage(code byte, p int) (float64, bool) {
+	if code == dosageMissing || p == 0 || (p == 1 && code == 1) {
+		return 0, false
+	}
+	return float64(code) * float64(p) / 2, true
+}
+
+// exactDosage is codeDosage for an exact dosage in exactUnit.
+func exactDosage(v uint16, p int) (float64, bool) {
+	if v == exactMissing || p == 0 {
+		return 0, false
+	}
+	return float64(v) / exactUnit * float64(p) / 2, true
+}
+
+// upToDate reports whether path exists and is newer than every non-empty input.
+func upToDate(path string, inputs []string) bool {
+	st, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	for _, in := range inputs {
+		if in == "" {
+			continue
+		}
+		ist, err := os.Stat(in)
+		if err != nil || ist.ModTime().After(st.ModTime()) {
+			return false
+		}
+	}
+	return true
+}
+
+// findPfile returns the prefix of the first .pgen in dir.
+func findPfile(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".pgen") {
+			return strings.TrimSuffix(filepath.Join(dir, e.Name()), ".pgen"), nil
+		}
+	}
+	return "", fmt.Errorf("no .pgen in %s", dir)
+}
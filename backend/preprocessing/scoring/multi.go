@@ -0,0 +1,416 @@
+package scoring
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/adamwestgate/easy-pgs/backend/config"
+	"github.com/adamwestgate/easy-pgs/backend/events"
+)
+
+// batchScore is one score file of a multi-column plink2 run.
+type batchScore struct {
+	path   string          // score file as given
+	name   string          // trimID(path), the result key and column name
+	rows   []scoreRow      // rsID-mapped rows, first occurrence per ID, extract applied
+	keys   map[string]bool // IDs of rows
+	err    error
+	scPath string // rsID-mapped score file
+	t      target // the score's own run, for scoring it alone
+}
+
+// scorePlinkBatch scores every file in scorePaths against pfilePrefix with as
+// few plink2 passes as possible. The files' rows are merged into one score
+// matrix (union of variants, weight 0 where a score lacks one) and scored with
+// --score-col-nums; each file's extract list is applied by leaving its weights
+// out rather than with --extract. Files giving a variant different effect
+// alleles cannot share a row, so they go to a further pass.
+//
+// The per-score <name>.sscore and .sscore.vars files are then split out of the
+// combined output with the columns a run of that score alone would write (see
+// splitSscore). A pass in which some sample has missing calls does not tell
+// each score's ALLELE_CT, so its scores are run again one at a time.
+func scorePlinkBatch(ctx context.Context, pfilePrefix, kitType string, scorePaths []string, pvarDir string, opt Options) map[string]BatchResult {
+	t := targetFor(opt, "")
+	scoresDir := t.scoresDir(pfilePrefix)
+	res := make(map[string]BatchResult, len(scorePaths))
+	total := int64(len(scorePaths))
+
+	scores := make([]*batchScore, len(scorePaths))
+	for i, sp := range scorePaths {
+		pgsID := strings.SplitN(trimID(sp), ".", 2)[0]
+		opt.Events.ForPGS(pgsID).Emit(events.Event{Type: events.ScoreStart, Done: int64(i + 1), Total: total})
+		scores[i] = loadBatchScore(sp, pfilePrefix, pvarDir, scoresDir, targetFor(opt, pgsID))
+	}
+
+	for _, group := range groupScores(scores) {
+		if err := ctx.Err(); err != nil {
+			for _, s := range group {
+				s.err = err
+			}
+			continue
+		}
+		runBatch(ctx, pfilePrefix, kitType, pvarDir, scoresDir, t, group)
+	}
+
+	for i, s := range scores {
+		ev := opt.Events.ForPGS(strings.SplitN(s.name, ".", 2)[0])
+		if s.err != nil {
+			if s.scPath != "" {
+				removeOutputs(filepath.Join(scoresDir, s.name), s.scPath, s.path)
+			}
+			ev.Emit(events.Event{Type: events.ScoreFailed, Done: int64(i + 1), Total: total, Error: s.err.Error()})
+			res[s.name] = BatchResult{"", s.err}
+			continue
+		}
+		ev.Emit(events.Event{Type: events.ScoreDone, Done: int64(i + 1), Total: total})
+		res[s.name] = BatchResult{filepath.Join(scoresDir, s.name) + ".sscore", nil}
+	}
+	return res
+}
+
+// loadBatchScore maps scorePath to rsIDs and reads its rows, keeping the
+// first occurrence of each ID and only IDs in t's extract list, if any.
+func loadBatchScore(scorePath, pfilePrefix, pvarDir, scoresDir string, t target) *batchScore {
+	s := &batchScore{path: scorePath, name: trimID(scorePath), keys: map[string]bool{}, t: t}
+	if s.scPath, s.err = prepareScoreFile(scorePath, pfilePrefix, pvarDir, scoresDir); s.err != nil {
+		return s
+	}
+	rows, err := readScoreRows(s.scPath)
+	if err != nil {
+		s.err = err
+		return s
+	}
+	var extract map[string]bool
+	if snplist := t.extractFile(s.scPath); snplist != "" {
+		if extract, s.err = readIDSet(snplist); s.err != nil {
+			return s
+		}
+	}
+	for _, r := range rows {
+		if s.keys[r.id] || (extract != nil && !extract[r.id]) {
+			continue
+		}
+		s.keys[r.id] = true
+		s.rows = append(s.rows, r)
+	}
+	if len(s.rows) == 0 {
+		s.err = fmt.Errorf("no valid variants in %s", s.scPath)
+	}
+	return s
+}
+
+// groupScores splits the usable scores into passes whose rows agree on the
+// effect allele of every shared variant, first fit in input order.
+func groupScores(scores []*batchScore) [][]*batchScore {
+	var (
+		groups  [][]*batchScore
+		alleles []map[string]string
+	)
+next:
+	for _, s := range scores {
+		if s.err != nil {
+			continue
+		}
+		for g, al := range alleles {
+			if compatible(al, s.rows) {
+				for _, r := range s.rows {
+					al[r.id] = r.allele
+				}
+				groups[g] = append(groups[g], s)
+				continue next
+			}
+		}
+		al := make(map[string]string, len(s.rows))
+		for _, r := range s.rows {
+			al[r.id] = r.allele
+		}
+		groups = append(groups, []*batchScore{s})
+		alleles = append(alleles, al)
+	}
+	return groups
+}
+
+// compatible reports whether rows use the same effect allele as al wherever
+// they share a variant.
+func compatible(al map[string]string, rows []scoreRow) bool {
+	for _, r := range rows {
+		if a, ok := al[r.id]; ok && a != r.allele {
+			return false
+		}
+	}
+	return true
+}
+
+// errMissingCalls is returned by splitSscore when a sample has missing calls
+// among the variants of a pass, so that a score's ALLELE_CT cannot be told
+// from the pass's.
+var errMissingCalls = errors.New("missing calls in a combined pass")
+
+// runBatch runs one plink2 pass over group under t and splits its output,
+// setting each score's err on failure. A group whose pass hits missing calls
+// is scored again one file at a time, as ScoreContext would.
+func runBatch(ctx context.Context, pfilePrefix, kitType, pvarDir, scoresDir string, t target, group []*batchScore) {
+	fail := func(err error) {
+		for _, s := range group {
+			s.err = err
+		}
+	}
+	batchDir, err := os.MkdirTemp(scoresDir, "batch-")
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer os.RemoveAll(batchDir)
+
+	merged := filepath.Join(batchDir, "merged.score")
+	if err := writeMergedScore(merged, group); err != nil {
+		fail(err)
+		return
+	}
+	outPrefix := filepath.Join(batchDir, "merged")
+	modifiers := []string{"header-read", "cols=+scoresums", "list-variants"}
+	if t.noImpute {
+		modifiers = append(modifiers, "no-mean-imputation")
+	}
+	args := append([]string{
+		"--pfile", pfilePrefix,
+		"--read-freq", t.freqs(kitType),
+		"--score", merged,
+	}, modifiers...)
+	args = append(args,
+		"--score-col-nums", fmt.Sprintf("3-%d", 2*len(group)+2),
+		"--out", outPrefix,
+	)
+	cmd := exec.CommandContext(ctx, config.Plink2Cmd, args...)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr // plink2's console log; stdout belongs to the caller
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			fail(ctx.Err())
+		} else {
+			fail(fmt.Errorf("%s failed: %w", config.Plink2Cmd, err))
+		}
+		return
+	}
+
+	err = splitSscore(pfilePrefix, outPrefix, scoresDir, group)
+	if !errors.Is(err, errMissingCalls) {
+		if err != nil {
+			fail(err)
+		}
+		return
+	}
+	for _, s := range group {
+		if err := ctx.Err(); err != nil {
+			s.err = err
+			continue
+		}
+		_, s.err = scorePlink(ctx, pfilePrefix, kitType, s.path, pvarDir, s.t)
+	}
+}
+
+// writeMergedScore writes the union of group's rows as one score file with
+// an ID and allele column, one weight column per score and then one count
+// column per score, <name>_N, weighting each of the score's rows 1. plink2
+// sums the count column to the score's NAMED_ALLELE_DOSAGE_SUM.
+func writeMergedScore(path string, group []*batchScore) error {
+	var (
+		order   []string
+		alleles = map[string]string{}
+		weights = map[string][]float64{}
+	)
+	for k, s := range group {
+		for _, r := range s.rows {
+			w, ok := weights[r.id]
+			if !ok {
+				w = make([]float64, 2*len(group))
+				weights[r.id] = w
+				alleles[r.id] = r.allele
+				order = append(order, r.id)
+			}
+			w[k] = r.weight
+			w[len(group)+k] = 1
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprint(w, "ID\tA1")
+	for _, s := range group {
+		fmt.Fprintf(w, "\t%s", s.name)
+	}
+	for _, s := range group {
+		fmt.Fprintf(w, "\t%s_N", s.name)
+	}
+	fmt.Fprintln(w)
+	for _, id := range order {
+		fmt.Fprintf(w, "%s\t%s", id, alleles[id])
+		for _, x := range weights[id] {
+			fmt.Fprintf(w, "\t%s", strconv.FormatFloat(x, 'g', -1, 64))
+		}
+		fmt.Fprintln(w)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// splitSscore turns the combined <outPrefix>.sscore and .sscore.vars of a
+// pass over pfilePrefix into one <name>.sscore and .sscore.vars per score in
+// scoresDir, with the columns a run of that score alone would write. A
+// score's .sscore.vars lists its own rows that plink2 used, in score-file
+// order, as list-variants does for a single-score run.
+//
+// SCORE1_SUM and NAMED_ALLELE_DOSAGE_SUM are the score's <name>_SUM and
+// <name>_N_SUM columns. plink2 reports one ALLELE_CT for all columns of a
+// pass; where it equals the sample's full ploidy over the pass's variants
+// no call was missing, and the score's ALLELE_CT is its full ploidy over its
+// own variants. Otherwise splitSscore writes nothing and returns
+// errMissingCalls.
+func splitSscore(pfilePrefix, outPrefix, scoresDir string, group []*batchScore) error {
+	usedAll, err := readIDSet(outPrefix + ".sscore.vars")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(outPrefix + ".sscore")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() {
+		return fmt.Errorf("%s.sscore: empty", outPrefix)
+	}
+	// columns of each score: its weight sum, then its count sum
+	iidIdx, ctIdx := -1, -1
+	colIdx := make([]int, 2*len(group))
+	for k := range colIdx {
+		colIdx[k] = -1
+	}
+	for i, h := range strings.Fields(sc.Text()) {
+		switch h = strings.TrimPrefix(h, "#"); h {
+		case "IID":
+			iidIdx = i
+		case "ALLELE_CT":
+			ctIdx = i
+		}
+		for k, s := range group {
+			switch h {
+			case s.name + "_SUM":
+				colIdx[k] = i
+			case s.name + "_N_SUM":
+				colIdx[len(group)+k] = i
+			}
+		}
+	}
+	if iidIdx < 0 || ctIdx < 0 {
+		return fmt.Errorf("%s.sscore: no IID or ALLELE_CT column", outPrefix)
+	}
+	for k, i := range colIdx {
+		if i < 0 {
+			return fmt.Errorf("%s.sscore: no column for %s", outPrefix, group[k%len(group)].name)
+		}
+	}
+
+	// per sample: ALLELE_CT, then colIdx's columns
+	need := append([]int{ctIdx}, colIdx...)
+	rows := map[string][]float64{}
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) <= iidIdx {
+			continue
+		}
+		vals := make([]float64, len(need))
+		for j, i := range need {
+			if i >= len(fields) {
+				return fmt.Errorf("%s.sscore: short row for %s", outPrefix, fields[iidIdx])
+			}
+			if vals[j], err = strconv.ParseFloat(fields[i], 64); err != nil {
+				return fmt.Errorf("%s.sscore: %s: %w", outPrefix, fields[iidIdx], err)
+			}
+		}
+		rows[fields[iidIdx]] = vals
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	pf, err := openPfile(pfilePrefix)
+	if err != nil {
+		return err
+	}
+	defer pf.Close()
+	males := sampleMales(pf.Samples)
+	chrs := map[string]int{} // used variant ID → chromosome rank
+	for _, v := range pf.Variants {
+		if usedAll[v.ID] {
+			chrs[v.ID] = chromRank(v.Chr)
+		}
+	}
+	// fullCt is a sample's ALLELE_CT over ids with every call present
+	fullCt := func(ids []string, male bool) int {
+		n := 0
+		for _, id := range ids {
+			n += ploidy(chrs[id], male)
+		}
+		return n
+	}
+
+	passIDs := make([]string, 0, len(usedAll))
+	for id := range usedAll {
+		passIDs = append(passIDs, id)
+	}
+	for i, smp := range pf.Samples {
+		r, ok := rows[smp.IID]
+		if !ok {
+			return fmt.Errorf("%s.sscore: no row for %s", outPrefix, smp.IID)
+		}
+		if int(r[0]) != fullCt(passIDs, males[i]) {
+			return errMissingCalls
+		}
+	}
+
+	for k, s := range group {
+		var used []string
+		for _, r := range s.rows {
+			if usedAll[r.id] {
+				used = append(used, r.id)
+			}
+		}
+		if len(used) == 0 {
+			s.err = fmt.Errorf("no valid variants in %s", s.scPath)
+			continue
+		}
+		scores := make([]sampleScore, len(pf.Samples))
+		for i, smp := range pf.Samples {
+			r := rows[smp.IID]
+			scores[i] = sampleScore{
+				alleleCt:  fullCt(used, males[i]),
+				dosageSum: r[1+len(group)+k],
+				sum:       r[1+k],
+			}
+		}
+		prefix := filepath.Join(scoresDir, s.name)
+		if err := writeSscore(prefix+".sscore", pf.Samples, scores); err != nil {
+			s.err = err
+			continue
+		}
+		if err := writeLines(prefix+".sscore.vars", used); err != nil {
+			s.err = err
+		}
+	}
+	return nil
+}
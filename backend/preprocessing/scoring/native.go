@@ -0,0 +1,631 @@
+package scoring
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/adamwestgate/easy-pgs/backend/preprocessing/pgen"
+)
+
+// scoreRow is one variant of an rsID-keyed score file.
+type scoreRow struct {
+	id     string
+	allele string
+	weight float64
+}
+
+// sampleScore holds one sample's totals, named after the plink2 .sscore columns.
+type sampleScore struct {
+	alleleCt  int     // ALLELE_CT
+	dosageSum float64 // NAMED_ALLELE_DOSAGE_SUM
+	sum       float64 // SCORE1_SUM
+}
+
+// alleleFreq is one row of a plink2 .afreq file.
+type alleleFreq struct {
+	ref, alt string
+	altFreq  float64
+}
+
+// NativeScore is the in-process equivalent of Score: it scores the genotypes
+// at pfilePrefix against scorePath without running plink2, and writes the same
+// <out>.sscore and <out>.sscore.vars files.
+//
+// Matching follows plink2 --score: variants are matched by ID, the effect
+// allele must be the variant's REF or ALT, missing genotypes are
+// mean-imputed from the reference allele frequencies of kitType, and male
+// X/Y calls are haploid.
+func NativeScore(pfilePrefix, kitType, scorePath, pvarDir string) (string, error) {
+	return NativeScoreContext(context.Background(), pfilePrefix, kitType, scorePath, pvarDir)
+}
+
+// NativeScoreContext is NativeScore that stops once ctx is done. Partial
+// outputs of a cancelled or failed run are removed.
+func NativeScoreContext(ctx context.Context, pfilePrefix, kitType, scorePath, pvarDir string) (string, error) {
+	return scoreNative(ctx, pfilePrefix, kitType, scorePath, pvarDir, target{})
+}
+
+// scoreNative is NativeScoreContext with explicit output and extract locations.
+func scoreNative(ctx context.Context, pfilePrefix, kitType, scorePath, pvarDir string, t target) (out string, err error) {
+	scoresDir := t.scoresDir(pfilePrefix)
+	scPath, err := prepareScoreFile(scorePath, pfilePrefix, pvarDir, scoresDir)
+	if err != nil {
+		return "", err
+	}
+	outPrefix := filepath.Join(scoresDir, trimID(scorePath))
+	defer func() {
+		if err != nil {
+			removeOutputs(outPrefix, scPath, scorePath)
+		}
+	}()
+
+	rows, err := readScoreRows(scPath)
+	if err != nil {
+		return "", err
+	}
+
+	// same --extract convention as Score
+	var extract map[string]bool
+	if snplist := t.extractFile(scPath); snplist != "" {
+		if extract, err = readIDSet(snplist); err != nil {
+			return "", err
+		}
+	}
+
+	pf, err := openPfile(pfilePrefix)
+	if err != nil {
+		return "", err
+	}
+	defer pf.Close()
+
+	freqs, err := loadFreqs(t.freqs(kitType))
+	if err != nil {
+		return "", err
+	}
+
+	scores, used, err := scoreSamples(ctx, pf, rows, freqs, extract, t.noImpute)
+	if err != nil {
+		return "", err
+	}
+	if len(used) == 0 {
+		return "", fmt.Errorf("no valid variants in %s", scPath)
+	}
+	if err := writeSscore(outPrefix+".sscore", pf.Samples, scores); err != nil {
+		return "", err
+	}
+	if err := writeLines(outPrefix+".sscore.vars", used); err != nil {
+		return "", err
+	}
+	return outPrefix + ".sscore", nil
+}
+
+// BatchScoreNative is BatchScore using NativeScore instead of plink2. It works
+// for single-sample kits and multi-sample reference panels alike.
+func BatchScoreNative(pfileDir, kitType string, scorePaths []string, pvarDir string) map[string]BatchResult {
+	return BatchScoreOpts(context.Background(), pfileDir, kitType, scorePaths, pvarDir, Options{Native: true})
+}
+
+// scoreSamples computes every sample's totals over rows and returns the IDs
+// of the variants that contributed, in score-file order. As in plink2,
+// ALLELE_CT counts called alleles only: a missing call is mean-imputed
+// without adding to it, or with noImpute skipped altogether. Haploid calls
+// (see ploidy) count one allele. ctx is checked every ctxCheckEvery rows.
+func scoreSamples(ctx context.Context, pf *pgen.Pfile, rows []scoreRow, freqs map[string]alleleFreq, extract map[string]bool, noImpute bool) ([]sampleScore, []string, error) {
+	scores := make([]sampleScore, len(pf.Samples))
+	males := sampleMales(pf.Samples)
+	var used []string
+	err := eachMatch(ctx, pf, rows, extract, func(row scoreRow, m match) {
+		impute, ok := imputedDosage(freqs, m.variant, m.effectIsAlt)
+		if !ok {
+			impute = m.meanDosage()
+		}
+		chr := chromRank(m.variant.Chr)
+		for s := range m.geno {
+			p := ploidy(chr, males[s])
+			d, called := m.sampleDosage(s, p)
+			if called {
+				scores[s].alleleCt += p
+			} else if noImpute {
+				continue
+			} else {
+				d = impute * float64(p) / 2
+			}
+			scores[s].dosageSum += d
+			scores[s].sum += d * row.weight
+		}
+		used = append(used, row.id)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return scores, used, nil
+}
+
+// chromRank maps a chromosome name to pgen.ChromRank's numbering, accepting
+// "chr" prefixes and lower case.
+func chromRank(chr string) int {
+	c := strings.ToUpper(chr)
+	c = strings.TrimPrefix(c, "CHR")
+	if c == "M" {
+		c = "MT"
+	}
+	return pgen.ChromRank(c)
+}
+
+// Chromosome ranks with haploid calls, as numbered by pgen.ChromRank.
+const (
+	rankX  = 23
+	rankY  = 24
+	rankMT = 26
+)
+
+// ploidy returns how many alleles a sample carries at a variant on the
+// chromosome of rank chr, as plink2 counts them: males are haploid on X and
+// Y, everyone is haploid on MT, and non-males have no Y calls.
+func ploidy(chr int, male bool) int {
+	switch {
+	case chr == rankMT, male && (chr == rankX || chr == rankY):
+		return 1
+	case chr == rankY:
+		return 0
+	default:
+		return 2
+	}
+}
+
+// callDosage returns the effect allele count of ALT count g at ploidy p.
+// A haploid call is stored as homozygous, so it counts half; heterozygous
+// haploid calls are treated as missing, like plink2 does.
+func callDosage(g byte, effectIsAlt bool, p int) (float64, bool) {
+	if g == pgen.Missing || p == 0 || (p == 1 && g == 1) {
+		return 0, false
+	}
+	return effectDosage(g, effectIsAlt) * float64(p) / 2, true
+}
+
+// sampleMales flags the samples whose .psam sex is male.
+func sampleMales(samples []pgen.Sample) []bool {
+	males := make([]bool, len(samples))
+	for i, s := range samples {
+		males[i] = s.Sex() == "male"
+	}
+	return males
+}
+
+// match is a score row found in the fileset with a usable effect allele.
+type match struct {
+	variant     pgen.Variant
+	effectIsAlt bool
+	geno        []byte    // every sample's ALT count; reused between calls
+	dosage      []float64 // every sample's ALT dosage (NaN if missing); nil without a dosage track
+}
+
+// sampleDosage returns sample s's effect allele count at ploidy p and
+// whether it was called: from the variant's dosages when it has them, as
+// plink2 scores them, otherwise from the hardcall (see callDosage).
+func (m match) sampleDosage(s, p int) (float64, bool) {
+	if m.dosage == nil {
+		return callDosage(m.geno[s], m.effectIsAlt, p)
+	}
+	d := m.dosage[s]
+	if math.IsNaN(d) || p == 0 {
+		return 0, false
+	}
+	if !m.effectIsAlt {
+		d = 2 - d
+	}
+	return d * float64(p) / 2, true
+}
+
+// meanDosage is the fallback imputation when no reference frequency is
+// available: the mean effect dosage over called samples, or 0.
+func (m match) meanDosage() float64 {
+	if m.dosage == nil {
+		return sampleMeanDosage(m.geno, m.effectIsAlt)
+	}
+	var n, sum float64
+	for _, d := range m.dosage {
+		if math.IsNaN(d) {
+			continue
+		}
+		if !m.effectIsAlt {
+			d = 2 - d
+		}
+		sum += d
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / n
+}
+
+// eachMatch calls fn for every row of rows that plink2 --score would use:
+// the first occurrence of each ID present in pf (and in extract, if set)
+// whose effect allele is the variant's REF or ALT. Multiallelic variants are
+// skipped. ctx is checked every ctxCheckEvery rows.
+func eachMatch(ctx context.Context, pf *pgen.Pfile, rows []scoreRow, extract map[string]bool, fn func(scoreRow, match)) error {
+	var (
+		geno   []byte
+		dosage []float64
+		seen   = make(map[string]bool, len(rows))
+	)
+	for i, row := range rows {
+		if i%ctxCheckEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if seen[row.id] || (extract != nil && !extract[row.id]) {
+			continue
+		}
+		idx, ok := pf.IndexOf(row.id)
+		if !ok {
+			continue
+		}
+		v := pf.Variants[idx]
+		if pf.Multiallelic(idx) || strings.Contains(v.Alt, ",") {
+			continue // only biallelic variants are read
+		}
+		effectIsAlt := row.allele == v.Alt
+		if !effectIsAlt && row.allele != v.Ref {
+			continue // allele mismatch, skipped like plink2
+		}
+		seen[row.id] = true
+
+		var err error
+		if geno, err = pf.Genotypes(idx, geno); err != nil {
+			return err
+		}
+		mt := match{variant: v, effectIsAlt: effectIsAlt, geno: geno}
+		if pf.HasDosage(idx) {
+			if dosage, err = pf.Dosages(idx, dosage); err != nil {
+				return err
+			}
+			mt.dosage = dosage
+		}
+		fn(row, mt)
+	}
+	return nil
+}
+
+// effectDosage converts an ALT count to a count of the effect allele.
+func effectDosage(g byte, effectIsAlt bool) float64 {
+	if effectIsAlt {
+		return float64(g)
+	}
+	return 2 - float64(g)
+}
+
+// Contribution is one scored variant of one sample, as the native engine
+// counts it.
+type Contribution struct {
+	ID           string
+	Chr          string
+	Pos          int
+	EffectAllele string
+	OtherAllele  string
+	Genotype     string  // e.g. "A/G"; "" for a missing call
+	Dosage       float64 // effect allele count (the stored dosage if any), mean-imputed for a missing call; 0–1 for haploid calls
+	Imputed      bool    // false for a missing call left out under NoMeanImpute, whose Dosage is 0
+	Weight       float64
+	Contribution float64 // Dosage × Weight, the variant's part of SCORE1_SUM
+	// Contribution / ALLELE_CT, the variant's part of SCORE1_AVG: the
+	// per-allele raw score the results report. These add up to it.
+	AvgContribution float64
+}
+
+// NativeContributions lists the variants of scorePath that score the first
+// sample of pfilePrefix, in score-file order, with the sample's genotype and
+// each variant's contribution to SCORE1_SUM and SCORE1_AVG. Matching and
+// imputation are those of NativeScore under opt (NoMeanImpute, FreqFile)
+// without an extract list.
+func NativeContributions(ctx context.Context, pfilePrefix, kitType, scorePath string, opt Options) ([]Contribution, error) {
+	tmp, err := os.MkdirTemp("", "contrib-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	scPath, err := prepareScoreFile(scorePath, pfilePrefix, "", tmp)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := readScoreRows(scPath)
+	if err != nil {
+		return nil, err
+	}
+	pf, err := openPfile(pfilePrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer pf.Close()
+	if len(pf.Samples) == 0 {
+		return nil, fmt.Errorf("%s: no samples", pfilePrefix)
+	}
+	t := targetFor(opt, "")
+	freqs, err := loadFreqs(t.freqs(kitType))
+	if err != nil {
+		return nil, err
+	}
+
+	males := sampleMales(pf.Samples)
+	var out []Contribution
+	alleleCt := 0
+	err = eachMatch(ctx, pf, rows, nil, func(row scoreRow, m match) {
+		v := m.variant
+		c := Contribution{
+			ID:           row.id,
+			Chr:          v.Chr,
+			Pos:          v.Pos,
+			EffectAllele: row.allele,
+			OtherAllele:  v.Ref,
+			Weight:       row.weight,
+		}
+		if !m.effectIsAlt {
+			c.OtherAllele = v.Alt
+		}
+		g := m.geno[0]
+		p := ploidy(chromRank(v.Chr), males[0])
+		if d, called := m.sampleDosage(0, p); called {
+			c.Dosage = d
+			alleleCt += p
+			if g != pgen.Missing {
+				c.Genotype = [...]string{v.Ref + "/" + v.Ref, v.Ref + "/" + v.Alt, v.Alt + "/" + v.Alt}[g]
+				if p == 1 {
+					c.Genotype = [...]string{v.Ref, "", v.Alt}[g]
+				}
+			}
+		} else if !t.noImpute {
+			d, ok := imputedDosage(freqs, v, m.effectIsAlt)
+			if !ok {
+				d = m.meanDosage()
+			}
+			c.Dosage, c.Imputed = d*float64(p)/2, true
+		}
+		c.Contribution = c.Dosage * c.Weight
+		out = append(out, c)
+	})
+	if alleleCt > 0 {
+		for i := range out {
+			out[i].AvgContribution = out[i].Contribution / float64(alleleCt)
+		}
+	}
+	return out, err
+}
+
+// ctxCheckEvery is how many score rows pass between cancellation checks.
+const ctxCheckEvery = 1024
+
+// imputedDosage returns 2×frequency of the effect allele from the .afreq table.
+func imputedDosage(freqs map[string]alleleFreq, v pgen.Variant, effectIsAlt bool) (float64, bool) {
+	f, ok := freqs[v.ID]
+	if !ok || f.ref != v.Ref || f.alt != v.Alt {
+		return 0, false
+	}
+	if effectIsAlt {
+		return 2 * f.altFreq, true
+	}
+	return 2 * (1 - f.altFreq), true
+}
+
+// sampleMeanDosage is the fallback imputation when no reference frequency is
+// available: the mean effect dosage over non-missing samples, or 0.
+func sampleMeanDosage(geno []byte, effectIsAlt bool) float64 {
+	var n, sum float64
+	for _, g := range geno {
+		if g == pgen.Missing {
+			continue
+		}
+		d := float64(g)
+		if !effectIsAlt {
+			d = 2 - d
+		}
+		sum += d
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / n
+}
+
+// readScoreRows reads an rsID score file (ID, effect allele, weight), skipping
+// the header line as plink2 --score ... header does.
+func readScoreRows(path string) ([]scoreRow, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rows []scoreRow
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	sc.Scan() // header
+	for sc.Scan() {
+		cols := strings.Fields(sc.Text())
+		if len(cols) < 3 {
+			continue
+		}
+		w, err := strconv.ParseFloat(cols[2], 64)
+		if err != nil {
+			continue
+		}
+		rows = append(rows, scoreRow{id: cols[0], allele: strings.ToUpper(cols[1]), weight: w})
+	}
+	return rows, sc.Err()
+}
+
+// readIDSet reads a one-ID-per-line file such as a .snplist.
+func readIDSet(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	set := make(map[string]bool)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if id := strings.TrimSpace(sc.Text()); id != "" {
+			set[id] = true
+		}
+	}
+	return set, sc.Err()
+}
+
+var (
+	pfileMu    sync.Mutex
+	pfileCache = map[string]cachedPfile{}
+)
+
+// cachedPfile remembers a parsed fileset and the .pgen mtime it was parsed at.
+type cachedPfile struct {
+	pf    *pgen.Pfile
+	mtime int64
+}
+
+// maxCachedPfiles bounds the table cache: both reference panels plus a few kits.
+const maxCachedPfiles = 4
+
+// openPfile returns a Pfile for prefix with its own reader. The parsed
+// .pvar/.psam tables are cached, so the reference panels are only parsed
+// once per server run rather than once per score.
+func openPfile(prefix string) (*pgen.Pfile, error) {
+	st, err := os.Stat(prefix + ".pgen")
+	if err != nil {
+		return nil, err
+	}
+	pfileMu.Lock()
+	defer pfileMu.Unlock()
+
+	if c, ok := pfileCache[prefix]; ok && c.mtime == st.ModTime().UnixNano() {
+		return c.pf.Reopen()
+	}
+	pf, err := pgen.OpenPfile(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(pfileCache) >= maxCachedPfiles {
+		for k, c := range pfileCache {
+			c.pf.Close()
+			delete(pfileCache, k)
+		}
+	}
+	pfileCache[prefix] = cachedPfile{pf: pf, mtime: st.ModTime().UnixNano()}
+	return pf.Reopen()
+}
+
+var (
+	freqMu    sync.Mutex
+	freqCache = map[string]map[string]alleleFreq{}
+)
+
+// loadFreqs reads (once per path) a plink2 .afreq file keyed by variant ID.
+// An empty path yields an empty table, leaving imputation to the sample mean.
+func loadFreqs(path string) (map[string]alleleFreq, error) {
+	if path == "" {
+		return map[string]alleleFreq{}, nil
+	}
+	freqMu.Lock()
+	defer freqMu.Unlock()
+	if m, ok := freqCache[path]; ok {
+		return m, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	idIdx, refIdx, altIdx, frqIdx := -1, -1, -1, -1
+	m := make(map[string]alleleFreq)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		cols := strings.Fields(sc.Text())
+		if len(cols) == 0 {
+			continue
+		}
+		if strings.HasPrefix(cols[0], "#") {
+			for i, c := range cols {
+				switch strings.TrimPrefix(c, "#") {
+				case "ID":
+					idIdx = i
+				case "REF":
+					refIdx = i
+				case "ALT":
+					altIdx = i
+				case "ALT_FREQS":
+					frqIdx = i
+				}
+			}
+			continue
+		}
+		if idIdx < 0 || refIdx < 0 || altIdx < 0 || frqIdx < 0 {
+			return nil, fmt.Errorf("%s: missing ID/REF/ALT/ALT_FREQS header", path)
+		}
+		p, err := strconv.ParseFloat(cols[frqIdx], 64)
+		if err != nil {
+			continue
+		}
+		m[cols[idIdx]] = alleleFreq{ref: strings.ToUpper(cols[refIdx]), alt: strings.ToUpper(cols[altIdx]), altFreq: p}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	freqCache[path] = m
+	return m, nil
+}
+
+// writeSscore writes a plink2-style .sscore (cols=+scoresums layout).
+func writeSscore(path string, samples []pgen.Sample, scores []sampleScore) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "#IID\tALLELE_CT\tNAMED_ALLELE_DOSAGE_SUM\tSCORE1_AVG\tSCORE1_SUM")
+	for i, s := range scores {
+		avg := 0.0
+		if s.alleleCt > 0 {
+			avg = s.sum / float64(s.alleleCt)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", samples[i].IID, s.alleleCt,
+			formatFloat(s.dosageSum), formatFloat(avg), formatFloat(s.sum))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeLines writes one string per line.
+func writeLines(path string, lines []string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, l := range lines {
+		fmt.Fprintln(w, l)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// formatFloat prints with plink2's default 6 significant digits.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
@@ -0,0 +1,556 @@
+package scoring
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/adamwestgate/easy-pgs/backend/config"
+	"github.com/adamwestgate/easy-pgs/backend/preprocessing/pgen"
+)
+
+// The parity fixtures in testdata/parity are a six-sample panel (two males,
+// three females, one of unknown sex) with missing autosomal calls, a chrX
+// variant and a chrY variant with a heterozygous male call. The expected
+// .sscore files are the output of
+//
+//	plink2 --pfile panel --read-freq panel.afreq --score score.txt 1 2 3 header cols=+scoresums
+//	plink2 --pfile panel --read-freq panel.afreq --score score.txt 1 2 3 header cols=+scoresums no-mean-imputation
+//	plink2 --pfile panel --score score_complete.txt 1 2 3 header cols=+scoresums
+//
+// regenerated with
+//
+//	go test ./backend/preprocessing/scoring -run TestParityPlink2 -update-parity
+//
+// which also records `plink2 --version` in testdata/parity/plink2.version.
+// TestParityPlink2 re-checks the goldens against the installed plink2 and
+// fails while that file is missing, i.e. while the goldens are still the
+// hand-derived ones they started as. CI sets EASY_PGS_REQUIRE_PLINK2=1 so a
+// missing plink2 fails rather than skips (.github/workflows/go.yml).
+var parityCases = []struct {
+	name, score, freqs string
+	noImpute           bool
+	args               []string
+}{
+	{"impute", "score.txt", "panel.afreq", false, nil},
+	{"no_mean_imputation", "score.txt", "panel.afreq", true, []string{"no-mean-imputation"}},
+	{"complete", "score_complete.txt", "", false, nil},
+}
+
+// parityDir copies the parity fixtures to a temporary directory, so test
+// outputs written next to the fileset stay out of testdata.
+func parityDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	entries, err := os.ReadDir(filepath.Join("testdata", "parity"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		b, err := os.ReadFile(filepath.Join("testdata", "parity", e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, e.Name()), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestNativeParity(t *testing.T) {
+	dir := parityDir(t)
+	for _, tc := range parityCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pf, err := pgen.OpenPfile(filepath.Join(dir, "panel"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pf.Close()
+			rows, err := readScoreRows(filepath.Join(dir, tc.score))
+			if err != nil {
+				t.Fatal(err)
+			}
+			freqs := map[string]alleleFreq{}
+			if tc.freqs != "" {
+				if freqs, err = loadFreqs(filepath.Join(dir, tc.freqs)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			scores, _, err := scoreSamples(context.Background(), pf, rows, freqs, nil, tc.noImpute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := filepath.Join(dir, tc.name+".native.sscore")
+			if err := writeSscore(got, pf.Samples, scores); err != nil {
+				t.Fatal(err)
+			}
+			compareSscore(t, got, filepath.Join(dir, tc.name+".sscore"))
+		})
+	}
+}
+
+// TestContributionsReconcile checks that the per-variant contributions of the
+// first sample add up to its SCORE1_SUM and SCORE1_AVG under each case's
+// imputation.
+func TestContributionsReconcile(t *testing.T) {
+	dir := parityDir(t)
+	for _, tc := range parityCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opt := Options{NoMeanImpute: tc.noImpute}
+			if tc.freqs != "" {
+				opt.FreqFile = filepath.Join(dir, tc.freqs)
+			}
+			contribs, err := NativeContributions(context.Background(), filepath.Join(dir, "panel"), "", filepath.Join(dir, tc.score), opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pf, err := pgen.OpenPfile(filepath.Join(dir, "panel"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pf.Close()
+			rows, err := readScoreRows(filepath.Join(dir, tc.score))
+			if err != nil {
+				t.Fatal(err)
+			}
+			freqs, err := loadFreqs(opt.FreqFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			scores, _, err := scoreSamples(context.Background(), pf, rows, freqs, nil, tc.noImpute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := scores[0]
+
+			var sum, avg float64
+			for _, c := range contribs {
+				sum += c.Contribution
+				avg += c.AvgContribution
+				if tc.noImpute && c.Imputed {
+					t.Errorf("%s imputed under no-mean-imputation", c.ID)
+				}
+			}
+			if math.Abs(sum-want.sum) > 1e-9 {
+				t.Errorf("contributions add up to %g, SCORE1_SUM is %g", sum, want.sum)
+			}
+			if wantAvg := want.sum / float64(want.alleleCt); math.Abs(avg-wantAvg) > 1e-9 {
+				t.Errorf("average contributions add up to %g, SCORE1_AVG is %g", avg, wantAvg)
+			}
+		})
+	}
+}
+
+// TestDosageMatrixParity scores the same cases through a written and re-read
+// dosage matrix, which must agree with NativeScore.
+func TestDosageMatrixParity(t *testing.T) {
+	dir := parityDir(t)
+	for _, tc := range parityCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pf, err := pgen.OpenPfile(filepath.Join(dir, "panel"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pf.Close()
+			rows, err := readScoreRows(filepath.Join(dir, tc.score))
+			if err != nil {
+				t.Fatal(err)
+			}
+			freqs := map[string]alleleFreq{}
+			if tc.freqs != "" {
+				if freqs, err = loadFreqs(filepath.Join(dir, tc.freqs)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			iids := make([]string, len(pf.Samples))
+			for i, s := range pf.Samples {
+				iids[i] = s.IID
+			}
+			m := newDosageMatrix(iids, sampleMales(pf.Samples))
+			err = eachMatch(context.Background(), pf, rows, nil, func(row scoreRow, mt match) {
+				impute, ok := imputedDosage(freqs, mt.variant, mt.effectIsAlt)
+				if !ok {
+					impute = mt.meanDosage()
+				}
+				m.add(row.id, mt.variant.Chr, row.weight, impute, mt)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, tc.name+".dm")
+			if err := m.write(path); err != nil {
+				t.Fatal(err)
+			}
+			out, err := ScoreDosageMatrix(path, "", filepath.Join(dir, tc.name+".matrix"), tc.noImpute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareSscore(t, out, filepath.Join(dir, tc.name+".sscore"))
+		})
+	}
+}
+
+// TestSplitSscore splits a combined two-score pass, as plink2 would write it
+// for score_complete.txt and a second score sharing one of its variants, and
+// checks that score_complete.txt's part matches a run of it alone. A pass
+// whose ALLELE_CT shows missing calls must not be split.
+func TestSplitSscore(t *testing.T) {
+	dir := parityDir(t)
+	prefix := filepath.Join(dir, "panel")
+	other := filepath.Join(dir, "other.txt")
+	if err := os.WriteFile(other, []byte("ID\tEFFECT_ALLELE\tWEIGHT\nrs6\tG\t2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	group := []*batchScore{
+		loadBatchScore(filepath.Join(dir, "score_complete.txt"), prefix, "", dir, target{}),
+		loadBatchScore(other, prefix, "", dir, target{}),
+	}
+	for _, s := range group {
+		if s.err != nil {
+			t.Fatal(s.err)
+		}
+	}
+
+	want := readSscoreTable(t, filepath.Join(dir, "complete.sscore"))
+	combined := filepath.Join(dir, "combined")
+	lines := []string{"#IID\tALLELE_CT\tNAMED_ALLELE_DOSAGE_SUM\tscore_complete_AVG\tother_AVG\tscore_complete_N_AVG\tother_N_AVG" +
+		"\tscore_complete_SUM\tother_SUM\tscore_complete_N_SUM\tother_N_SUM"}
+	iids := []string{"s1", "s2", "s3", "s4", "s5", "s6"}
+	for i, iid := range iids {
+		w := want[iid]
+		lines = append(lines, fmt.Sprintf("%s\t%g\t99\t0\t0\t0\t0\t%g\t%d\t%g\t%d",
+			iid, w["ALLELE_CT"], w["SCORE1_SUM"], 2*(i%3), w["NAMED_ALLELE_DOSAGE_SUM"], i%3))
+	}
+	if err := writeLines(combined+".sscore", lines); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeLines(combined+".sscore.vars", []string{"rs4", "rs6"}); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "split")
+	if err := os.Mkdir(out, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := splitSscore(prefix, combined, out, group); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range group {
+		if s.err != nil {
+			t.Fatalf("%s: %v", s.name, s.err)
+		}
+	}
+	compareSscore(t, filepath.Join(out, "score_complete.sscore"), filepath.Join(dir, "complete.sscore"))
+
+	got := readSscoreTable(t, filepath.Join(out, "other.sscore"))
+	for i, iid := range iids {
+		if g := got[iid]; g["ALLELE_CT"] != 2 || g["NAMED_ALLELE_DOSAGE_SUM"] != float64(i%3) || g["SCORE1_AVG"] != float64(i%3) {
+			t.Errorf("other %s = %v", iid, g)
+		}
+	}
+	vars, err := readIDSet(filepath.Join(out, "other.sscore.vars"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vars) != 1 || !vars["rs6"] {
+		t.Errorf("other.sscore.vars = %v, want rs6", vars)
+	}
+
+	// score.txt has missing calls: the pass's ALLELE_CT is below full ploidy
+	group = group[:1]
+	group[0] = loadBatchScore(filepath.Join(dir, "score.txt"), prefix, "", dir, target{})
+	want = readSscoreTable(t, filepath.Join(dir, "impute.sscore"))
+	lines = []string{"#IID\tALLELE_CT\tNAMED_ALLELE_DOSAGE_SUM\tscore_AVG\tscore_N_AVG\tscore_SUM\tscore_N_SUM"}
+	for _, iid := range iids {
+		w := want[iid]
+		lines = append(lines, fmt.Sprintf("%s\t%g\t%g\t0\t0\t%g\t%g",
+			iid, w["ALLELE_CT"], w["NAMED_ALLELE_DOSAGE_SUM"], w["SCORE1_SUM"], w["NAMED_ALLELE_DOSAGE_SUM"]))
+	}
+	if err := writeLines(combined+".sscore", lines); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeLines(combined+".sscore.vars", []string{"rs1", "rs2", "rs3", "rs4", "rs5", "rs6"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := splitSscore(prefix, combined, out, group); !errors.Is(err, errMissingCalls) {
+		t.Errorf("split with missing calls: %v, want errMissingCalls", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "score.sscore")); err == nil {
+		t.Error("score.sscore written for a pass with missing calls")
+	}
+}
+
+// TestPanelReadOnly builds a dosage matrix from a chr:pos score file, which
+// is mapped to rsIDs through the panel's variant index, and checks that
+// nothing in the panel directory was created or changed.
+func TestPanelReadOnly(t *testing.T) {
+	panel := parityDir(t)
+	before := dirSnapshot(t, panel)
+	if err := os.Chmod(panel, 0o555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(panel, 0o755) })
+
+	work := t.TempDir()
+	score := filepath.Join(work, "PGS000001.txt")
+	rows := "chr_name\tchr_position\teffect_allele\teffect_weight\n1\t100\tG\t0.5\n2\t300\tA\t1.2\n"
+	if err := os.WriteFile(score, []byte(rows), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnsureDosageMatrix(context.Background(), panel, "", score, filepath.Join(work, "PGS000001.dm")); err != nil {
+		t.Fatal(err)
+	}
+
+	after := dirSnapshot(t, panel)
+	for name, st := range after {
+		if before[name] != st {
+			t.Errorf("panel file %s was written", name)
+		}
+	}
+}
+
+// dirSnapshot returns name → size and mtime of every file in dir.
+func dirSnapshot(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]string, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[e.Name()] = fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano())
+	}
+	return out
+}
+
+// updateParity makes TestParityPlink2 rewrite the golden .sscore files from
+// plink2's output instead of comparing against them.
+var updateParity = flag.Bool("update-parity", false, "rewrite testdata/parity goldens from plink2")
+
+// TestParityPlink2 checks the golden files against plink2 itself, or
+// rewrites them with -update-parity. Skipped when plink2 is not installed,
+// unless EASY_PGS_REQUIRE_PLINK2 is set.
+func TestParityPlink2(t *testing.T) {
+	exe, err := exec.LookPath(config.Plink2Cmd)
+	if err != nil {
+		if os.Getenv("EASY_PGS_REQUIRE_PLINK2") != "" {
+			t.Fatalf("plink2 not found: %v", err)
+		}
+		t.Skip("plink2 not found")
+	}
+	version, err := exec.Command(exe, "--version").Output()
+	if err != nil {
+		t.Fatalf("plink2 --version: %v", err)
+	}
+	version = append(bytes.TrimSpace(version), '\n')
+	golden := filepath.Join("testdata", "parity")
+	if *updateParity {
+		if err := os.WriteFile(filepath.Join(golden, "plink2.version"), version, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	} else if recorded, err := os.ReadFile(filepath.Join(golden, "plink2.version")); err != nil {
+		t.Fatalf("goldens have no recorded plink2 version, regenerate them with -update-parity: %v", err)
+	} else if !bytes.Equal(recorded, version) {
+		t.Logf("goldens were written by %s, checking against %s", bytes.TrimSpace(recorded), bytes.TrimSpace(version))
+	}
+	dir := parityDir(t)
+	for _, tc := range parityCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out := filepath.Join(dir, tc.name+".plink2")
+			args := []string{"--pfile", filepath.Join(dir, "panel")}
+			if tc.freqs != "" {
+				args = append(args, "--read-freq", filepath.Join(dir, tc.freqs))
+			}
+			args = append(args, "--score", filepath.Join(dir, tc.score), "1", "2", "3", "header", "cols=+scoresums")
+			args = append(args, tc.args...)
+			args = append(args, "--out", out)
+			if b, err := exec.Command(exe, args...).CombinedOutput(); err != nil {
+				t.Fatalf("plink2: %v\n%s", err, b)
+			}
+			if *updateParity {
+				b, err := os.ReadFile(out + ".sscore")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(golden, tc.name+".sscore"), b, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			compareSscore(t, out+".sscore", filepath.Join(dir, tc.name+".sscore"))
+		})
+	}
+}
+
+// compareSscore checks that two .sscore files have the same samples and the
+// same values in every column of want, up to printing precision.
+func compareSscore(t *testing.T, gotPath, wantPath string) {
+	t.Helper()
+	got, want := readSscoreTable(t, gotPath), readSscoreTable(t, wantPath)
+	if len(got) != len(want) {
+		t.Fatalf("%d samples, want %d", len(got), len(want))
+	}
+	for iid, wcols := range want {
+		gcols, ok := got[iid]
+		if !ok {
+			t.Errorf("sample %s missing", iid)
+			continue
+		}
+		for col, w := range wcols {
+			g, ok := gcols[col]
+			if !ok {
+				t.Errorf("column %s missing", col)
+				continue
+			}
+			if math.Abs(g-w) > 1e-5*math.Max(1, math.Abs(w)) {
+				t.Errorf("%s %s = %g, want %g", iid, col, g, w)
+			}
+		}
+	}
+}
+
+// readSscoreTable reads an .sscore into IID → column → value.
+func readSscoreTable(t *testing.T, path string) map[string]map[string]float64 {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Scan()
+	header := strings.Fields(strings.TrimPrefix(sc.Text(), "#"))
+	out := map[string]map[string]float64{}
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		row := map[string]float64{}
+		var iid string
+		for i, h := range header {
+			switch h {
+			case "IID":
+				iid = fields[i]
+			case "FID":
+			default:
+				v, err := strconv.ParseFloat(fields[i], 64)
+				if err != nil {
+					t.Fatalf("%s: %s: %v", path, h, err)
+				}
+				row[h] = v
+			}
+		}
+		out[iid] = row
+	}
+	return out
+}
+
+// TestDosageMatrixExact checks that a variant with a dosage track keeps its
+// exact dosages through a written and re-read matrix.
+func TestDosageMatrixExact(t *testing.T) {
+	m := newDosageMatrix([]string{"s1", "s2", "s3"}, []bool{false, false, true})
+	mt := match{
+		variant:     pgen.Variant{ID: "rs1", Chr: "X", Ref: "A", Alt: "G"},
+		effectIsAlt: false,
+		geno:        []byte{0, pgen.Missing, 2},
+		dosage:      []float64{0.25, math.NaN(), 1.5},
+	}
+	m.add("rs1", "X", 2, 1, mt)
+	path := filepath.Join(t.TempDir(), "exact.dm")
+	if err := m.write(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readDosageMatrix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scores, used := got.score(nil, false)
+	if len(used) != 1 {
+		t.Fatalf("used %v", used)
+	}
+	// effect allele is REF: 2-0.25, imputed 1, and a haploid male 0.5 × (2-1.5)
+	want := []sampleScore{
+		{alleleCt: 2, dosageSum: 1.75, sum: 3.5},
+		{alleleCt: 0, dosageSum: 1, sum: 2},
+		{alleleCt: 1, dosageSum: 0.25, sum: 0.5},
+	}
+	for s, w := range want {
+		g := scores[s]
+		if g.alleleCt != w.alleleCt || math.Abs(g.dosageSum-w.dosageSum) > 1e-4 || math.Abs(g.sum-w.sum) > 1e-4 {
+			t.Errorf("sample %d: got %+v, want %+v", s, g, w)
+		}
+	}
+}
+
+func TestDosageMatrixCache(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, variants int) string {
+		m := newDosageMatrix([]string{"s1", "s2"}, []bool{false, true})
+		for i := 0; i < variants; i++ {
+			id := fmt.Sprintf("rs%d", i)
+			m.add(id, "1", 1, 1, match{variant: pgen.Variant{ID: id, Chr: "1"}, geno: []byte{0, 2}})
+		}
+		path := filepath.Join(dir, name+".dm")
+		if err := m.write(path); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	a, b, c, big := write("a", 10), write("b", 10), write("c", 10), write("big", 100)
+
+	load := func(path string) *dosageMatrix {
+		m, err := loadDosageMatrix(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	small, err := readDosageMatrix(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reset := func(limit int64) {
+		matrixMu.Lock()
+		matrixCache, matrixBytes, matrixCacheLimit = map[string]*cachedMatrix{}, 0, limit
+		matrixMu.Unlock()
+	}
+	t.Cleanup(func() { reset(config.DosageMatrixCacheBytes) })
+	reset(2*small.size() + small.size()/2) // room for two of the small matrices
+
+	ma := load(a)
+	load(b)
+	if load(a) != ma {
+		t.Error("a reloaded while cached")
+	}
+	load(c) // evicts b, the least recently used
+	if _, ok := matrixCache[b]; ok {
+		t.Error("b still cached")
+	}
+	if load(a) != ma {
+		t.Error("a evicted instead of b")
+	}
+	if load(big); len(matrixCache) != 2 {
+		t.Errorf("a matrix over the limit changed the cache: %d entries", len(matrixCache))
+	}
+	var sum int64
+	for _, e := range matrixCache {
+		sum += e.size
+	}
+	if sum != matrixBytes || matrixBytes > matrixCacheLimit {
+		t.Errorf("cache holds %d bytes, accounted %d, limit %d", sum, matrixBytes, matrixCacheLimit)
+	}
+}
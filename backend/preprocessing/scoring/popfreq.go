@@ -0,0 +1,93 @@
+package scoring
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/adamwestgate/easy-pgs/backend/preprocessing/pgen"
+)
+
+// EnsureSuperPopFreqs writes at path a plink2-style .afreq of the reference
+// panel in pfileDir, counted over the samples of one 1000 Genomes
+// superpopulation (the .psam SuperPop column), unless one newer than the
+// panel is already there. Variants without a call in the superpopulation
+// are left out, so imputation falls back to the sample mean for them.
+// Like EnsureDosageMatrix, it leaves sharing concurrent builds of one path
+// to the caller.
+func EnsureSuperPopFreqs(ctx context.Context, pfileDir, superPop, path string) error {
+	prefix, err := findPfile(pfileDir)
+	if err != nil {
+		return err
+	}
+	if upToDate(path, []string{prefix + ".pgen", prefix + ".pvar", prefix + ".psam"}) {
+		return nil
+	}
+
+	pf, err := openPfile(prefix)
+	if err != nil {
+		return err
+	}
+	defer pf.Close()
+	var keep []int
+	for i, s := range pf.Samples {
+		if strings.EqualFold(s.SuperPop(), superPop) {
+			keep = append(keep, i)
+		}
+	}
+	if len(keep) == 0 {
+		return fmt.Errorf("no %s samples in %s", superPop, pfileDir)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after the rename
+	w := bufio.NewWriter(tmp)
+	fmt.Fprintln(w, "#CHROM\tID\tREF\tALT\tALT_FREQS\tOBS_CT")
+
+	var geno []byte
+	for i, v := range pf.Variants {
+		if i%ctxCheckEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				tmp.Close()
+				return err
+			}
+		}
+		if v.ID == "." || v.Alt == "." {
+			continue
+		}
+		if geno, err = pf.Genotypes(i, geno); err != nil {
+			tmp.Close()
+			return err
+		}
+		alt, obs := 0, 0
+		for _, s := range keep {
+			if g := geno[s]; g != pgen.Missing {
+				alt += int(g)
+				obs += 2
+			}
+		}
+		if obs == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\n", v.Chr, v.ID, v.Ref, v.Alt,
+			strconv.FormatFloat(float64(alt)/float64(obs), 'g', 6, 64), obs)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -4,16 +4,22 @@ package scoring
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/adamwestgate/easy-pgs/backend/config"
+	"github.com/adamwestgate/easy-pgs/backend/events"
+	"github.com/adamwestgate/easy-pgs/backend/preprocessing/pgen"
 )
 
-func refFreqFor(kitType string) string {
+// RefFreqFor returns the reference panel allele frequency file of kitType,
+// or "" for an unknown kit type.
+func RefFreqFor(kitType string) string {
 	switch strings.ToLower(kitType) {
 	case "ancestry":
 		return config.ReferenceFreqAncestry
@@ -32,6 +38,70 @@ type BatchResult struct {
 	Err       error
 }
 
+// Options selects the scoring engine and where progress is reported.
+//  - Native: score in-process (NativeScore) instead of running plink2
+//  - Events: optional emitter for per-score start/finish events
+//  - OutDir: write .rsid.score/.sscore files here instead of <pfileDir>/scores
+//  - Extract: PGS ID → variant ID list restricting that score, replacing the
+//    default <pgsID>.snplist lookup next to the score file
+//  - NoMeanImpute: missing genotypes add nothing (plink2's
+//    no-mean-imputation) instead of being mean-imputed; either way they are
+//    left out of ALLELE_CT
+//  - FreqFile: .afreq to mean-impute from instead of the kit type's
+//    reference panel frequencies
+type Options struct {
+	Native       bool
+	Events       events.Emitter
+	OutDir       string
+	Extract      map[string]string
+	NoMeanImpute bool
+	FreqFile     string
+}
+
+// target is where one score run reads its variant filter and writes its
+// outputs, and how it treats missing genotypes; zero values mean the
+// defaults described on Options.
+type target struct {
+	outDir   string
+	extract  string
+	noImpute bool
+	freqFile string
+}
+
+// targetFor returns the target of pgsID's run under opt.
+func targetFor(opt Options, pgsID string) target {
+	return target{outDir: opt.OutDir, extract: opt.Extract[pgsID], noImpute: opt.NoMeanImpute, freqFile: opt.FreqFile}
+}
+
+// freqs returns the allele frequency file to mean-impute from.
+func (t target) freqs(kitType string) string {
+	if t.freqFile != "" {
+		return t.freqFile
+	}
+	return RefFreqFor(kitType)
+}
+
+// scoresDir returns the output directory for a run against pfilePrefix.
+func (t target) scoresDir(pfilePrefix string) string {
+	if t.outDir != "" {
+		return t.outDir
+	}
+	return filepath.Join(filepath.Dir(pfilePrefix), config.ScoreOutputDirName)
+}
+
+// extractFile returns the variant ID list to restrict scPath to, or "".
+func (t target) extractFile(scPath string) string {
+	if t.extract != "" {
+		return t.extract
+	}
+	pgsID := strings.SplitN(filepath.Base(scPath), ".", 2)[0]
+	snplist := filepath.Join(filepath.Dir(scPath), pgsID+".snplist")
+	if _, err := os.Stat(snplist); err != nil {
+		return ""
+	}
+	return snplist
+}
+
 // BatchScore locates pgen/pvar/psam files for the associated kit, then 
 //  scores them for each PGS file requested by the user.
 // Returns a map from trimmed PGS ID to BatchResult.
@@ -40,79 +110,75 @@ type BatchResult struct {
 //  - scorePaths: list of PGS weight files to apply
 //  - pvarDir: optional directory to search for a .pvar file
 func BatchScore(pfileDir, kitType string, scorePaths []string, pvarDir string) map[string]BatchResult {
-	res := make(map[string]BatchResult, len(scorePaths))
+	return BatchScoreOpts(context.Background(), pfileDir, kitType, scorePaths, pvarDir, Options{})
+}
 
-	// locate <prefix>.pgen in pfileDir
-	var prefix string
-	for _, e := range mustReadDir(pfileDir) {
-		if strings.HasSuffix(e.Name(), ".pgen") {
-			prefix = strings.TrimSuffix(filepath.Join(pfileDir, e.Name()), ".pgen")
-			break
-		}
+// BatchScoreOpts is BatchScore with an explicit engine choice and progress
+// events. With plink2 the whole batch is scored in as few passes over the
+// genotypes as possible (see scorePlinkBatch); the native engine scores one
+// file at a time. Once ctx is done the running score is aborted and every
+// remaining score file gets ctx.Err() as its result.
+func BatchScoreOpts(ctx context.Context, pfileDir, kitType string, scorePaths []string, pvarDir string, opt Options) map[string]BatchResult {
+	score := scorePlink
+	if opt.Native {
+		score = scoreNative
 	}
-	if prefix == "" {
-		err := fmt.Errorf("no .pgen in %s", pfileDir)
+	res := make(map[string]BatchResult, len(scorePaths))
+
+	// locate <prefix>.pgen in pfileDir; an unreadable directory fails every score
+	prefix, err := findPfile(pfileDir)
+	if err != nil {
 		for _, p := range scorePaths {
 			res[trimID(p)] = BatchResult{"", err}
 		}
 		return res
 	}
 
-	for _, sp := range scorePaths {
-		out, err := Score(prefix, kitType, sp, pvarDir)
+	if !opt.Native {
+		return scorePlinkBatch(ctx, prefix, kitType, scorePaths, pvarDir, opt)
+	}
+
+	total := int64(len(scorePaths))
+	for i, sp := range scorePaths {
+		if err := ctx.Err(); err != nil {
+			res[trimID(sp)] = BatchResult{"", err}
+			continue
+		}
+		pgsID := strings.SplitN(trimID(sp), ".", 2)[0]
+		ev := opt.Events.ForPGS(pgsID)
+		ev.Emit(events.Event{Type: events.ScoreStart, Done: int64(i + 1), Total: total})
+		out, err := score(ctx, prefix, kitType, sp, pvarDir, targetFor(opt, pgsID))
+		if err != nil {
+			ev.Emit(events.Event{Type: events.ScoreFailed, Done: int64(i + 1), Total: total, Error: err.Error()})
+		} else {
+			ev.Emit(events.Event{Type: events.ScoreDone, Done: int64(i + 1), Total: total})
+		}
 		res[trimID(sp)] = BatchResult{out, err}
 	}
 	return res
 }
 
 // prepareScoreFile rewrites a raw PGS file to use RSIDs rather than chr:pos identifiers.
-// It scans a .pvar file to build a map and outputs a .rsid.score file with only the score-relevant RSIDs found in the user kit
+// It looks positions up in the variant index of kitPrefix's .pvar, or of the fileset in pvarDir if given,
+// and outputs a .rsid.score file with only the score-relevant RSIDs found in the user kit.
+// The mapped file is written to scoresDir.
 // Returns the path to the RSID-mapped score file, or the original if mapping is not needed.
-func prepareScoreFile(scorePath, kitPrefix, pvarDir string) (string, error) {
-	fmt.Printf("[prepareScoreFile] Mapping RSIDs for %s\n", scorePath)
-
-	kitDir := filepath.Dir(kitPrefix)
-	scoresDir := filepath.Join(kitDir, config.ScoreOutputDirName)
+func prepareScoreFile(scorePath, kitPrefix, pvarDir, scoresDir string) (string, error) {
 	if err := os.MkdirAll(scoresDir, 0755); err != nil {
 		return "", err
 	}
 
-	// locate a .pvar
-	searchDir := kitDir
-	if pvarDir != "" {
-		searchDir = pvarDir
-	}
-	var pvarPath string
-	filepath.Walk(searchDir, func(path string, info os.FileInfo, err error) error {
-		if strings.HasSuffix(path, ".pvar") && pvarPath == "" {
-			pvarPath = path
-		}
-		return nil
-	})
-	if pvarPath == "" {
-		return "", fmt.Errorf("pvar not found in %s", searchDir)
-	}
-
-	// build chr:pos → rsID map
-	rsMap := make(map[string]string)
-	pf, _ := os.Open(pvarPath)
-	defer pf.Close()
-	sc := bufio.NewScanner(pf)
-	for sc.Scan() {
-		if strings.HasPrefix(sc.Text(), "#") {
-			continue
-		}
-		cols := strings.Split(sc.Text(), "\t")
-		if len(cols) >= 3 {
-			rsMap[fmt.Sprintf("%s:%s", cols[0], cols[1])] = cols[2]
-		}
-	}
-
 	// if score already uses rsIDs, return as-is
-	inF, _ := os.Open(scorePath)
+	inF, err := os.Open(scorePath)
+	if err != nil {
+		return "", err
+	}
 	defer inF.Close()
 	s := bufio.NewScanner(inF)
 	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return "", err
+		}
 		return "", fmt.Errorf("empty score file")
 	}
 	hdr := strings.Split(s.Text(), "\t")
@@ -120,20 +186,54 @@ func prepareScoreFile(scorePath, kitPrefix, pvarDir string) (string, error) {
 		return scorePath, nil
 	}
 
+	// chr:pos → rsID via the fileset's variant index
+	indexPrefix := kitPrefix
+	if pvarDir != "" {
+		if indexPrefix, err = findPfile(pvarDir); err != nil {
+			return "", err
+		}
+	}
+	idx, err := pgen.LoadIndex(indexPrefix)
+	if err != nil {
+		return "", err
+	}
+
 	out := filepath.Join(scoresDir,
 		strings.TrimSuffix(filepath.Base(scorePath), filepath.Ext(scorePath))+".rsid.score")
-	outF, _ := os.Create(out)
-	defer outF.Close()
+	outF, err := os.Create(out)
+	if err != nil {
+		return "", err
+	}
+	w := bufio.NewWriter(outF)
+	// header line, consumed by plink2's "header" modifier
+	fmt.Fprintln(w, "rsID\teffect_allele\teffect_weight")
 
 	for s.Scan() {
 		cols := strings.Split(s.Text(), "\t")
 		if len(cols) < 4 {
 			continue
 		}
-		key := fmt.Sprintf("%s:%s", strings.TrimPrefix(cols[0], "chr"), cols[1])
-		if rs, ok := rsMap[key]; ok && rs != "." {
-			fmt.Fprintf(outF, "%s\t%s\t%s\n", rs, cols[2], cols[3])
+		pos, err := strconv.Atoi(cols[1])
+		if err != nil {
+			continue
 		}
+		if rs, ok := idx.ID(cols[0], pos); ok {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", rs, cols[2], cols[3])
+		}
+	}
+	if err := s.Err(); err != nil {
+		outF.Close()
+		os.Remove(out)
+		return "", err
+	}
+	if err := w.Flush(); err != nil {
+		outF.Close()
+		os.Remove(out)
+		return "", err
+	}
+	if err := outF.Close(); err != nil {
+		os.Remove(out)
+		return "", err
 	}
 	return out, nil
 }
@@ -143,52 +243,70 @@ func prepareScoreFile(scorePath, kitPrefix, pvarDir string) (string, error) {
 // 2. Constructs arguments (pfile, allele frequencies, score, header, extract)
 // 3. Executes PLINK2 and returns the path to the .sscore output
 func Score(pfilePrefix, kitType, scorePath, pvarDir string) (string, error) {
+	return ScoreContext(context.Background(), pfilePrefix, kitType, scorePath, pvarDir)
+}
+
+// ScoreContext is Score that kills plink2 when ctx is done. Partial outputs
+// of a cancelled or failed run are removed.
+func ScoreContext(ctx context.Context, pfilePrefix, kitType, scorePath, pvarDir string) (string, error) {
+	return scorePlink(ctx, pfilePrefix, kitType, scorePath, pvarDir, target{})
+}
+
+// scorePlink is ScoreContext with explicit output and extract locations.
+func scorePlink(ctx context.Context, pfilePrefix, kitType, scorePath, pvarDir string, t target) (string, error) {
 	// prepare file with RSIDs
-	scPath, err := prepareScoreFile(scorePath, pfilePrefix, pvarDir)
+	scoresDir := t.scoresDir(pfilePrefix)
+	scPath, err := prepareScoreFile(scorePath, pfilePrefix, pvarDir, scoresDir)
 	if err != nil {
 		return "", err
 	}
 
 	// output prefix under scores dir
-	outPrefix := filepath.Join(filepath.Dir(pfilePrefix), config.ScoreOutputDirName,
-		trimID(scorePath))
+	outPrefix := filepath.Join(scoresDir, trimID(scorePath))
 
 	// build plink2 args
-	args := []string{
-		"--pfile", pfilePrefix,
-		"--read-freq", refFreqFor(kitType),
-		"--score", scPath, "cols=+scoresums", "header", "list-variants",
-		"--out", outPrefix,
+	modifiers := []string{"cols=+scoresums", "header", "list-variants"}
+	if t.noImpute {
+		modifiers = append(modifiers, "no-mean-imputation")
 	}
+	args := append([]string{
+		"--pfile", pfilePrefix,
+		"--read-freq", t.freqs(kitType),
+		"--score", scPath,
+	}, modifiers...)
+	args = append(args, "--out", outPrefix)
 
 	// if a matching snplist exists, extract
-	base := filepath.Base(scPath)
-	pgsID := strings.SplitN(base, ".", 2)[0]
-	snplist := filepath.Join(filepath.Dir(scPath), pgsID+".snplist")
-	if _, err := os.Stat(snplist); err == nil {
+	if snplist := t.extractFile(scPath); snplist != "" {
 		args = append(args, "--extract", snplist)
 	}
 
-	// run plink2
-	cmd := exec.Command(config.Plink2Cmd, args...)
-	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	// run plink2; its console log goes to stderr, stdout belongs to the caller
+	cmd := exec.CommandContext(ctx, config.Plink2Cmd, args...)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
 	if err := cmd.Run(); err != nil {
+		removeOutputs(outPrefix, scPath, scorePath)
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
 		return "", fmt.Errorf("%s failed: %w", config.Plink2Cmd, err)
 	}
 	return outPrefix + ".sscore", nil
 }
 
+// removeOutputs deletes the files a scoring run writes under outPrefix, plus
+// the generated rsID score file when it is not the caller's own input.
+func removeOutputs(outPrefix, scPath, scorePath string) {
+	for _, ext := range []string{".sscore", ".sscore.vars", ".log"} {
+		os.Remove(outPrefix + ext)
+	}
+	if scPath != scorePath {
+		os.Remove(scPath)
+	}
+}
+
 // trimID removes the file extension from a path and returns the base name.
 func trimID(path string) string {
 	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
 }
 
-// mustReadDir reads the directory and panics on error, returning all entries.
-// Used when failure is unrecoverable (kit files must exist).
-func mustReadDir(dir string) []os.DirEntry {
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		panic(fmt.Errorf("read dir %s: %w", dir, err))
-	}
-	return entries
-}
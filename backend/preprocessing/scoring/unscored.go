@@ -0,0 +1,145 @@
+package scoring
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/adamwestgate/easy-pgs/backend/preprocessing/pgen"
+)
+
+// Unscored summarises the variants of a score that a run could not use, so
+// a low-coverage score can be presented with matching uncertainty. Sums are
+// on the SCORE1_SUM scale and assume Hardy-Weinberg equilibrium and
+// independent variants: a variant with effect allele frequency p and weight
+// w adds 2pw to the expected score and 2p(1-p)w² to its variance.
+type Unscored struct {
+	Variants int     // score variants not used
+	Mean     float64 // expected contribution of the unscored variants
+	SD       float64 // standard deviation of that contribution
+	Share    float64 // unscored share of the score's expected variance, 0-1
+	NoFreq   int     // unscored variants without a known frequency, taken at p = 0.5
+}
+
+// UnscoredContribution compares the normalized score file scorePath with
+// the variants a run used (varsPath, a .sscore.vars of the fileset in
+// kitDir). Effect allele frequencies come from freqFile, matched through the
+// variant index of the reference panel in panelDir.
+func UnscoredContribution(scorePath, kitDir, panelDir, freqFile, varsPath string) (Unscored, error) {
+	var u Unscored
+	used, err := readIDSet(varsPath)
+	if err != nil {
+		return u, err
+	}
+	kitPrefix, err := findPfile(kitDir)
+	if err != nil {
+		return u, err
+	}
+	kit, err := pgen.LoadIndex(kitPrefix)
+	if err != nil {
+		return u, err
+	}
+	var panel *pgen.Index
+	if prefix, err := findPfile(panelDir); err == nil {
+		panel, _ = pgen.LoadIndex(prefix) // no panel: every frequency unknown
+	}
+	freqs, err := loadFreqs(freqFile)
+	if err != nil {
+		return u, err
+	}
+
+	f, err := os.Open(scorePath)
+	if err != nil {
+		return u, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	if !sc.Scan() {
+		return u, sc.Err()
+	}
+	byPos := strings.HasPrefix(sc.Text(), "chr_name")
+
+	var scoredVar, unscoredVar float64
+	seen := map[string]bool{}
+	for sc.Scan() {
+		cols := strings.Split(sc.Text(), "\t")
+		var (
+			id, key, allele, weight string
+			v                       pgen.Variant
+			inPanel                 bool
+		)
+		if byPos {
+			if len(cols) < 4 {
+				continue
+			}
+			pos, err := strconv.Atoi(cols[1])
+			if err != nil {
+				continue
+			}
+			id, _ = kit.ID(cols[0], pos)
+			key, allele, weight = strings.TrimPrefix(cols[0], "chr")+":"+cols[1], cols[2], cols[3]
+			if panel != nil {
+				for _, pv := range panel.Lookup(cols[0], pos) {
+					if pv.ID != "." {
+						v, inPanel = pv, true
+						break
+					}
+				}
+			}
+		} else {
+			if len(cols) < 3 {
+				continue
+			}
+			id, allele, weight = cols[0], cols[1], cols[2]
+			key = id
+			if panel != nil {
+				v, inPanel = panel.ByID(id)
+			}
+		}
+		w, err := strconv.ParseFloat(weight, 64)
+		if err != nil || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		p, known := 0.5, false
+		if inPanel {
+			p, known = effectFreq(freqs, v, strings.ToUpper(allele))
+		}
+		variance := 2 * p * (1 - p) * w * w
+		if id != "" && used[id] {
+			scoredVar += variance
+			continue
+		}
+		u.Variants++
+		u.Mean += 2 * p * w
+		unscoredVar += variance
+		if !known {
+			u.NoFreq++
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return u, err
+	}
+	u.SD = math.Sqrt(unscoredVar)
+	if total := scoredVar + unscoredVar; total > 0 {
+		u.Share = unscoredVar / total
+	}
+	return u, nil
+}
+
+// effectFreq returns the frequency of effect at v from the .afreq table, or
+// 0.5 and false when unknown.
+func effectFreq(freqs map[string]alleleFreq, v pgen.Variant, effect string) (float64, bool) {
+	if effect != v.Alt && effect != v.Ref {
+		return 0.5, false
+	}
+	d, ok := imputedDosage(freqs, v, effect == v.Alt)
+	if !ok {
+		return 0.5, false
+	}
+	return d / 2, true
+}
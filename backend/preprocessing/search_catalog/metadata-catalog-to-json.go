@@ -17,6 +17,8 @@ const (
 	MetadataFilePath         = "./pgs_all_metadata.xlsx"
 	OntologyTraitsOutputFile = "backend/data/ontology_traits.json"
 	ScoresMetadataOutputFile = "backend/data/scores_metadata.json"
+	PublicationsOutputFile   = "backend/data/publications.json"
+	PerformanceOutputFile    = "backend/data/performance_metrics.json"
 )
 
 // sheetToRecords reads a sheet into a slice of map[string]interface{}
@@ -92,6 +94,20 @@ func main() {
 	writeJSON(ScoresMetadataOutputFile, scoresMeta)
 
 	fmt.Printf("✅ JSON files written: %s and %s\n", OntologyTraitsOutputFile, ScoresMetadataOutputFile)
+
+	// Publications and performance metrics are optional extras for the score detail endpoint
+	for sheet, out := range map[string]string{
+		"Publications":        PublicationsOutputFile,
+		"Performance Metrics": PerformanceOutputFile,
+	} {
+		records, err := sheetToRecords(f, sheet)
+		if err != nil {
+			log.Printf("⚠ Skipping %s: %v", sheet, err)
+			continue
+		}
+		writeJSON(out, records)
+		fmt.Printf("✅ JSON file written: %s\n", out)
+	}
 }
 
 // writeJSON marshals data and writes to a file
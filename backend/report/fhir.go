@@ -0,0 +1,273 @@
+// backend/report/fhir.go
+package report
+
+import (
+    "encoding/json"
+    "io"
+    "strings"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// FHIR R4 code systems and profiles used in the export.
+const (
+    fhirGenomicReport = "http://hl7.org/fhir/uv/genomics-reporting/StructureDefinition/genomic-report"
+    fhirV20074        = "http://terminology.hl7.org/CodeSystem/v2-0074"
+    fhirObsCategory   = "http://terminology.hl7.org/CodeSystem/observation-category"
+    fhirUCUM          = "http://unitsofmeasure.org"
+    fhirLOINC         = "http://loinc.org"
+    fhirEFO           = "http://www.ebi.ac.uk/efo"
+    fhirOBO           = "http://purl.obolibrary.org/obo"
+    fhirPGSCatalog    = "https://www.pgscatalog.org/score"
+
+    // fhirLocal holds the codes the Genomics Reporting IG has no published
+    // terminology for yet: the polygenic score itself and its numeric
+    // components. Report, trait and assembly codes are LOINC, as in the IG.
+    fhirLocal = "https://github.com/adamwestgate/easy-pgs/fhir/CodeSystem/pgs"
+
+    // fhirReferencePopulation describes the panel z-scores and percentiles are relative to.
+    fhirReferencePopulation = "1000 Genomes phase 3 (GRCh37), all superpopulations"
+)
+
+// FHIR R4 resources, reduced to the elements the export fills in.
+type (
+    fhirCoding struct {
+        System  string `json:"system,omitempty"`
+        Code    string `json:"code"`
+        Display string `json:"display,omitempty"`
+    }
+    fhirConcept struct {
+        Coding []fhirCoding `json:"coding,omitempty"`
+        Text   string       `json:"text,omitempty"`
+    }
+    fhirQuantity struct {
+        Value  float64 `json:"value"`
+        Unit   string  `json:"unit,omitempty"`
+        System string  `json:"system,omitempty"`
+        Code   string  `json:"code,omitempty"`
+    }
+    fhirReference struct {
+        Reference string `json:"reference"`
+    }
+    fhirIdentifier struct {
+        System string `json:"system"`
+        Value  string `json:"value"`
+    }
+    fhirMeta struct {
+        Profile []string `json:"profile,omitempty"`
+    }
+    fhirPatient struct {
+        ResourceType string           `json:"resourceType"`
+        ID           string           `json:"id"`
+        Identifier   []fhirIdentifier `json:"identifier"`
+    }
+    fhirAnnotation struct {
+        Text string `json:"text"`
+    }
+    fhirComponent struct {
+        Code                 fhirConcept   `json:"code"`
+        ValueQuantity        *fhirQuantity `json:"valueQuantity,omitempty"`
+        ValueCodeableConcept *fhirConcept  `json:"valueCodeableConcept,omitempty"`
+        ValueString          string        `json:"valueString,omitempty"`
+    }
+    fhirObservation struct {
+        ResourceType      string           `json:"resourceType"`
+        ID                string           `json:"id"`
+        Status            string           `json:"status"`
+        Category          []fhirConcept    `json:"category"`
+        Code              fhirConcept      `json:"code"`
+        Subject           fhirReference    `json:"subject"`
+        EffectiveDateTime string           `json:"effectiveDateTime,omitempty"`
+        ValueQuantity     *fhirQuantity    `json:"valueQuantity,omitempty"`
+        Method            *fhirConcept     `json:"method,omitempty"`
+        Note              []fhirAnnotation `json:"note,omitempty"`
+        Component         []fhirComponent  `json:"component"`
+    }
+    fhirDiagnosticReport struct {
+        ResourceType      string           `json:"resourceType"`
+        ID                string           `json:"id"`
+        Meta              fhirMeta         `json:"meta"`
+        Identifier        []fhirIdentifier `json:"identifier,omitempty"`
+        Status            string           `json:"status"`
+        Category          []fhirConcept    `json:"category"`
+        Code              fhirConcept      `json:"code"`
+        Subject           fhirReference    `json:"subject"`
+        EffectiveDateTime string           `json:"effectiveDateTime,omitempty"`
+        Issued            string           `json:"issued"`
+        Result            []fhirReference  `json:"result"`
+        Conclusion        string           `json:"conclusion"`
+    }
+    fhirEntry struct {
+        FullURL  string      `json:"fullUrl"`
+        Resource interface{} `json:"resource"`
+    }
+    fhirBundle struct {
+        ResourceType string      `json:"resourceType"`
+        ID           string      `json:"id"`
+        Type         string      `json:"type"`
+        Timestamp    string      `json:"timestamp"`
+        Entry        []fhirEntry `json:"entry"`
+    }
+)
+
+// WriteFHIR writes r as a FHIR R4 collection Bundle: one DiagnosticReport
+// (Genomics Reporting IG genomic-report profile) whose results are one
+// Observation per score, both about a Patient identified only by the kit ID.
+// Each Observation carries the raw score as its value and the PGS ID, trait
+// ontology codes, z-score, percentile, coverage, reference population and
+// assembly as components. The IG's polygenic score profile is not published
+// yet, so the score and its numeric components use a local code system.
+func WriteFHIR(w io.Writer, r *Report) error {
+    enc := json.NewEncoder(w)
+    enc.SetIndent("", "  ")
+    return enc.Encode(fhirBundleOf(r))
+}
+
+// fhirBundleOf builds the bundle written by WriteFHIR.
+func fhirBundleOf(r *Report) fhirBundle {
+    genetics := fhirConcept{Coding: []fhirCoding{{System: fhirV20074, Code: "GE", Display: "Genetics"}}}
+    laboratory := fhirConcept{Coding: []fhirCoding{{System: fhirObsCategory, Code: "laboratory", Display: "Laboratory"}}}
+
+    var effective string
+    if !r.Provenance.Created.IsZero() {
+        effective = r.Provenance.Created.UTC().Format(time.RFC3339)
+    }
+    var method *fhirConcept
+    if r.Provenance.Inputs.Engine != "" {
+        method = &fhirConcept{Text: "easy-pgs " + r.Provenance.Inputs.Engine}
+    }
+
+    patient := fhirPatient{
+        ResourceType: "Patient",
+        ID:           uuid.NewString(),
+        Identifier:   []fhirIdentifier{{System: fhirLocal + "/kit", Value: r.KitID}},
+    }
+    subject := fhirReference{Reference: "urn:uuid:" + patient.ID}
+
+    bundle := fhirBundle{
+        ResourceType: "Bundle",
+        ID:           uuid.NewString(),
+        Type:         "collection",
+        Timestamp:    r.Generated.UTC().Format(time.RFC3339),
+    }
+    dr := fhirDiagnosticReport{
+        ResourceType: "DiagnosticReport",
+        ID:           uuid.NewString(),
+        Meta:         fhirMeta{Profile: []string{fhirGenomicReport}},
+        Status:       "final",
+        Category:     []fhirConcept{genetics},
+        Code: fhirConcept{
+            Coding: []fhirCoding{{System: fhirLOINC, Code: "51969-4", Display: "Genetic analysis report"}},
+            Text:   "Polygenic score report",
+        },
+        Subject:           subject,
+        EffectiveDateTime: effective,
+        Issued:            r.Generated.UTC().Format(time.RFC3339),
+        Result:            []fhirReference{},
+        Conclusion:        Disclaimer,
+    }
+    if r.Provenance.RunID != "" {
+        dr.Identifier = []fhirIdentifier{{System: fhirLocal + "/run", Value: r.Provenance.RunID}}
+    }
+
+    var obs []fhirEntry
+    for _, t := range r.Traits {
+        o := fhirObservation{
+            ResourceType: "Observation",
+            ID:           uuid.NewString(),
+            Status:       "final",
+            Category:     []fhirConcept{laboratory, genetics},
+            Code: fhirConcept{
+                Coding: []fhirCoding{{System: fhirLocal, Code: "polygenic-score", Display: "Polygenic score"}},
+                Text:   "Polygenic score " + t.PGSID,
+            },
+            Subject:           subject,
+            EffectiveDateTime: effective,
+            ValueQuantity:     &fhirQuantity{Value: t.Score},
+            Method:            method,
+        }
+        o.Component = append(o.Component, fhirComponent{
+            Code:                 localConcept("pgs-id", "PGS Catalog score"),
+            ValueCodeableConcept: &fhirConcept{Coding: []fhirCoding{{System: fhirPGSCatalog, Code: t.PGSID}}},
+        })
+        trait := &fhirConcept{Text: t.Trait}
+        for _, term := range t.Terms {
+            trait.Coding = append(trait.Coding, fhirCoding{System: termSystem(term.ID), Code: term.ID, Display: term.Label})
+        }
+        if trait.Text != "" || len(trait.Coding) > 0 {
+            o.Component = append(o.Component, fhirComponent{Code: loincConcept("81259-4", "Associated phenotype"), ValueCodeableConcept: trait})
+        }
+        if t.Z != nil {
+            o.Component = append(o.Component, fhirComponent{
+                Code:          localConcept("z-score", "Z-score against reference population"),
+                ValueQuantity: &fhirQuantity{Value: *t.Z, Unit: "SD", System: fhirUCUM, Code: "1"},
+            })
+        }
+        if t.Percentile != nil {
+            o.Component = append(o.Component, fhirComponent{
+                Code:          localConcept("percentile", "Percentile in reference population"),
+                ValueQuantity: &fhirQuantity{Value: *t.Percentile, Unit: "percentile", System: fhirUCUM, Code: "{percentile}"},
+            })
+        }
+        if t.Coverage != nil {
+            o.Component = append(o.Component, fhirComponent{
+                Code:          localConcept("coverage", "Score variants genotyped"),
+                ValueQuantity: &fhirQuantity{Value: *t.Coverage, Unit: "%", System: fhirUCUM, Code: "%"},
+            })
+        }
+        if t.PopMean != nil {
+            o.Component = append(o.Component, fhirComponent{
+                Code:          localConcept("reference-mean", "Reference population mean score"),
+                ValueQuantity: &fhirQuantity{Value: *t.PopMean},
+            })
+        }
+        if t.PopSD != nil {
+            o.Component = append(o.Component, fhirComponent{
+                Code:          localConcept("reference-sd", "Reference population score SD"),
+                ValueQuantity: &fhirQuantity{Value: *t.PopSD},
+            })
+        }
+        o.Component = append(o.Component, fhirComponent{
+            Code:        localConcept("reference-population", "Reference population"),
+            ValueString: fhirReferencePopulation,
+        }, fhirComponent{
+            Code: loincConcept("62374-4", "Human reference sequence assembly version"),
+            ValueCodeableConcept: &fhirConcept{
+                Coding: []fhirCoding{{System: fhirLOINC, Code: "LA14029-5", Display: "GRCh37"}},
+            },
+        })
+        for _, c := range t.Caveats {
+            o.Note = append(o.Note, fhirAnnotation{Text: c})
+        }
+
+        url := "urn:uuid:" + o.ID
+        dr.Result = append(dr.Result, fhirReference{Reference: url})
+        obs = append(obs, fhirEntry{FullURL: url, Resource: o})
+    }
+
+    bundle.Entry = append([]fhirEntry{
+        {FullURL: "urn:uuid:" + dr.ID, Resource: dr},
+        {FullURL: subject.Reference, Resource: patient},
+    }, obs...)
+    return bundle
+}
+
+// localConcept is a code from the easy-pgs code system.
+func localConcept(code, display string) fhirConcept {
+    return fhirConcept{Coding: []fhirCoding{{System: fhirLocal, Code: code, Display: display}}}
+}
+
+// loincConcept is a LOINC code.
+func loincConcept(code, display string) fhirConcept {
+    return fhirConcept{Coding: []fhirCoding{{System: fhirLOINC, Code: code, Display: display}}}
+}
+
+// termSystem returns the code system URI of an ontology term ID such as
+// EFO_0000305 or MONDO_0004975.
+func termSystem(id string) string {
+    if strings.HasPrefix(id, "EFO_") {
+        return fhirEFO
+    }
+    return fhirOBO
+}
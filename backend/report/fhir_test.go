@@ -0,0 +1,137 @@
+package report
+
+import (
+    "bytes"
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// The official FHIR R4 JSON schema is too large to keep in the tree. The
+// schema test reads it from $EASY_PGS_FHIR_SCHEMA, which CI sets after
+// downloading https://hl7.org/fhir/R4/fhir.schema.json.zip, or from
+// testdata/fhir.schema.json, and is skipped when neither exists.
+const fhirSchemaEnv = "EASY_PGS_FHIR_SCHEMA"
+
+// fixtureBundle writes the FHIR bundle of testdata/report_fixture.json.
+func fixtureBundle(t *testing.T) (*Report, []byte) {
+    t.Helper()
+    var r Report
+    readJSON(t, filepath.Join("testdata", "report_fixture.json"), &r)
+    var buf bytes.Buffer
+    if err := WriteFHIR(&buf, &r); err != nil {
+        t.Fatal(err)
+    }
+    return &r, buf.Bytes()
+}
+
+// TestFHIRSchema validates the bundle written for testdata/report_fixture.json
+// against the official FHIR R4 JSON schema.
+func TestFHIRSchema(t *testing.T) {
+    path := os.Getenv(fhirSchemaEnv)
+    if path == "" {
+        path = filepath.Join("testdata", "fhir.schema.json")
+        if _, err := os.Stat(path); err != nil {
+            t.Skipf("no FHIR R4 schema: unzip https://hl7.org/fhir/R4/fhir.schema.json.zip into testdata or set %s", fhirSchemaEnv)
+        }
+    }
+    f, err := os.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    schemaDoc, err := jsonschema.UnmarshalJSON(f)
+    if err != nil {
+        t.Fatalf("%s: %v", path, err)
+    }
+    c := jsonschema.NewCompiler()
+    if err := c.AddResource("fhir.schema.json", schemaDoc); err != nil {
+        t.Fatal(err)
+    }
+    schema, err := c.Compile("fhir.schema.json")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    _, b := fixtureBundle(t)
+    doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(b))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := schema.Validate(doc); err != nil {
+        t.Error(err)
+    }
+}
+
+// TestFHIRBundle checks the references and codes the schema cannot: every
+// DiagnosticReport result resolves to an Observation, and the report and
+// its Observations are all about the kit's Patient.
+func TestFHIRBundle(t *testing.T) {
+    r, b := fixtureBundle(t)
+    var bundle struct {
+        Entry []struct {
+            FullURL  string `json:"fullUrl"`
+            Resource struct {
+                ResourceType string        `json:"resourceType"`
+                Code         fhirConcept   `json:"code"`
+                Subject      fhirReference `json:"subject"`
+                Result       []fhirReference
+                Identifier   []fhirIdentifier
+            } `json:"resource"`
+        } `json:"entry"`
+    }
+    if err := json.Unmarshal(b, &bundle); err != nil {
+        t.Fatal(err)
+    }
+
+    types := map[string]string{}
+    for _, e := range bundle.Entry {
+        types[e.FullURL] = e.Resource.ResourceType
+    }
+    var reports, observations int
+    for _, e := range bundle.Entry {
+        res := e.Resource
+        switch res.ResourceType {
+        case "Patient":
+            if len(res.Identifier) != 1 || res.Identifier[0].Value != r.KitID {
+                t.Errorf("patient identifier = %+v, want kit %s", res.Identifier, r.KitID)
+            }
+            continue
+        case "DiagnosticReport":
+            reports++
+            if c := res.Code.Coding; len(c) == 0 || c[0].System != fhirLOINC || c[0].Code != "51969-4" {
+                t.Errorf("report code = %+v, want LOINC 51969-4", res.Code)
+            }
+            if len(res.Result) != len(r.Traits) {
+                t.Errorf("%d results for %d traits", len(res.Result), len(r.Traits))
+            }
+            for _, ref := range res.Result {
+                if types[ref.Reference] != "Observation" {
+                    t.Errorf("result %s does not resolve to an Observation", ref.Reference)
+                }
+            }
+        case "Observation":
+            observations++
+        }
+        if types[res.Subject.Reference] != "Patient" {
+            t.Errorf("%s subject %q does not resolve to a Patient", res.ResourceType, res.Subject.Reference)
+        }
+    }
+    if reports != 1 || observations != len(r.Traits) {
+        t.Errorf("%d reports and %d observations, want 1 and %d", reports, observations, len(r.Traits))
+    }
+}
+
+func readJSON(t *testing.T, path string, dst interface{}) {
+    t.Helper()
+    b, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := json.Unmarshal(b, dst); err != nil {
+        t.Fatalf("%s: %v", path, err)
+    }
+}
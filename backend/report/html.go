@@ -0,0 +1,165 @@
+// backend/report/html.go
+package report
+
+import (
+    "fmt"
+    "html/template"
+    "io"
+    "strings"
+    "time"
+)
+
+// chart dimensions in SVG user units
+const (
+    chartW, chartH = 360.0, 110.0
+    chartPad       = 16.0 // room for the axis labels below the curve
+)
+
+var htmlTmpl = template.Must(template.New("report").Funcs(template.FuncMap{
+    "num":        num,
+    "ordinal":    ordinal,
+    "chart":      chartSVG,
+    "pct":        func(v float64) string { return fmt.Sprintf("%.1f%%", v*100) },
+    "date":       func(t time.Time) string { return t.Format("2006-01-02 15:04 MST") },
+    "disclaimer": func() string { return Disclaimer },
+}).Parse(htmlSrc))
+
+// WriteHTML renders r as a single self-contained HTML page.
+func WriteHTML(w io.Writer, r *Report) error {
+    return htmlTmpl.Execute(w, r)
+}
+
+// chartSVG draws the reference distribution of t with the area below the
+// kit's score shaded and its position marked. Without a z-score only the
+// distribution is drawn.
+func chartSVG(t Trait) template.HTML {
+    pts := distPoints(t)
+    base := chartH - chartPad
+    xy := func(p [2]float64) (float64, float64) {
+        return p[0] * chartW, base - p[1]*(base-6)
+    }
+
+    var curve strings.Builder
+    for i, p := range pts {
+        x, y := xy(p)
+        cmd := "L"
+        if i == 0 {
+            cmd = "M"
+        }
+        fmt.Fprintf(&curve, "%s%.1f %.1f ", cmd, x, y)
+    }
+
+    var b strings.Builder
+    fmt.Fprintf(&b, `<svg class="chart" viewBox="0 0 %.0f %.0f" width="%.0f" height="%.0f" role="img" aria-label="Reference distribution">`,
+        chartW, chartH, chartW, chartH)
+    if t.Z != nil {
+        mx := markerX(*t.Z)
+        var area strings.Builder
+        fmt.Fprintf(&area, "M0 %.1f ", base)
+        for _, p := range areaPoints(pts, mx) {
+            x, y := xy(p)
+            fmt.Fprintf(&area, "L%.1f %.1f ", x, y)
+        }
+        fmt.Fprintf(&area, "L%.1f %.1f Z", mx*chartW, base)
+        fmt.Fprintf(&b, `<path d="%s" fill="#c7d7f2"/>`, area.String())
+    }
+    fmt.Fprintf(&b, `<path d="%s" fill="none" stroke="#35507a" stroke-width="1.5"/>`, curve.String())
+    fmt.Fprintf(&b, `<line x1="0" y1="%.1f" x2="%.0f" y2="%.1f" stroke="#999"/>`, base, chartW, base)
+    for z := -zRange; z <= zRange; z += 2 {
+        x := markerX(z) * chartW
+        anchor := "middle"
+        if z == -zRange {
+            anchor = "start"
+        } else if z == zRange {
+            anchor = "end"
+        }
+        fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" font-size="9" text-anchor="%s" fill="#666">%s</text>`, x, chartH-3, anchor, axisLabel(z))
+    }
+    if t.Z != nil {
+        x := markerX(*t.Z) * chartW
+        fmt.Fprintf(&b, `<line x1="%.1f" y1="4" x2="%.1f" y2="%.1f" stroke="#c0392b" stroke-width="2"/>`, x, x, base)
+        fmt.Fprintf(&b, `<text x="%.1f" y="12" font-size="10" text-anchor="%s" fill="#c0392b">you</text>`, x+4, "start")
+    }
+    b.WriteString(`</svg>`)
+    return template.HTML(b.String())
+}
+
+const htmlSrc = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>easy-pgs report – kit {{.KitID}}</title>
+<style>
+  body { font-family: Georgia, serif; color: #222; max-width: 820px; margin: 2em auto; padding: 0 1em; }
+  h1 { font-size: 1.6em; margin-bottom: 0.2em; }
+  h2 { font-size: 1.2em; border-bottom: 1px solid #ccc; padding-bottom: 0.2em; margin-top: 1.6em; }
+  h3 { font-size: 1.05em; margin: 0 0 0.4em; }
+  .muted { color: #666; font-size: 0.9em; }
+  .disclaimer { background: #fdf6e3; border: 1px solid #e8d9a8; padding: 0.7em 1em; font-size: 0.9em; }
+  .trait { border: 1px solid #ddd; border-radius: 6px; padding: 1em; margin: 1em 0; page-break-inside: avoid; }
+  .figures { display: flex; gap: 1.5em; flex-wrap: wrap; margin: 0.5em 0; }
+  .figures div { min-width: 6em; }
+  .figures strong { display: block; font-size: 1.3em; }
+  table { border-collapse: collapse; font-size: 0.88em; margin: 0.4em 0; }
+  td, th { border: 1px solid #ddd; padding: 0.25em 0.6em; text-align: left; vertical-align: top; }
+  ul.caveats { font-size: 0.88em; color: #8a4b08; margin: 0.4em 0; }
+  code { font-size: 0.85em; }
+  @media print { body { margin: 0; } .trait { border-color: #bbb; } }
+</style>
+</head>
+<body>
+<h1>Polygenic score report</h1>
+<p class="muted">Kit {{.KitID}} ({{.KitType}}) · generated {{date .Generated}}{{with .AncestryLabel}} · inferred ancestry {{.}}{{end}}</p>
+<p class="disclaimer">{{disclaimer}}</p>
+
+<h2>Results</h2>
+{{range .Traits}}
+<section class="trait">
+  <h3>{{if .Trait}}{{.Trait}}{{else}}{{.PGSID}}{{end}} <span class="muted">{{.PGSID}}</span></h3>
+  {{if .Citation}}<p class="muted">{{.Citation}}</p>{{end}}
+  <div class="figures">
+    <div>Percentile<strong>{{ordinal .Percentile}}</strong></div>
+    <div>z-score<strong>{{num .Z 2}}</strong></div>
+    <div>Score<strong>{{printf "%.4g" .Score}}</strong></div>
+    <div>Reference mean ± SD<strong>{{num .PopMean 4}} ± {{num .PopSD 4}}</strong></div>
+    <div>Coverage<strong>{{with .Coverage}}{{num . 1}}%{{else}}–{{end}}</strong></div>
+  </div>
+  {{chart .}}
+  <p class="muted">Variants in score: {{if .Variants}}{{.Variants}}{{else}}unknown{{end}}{{if .Ancestry}} · developed in: {{.Ancestry}}{{end}}</p>
+  {{if .Metrics}}
+  <table>
+    <tr><th>Metric</th><th>Value</th><th>Evaluation sample</th></tr>
+    {{range .Metrics}}<tr><td>{{.Name}}</td><td>{{.Value}}</td><td>{{.SampleSet}}</td></tr>{{end}}
+  </table>
+  {{else}}<p class="muted">No published performance metrics.</p>{{end}}
+  {{if .Caveats}}<ul class="caveats">{{range .Caveats}}<li>{{.}}</li>{{end}}</ul>{{end}}
+</section>
+{{else}}
+<p>No scored traits.</p>
+{{end}}
+
+<h2>Kit quality</h2>
+{{with .QC}}
+<table>
+  <tr><th>Variants genotyped</th><td>{{.Variants}}{{if .ManifestVariants}} of {{.ManifestVariants}} on the chip manifest ({{pct .ManifestRate}}){{end}}</td></tr>
+  <tr><th>Call rate</th><td>{{pct .CallRate}}</td></tr>
+  <tr><th>Autosomal heterozygosity</th><td>{{pct .Heterozygosity}}</td></tr>
+  <tr><th>Chromosome X heterozygosity</th><td>{{pct .ChrXHet}}{{if .InferredSex}} (consistent with {{.InferredSex}}){{end}}</td></tr>
+</table>
+{{else}}
+<p class="muted">Kit files unavailable; no quality summary.</p>
+{{end}}
+
+<h2>Provenance</h2>
+<table>
+  <tr><th>Run</th><td>{{with .Provenance.RunID}}<code>{{.}}</code>{{else}}–{{end}}{{if not .Provenance.Created.IsZero}} · {{date .Provenance.Created}}{{end}}</td></tr>
+  <tr><th>PGS Catalog metadata</th><td><code>{{or .Provenance.CatalogVersion "unknown"}}</code></td></tr>
+  <tr><th>Scoring engine</th><td>{{or .Provenance.Inputs.Engine "unknown"}}</td></tr>
+  <tr><th>Code version</th><td><code>{{or .Provenance.Inputs.CodeVersion "unknown"}}</code></td></tr>
+  <tr><th>Reference panel</th><td>1000 Genomes phase 3 (GRCh37){{with .Provenance.Inputs.Panel}} · <code>{{.}}</code>{{end}}</td></tr>
+  <tr><th>Chip manifest</th><td>{{with .Provenance.Inputs.Manifest}}<code>{{.}}</code>{{else}}–{{end}}</td></tr>
+  {{range $id, $sum := .Provenance.Inputs.ScoreFiles}}<tr><th>{{$id}} scoring file</th><td><code>sha256 {{$sum}}</code></td></tr>{{end}}
+</table>
+</body>
+</html>
+`
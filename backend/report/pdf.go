@@ -0,0 +1,271 @@
+// backend/report/pdf.go
+package report
+
+import (
+    "fmt"
+    "io"
+    "sort"
+
+    "github.com/go-pdf/fpdf"
+)
+
+// page geometry in mm (A4 portrait)
+const (
+    pdfMargin     = 15.0
+    pdfLine       = 5.0
+    pdfChartW     = 120.0
+    pdfChartH     = 30.0
+    pdfTraitSpace = 95.0 // keep a trait's heading, figures and chart on one page
+)
+
+// WritePDF renders r as an A4 PDF.
+func WritePDF(w io.Writer, r *Report) error {
+    pdf := fpdf.New("P", "mm", "A4", "")
+    pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+    pdf.SetAutoPageBreak(true, pdfMargin)
+    pdf.SetTitle("easy-pgs report – kit "+r.KitID, true)
+    pdf.SetCreator("easy-pgs", false)
+    tr := pdf.UnicodeTranslatorFromDescriptor("") // cp1252, for "–", "±", "·"
+    pdf.SetFooterFunc(func() {
+        pdf.SetY(-10)
+        pdf.SetFont("Helvetica", "", 8)
+        pdf.SetTextColor(120, 120, 120)
+        pdf.CellFormat(0, 4, fmt.Sprintf("easy-pgs · kit %s · page %d", r.KitID, pdf.PageNo()), "", 0, "C", false, 0, "")
+    })
+    pdf.AddPage()
+    pageW, pageH := pdf.GetPageSize()
+    bodyW := pageW - 2*pdfMargin
+
+    heading := func(txt string) {
+        pdf.Ln(3)
+        pdf.SetFont("Helvetica", "B", 13)
+        pdf.SetTextColor(34, 34, 34)
+        pdf.CellFormat(0, 8, tr(txt), "B", 1, "", false, 0, "")
+        pdf.Ln(2)
+    }
+    muted := func(txt string) {
+        pdf.SetFont("Helvetica", "", 9)
+        pdf.SetTextColor(110, 110, 110)
+        pdf.MultiCell(0, 4.5, tr(txt), "", "", false)
+    }
+    row := func(label, value string) {
+        pdf.SetFont("Helvetica", "B", 9)
+        pdf.SetTextColor(34, 34, 34)
+        pdf.CellFormat(55, 6, tr(label), "1", 0, "", false, 0, "")
+        pdf.SetFont("Helvetica", "", 9)
+        pdf.CellFormat(bodyW-55, 6, tr(value), "1", 1, "", false, 0, "")
+    }
+
+    // title
+    pdf.SetFont("Helvetica", "B", 18)
+    pdf.SetTextColor(34, 34, 34)
+    pdf.CellFormat(0, 10, "Polygenic score report", "", 1, "", false, 0, "")
+    header := fmt.Sprintf("Kit %s (%s) · generated %s", r.KitID, r.KitType, r.Generated.Format("2006-01-02 15:04 MST"))
+    if a := r.AncestryLabel(); a != "" {
+        header += " · inferred ancestry " + a
+    }
+    muted(header)
+    pdf.Ln(2)
+    pdf.SetFillColor(253, 246, 227)
+    pdf.SetFont("Helvetica", "", 9)
+    pdf.SetTextColor(34, 34, 34)
+    pdf.MultiCell(0, 4.5, tr(Disclaimer), "1", "", true)
+
+    heading("Results")
+    if len(r.Traits) == 0 {
+        muted("No scored traits.")
+    }
+    for _, t := range r.Traits {
+        if pdf.GetY()+pdfTraitSpace > pageH-pdfMargin {
+            pdf.AddPage()
+        }
+        title := t.Trait
+        if title == "" {
+            title = t.PGSID
+        }
+        pdf.SetFont("Helvetica", "B", 11)
+        pdf.SetTextColor(34, 34, 34)
+        pdf.CellFormat(0, 6, tr(title+"  ("+t.PGSID+")"), "", 1, "", false, 0, "")
+        if t.Citation != "" {
+            muted(t.Citation)
+        }
+        pdf.Ln(1)
+
+        // figures
+        coverage := "–"
+        if t.Coverage != nil {
+            coverage = num(t.Coverage, 1) + "%"
+        }
+        figures := [][2]string{
+            {"Percentile", ordinal(t.Percentile)},
+            {"z-score", num(t.Z, 2)},
+            {"Score", fmt.Sprintf("%.4g", t.Score)},
+            {"Reference mean ± SD", num(t.PopMean, 4) + " ± " + num(t.PopSD, 4)},
+            {"Coverage", coverage},
+        }
+        widths := []float64{26, 24, 30, 62, 26}
+        x0, y0 := pdf.GetXY()
+        for i, f := range figures {
+            pdf.SetXY(x0, y0)
+            pdf.SetFont("Helvetica", "", 8)
+            pdf.SetTextColor(110, 110, 110)
+            pdf.CellFormat(widths[i], 4, tr(f[0]), "", 2, "", false, 0, "")
+            pdf.SetFont("Helvetica", "B", 12)
+            pdf.SetTextColor(34, 34, 34)
+            pdf.CellFormat(widths[i], 6, tr(f[1]), "", 0, "", false, 0, "")
+            x0 += widths[i]
+        }
+        pdf.SetXY(pdfMargin, y0+12)
+
+        drawChart(pdf, tr, t, pdfMargin, pdf.GetY())
+        pdf.SetY(pdf.GetY() + pdfChartH + 8)
+
+        meta := "Variants in score: unknown"
+        if t.Variants != "" {
+            meta = "Variants in score: " + t.Variants
+        }
+        if t.Ancestry != "" {
+            meta += " · developed in: " + t.Ancestry
+        }
+        muted(meta)
+
+        if len(t.Metrics) > 0 {
+            pdf.Ln(1)
+            pdf.SetFont("Helvetica", "B", 8)
+            pdf.SetTextColor(34, 34, 34)
+            pdf.CellFormat(30, 5, "Metric", "1", 0, "", false, 0, "")
+            pdf.CellFormat(70, 5, "Value", "1", 0, "", false, 0, "")
+            pdf.CellFormat(bodyW-100, 5, "Evaluation sample", "1", 1, "", false, 0, "")
+            pdf.SetFont("Helvetica", "", 8)
+            for _, m := range t.Metrics {
+                pdf.CellFormat(30, 5, tr(m.Name), "1", 0, "", false, 0, "")
+                pdf.CellFormat(70, 5, tr(clip(m.Value, 48)), "1", 0, "", false, 0, "")
+                pdf.CellFormat(bodyW-100, 5, tr(clip(m.SampleSet, 50)), "1", 1, "", false, 0, "")
+            }
+        } else {
+            muted("No published performance metrics.")
+        }
+        if len(t.Caveats) > 0 {
+            pdf.Ln(1)
+            pdf.SetFont("Helvetica", "", 8.5)
+            pdf.SetTextColor(138, 75, 8)
+            for _, c := range t.Caveats {
+                pdf.MultiCell(0, 4.2, tr("• "+c), "", "", false)
+            }
+        }
+        pdf.Ln(5)
+    }
+
+    heading("Kit quality")
+    if q := r.QC; q != nil {
+        genotyped := fmt.Sprint(q.Variants)
+        if q.ManifestVariants > 0 {
+            genotyped += fmt.Sprintf(" of %d on the chip manifest (%.1f%%)", q.ManifestVariants, q.ManifestRate*100)
+        }
+        xHet := fmt.Sprintf("%.1f%%", q.ChrXHet*100)
+        if q.InferredSex != "" {
+            xHet += " (consistent with " + q.InferredSex + ")"
+        }
+        row("Variants genotyped", genotyped)
+        row("Call rate", fmt.Sprintf("%.1f%%", q.CallRate*100))
+        row("Autosomal heterozygosity", fmt.Sprintf("%.1f%%", q.Heterozygosity*100))
+        row("Chromosome X heterozygosity", xHet)
+    } else {
+        muted("Kit files unavailable; no quality summary.")
+    }
+
+    heading("Provenance")
+    p := r.Provenance
+    run := or(p.RunID, "–")
+    if !p.Created.IsZero() {
+        run += " · " + p.Created.Format("2006-01-02 15:04 MST")
+    }
+    row("Run", run)
+    row("PGS Catalog metadata", or(p.CatalogVersion, "unknown"))
+    row("Scoring engine", or(p.Inputs.Engine, "unknown"))
+    row("Code version", or(p.Inputs.CodeVersion, "unknown"))
+    panel := "1000 Genomes phase 3 (GRCh37)"
+    if p.Inputs.Panel != "" {
+        panel += " · " + p.Inputs.Panel
+    }
+    row("Reference panel", panel)
+    row("Chip manifest", or(p.Inputs.Manifest, "–"))
+    ids := make([]string, 0, len(p.Inputs.ScoreFiles))
+    for id := range p.Inputs.ScoreFiles {
+        ids = append(ids, id)
+    }
+    sort.Strings(ids)
+    for _, id := range ids {
+        row(id+" scoring file", "sha256 "+clip(p.Inputs.ScoreFiles[id], 32))
+    }
+
+    return pdf.Output(w)
+}
+
+// drawChart draws the reference distribution of t at (x, y), mirroring chartSVG.
+func drawChart(pdf *fpdf.Fpdf, tr func(string) string, t Trait, x, y float64) {
+    pts := distPoints(t)
+    base := y + pdfChartH - 6
+    at := func(p [2]float64) fpdf.PointType {
+        return fpdf.PointType{X: x + p[0]*pdfChartW, Y: base - p[1]*(pdfChartH-8)}
+    }
+
+    if t.Z != nil {
+        mx := markerX(*t.Z)
+        area := []fpdf.PointType{{X: x, Y: base}}
+        for _, p := range areaPoints(pts, mx) {
+            area = append(area, at(p))
+        }
+        area = append(area, fpdf.PointType{X: x + mx*pdfChartW, Y: base})
+        pdf.SetFillColor(199, 215, 242)
+        pdf.SetDrawColor(199, 215, 242)
+        pdf.Polygon(area, "F")
+    }
+
+    pdf.SetDrawColor(53, 80, 122)
+    pdf.SetLineWidth(0.4)
+    for i := 1; i < len(pts); i++ {
+        a, b := at(pts[i-1]), at(pts[i])
+        pdf.Line(a.X, a.Y, b.X, b.Y)
+    }
+    pdf.SetDrawColor(153, 153, 153)
+    pdf.SetLineWidth(0.2)
+    pdf.Line(x, base, x+pdfChartW, base)
+
+    pdf.SetFont("Helvetica", "", 7)
+    pdf.SetTextColor(110, 110, 110)
+    for z := -zRange; z <= zRange; z += 2 {
+        lx := x + markerX(z)*pdfChartW
+        pdf.SetXY(lx-10, base+1)
+        pdf.CellFormat(20, 4, axisLabel(z), "", 0, "C", false, 0, "")
+    }
+
+    if t.Z != nil {
+        mx := x + markerX(*t.Z)*pdfChartW
+        pdf.SetDrawColor(192, 57, 43)
+        pdf.SetLineWidth(0.6)
+        pdf.Line(mx, y+1, mx, base)
+        pdf.SetTextColor(192, 57, 43)
+        pdf.SetXY(mx+1, y)
+        pdf.CellFormat(10, 4, tr("you"), "", 0, "", false, 0, "")
+    }
+    pdf.SetLineWidth(0.2)
+    pdf.SetDrawColor(0, 0, 0)
+}
+
+// clip shortens s to at most n runes, marking the cut with "…".
+func clip(s string, n int) string {
+    r := []rune(s)
+    if len(r) <= n {
+        return s
+    }
+    return string(r[:n-1]) + "…"
+}
+
+// or returns s, or def when s is empty.
+func or(s, def string) string {
+    if s == "" {
+        return def
+    }
+    return s
+}
@@ -0,0 +1,186 @@
+// backend/report/report.go
+// Package report renders a kit's scoring results as a printable report: a
+// self-contained HTML page with inline SVG charts, or a PDF. It only renders;
+// callers assemble the Report from the result store and catalog metadata.
+package report
+
+import (
+    "fmt"
+    "math"
+    "strconv"
+    "time"
+
+    "github.com/adamwestgate/easy-pgs/backend/pipeline"
+    "github.com/adamwestgate/easy-pgs/backend/store"
+)
+
+// Report is everything shown in one kit's report.
+type Report struct {
+    KitID      string
+    KitType    string
+    Generated  time.Time
+    QC         *pipeline.KitQC // nil when the kit files could not be read
+    Ancestry   *store.Ancestry // nil when it could not be inferred
+    Traits     []Trait
+    Provenance Provenance
+}
+
+// Trait is one scored PGS.
+type Trait struct {
+    PGSID      string
+    Trait      string
+    Terms      []Term // ontology terms the catalog maps the score's trait to
+    Citation   string
+    Variants   string // variants in the published score
+    Ancestry   string // ancestry of the GWAS the score was developed on
+    Score      float64
+    PopMean    *float64
+    PopSD      *float64
+    PopHist    []int    // reference scores binned by z, see store.ScoreStats.Hist; nil when not recorded
+    Z          *float64
+    Percentile *float64 // 0–100
+    Coverage   *float64 // % of score variants scored
+    Unscored   *float64 // unscored variants' share of the score's expected variance, 0–1
+    Group      string   // comparison group matching the kit's inferred ancestry, e.g. "EUR"
+    GroupN     int      // reference samples in Group
+    GroupPct   *float64 // empirical percentile among Group, 0–100
+    PctAdj     *float64 // percentile after the ancestry (PC) adjustment, 0–100
+    Metrics    []Metric
+    Caveats    []string
+}
+
+// AncestryLabel describes the kit's inferred ancestry, e.g. "EUR (97%)",
+// or "" when unknown.
+func (r *Report) AncestryLabel() string {
+    if r.Ancestry == nil || r.Ancestry.SuperPop == "" {
+        return ""
+    }
+    return fmt.Sprintf("%s (%.0f%%)", r.Ancestry.SuperPop, 100*r.Ancestry.Probabilities[r.Ancestry.SuperPop])
+}
+
+// Term is an ontology term such as EFO_0000305 with its label.
+type Term struct {
+    ID    string
+    Label string
+}
+
+// Metric is one published performance metric of a score.
+type Metric struct {
+    Name      string // e.g. "AUROC"
+    Value     string
+    SampleSet string // PGS Catalog sample set (PSS) it was evaluated in
+}
+
+// Provenance records what produced the results.
+type Provenance struct {
+    RunID          string
+    Created        time.Time
+    CatalogVersion string
+    Inputs         store.RunInputs
+}
+
+// zRange is the span of the distribution charts, in SDs either side of the
+// mean; the same as the recorded histograms'.
+const zRange = store.HistRange
+
+// distPoints outlines the reference distribution of t as (x, y) pairs in the
+// unit square, x left to right and y scaled so the peak is 1: the histogram
+// of the reference panel's scores where the run recorded one, otherwise the
+// standard normal density.
+func distPoints(t Trait) [][2]float64 {
+    peak := 0
+    for _, c := range t.PopHist {
+        if c > peak {
+            peak = c
+        }
+    }
+    if peak == 0 {
+        return curvePoints(81)
+    }
+    n := float64(len(t.PopHist))
+    pts := make([][2]float64, 0, 2*len(t.PopHist)+2)
+    pts = append(pts, [2]float64{0, 0})
+    for i, c := range t.PopHist {
+        y := float64(c) / float64(peak)
+        pts = append(pts, [2]float64{float64(i) / n, y}, [2]float64{float64(i+1) / n, y})
+    }
+    return append(pts, [2]float64{1, 0})
+}
+
+// curvePoints samples the standard normal density across ±zRange as (x, y)
+// pairs in the unit square: x left to right, y scaled so the peak is 1.
+func curvePoints(n int) [][2]float64 {
+    pts := make([][2]float64, n)
+    for i := range pts {
+        x := float64(i) / float64(n-1)
+        z := (x*2 - 1) * zRange
+        pts[i] = [2]float64{x, math.Exp(-z * z / 2)}
+    }
+    return pts
+}
+
+// areaPoints returns the points of pts left of x = mx, followed by the
+// outline's height at mx, for shading the area below the kit's score.
+func areaPoints(pts [][2]float64, mx float64) [][2]float64 {
+    var out [][2]float64
+    for i, p := range pts {
+        if p[0] <= mx {
+            out = append(out, p)
+            continue
+        }
+        if i > 0 {
+            q := pts[i-1]
+            y := q[1] + (p[1]-q[1])*(mx-q[0])/(p[0]-q[0])
+            out = append(out, [2]float64{mx, y})
+        }
+        break
+    }
+    return out
+}
+
+// markerX places z on the chart's unit x axis, clamped to the chart.
+func markerX(z float64) float64 {
+    return math.Max(0, math.Min(1, (z/zRange+1)/2))
+}
+
+// axisLabel labels a chart tick at z SDs from the reference mean.
+func axisLabel(z float64) string {
+    if z == 0 {
+        return "mean"
+    }
+    return strconv.FormatFloat(z, 'f', 0, 64) + " SD"
+}
+
+// num formats an optional value with the given number of decimals, or "–".
+func num(v *float64, decimals int) string {
+    if v == nil {
+        return "–"
+    }
+    return strconv.FormatFloat(*v, 'f', decimals, 64)
+}
+
+// ordinal formats an optional 0–100 percentile as "73rd", or "–".
+func ordinal(pct *float64) string {
+    if pct == nil {
+        return "–"
+    }
+    n := int(math.Round(*pct))
+    suffix := "th"
+    if n%100 < 11 || n%100 > 13 {
+        switch n % 10 {
+        case 1:
+            suffix = "st"
+        case 2:
+            suffix = "nd"
+        case 3:
+            suffix = "rd"
+        }
+    }
+    return strconv.Itoa(n) + suffix
+}
+
+// Disclaimer is printed at the top of every report.
+const Disclaimer = "Polygenic scores estimate relative genetic predisposition from common variants. " +
+    "They are not a diagnosis, do not account for family history, lifestyle or rare variants, " +
+    "and were computed from a consumer genotyping chip rather than clinical sequencing. " +
+    "Discuss any result with a qualified health professional."
@@ -0,0 +1,55 @@
+package report
+
+import (
+    "math"
+    "reflect"
+    "testing"
+)
+
+func TestDistPoints(t *testing.T) {
+    // without a recorded histogram the chart falls back to the normal curve
+    if got := distPoints(Trait{}); !reflect.DeepEqual(got, curvePoints(81)) {
+        t.Error("no histogram: not the normal curve")
+    }
+    if got := distPoints(Trait{PopHist: []int{0, 0}}); !reflect.DeepEqual(got, curvePoints(81)) {
+        t.Error("empty histogram: not the normal curve")
+    }
+
+    got := distPoints(Trait{PopHist: []int{1, 4, 2, 0}})
+    want := [][2]float64{
+        {0, 0},
+        {0, 0.25}, {0.25, 0.25},
+        {0.25, 1}, {0.5, 1},
+        {0.5, 0.5}, {0.75, 0.5},
+        {0.75, 0}, {1, 0},
+        {1, 0},
+    }
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("distPoints = %v, want %v", got, want)
+    }
+}
+
+func TestAreaPoints(t *testing.T) {
+    pts := distPoints(Trait{PopHist: []int{1, 4, 2, 0}})
+    for _, tc := range []struct {
+        mx   float64
+        want [][2]float64
+    }{
+        {0.375, [][2]float64{{0, 0}, {0, 0.25}, {0.25, 0.25}, {0.25, 1}, {0.375, 1}}},
+        {0.5, [][2]float64{{0, 0}, {0, 0.25}, {0.25, 0.25}, {0.25, 1}, {0.5, 1}, {0.5, 0.5}, {0.5, 0.5}}},
+    } {
+        got := areaPoints(pts, tc.mx)
+        if !reflect.DeepEqual(got, tc.want) {
+            t.Errorf("areaPoints(%g) = %v, want %v", tc.mx, got, tc.want)
+        }
+    }
+
+    // on the normal curve the shading ends on the curve itself
+    curve := curvePoints(81)
+    area := areaPoints(curve, markerX(0.1))
+    last := area[len(area)-1]
+    z := (last[0]*2 - 1) * zRange
+    if math.Abs(last[0]-markerX(0.1)) > 1e-12 || math.Abs(last[1]-math.Exp(-z*z/2)) > 1e-3 {
+        t.Errorf("shading ends at %v, off the curve", last)
+    }
+}
@@ -0,0 +1,403 @@
+// backend/server/handlers/compare_handler.go
+package handlers
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+
+    "github.com/adamwestgate/easy-pgs/backend/config"
+    "github.com/adamwestgate/easy-pgs/backend/pipeline"
+    "github.com/adamwestgate/easy-pgs/backend/preprocessing/scoring"
+    "github.com/adamwestgate/easy-pgs/backend/store"
+)
+
+// CompareRequest is the payload of POST /compare.
+type CompareRequest struct {
+    KitIDs []string `json:"kitIds"`
+    PgsIds []string `json:"pgsIds"`
+}
+
+// CompareKit is one kit's column of the comparison.
+type CompareKit struct {
+    KitID    string             `json:"kitId"`
+    KitType  string             `json:"kitType"`
+    Coverage map[string]float64 `json:"coverage"` // % of score variants this kit could score on its own
+}
+
+// CompareResponse holds the comparison matrices. Score, Z and Pct are
+// indexed [pgs][kit] following PgsIds and KitIDs; null where unavailable.
+type CompareResponse struct {
+    KitIDs         []string           `json:"kitIds"`
+    PgsIds         []string           `json:"pgsIds"`
+    Trait          map[string]string  `json:"trait"`
+    Variants       map[string]int     `json:"variants"`        // shared variants scored per PGS
+    SharedCoverage map[string]float64 `json:"shared_coverage"` // shared variants as % of the score's variants
+    Score          [][]*float64       `json:"score"`
+    Z              [][]*float64       `json:"z"`
+    Pct            [][]*float64       `json:"pct"`
+    Kits           []CompareKit       `json:"kits"`
+    Errors         map[string]string  `json:"errors,omitempty"`
+}
+
+// compareKit is a resolved kit of a comparison.
+type compareKit struct {
+    id, prefix, kitType string
+}
+
+// maxPanelPasses bounds the panel/intersection refinement loop; panels
+// normally contain every chip variant and settle on the first pass.
+const maxPanelPasses = 3
+
+// CompareHandler handles POST /compare with {kitIds, pgsIds}.
+//
+// Every kit is scored on the same variant set per PGS: the variants all
+// kits can score, further restricted to those present in each kit's
+// reference panel. Values are therefore directly comparable across kits.
+// The work runs as a job on the scoring workers; the handler waits for it,
+// cancels it if the client goes away, and answers 503 when the queue is full.
+func CompareHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusOK)
+        return
+    }
+
+    var req CompareRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "invalid JSON payload", http.StatusBadRequest)
+        return
+    }
+    if len(req.KitIDs) < 2 {
+        http.Error(w, "at least two kitIds are required", http.StatusBadRequest)
+        return
+    }
+    if len(req.PgsIds) == 0 {
+        http.Error(w, "no pgsIds provided", http.StatusBadRequest)
+        return
+    }
+    kits := make([]compareKit, 0, len(req.KitIDs))
+    for _, id := range req.KitIDs {
+        prefix, kitType, ok := kitStore.Lookup(id)
+        if !ok {
+            http.Error(w, "invalid kit_id: "+id, http.StatusBadRequest)
+            return
+        }
+        kits = append(kits, compareKit{id: id, prefix: prefix, kitType: kitType})
+    }
+
+    var resp *CompareResponse
+    job, err := submitTask(req.KitIDs, req.PgsIds, func(job *Job) {
+        defer close(job.done)
+        defer job.cancel()
+        if job.stopIfCancelled() {
+            return
+        }
+        job.setStage(StageComparing)
+        var err error
+        resp, err = runComparison(job.ctx, kits, req.PgsIds)
+        switch {
+        case job.stopIfCancelled():
+        case err != nil:
+            job.fail(err)
+        default:
+            for _, id := range req.PgsIds {
+                if msg, failed := resp.Errors[id]; failed {
+                    job.setPGS(id, StageFailed, errors.New(msg))
+                } else {
+                    job.setPGS(id, StageReady, nil)
+                }
+            }
+            job.setStage(StageReady)
+        }
+    })
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusServiceUnavailable)
+        return
+    }
+    select {
+    case <-job.done:
+    case <-r.Context().Done():
+        job.Cancel()
+        return
+    }
+
+    if st := job.snapshot(); st.Stage != StageReady {
+        http.Error(w, "comparison error: "+st.Error, http.StatusInternalServerError)
+        return
+    }
+    writeJSON(w, resp)
+}
+
+// runComparison runs compareKits in a fresh scratch directory under
+// config.CompareWorkDir, removed afterwards.
+func runComparison(ctx context.Context, kits []compareKit, pgsIDs []string) (*CompareResponse, error) {
+    if err := os.MkdirAll(config.CompareWorkDir, 0o755); err != nil {
+        return nil, err
+    }
+    ws, err := os.MkdirTemp(config.CompareWorkDir, "compare-")
+    if err != nil {
+        return nil, err
+    }
+    defer os.RemoveAll(ws)
+    return compareKits(ctx, ws, kits, pgsIDs)
+}
+
+// compareKits runs the comparison inside the scratch directory ws.
+func compareKits(ctx context.Context, ws string, kits []compareKit, pgsIDs []string) (*CompareResponse, error) {
+    resp := &CompareResponse{
+        PgsIds:         pgsIDs,
+        Trait:          map[string]string{},
+        Variants:       map[string]int{},
+        SharedCoverage: map[string]float64{},
+        Errors:         map[string]string{},
+    }
+    for _, k := range kits {
+        resp.KitIDs = append(resp.KitIDs, k.id)
+    }
+
+    // 1) score files
+    norms := map[string]string{}
+    rows := map[string]int{}
+    for _, id := range pgsIDs {
+        resp.Trait[id] = pipeline.TraitLabel(id)
+        norm, err := pipeline.EnsureScoreFile(ctx, id, nil)
+        if err != nil {
+            resp.Errors[id] = err.Error()
+            continue
+        }
+        norms[id] = norm
+        rows[id] = pipeline.CountDataLines(norm)
+    }
+
+    // 2) each kit on its own: which variants can it score?
+    shared := map[string]map[string]bool{}
+    for i, k := range kits {
+        ck := CompareKit{KitID: k.id, KitType: k.kitType, Coverage: map[string]float64{}}
+        outDir := filepath.Join(ws, fmt.Sprintf("kit%d-pass1", i))
+        res := scoreInto(ctx, k.prefix, k.kitType, norms, outDir, nil, config.NativeUserScoring)
+        for id := range norms {
+            if resp.Errors[id] != "" {
+                continue
+            }
+            br := res[id]
+            if br.Err != nil {
+                resp.Errors[id] = fmt.Sprintf("kit %s: %v", k.id, br.Err)
+                continue
+            }
+            vars, err := pipeline.ReadIDList(br.ScorePath + ".vars")
+            if err != nil {
+                resp.Errors[id] = fmt.Sprintf("kit %s: %v", k.id, err)
+                continue
+            }
+            if rows[id] > 0 {
+                ck.Coverage[id] = float64(len(vars)) / float64(rows[id]) * 100
+            }
+            if i == 0 {
+                shared[id] = vars
+            } else {
+                intersect(shared[id], vars)
+            }
+        }
+        resp.Kits = append(resp.Kits, ck)
+    }
+    if err := ctx.Err(); err != nil {
+        return nil, err
+    }
+
+    // 3) reference panels on the shared set, shrinking it until every panel has it all
+    panels := map[string]string{} // panel dir → a kit type using it
+    for _, k := range kits {
+        panels[pipeline.PanelDir(k.kitType)] = k.kitType
+    }
+    popStats := map[string]map[string]store.ScoreStats{} // panel dir → PGS → stats
+    var unsettled map[string]bool                        // PGS whose set shrank in the last pass
+    for pass := 0; pass < maxPanelPasses; pass++ {
+        extract, err := writeExtracts(filepath.Join(ws, fmt.Sprintf("extract%d", pass)), shared, resp.Errors)
+        if err != nil {
+            return nil, err
+        }
+        unsettled = map[string]bool{}
+        for dir, kitType := range panels {
+            outDir := filepath.Join(ws, fmt.Sprintf("panel-%s-%d", filepath.Base(dir), pass))
+            res := scoreInto(ctx, dir, kitType, pick(norms, extract), outDir, extract, config.NativePopulationScoring)
+            popStats[dir] = map[string]store.ScoreStats{}
+            for id := range extract {
+                br := res[id]
+                if br.Err != nil {
+                    resp.Errors[id] = fmt.Sprintf("reference panel: %v", br.Err)
+                    continue
+                }
+                vars, err := pipeline.ReadIDList(br.ScorePath + ".vars")
+                if err != nil {
+                    resp.Errors[id] = fmt.Sprintf("reference panel: %v", err)
+                    continue
+                }
+                if len(vars) < len(shared[id]) {
+                    intersect(shared[id], vars)
+                    unsettled[id] = true
+                }
+                if st, err := pipeline.ParseSscoreStats(br.ScorePath); err == nil {
+                    popStats[dir][id] = st
+                }
+            }
+        }
+        if err := ctx.Err(); err != nil {
+            return nil, err
+        }
+        if len(unsettled) == 0 {
+            break
+        }
+    }
+    // panel stats of a set that was still shrinking do not describe the
+    // final one, so those scores are not compared
+    for id := range unsettled {
+        resp.Errors[id] = fmt.Sprintf("reference panels did not settle on a shared variant set in %d passes", maxPanelPasses)
+    }
+
+    // 4) every kit on the final shared set
+    extract, err := writeExtracts(filepath.Join(ws, "extract-final"), shared, resp.Errors)
+    if err != nil {
+        return nil, err
+    }
+    for id := range extract {
+        resp.Variants[id] = len(shared[id])
+        if rows[id] > 0 {
+            resp.SharedCoverage[id] = float64(len(shared[id])) / float64(rows[id]) * 100
+        }
+    }
+    resp.Score = matrix(len(pgsIDs), len(kits))
+    resp.Z = matrix(len(pgsIDs), len(kits))
+    resp.Pct = matrix(len(pgsIDs), len(kits))
+    for j, k := range kits {
+        outDir := filepath.Join(ws, fmt.Sprintf("kit%d-final", j))
+        res := scoreInto(ctx, k.prefix, k.kitType, pick(norms, extract), outDir, extract, config.NativeUserScoring)
+        pop := popStats[pipeline.PanelDir(k.kitType)]
+        for i, id := range pgsIDs {
+            if _, ok := extract[id]; !ok || resp.Errors[id] != "" {
+                continue
+            }
+            br := res[id]
+            if br.Err != nil {
+                resp.Errors[id] = fmt.Sprintf("kit %s: %v", k.id, br.Err)
+                continue
+            }
+            st, err := pipeline.ParseSscoreStats(br.ScorePath)
+            if err != nil {
+                continue
+            }
+            resp.Score[i][j] = finite(st.Mean)
+            if p, ok := pop[id]; ok && p.SD > 0 {
+                z := (st.Mean - p.Mean) / p.SD
+                resp.Z[i][j] = finite(z)
+                resp.Pct[i][j] = finite(pipeline.Percentile(z))
+            }
+        }
+    }
+    if err := ctx.Err(); err != nil {
+        return nil, err
+    }
+    if len(resp.Errors) == 0 {
+        resp.Errors = nil
+    }
+    return resp, nil
+}
+
+// scoreInto scores every norm file against the fileset in dir, writing to
+// outDir, and keys the results by canonical PGS ID.
+func scoreInto(ctx context.Context, dir, kitType string, norms map[string]string, outDir string, extract map[string]string, native bool) map[string]scoring.BatchResult {
+    paths := make([]string, 0, len(norms))
+    for _, p := range norms {
+        paths = append(paths, p)
+    }
+    sort.Strings(paths)
+    if err := os.MkdirAll(outDir, 0o755); err != nil {
+        res := map[string]scoring.BatchResult{}
+        for id := range norms {
+            res[id] = scoring.BatchResult{Err: err}
+        }
+        return res
+    }
+    raw := scoring.BatchScoreOpts(ctx, dir, kitType, paths, "", scoring.Options{
+        Native:  native,
+        OutDir:  outDir,
+        Extract: extract,
+    })
+    res := make(map[string]scoring.BatchResult, len(raw))
+    for k, v := range raw {
+        res[pipeline.CanonicalID(k)] = v
+    }
+    return res
+}
+
+// writeExtracts writes one variant list per PGS with a non-empty shared set
+// and no error into dir, returning PGS ID → path. Empty sets become errors.
+func writeExtracts(dir string, shared map[string]map[string]bool, errs map[string]string) (map[string]string, error) {
+    if err := os.MkdirAll(dir, 0o755); err != nil {
+        return nil, err
+    }
+    out := map[string]string{}
+    for id, set := range shared {
+        if errs[id] != "" {
+            continue
+        }
+        if len(set) == 0 {
+            errs[id] = "no variants shared by all kits"
+            continue
+        }
+        ids := make([]string, 0, len(set))
+        for v := range set {
+            ids = append(ids, v)
+        }
+        sort.Strings(ids)
+        path := filepath.Join(dir, id+".snplist")
+        if err := os.WriteFile(path, []byte(strings.Join(ids, "\n")+"\n"), 0o644); err != nil {
+            return nil, err
+        }
+        out[id] = path
+    }
+    return out, nil
+}
+
+// pick returns the entries of norms whose PGS ID is in keep.
+func pick(norms, keep map[string]string) map[string]string {
+    out := make(map[string]string, len(keep))
+    for id := range keep {
+        if p, ok := norms[id]; ok {
+            out[id] = p
+        }
+    }
+    return out
+}
+
+// intersect removes from a every key missing in b.
+func intersect(a, b map[string]bool) {
+    for k := range a {
+        if !b[k] {
+            delete(a, k)
+        }
+    }
+}
+
+// matrix allocates an n×m matrix of nulls.
+func matrix(n, m int) [][]*float64 {
+    out := make([][]*float64, n)
+    for i := range out {
+        out[i] = make([]*float64, m)
+    }
+    return out
+}
+
+// finite returns &v, or nil for NaN/Inf (which JSON cannot encode).
+func finite(v float64) *float64 {
+    if math.IsNaN(v) || math.IsInf(v, 0) {
+        return nil
+    }
+    return &v
+}
@@ -2,25 +2,18 @@
 package handlers
 
 import (
-	"compress/gzip"
 	"encoding/json"
-	"io"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
-	"strings"
 
-	"github.com/adamwestgate/easy-pgs/backend/data"
-	"github.com/adamwestgate/easy-pgs/backend/preprocessing/pgs_convert"
 	"github.com/adamwestgate/easy-pgs/backend/preprocessing/scoring"
-	"github.com/adamwestgate/easy-pgs/backend/config"
 )
 
 // DownloadRequest is the JSON payload shape the frontend sends.
 type DownloadRequest struct {
-	KitID  string   `json:"kitId"`
-	PgsIds []string `json:"pgsIds"`
+	KitID      string   `json:"kitId"`
+	PgsIds     []string `json:"pgsIds"`
+	Imputation string   `json:"imputation,omitempty"` // "reference" (default), "none" or "ancestry:<SUPERPOP>"
 }
 
 // DownloadResponse contains scoring results for each PGS ID.
@@ -30,10 +23,10 @@ type DownloadResponse struct {
 }
 
 // DownloadHandler downloads and formats PGS files from pgs-catalog.org
-// When PGS files are downloaded it runs scoring on them via results_handler
+// and scores the kit against them. It runs the same pipeline as POST /jobs
+// but blocks until the job finishes and returns the results directly.
 func DownloadHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("DownloadHandler: called")
-	SetStatus("downloading")
 
 	// CORS preflight
 	if r.Method == http.MethodOptions {
@@ -47,128 +40,37 @@ func DownloadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Decode request
-	var req DownloadRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("DownloadHandler: JSON decode error: %v", err)
-		http.Error(w, "invalid JSON payload", http.StatusBadRequest)
+	// Decode and validate request
+	req, imp, ok := decodeScoringRequest(w, r)
+	if !ok {
 		return
 	}
 
-	// Validate payload
-	if req.KitID == "" {
-		http.Error(w, "kitId is required", http.StatusBadRequest)
+	// Run as a job so /status and /jobs/{id} report its progress
+	job, err := submitJob(req.KitID, req.PgsIds, imp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		return
 	}
-	if len(req.PgsIds) == 0 {
-		http.Error(w, "no pgsIds provided", http.StatusBadRequest)
+	log.Printf("DownloadHandler: kit %s running as job %s", req.KitID, job.ID)
+	select {
+	case <-job.done:
+	case <-r.Context().Done():
+		// client went away (tab closed): stop the work it was waiting for
+		log.Printf("DownloadHandler: client gone, cancelling job %s", job.ID)
+		job.Cancel()
 		return
 	}
 
-	// Locate processed kit prefix (for logging)
-	userPfilePrefix, _, ok := kitStore.Lookup(req.KitID)
-	if !ok {
-		http.Error(w, "invalid kit_id", http.StatusBadRequest)
-		return
-	}
-	log.Printf("DownloadHandler: Resolved kit prefix: %s", userPfilePrefix)
-
-	// Download and normalize each PGS file
-	normPaths := make([]string, 0, len(req.PgsIds))
-	for _, pgsID := range req.PgsIds {
-		link := findScoreURL(pgsID)
-		if strings.HasPrefix(link, "ftp://") {
-			link = "http://" + strings.TrimPrefix(link, "ftp://")
-		}
-
-		// Use config for download directory
-		dir := filepath.Join(config.PGSDownloadDir, pgsID)
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			log.Printf("DownloadHandler: mkdir %s failed: %v", dir, err)
-			continue
-		}
-
-		// Download if missing
-		fname := filepath.Base(link)
-		gzPath := filepath.Join(dir, fname)
-		if _, err := os.Stat(gzPath); os.IsNotExist(err) {
-			if err := fetchFile(link, gzPath); err != nil {
-				log.Printf("DownloadHandler: fetchFile error for %s: %v", pgsID, err)
-				continue
-			}
-		}
-
-		// Decompress and normalize
-		src, err := os.Open(gzPath)
-		if err != nil {
-			continue
-		}
-		gzReader, err := gzip.NewReader(src)
-		if err != nil {
-			src.Close()
-			continue
-		}
-
-		normPath := filepath.Join(dir, strings.TrimSuffix(fname, ".txt.gz")+".norm.tsv")
-		out, err := os.Create(normPath)
-		if err != nil {
-			gzReader.Close()
-			src.Close()
-			continue
-		}
-
-		if err := pgs_convert.Normalize(gzReader, out, pgs_convert.Options{}); err != nil {
-			gzReader.Close()
-			src.Close()
-			out.Close()
-			continue
-		}
-		gzReader.Close()
-		src.Close()
-		out.Close()
-
-		normPaths = append(normPaths, normPath)
-	}
-
-	// Perform scoring
-	results, err := ScoreKitWithPGS(req.KitID, normPaths)
-	if err != nil {
+	job.mu.Lock()
+	results, jobErr := job.results, job.Error
+	job.mu.Unlock()
+	if results == nil {
+		log.Printf("DownloadHandler: job %s: %s", job.ID, jobErr)
 		http.Error(w, "scoring error", http.StatusInternalServerError)
 		return
 	}
-	storeResults(req.KitID, results)
-	SetStatus("ready")
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(results)
 }
-
-// fetchFile downloads a file via HTTP GET
-func fetchFile(url, dest string) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	f, err := os.Create(dest)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	_, err = io.Copy(f, resp.Body)
-	return err
-}
-
-// findScoreURL looks up the FTP link for a PGS ID in LoadedScores
-func findScoreURL(id string) string {
-	for _, m := range data.LoadedScores {
-		if sid, ok := m["Polygenic Score (PGS) ID"].(string); ok && sid == id {
-			if link, ok2 := m["FTP link"].(string); ok2 {
-				return link
-			}
-		}
-	}
-	return ""
-}
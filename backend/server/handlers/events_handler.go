@@ -0,0 +1,116 @@
+// backend/server/handlers/events_handler.go
+package handlers
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "time"
+
+    "github.com/adamwestgate/easy-pgs/backend/events"
+)
+
+// sseHeartbeat keeps idle connections from being closed by proxies.
+const sseHeartbeat = 15 * time.Second
+
+// EventsHandler handles GET /events?jobId=<id> or ?kitId=<id> as a
+// Server-Sent Events stream of pipeline progress; one of them is required,
+// as for /status. With jobId the stream starts with a "status" event holding
+// the job snapshot, carries only that job's events and ends after
+// job_done / job_failed / job_cancelled. Should the terminal event not
+// arrive, the heartbeat notices the finished job, sends its final "status"
+// and ends the stream all the same. With kitId alone the stream carries the
+// events of every job reading that kit until the client goes away.
+func EventsHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusOK)
+        return
+    }
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+        return
+    }
+
+    q := r.URL.Query()
+    jobID, kitID := q.Get("jobId"), q.Get("kitId")
+    var job *Job
+    switch {
+    case jobID != "":
+        if job, ok = lookupJob(jobID); !ok || (kitID != "" && !job.reads(kitID)) {
+            http.Error(w, "job not found", http.StatusNotFound)
+            return
+        }
+    case kitID == "":
+        http.Error(w, "kitId or jobId is required", http.StatusBadRequest)
+        return
+    }
+
+    // subscribe before taking the snapshot so nothing falls in between
+    ch, unsubscribe := events.Default.Subscribe(256)
+    defer unsubscribe()
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("Connection", "keep-alive")
+    w.WriteHeader(http.StatusOK)
+
+    if job != nil {
+        snap := job.snapshot()
+        writeSSE(w, "status", snap)
+        flusher.Flush()
+        if finished(snap.Stage) {
+            return
+        }
+    }
+
+    tick := time.NewTicker(sseHeartbeat)
+    defer tick.Stop()
+    for {
+        select {
+        case <-r.Context().Done():
+            return
+        case <-tick.C:
+            if job != nil {
+                if snap := job.snapshot(); finished(snap.Stage) {
+                    writeSSE(w, "status", snap)
+                    flusher.Flush()
+                    return
+                }
+            }
+            fmt.Fprint(w, ": keep-alive\n\n")
+            flusher.Flush()
+        case e, ok := <-ch:
+            if !ok {
+                return
+            }
+            if !eventFor(e, jobID, kitID) {
+                continue
+            }
+            writeSSE(w, e.Type, e)
+            flusher.Flush()
+            if job != nil && events.Terminal(e.Type) {
+                return
+            }
+        }
+    }
+}
+
+// eventFor reports whether e belongs to the stream for jobID, or if that is
+// empty, to a job reading kitID.
+func eventFor(e events.Event, jobID, kitID string) bool {
+    if jobID != "" {
+        return e.Job == jobID
+    }
+    j, ok := lookupJob(e.Job)
+    return ok && j.reads(kitID)
+}
+
+// writeSSE writes one named event with a JSON payload.
+func writeSSE(w http.ResponseWriter, name string, v interface{}) {
+    b, err := json.Marshal(v)
+    if err != nil {
+        return
+    }
+    fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, b)
+}
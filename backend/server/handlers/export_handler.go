@@ -0,0 +1,302 @@
+// backend/server/handlers/export_handler.go
+package handlers
+
+import (
+    "bytes"
+    "context"
+    "encoding/csv"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "path/filepath"
+    "strconv"
+    "strings"
+
+    "github.com/gorilla/mux"
+    "github.com/xuri/excelize/v2"
+
+    "github.com/adamwestgate/easy-pgs/backend/pipeline"
+    "github.com/adamwestgate/easy-pgs/backend/preprocessing/scoring"
+    "github.com/adamwestgate/easy-pgs/backend/report"
+    "github.com/adamwestgate/easy-pgs/backend/store"
+)
+
+// summaryHeader and variantHeader are the columns of the exported tables.
+var (
+    summaryHeader = []string{"Trait", "PGS ID", "Raw score (per-allele average)", "Z-score", "Percentile", "Coverage (%)", "Population mean", "Population SD"}
+    variantHeader = []string{"Variant", "Chr", "Position", "Effect allele", "Other allele", "Genotype", "Effect allele dosage", "Imputed", "Weight", "Contribution to score sum", "Contribution to raw score"}
+)
+
+// ResultsXLSXHandler handles GET /kits/{id}/results.xlsx.
+// The workbook has a Summary sheet with one row per score, followed by one
+// sheet per PGS listing the kit's matched variants, genotype, effect allele,
+// weight and contribution to the score sum and to the raw score, which is
+// that sum divided by the kit's allele count. Missing genotypes are handled
+// as in the run. A PGS whose scoring file changed since the run gets a note
+// instead of its variants, which would no longer add up to the results.
+func ResultsXLSXHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusOK)
+        return
+    }
+    kitID := mux.Vars(r)["id"]
+    ex, status, err := exportData(r.Context(), kitID)
+    if err != nil {
+        http.Error(w, err.Error(), status)
+        return
+    }
+    rep := ex.rep
+
+    f := excelize.NewFile()
+    defer f.Close()
+    bold, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+    if err != nil {
+        http.Error(w, "export error: "+err.Error(), http.StatusInternalServerError)
+        return
+    }
+    if err := f.SetSheetName("Sheet1", "Summary"); err != nil {
+        http.Error(w, "export error: "+err.Error(), http.StatusInternalServerError)
+        return
+    }
+    rows := [][]interface{}{headerRow(summaryHeader, bold)}
+    for _, t := range rep.Traits {
+        rows = append(rows, summaryRow(t))
+    }
+    if err := writeSheet(f, "Summary", rows); err != nil {
+        http.Error(w, "export error: "+err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    for _, t := range rep.Traits {
+        rows := [][]interface{}{headerRow(variantHeader, bold)}
+        contribs, err := ex.contributions(r.Context(), t.PGSID)
+        if err != nil {
+            log.Printf("ResultsXLSXHandler: %s %s: %v", kitID, t.PGSID, err)
+            rows = append(rows, []interface{}{"variants unavailable: " + err.Error()})
+        }
+        for _, c := range contribs {
+            rows = append(rows, variantRow(c))
+        }
+        if _, err := f.NewSheet(t.PGSID); err != nil {
+            http.Error(w, "export error: "+err.Error(), http.StatusInternalServerError)
+            return
+        }
+        if err := writeSheet(f, t.PGSID, rows); err != nil {
+            http.Error(w, "export error: "+err.Error(), http.StatusInternalServerError)
+            return
+        }
+    }
+    if err := r.Context().Err(); err != nil {
+        return // client gone
+    }
+
+    var buf bytes.Buffer
+    if err := f.Write(&buf); err != nil {
+        http.Error(w, "export error: "+err.Error(), http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+    w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="easy-pgs-%s.xlsx"`, kitID))
+    w.Write(buf.Bytes())
+}
+
+// ResultsCSVHandler handles GET /kits/{id}/results.csv[?pgsId=<id>].
+// Without pgsId it returns the summary table; with it, that score's
+// variant-level table, or 409 if its scoring file changed since the run.
+func ResultsCSVHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusOK)
+        return
+    }
+    kitID := mux.Vars(r)["id"]
+    ex, status, err := exportData(r.Context(), kitID)
+    if err != nil {
+        http.Error(w, err.Error(), status)
+        return
+    }
+    rep := ex.rep
+
+    var rows [][]interface{}
+    name := "easy-pgs-" + kitID
+    if pgsID := r.URL.Query().Get("pgsId"); pgsID != "" {
+        found := false
+        for _, t := range rep.Traits {
+            found = found || t.PGSID == pgsID
+        }
+        if !found {
+            http.Error(w, "no results for "+pgsID, http.StatusNotFound)
+            return
+        }
+        contribs, err := ex.contributions(r.Context(), pgsID)
+        if errors.Is(err, errScoreFileChanged) {
+            http.Error(w, err.Error(), http.StatusConflict)
+            return
+        }
+        if err != nil {
+            http.Error(w, "variants unavailable: "+err.Error(), http.StatusInternalServerError)
+            return
+        }
+        rows = append(rows, headerRow(variantHeader, 0))
+        for _, c := range contribs {
+            rows = append(rows, variantRow(c))
+        }
+        name += "-" + pgsID
+    } else {
+        rows = append(rows, headerRow(summaryHeader, 0))
+        for _, t := range rep.Traits {
+            rows = append(rows, summaryRow(t))
+        }
+    }
+
+    var buf bytes.Buffer
+    cw := csv.NewWriter(&buf)
+    for _, row := range rows {
+        rec := make([]string, len(row))
+        for i, v := range row {
+            rec[i] = csvValue(v)
+        }
+        cw.Write(rec)
+    }
+    cw.Flush()
+    if err := cw.Error(); err != nil {
+        http.Error(w, "export error: "+err.Error(), http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+    w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, name))
+    w.Write(buf.Bytes())
+}
+
+// export is what the export handlers need of a kit's latest run.
+type export struct {
+    rep    *report.Report
+    rec    store.ResultRecord
+    prefix string // the kit's fileset prefix, "" if its files are gone
+    opt    scoring.Options
+}
+
+// errScoreFileChanged is returned for a PGS whose scoring file no longer
+// matches the checksum recorded with the run.
+var errScoreFileChanged = errors.New("scoring file changed since this run; score the kit again to export its variants")
+
+// exportData loads a kit's latest results, its fileset prefix and the run's
+// missing-genotype handling. On error it also returns the HTTP status to
+// answer with.
+func exportData(ctx context.Context, kitID string) (*export, int, error) {
+    if kitStore == nil {
+        return nil, http.StatusInternalServerError, fmt.Errorf("server mis-config: kitStore not set")
+    }
+    processedDir, kitType, ok := kitStore.Lookup(kitID)
+    if !ok {
+        return nil, http.StatusNotFound, fmt.Errorf("kit not found")
+    }
+    rec, ok := latestRecord(kitID)
+    if !ok {
+        return nil, http.StatusNotFound, fmt.Errorf("results not ready")
+    }
+    imp, err := pipeline.ParseImputation(rec.Inputs.Imputation)
+    if err != nil {
+        return nil, http.StatusInternalServerError, fmt.Errorf("run %s: %w", rec.RunID, err)
+    }
+    opt, err := imp.ScoringOptions(ctx, kitType)
+    if err != nil {
+        return nil, http.StatusInternalServerError, err
+    }
+    ex := &export{rep: buildReport(kitID, kitType, rec), rec: rec, opt: opt}
+    if pgens, _ := filepath.Glob(filepath.Join(processedDir, "*.pgen")); len(pgens) > 0 {
+        ex.prefix = strings.TrimSuffix(pgens[0], ".pgen")
+    }
+    return ex, http.StatusOK, nil
+}
+
+// contributions lists the kit's variant contributions to one score, scored
+// as in the run. It returns errScoreFileChanged when the scoring file on
+// disk is not the one the run used.
+func (ex *export) contributions(ctx context.Context, pgsID string) ([]scoring.Contribution, error) {
+    if ex.prefix == "" {
+        return nil, fmt.Errorf("kit files missing")
+    }
+    if want := ex.rec.Inputs.ScoreFiles[pgsID]; want != "" {
+        gz := pipeline.ScoreFileGz(pgsID)
+        if gz == "" {
+            return nil, fmt.Errorf("scoring file not downloaded")
+        }
+        if sum, err := fileChecksum(gz); err != nil {
+            return nil, err
+        } else if sum != want {
+            return nil, fmt.Errorf("%s: %w", pgsID, errScoreFileChanged)
+        }
+    }
+    norm := pipeline.NormalizedFile(pgsID)
+    if norm == "" {
+        return nil, fmt.Errorf("scoring file not downloaded")
+    }
+    return scoring.NativeContributions(ctx, ex.prefix, ex.rep.KitType, norm, ex.opt)
+}
+
+// headerRow returns names as a table row, bold in spreadsheets when style is set.
+func headerRow(names []string, style int) []interface{} {
+    row := make([]interface{}, len(names))
+    for i, n := range names {
+        if style != 0 {
+            row[i] = excelize.Cell{StyleID: style, Value: n}
+        } else {
+            row[i] = n
+        }
+    }
+    return row
+}
+
+// summaryRow is one score of the summary table; missing values are nil.
+func summaryRow(t report.Trait) []interface{} {
+    opt := func(v *float64) interface{} {
+        if v == nil {
+            return nil
+        }
+        return *v
+    }
+    return []interface{}{t.Trait, t.PGSID, t.Score, opt(t.Z), opt(t.Percentile), opt(t.Coverage), opt(t.PopMean), opt(t.PopSD)}
+}
+
+// variantRow is one variant of a variant-level table.
+func variantRow(c scoring.Contribution) []interface{} {
+    imputed := "no"
+    if c.Imputed {
+        imputed = "yes"
+    }
+    return []interface{}{c.ID, c.Chr, c.Pos, c.EffectAllele, c.OtherAllele, c.Genotype, c.Dosage, imputed, c.Weight, c.Contribution, c.AvgContribution}
+}
+
+// writeSheet streams rows into sheet starting at A1.
+func writeSheet(f *excelize.File, sheet string, rows [][]interface{}) error {
+    sw, err := f.NewStreamWriter(sheet)
+    if err != nil {
+        return err
+    }
+    if err := sw.SetColWidth(1, len(rows[0]), 16); err != nil {
+        return err
+    }
+    for i, row := range rows {
+        cell, err := excelize.CoordinatesToCellName(1, i+1)
+        if err != nil {
+            return err
+        }
+        if err := sw.SetRow(cell, row); err != nil {
+            return err
+        }
+    }
+    return sw.Flush()
+}
+
+// csvValue formats one table value for CSV; nil becomes an empty field.
+func csvValue(v interface{}) string {
+    switch x := v.(type) {
+    case nil:
+        return ""
+    case float64:
+        return strconv.FormatFloat(x, 'g', -1, 64)
+    default:
+        return fmt.Sprint(x)
+    }
+}
@@ -0,0 +1,48 @@
+// backend/server/handlers/fhir_handler.go
+package handlers
+
+import (
+    "bytes"
+    "fmt"
+    "net/http"
+
+    "github.com/gorilla/mux"
+
+    "github.com/adamwestgate/easy-pgs/backend/report"
+)
+
+// FHIRHandler handles GET /kits/{id}/fhir.
+// It exports the kit's latest results as a FHIR R4 Bundle (a genomic-report
+// DiagnosticReport plus one Observation per score) for import into a
+// personal health record.
+func FHIRHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusOK)
+        return
+    }
+    if kitStore == nil {
+        http.Error(w, "server mis-config: kitStore not set", http.StatusInternalServerError)
+        return
+    }
+
+    kitID := mux.Vars(r)["id"]
+    _, kitType, ok := kitStore.Lookup(kitID)
+    if !ok {
+        http.Error(w, "kit not found", http.StatusNotFound)
+        return
+    }
+    rec, ok := latestRecord(kitID)
+    if !ok {
+        http.Error(w, "results not ready", http.StatusNotFound)
+        return
+    }
+
+    var buf bytes.Buffer
+    if err := report.WriteFHIR(&buf, buildReport(kitID, kitType, rec)); err != nil {
+        http.Error(w, "export error: "+err.Error(), http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", "application/fhir+json")
+    w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="easy-pgs-%s.fhir.json"`, kitID))
+    w.Write(buf.Bytes())
+}
@@ -0,0 +1,616 @@
+// backend/server/handlers/jobs.go
+package handlers
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/gorilla/mux"
+
+    "github.com/adamwestgate/easy-pgs/backend/config"
+    "github.com/adamwestgate/easy-pgs/backend/events"
+    "github.com/adamwestgate/easy-pgs/backend/pipeline"
+)
+
+// Job and per-PGS stages, in pipeline order.
+const (
+    StageQueued            = "queued"
+    StageDownloading       = "downloading"
+    StageNormalizing       = "normalizing"
+    StageScoringUser       = "scoring_user"
+    StageScoringPopulation = "scoring_population"
+    StageComparing         = "comparing" // compare jobs only
+    StageReady             = "ready"
+    StageFailed            = "failed"
+    StageCancelled         = "cancelled"
+)
+
+// StageTiming records when a job entered and left one stage.
+type StageTiming struct {
+    Stage    string    `json:"stage"`
+    Started  time.Time `json:"started"`
+    Finished time.Time `json:"finished,omitempty"`
+}
+
+// PGSProgress tracks one score within a job.
+type PGSProgress struct {
+    Stage    string    `json:"stage"`
+    Error    string    `json:"error,omitempty"`
+    Started  time.Time `json:"started,omitempty"`
+    Finished time.Time `json:"finished,omitempty"`
+}
+
+// JobStatus is the reported state of a job.
+type JobStatus struct {
+    ID         string                  `json:"id"`
+    KitID      string                  `json:"kitId"`
+    PgsIds     []string                `json:"pgsIds"`
+    Imputation string                  `json:"imputation"` // missing-genotype handling, see pipeline.ParseImputation
+    Stage      string                  `json:"stage"`
+    Error      string                  `json:"error,omitempty"`
+    Created    time.Time               `json:"created"`
+    Finished   time.Time               `json:"finished,omitempty"`
+    Stages     []StageTiming           `json:"stages"`
+    PGS        map[string]*PGSProgress `json:"pgs"`
+}
+
+// Job is one scoring run: download → normalize → user scoring → population
+// scoring, or another task queued through the same workers (see submitTask).
+type Job struct {
+    JobStatus
+
+    mu      sync.Mutex
+    ctx     context.Context
+    cancel  context.CancelFunc
+    done    chan struct{}
+    run     func(*Job) // runJob, or the task given to submitTask
+    kitIDs  []string   // every kit the job reads, when more than KitID
+    imp     pipeline.Imputation
+    results *ScoringResults
+    events  events.Emitter
+}
+
+var (
+    jobsMu    sync.RWMutex
+    jobs      = make(map[string]*Job)
+    jobQueue  = make(chan *Job, 64)
+    startOnce sync.Once
+)
+
+const (
+    // workspaceSweepEvery is how often stale job workspaces are looked for.
+    workspaceSweepEvery = time.Hour
+    // jobSweepEvery is how often expired jobs are dropped from the job map.
+    jobSweepEvery = 10 * time.Minute
+)
+
+// errQueueFull is returned by submitJob when every queue slot is taken.
+var errQueueFull = errors.New("too many scoring jobs queued, try again later")
+
+// StartJobWorkers launches the pool of goroutines that run queued jobs, the
+// sweep that removes workspaces left behind by failed or cancelled jobs, and
+// the one that forgets jobs finished more than config.JobRetentionHours ago.
+// Safe to call more than once.
+func StartJobWorkers() {
+    startOnce.Do(func() {
+        for i := 0; i < config.JobWorkers; i++ {
+            go func() {
+                for job := range jobQueue {
+                    job.run(job)
+                }
+            }()
+        }
+        go func() {
+            retention := time.Duration(config.WorkspaceRetentionHours) * time.Hour
+            for {
+                pipeline.SweepWorkspaces(retention)
+                time.Sleep(workspaceSweepEvery)
+            }
+        }()
+        go func() {
+            retention := time.Duration(config.JobRetentionHours) * time.Hour
+            for {
+                time.Sleep(jobSweepEvery)
+                evictJobs(time.Now().Add(-retention))
+            }
+        }()
+    })
+}
+
+// evictJobs drops the jobs that reached a terminal stage before cutoff.
+func evictJobs(cutoff time.Time) {
+    jobsMu.Lock()
+    defer jobsMu.Unlock()
+    for id, j := range jobs {
+        j.mu.Lock()
+        expired := finished(j.Stage) && j.Finished.Before(cutoff)
+        j.mu.Unlock()
+        if expired {
+            delete(jobs, id)
+        }
+    }
+}
+
+// submitJob registers and queues a job for kitID, handling missing
+// genotypes as imp says. It never blocks: with the queue full the job is
+// dropped and errQueueFull returned.
+func submitJob(kitID string, pgsIDs []string, imp pipeline.Imputation) (*Job, error) {
+    job := newJob(kitID, pgsIDs, imp)
+    job.run = runJob
+    return enqueue(job)
+}
+
+// submitTask queues task to run on a job worker, as a job over kitIDs and
+// pgsIDs. task must close job.done when it returns, as runJob does. Like
+// submitJob it returns errQueueFull rather than block.
+func submitTask(kitIDs, pgsIDs []string, task func(*Job)) (*Job, error) {
+    job := newJob(strings.Join(kitIDs, ","), pgsIDs, pipeline.Imputation{})
+    job.kitIDs = kitIDs
+    job.run = task
+    return enqueue(job)
+}
+
+// newJob returns a queued job that has not been registered yet.
+func newJob(kitID string, pgsIDs []string, imp pipeline.Imputation) *Job {
+    job := &Job{
+        JobStatus: JobStatus{
+            ID:         uuid.NewString(),
+            KitID:      kitID,
+            PgsIds:     pgsIDs,
+            Imputation: imp.String(),
+            Stage:      StageQueued,
+            Created:    time.Now(),
+            Stages:     []StageTiming{},
+            PGS:        make(map[string]*PGSProgress, len(pgsIDs)),
+        },
+        done: make(chan struct{}),
+        imp:  imp,
+    }
+    job.ctx, job.cancel = context.WithCancel(context.Background())
+    job.events = events.Default.ForJob(job.ID)
+    for _, id := range pgsIDs {
+        job.PGS[id] = &PGSProgress{Stage: StageQueued}
+    }
+    return job
+}
+
+// enqueue registers job and hands it to the workers without blocking.
+func enqueue(job *Job) (*Job, error) {
+    StartJobWorkers()
+    jobsMu.Lock()
+    defer jobsMu.Unlock()
+    select {
+    case jobQueue <- job:
+        jobs[job.ID] = job
+        return job, nil
+    default:
+        job.cancel()
+        return nil, errQueueFull
+    }
+}
+
+// lookupJob returns the job with the given ID.
+func lookupJob(id string) (*Job, bool) {
+    jobsMu.RLock()
+    defer jobsMu.RUnlock()
+    j, ok := jobs[id]
+    return j, ok
+}
+
+// cancelKitJobs cancels every unfinished job of kitID. It returns the ones
+// already running; their done channels close once they have stopped. Queued
+// ones are marked cancelled at once and never touch the kit.
+func cancelKitJobs(kitID string) []*Job {
+    jobsMu.RLock()
+    defer jobsMu.RUnlock()
+    var running []*Job
+    for _, j := range jobs {
+        if !j.reads(kitID) {
+            continue
+        }
+        if started, err := j.stop(); err == nil && started {
+            running = append(running, j)
+        }
+    }
+    return running
+}
+
+// reads reports whether the job uses kitID's files.
+func (j *Job) reads(kitID string) bool {
+    if j.KitID == kitID {
+        return true
+    }
+    for _, id := range j.kitIDs {
+        if id == kitID {
+            return true
+        }
+    }
+    return false
+}
+
+// runJob executes every pipeline stage of job and stores its results.
+// A cancelled job stops at the next stage boundary (or sooner, where the
+// stage itself honours the context) and stores nothing.
+func runJob(job *Job) {
+    defer close(job.done)
+    defer job.cancel()
+    ctx := job.ctx
+
+    // download + normalize, one PGS at a time
+    normPaths := make([]string, 0, len(job.PgsIds))
+    for _, pgsID := range job.PgsIds {
+        if job.stopIfCancelled() {
+            return
+        }
+        job.setStage(StageDownloading)
+        job.setPGS(pgsID, StageDownloading, nil)
+        ev := job.events.ForPGS(pgsID)
+        gzPath, err := pipeline.DownloadScoreFile(ctx, pgsID, ev)
+        if err != nil {
+            if job.stopIfCancelled() {
+                return
+            }
+            log.Printf("job %s: download %s: %v", job.ID, pgsID, err)
+            job.setPGS(pgsID, StageFailed, err)
+            continue
+        }
+
+        job.setStage(StageNormalizing)
+        job.setPGS(pgsID, StageNormalizing, nil)
+        normPath, err := pipeline.NormalizeScoreFile(ctx, pgsID, gzPath, ev)
+        if err != nil {
+            if job.stopIfCancelled() {
+                return
+            }
+            log.Printf("job %s: normalize %s: %v", job.ID, pgsID, err)
+            job.setPGS(pgsID, StageFailed, err)
+            continue
+        }
+        job.setPGS(pgsID, StageScoringUser, nil)
+        normPaths = append(normPaths, normPath)
+    }
+
+    if job.stopIfCancelled() {
+        return
+    }
+    // private scratch space; removed on success, swept later otherwise
+    ws, err := pipeline.NewWorkspace(job.ID)
+    if err != nil {
+        job.fail(err)
+        return
+    }
+    stored := false
+    defer func() {
+        if stored {
+            if err := ws.Remove(); err != nil {
+                log.Printf("job %s: removing workspace: %v", job.ID, err)
+            }
+        }
+    }()
+
+    job.setStage(StageScoringUser)
+    user, kitType, err := scoreKitUser(ctx, job.KitID, normPaths, job.imp, ws, job.events)
+    if err != nil {
+        job.fail(err)
+        return
+    }
+    if job.stopIfCancelled() {
+        return
+    }
+    for id, br := range user {
+        if br.Err != nil {
+            job.setPGS(id, StageFailed, br.Err)
+        } else {
+            job.setPGS(id, StageScoringPopulation, nil)
+        }
+    }
+
+    job.setStage(StageScoringPopulation)
+    pop := pipeline.ScorePopulation(ctx, kitType, user, job.imp, ws, job.events)
+    if job.stopIfCancelled() {
+        return
+    }
+    for id, br := range user {
+        if br.Err != nil {
+            continue
+        }
+        if pbr, ok := pop[id]; ok && pbr.Err != nil {
+            job.setPGS(id, StageFailed, pbr.Err)
+        } else {
+            job.setPGS(id, StageReady, nil)
+        }
+    }
+
+    results := &ScoringResults{User: user, Pop: pop, Imputation: job.imp}
+    storeResults(job.KitID, results)
+    stored = true
+
+    job.mu.Lock()
+    job.results = results
+    job.mu.Unlock()
+    job.setStage(StageReady)
+}
+
+// setStage moves the job to stage, closing the timing of the previous one,
+// and publishes the change. A job that reached a terminal stage stays there.
+func (j *Job) setStage(stage string) {
+    j.mu.Lock()
+    e, changed := j.moveLocked(stage)
+    j.mu.Unlock()
+    if changed {
+        j.events.Emit(e)
+    }
+}
+
+// moveLocked is setStage without the locking and publishing: it returns
+// the event to publish once j.mu is released, and false if nothing changed.
+func (j *Job) moveLocked(stage string) (events.Event, bool) {
+    if j.Stage == stage || finished(j.Stage) {
+        return events.Event{}, false
+    }
+    now := time.Now()
+    if n := len(j.Stages); n > 0 {
+        j.Stages[n-1].Finished = now
+    }
+    j.Stage = stage
+    if finished(stage) {
+        j.Finished = now
+    } else {
+        j.Stages = append(j.Stages, StageTiming{Stage: stage, Started: now})
+    }
+
+    switch stage {
+    case StageReady:
+        return events.Event{Type: events.JobDone, Stage: stage}, true
+    case StageFailed:
+        return events.Event{Type: events.JobFailed, Stage: stage, Error: j.Error}, true
+    case StageCancelled:
+        return events.Event{Type: events.JobCancelled, Stage: stage}, true
+    default:
+        return events.Event{Type: events.JobStage, Stage: stage}, true
+    }
+}
+
+// setPGS updates the progress of one score. A score that reached a terminal
+// stage (say, cancelled with its job) keeps it.
+func (j *Job) setPGS(pgsID, stage string, err error) {
+    j.mu.Lock()
+    p, ok := j.PGS[pgsID]
+    if !ok || finished(p.Stage) {
+        j.mu.Unlock()
+        return
+    }
+    now := time.Now()
+    if p.Started.IsZero() {
+        p.Started = now
+    }
+    p.Stage = stage
+    if err != nil {
+        p.Error = err.Error()
+    }
+    if finished(stage) {
+        p.Finished = now
+    }
+    errMsg := p.Error
+    j.mu.Unlock()
+
+    if stage == StageFailed {
+        j.events.Emit(events.Event{Type: events.PGSFailed, PGSID: pgsID, Error: errMsg})
+    }
+}
+
+// stopIfCancelled marks the job and its unfinished scores as cancelled if
+// its context is done, and reports whether it was.
+func (j *Job) stopIfCancelled() bool {
+    if j.ctx.Err() == nil {
+        return false
+    }
+    j.mu.Lock()
+    e, changed := j.cancelLocked()
+    j.mu.Unlock()
+    if changed {
+        j.events.Emit(e)
+    }
+    return true
+}
+
+// cancelLocked marks the job and its unfinished scores as cancelled and
+// returns the event to publish once j.mu is released.
+func (j *Job) cancelLocked() (events.Event, bool) {
+    now := time.Now()
+    for _, p := range j.PGS {
+        if !finished(p.Stage) {
+            p.Stage, p.Finished = StageCancelled, now
+        }
+    }
+    if !finished(j.Stage) {
+        j.Error = "cancelled"
+    }
+    return j.moveLocked(StageCancelled)
+}
+
+// finished reports whether stage is terminal.
+func finished(stage string) bool {
+    return stage == StageReady || stage == StageFailed || stage == StageCancelled
+}
+
+// errJobFinished is returned when cancelling a job that already ended.
+var errJobFinished = errors.New("job already finished")
+
+// Cancel stops the job: queued jobs are marked cancelled at once and never
+// start, running downloads, normalisation and scoring are interrupted
+// (plink2 is killed).
+func (j *Job) Cancel() error {
+    _, err := j.stop()
+    return err
+}
+
+// stop cancels the job and reports whether it had already started, in which
+// case it reaches the cancelled stage only once its worker has noticed.
+func (j *Job) stop() (started bool, err error) {
+    j.mu.Lock()
+    stage := j.Stage
+    var e events.Event
+    var changed bool
+    if stage == StageQueued {
+        e, changed = j.cancelLocked()
+    }
+    j.mu.Unlock()
+    if finished(stage) {
+        return false, errJobFinished
+    }
+    j.cancel()
+    if changed {
+        j.events.Emit(e)
+    }
+    return stage != StageQueued, nil
+}
+
+// fail marks the whole job as failed, or as cancelled if that was the cause.
+func (j *Job) fail(err error) {
+    if j.stopIfCancelled() {
+        return
+    }
+    log.Printf("job %s failed: %v", j.ID, err)
+    j.mu.Lock()
+    if !finished(j.Stage) {
+        j.Error = err.Error()
+    }
+    e, changed := j.moveLocked(StageFailed)
+    j.mu.Unlock()
+    if changed {
+        j.events.Emit(e)
+    }
+}
+
+// snapshot returns a copy of the job that is safe to encode.
+func (j *Job) snapshot() JobStatus {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    c := JobStatus{
+        ID:         j.ID,
+        KitID:      j.KitID,
+        PgsIds:     j.PgsIds,
+        Imputation: j.Imputation,
+        Stage:      j.Stage,
+        Error:      j.Error,
+        Created:    j.Created,
+        Finished:   j.Finished,
+        Stages:     append([]StageTiming(nil), j.Stages...),
+        PGS:        make(map[string]*PGSProgress, len(j.PGS)),
+    }
+    for id, p := range j.PGS {
+        cp := *p
+        c.PGS[id] = &cp
+    }
+    return c
+}
+
+// recentJobs returns snapshots of all jobs, newest first.
+func recentJobs() []JobStatus {
+    jobsMu.RLock()
+    list := make([]JobStatus, 0, len(jobs))
+    for _, j := range jobs {
+        list = append(list, j.snapshot())
+    }
+    jobsMu.RUnlock()
+    sort.Slice(list, func(a, b int) bool { return list[a].Created.After(list[b].Created) })
+    return list
+}
+
+// CreateJobHandler handles POST /jobs with the same payload as /download.
+// It queues the job and returns 202 with its ID immediately, or 503 when
+// the queue is full.
+func CreateJobHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusOK)
+        return
+    }
+    req, imp, ok := decodeScoringRequest(w, r)
+    if !ok {
+        return
+    }
+    job, err := submitJob(req.KitID, req.PgsIds, imp)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusServiceUnavailable)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Location", "/jobs/"+job.ID)
+    w.WriteHeader(http.StatusAccepted)
+    json.NewEncoder(w).Encode(map[string]string{"jobId": job.ID})
+}
+
+// JobHandler handles GET /jobs/{id} and reports per-stage and per-PGS progress.
+func JobHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusOK)
+        return
+    }
+    job, ok := lookupJob(mux.Vars(r)["id"])
+    if !ok {
+        http.Error(w, "job not found", http.StatusNotFound)
+        return
+    }
+    writeJSON(w, job.snapshot())
+}
+
+// CancelJobHandler handles POST /jobs/{id}/cancel. Returns 202 with the job
+// status, 404 for unknown jobs and 409 if the job already finished.
+func CancelJobHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusOK)
+        return
+    }
+    job, ok := lookupJob(mux.Vars(r)["id"])
+    if !ok {
+        http.Error(w, "job not found", http.StatusNotFound)
+        return
+    }
+    if err := job.Cancel(); err != nil {
+        http.Error(w, err.Error(), http.StatusConflict)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusAccepted)
+    json.NewEncoder(w).Encode(job.snapshot())
+}
+
+// decodeScoringRequest parses and validates a {kitId, pgsIds, imputation}
+// payload, writing the error response itself when it returns false.
+func decodeScoringRequest(w http.ResponseWriter, r *http.Request) (DownloadRequest, pipeline.Imputation, bool) {
+    var req DownloadRequest
+    var imp pipeline.Imputation
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        log.Printf("decodeScoringRequest: JSON decode error: %v", err)
+        http.Error(w, "invalid JSON payload", http.StatusBadRequest)
+        return req, imp, false
+    }
+    if req.KitID == "" {
+        http.Error(w, "kitId is required", http.StatusBadRequest)
+        return req, imp, false
+    }
+    if len(req.PgsIds) == 0 {
+        http.Error(w, "no pgsIds provided", http.StatusBadRequest)
+        return req, imp, false
+    }
+    imp, err := pipeline.ParseImputation(req.Imputation)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return req, imp, false
+    }
+    if _, _, ok := kitStore.Lookup(req.KitID); !ok {
+        http.Error(w, "invalid kit_id", http.StatusBadRequest)
+        return req, imp, false
+    }
+    return req, imp, true
+}
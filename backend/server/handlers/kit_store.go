@@ -1,6 +1,9 @@
 package handlers
 
-import "github.com/adamwestgate/easy-pgs/backend/store"
+import (
+    "github.com/adamwestgate/easy-pgs/backend/pipeline"
+    "github.com/adamwestgate/easy-pgs/backend/store"
+)
 
 // kitStore holds the BoltDB-backed KitStore; all handlers use this.
 var kitStore store.KitStore
@@ -9,3 +12,21 @@ var kitStore store.KitStore
 func SetKitStore(ks store.KitStore) {
     kitStore = ks
 }
+
+// scoreIndex is the reverse variant → score index, kept up to date by the download flow.
+var scoreIndex store.ScoreIndex
+
+// SetScoreIndex initializes the package-level score index, and the one the
+// scoring pipeline updates as it normalises files.
+func SetScoreIndex(si store.ScoreIndex) {
+    scoreIndex = si
+    pipeline.SetScoreIndex(si)
+}
+
+// resultStore persists scoring results so they survive restarts.
+var resultStore store.ResultStore
+
+// SetResultStore initializes the package-level result store.
+func SetResultStore(rs store.ResultStore) {
+    resultStore = rs
+}
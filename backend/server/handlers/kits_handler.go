@@ -0,0 +1,113 @@
+// backend/server/handlers/kits_handler.go
+package handlers
+
+import (
+    "log"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/gorilla/mux"
+
+    "github.com/adamwestgate/easy-pgs/backend/config"
+    "github.com/adamwestgate/easy-pgs/backend/pipeline"
+    "github.com/adamwestgate/easy-pgs/backend/store"
+)
+
+// DeleteKitHandler handles DELETE /kits/{id}.
+// It cancels the kit's unfinished jobs, then removes the kit mapping, its
+// persisted and cached results, and the processed genotype files.
+// Returns 204 on success, 404 for unknown kits.
+func DeleteKitHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusOK)
+        return
+    }
+    if kitStore == nil {
+        http.Error(w, "server mis-config: kitStore not set", http.StatusInternalServerError)
+        return
+    }
+
+    kitID := mux.Vars(r)["id"]
+    processedDir, _, ok := kitStore.Lookup(kitID)
+    if !ok {
+        http.Error(w, "kit not found", http.StatusNotFound)
+        return
+    }
+
+    // stop the kit's jobs first, so none reads the files or stores results
+    // after they are gone
+    for _, job := range cancelKitJobs(kitID) {
+        select {
+        case <-job.done:
+        case <-r.Context().Done():
+            return
+        }
+    }
+
+    // also removes the kit's stored results
+    if err := kitStore.Delete(kitID); err != nil {
+        http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+        return
+    }
+    forgetResults(kitID)
+
+    // only ever remove directories inside the processed uploads root
+    if root, err := filepath.Abs(config.UploadProcessedDir); err == nil {
+        if dir, err := filepath.Abs(processedDir); err == nil && strings.HasPrefix(dir, root+string(filepath.Separator)) {
+            if err := os.RemoveAll(dir); err != nil {
+                log.Printf("DeleteKitHandler: removing %s: %v", dir, err)
+            }
+        }
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// KitAncestryHandler handles GET /kits/{id}/ancestry and returns the kit's
+// inferred genetic ancestry: the most probable 1000G superpopulation, the
+// probability of each, and the kit's principal component coordinates.
+// Returns 404 for unknown kits and 503 when the reference panel has no
+// ancestry model.
+func KitAncestryHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusOK)
+        return
+    }
+    if kitStore == nil {
+        http.Error(w, "server mis-config: kitStore not set", http.StatusInternalServerError)
+        return
+    }
+    kitID := mux.Vars(r)["id"]
+    if _, _, ok := kitStore.Lookup(kitID); !ok {
+        http.Error(w, "kit not found", http.StatusNotFound)
+        return
+    }
+    a, ok := kitAncestry(kitID)
+    if !ok {
+        http.Error(w, "ancestry not available", http.StatusServiceUnavailable)
+        return
+    }
+    writeJSON(w, a)
+}
+
+// kitAncestry returns the recorded ancestry of kitID, inferring and
+// recording it first for kits uploaded before the panel had a model.
+func kitAncestry(kitID string) (store.Ancestry, bool) {
+    if a, ok := kitStore.Ancestry(kitID); ok {
+        return a, true
+    }
+    processedDir, kitType, ok := kitStore.Lookup(kitID)
+    if !ok {
+        return store.Ancestry{}, false
+    }
+    a, err := pipeline.InferAncestry(processedDir, kitType)
+    if err != nil {
+        log.Printf("kitAncestry: %s: %v", kitID, err)
+        return store.Ancestry{}, false
+    }
+    if err := kitStore.SetAncestry(kitID, a); err != nil {
+        log.Printf("kitAncestry: recording %s: %v", kitID, err)
+    }
+    return a, true
+}
@@ -0,0 +1,306 @@
+// backend/server/handlers/report_handler.go
+package handlers
+
+import (
+    "bytes"
+    "fmt"
+    "log"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+
+    "github.com/adamwestgate/easy-pgs/backend/data"
+    "github.com/adamwestgate/easy-pgs/backend/pipeline"
+    "github.com/adamwestgate/easy-pgs/backend/report"
+    "github.com/adamwestgate/easy-pgs/backend/store"
+)
+
+// maxReportMetrics caps the published performance metrics listed per score.
+const maxReportMetrics = 6
+
+// lowCoverage is the coverage (%) below which a score gets a caveat.
+const lowCoverage = 50.0
+
+// highUnscoredShare is the unscored share of a score's expected variance
+// (0–1) from which a score gets a caveat.
+const highUnscoredShare = 0.5
+
+// performanceColumns maps performance_metrics.json columns to report labels.
+var performanceColumns = []struct{ key, name string }{
+    {"Hazard Ratio (HR)", "HR"},
+    {"Odds Ratio (OR)", "OR"},
+    {"Beta", "Beta"},
+    {"Area Under the Receiver-Operating Characteristic Curve (AUROC)", "AUROC"},
+    {"Concordance Statistic (C-index)", "C-index"},
+    {"Other Metric(s)", "Other"},
+}
+
+// ReportHandler handles GET /kits/{id}/report[?format=pdf].
+// It renders the kit's latest results as a printable report: per trait the
+// score, percentile, coverage, reference distribution chart, published
+// performance metrics and caveats, followed by a kit QC summary and the
+// run's provenance. HTML (default) is self-contained; format=pdf returns a
+// PDF download.
+func ReportHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusOK)
+        return
+    }
+    if kitStore == nil {
+        http.Error(w, "server mis-config: kitStore not set", http.StatusInternalServerError)
+        return
+    }
+    format := r.URL.Query().Get("format")
+    if format != "" && format != "html" && format != "pdf" {
+        http.Error(w, "format must be html or pdf", http.StatusBadRequest)
+        return
+    }
+
+    kitID := mux.Vars(r)["id"]
+    processedDir, kitType, ok := kitStore.Lookup(kitID)
+    if !ok {
+        http.Error(w, "kit not found", http.StatusNotFound)
+        return
+    }
+    rec, ok := latestRecord(kitID)
+    if !ok {
+        http.Error(w, "results not ready", http.StatusNotFound)
+        return
+    }
+
+    rep := buildReport(kitID, kitType, rec)
+    if qc, err := pipeline.QC(processedDir, kitType); err == nil {
+        rep.QC = &qc
+    } else {
+        log.Printf("ReportHandler: QC of %s: %v", kitID, err)
+    }
+
+    // render into a buffer so a failure can still become an HTTP error
+    var buf bytes.Buffer
+    if format == "pdf" {
+        if err := report.WritePDF(&buf, rep); err != nil {
+            http.Error(w, "report error: "+err.Error(), http.StatusInternalServerError)
+            return
+        }
+        w.Header().Set("Content-Type", "application/pdf")
+        w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="easy-pgs-report-%s.pdf"`, kitID))
+    } else {
+        if err := report.WriteHTML(&buf, rep); err != nil {
+            http.Error(w, "report error: "+err.Error(), http.StatusInternalServerError)
+            return
+        }
+        w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    }
+    w.Write(buf.Bytes())
+}
+
+// latestRecord returns the kit's latest persisted run, or a record holding
+// only the cached results when no result store is configured.
+func latestRecord(kitID string) (store.ResultRecord, bool) {
+    if resultStore != nil {
+        rec, ok, err := resultStore.LoadResults(kitID)
+        if err != nil {
+            log.Printf("latestRecord: loading %s failed: %v", kitID, err)
+        }
+        if ok {
+            return rec, true
+        }
+    }
+    flat, ok := fetchResults(kitID)
+    return store.ResultRecord{KitID: kitID, Results: flat}, ok
+}
+
+// buildReport assembles the report of one run, ordered by trait label.
+func buildReport(kitID, kitType string, rec store.ResultRecord) *report.Report {
+    rep := &report.Report{
+        KitID:     kitID,
+        KitType:   kitType,
+        Generated: time.Now(),
+        Provenance: report.Provenance{
+            RunID:          rec.RunID,
+            Created:        rec.Created,
+            CatalogVersion: rec.CatalogVersion,
+            Inputs:         rec.Inputs,
+        },
+    }
+    group := ""
+    if a, ok := kitAncestry(kitID); ok {
+        rep.Ancestry = &a
+        group = pipeline.ConfidentSuperPop(a)
+    }
+
+    res := rec.Results
+    for id, score := range res.User {
+        t := report.Trait{PGSID: id, Trait: res.Trait[id], Score: score}
+        if t.Trait == "" {
+            t.Trait = pipeline.TraitLabel(id)
+        }
+        if v, ok := res.Population[id]; ok {
+            t.PopMean = &v
+        }
+        if st, ok := rec.PopStats[id]; ok && st.SD > 0 {
+            sd := st.SD
+            t.PopSD = &sd
+            t.PopHist = st.Hist
+        }
+        if v, ok := res.Z[id]; ok {
+            t.Z = &v
+        }
+        if v, ok := res.Pct[id]; ok {
+            pct := v * 100
+            t.Percentile = &pct
+        }
+        if v, ok := res.PctSnpsScored[id]; ok {
+            t.Coverage = &v
+        }
+        if v, ok := res.UnscoredShare[id]; ok {
+            t.Unscored = &v
+        }
+        if v, ok := res.PctAdjusted[id]; ok {
+            pct := v * 100
+            t.PctAdj = &pct
+        }
+        if g, ok := res.Groups[id][group]; ok && group != "" {
+            pct := g.Pct * 100
+            t.Group, t.GroupN, t.GroupPct = group, g.N, &pct
+        }
+
+        var gwas []ancestryShare
+        if meta := pipeline.FindScoreMeta(id); meta != nil {
+            t.Citation = formatCitation(meta, findPublication(meta))
+            t.Terms = mappedTerms(meta)
+            if n, ok := meta["Number of Variants"]; ok && n != nil {
+                t.Variants = fmt.Sprint(n)
+            }
+            raw, _ := meta["Ancestry Distribution (%) - Source of Variant Associations (GWAS)"].(string)
+            gwas = parseAncestry(raw)
+            t.Ancestry = formatAncestry(gwas)
+        }
+        t.Metrics = performanceMetrics(id)
+        t.Caveats = reportCaveats(t, kitType, gwas)
+        rep.Traits = append(rep.Traits, t)
+    }
+    sort.Slice(rep.Traits, func(i, j int) bool {
+        a, b := rep.Traits[i], rep.Traits[j]
+        if a.Trait != b.Trait {
+            return a.Trait < b.Trait
+        }
+        return a.PGSID < b.PGSID
+    })
+    return rep
+}
+
+// performanceMetrics lists the published metrics evaluated for pgsID.
+func performanceMetrics(pgsID string) []report.Metric {
+    var out []report.Metric
+    for _, pm := range data.LoadedPerformance {
+        if id, _ := pm["Evaluated Score"].(string); id != pgsID {
+            continue
+        }
+        sample, _ := pm["PGS Sample Set (PSS)"].(string)
+        for _, c := range performanceColumns {
+            v, ok := pm[c.key]
+            if !ok || v == nil {
+                continue
+            }
+            s := strings.TrimSpace(fmt.Sprint(v))
+            if s == "" {
+                continue
+            }
+            out = append(out, report.Metric{Name: c.name, Value: s, SampleSet: sample})
+            if len(out) == maxReportMetrics {
+                return out
+            }
+        }
+    }
+    return out
+}
+
+// mappedTerms pairs the catalog's pipe-separated "Mapped Trait(s)" IDs and labels.
+func mappedTerms(meta map[string]interface{}) []report.Term {
+    ids, _ := meta["Mapped Trait(s) (EFO ID)"].(string)
+    labels, _ := meta["Mapped Trait(s) (EFO label)"].(string)
+    labelList := strings.Split(labels, "|")
+    var out []report.Term
+    for i, id := range strings.Split(ids, "|") {
+        id = strings.TrimSpace(id)
+        if id == "" {
+            continue
+        }
+        t := report.Term{ID: id}
+        if i < len(labelList) {
+            t.Label = strings.TrimSpace(labelList[i])
+        }
+        out = append(out, t)
+    }
+    return out
+}
+
+// ancestryShare is one "Region:percent" entry of the catalog's ancestry columns.
+type ancestryShare struct {
+    region string
+    pct    float64
+}
+
+// parseAncestry parses "European:95.2|African:4.8", largest share first.
+func parseAncestry(raw string) []ancestryShare {
+    var out []ancestryShare
+    for _, seg := range strings.Split(raw, "|") {
+        parts := strings.SplitN(seg, ":", 2)
+        if len(parts) != 2 {
+            continue
+        }
+        pct, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+        if err != nil {
+            continue
+        }
+        out = append(out, ancestryShare{strings.TrimSpace(parts[0]), pct})
+    }
+    sort.SliceStable(out, func(i, j int) bool { return out[i].pct > out[j].pct })
+    return out
+}
+
+// formatAncestry renders shares as "European 95.2%, African 4.8%".
+func formatAncestry(shares []ancestryShare) string {
+    parts := make([]string, len(shares))
+    for i, s := range shares {
+        parts[i] = fmt.Sprintf("%s %s%%", s.region, strconv.FormatFloat(s.pct, 'f', -1, 64))
+    }
+    return strings.Join(parts, ", ")
+}
+
+// reportCaveats lists the limitations that apply to one score's result.
+func reportCaveats(t report.Trait, kitType string, gwas []ancestryShare) []string {
+    var out []string
+    if t.Coverage == nil || *t.Coverage < lowCoverage {
+        cov := "few"
+        if t.Coverage != nil {
+            cov = fmt.Sprintf("only %.0f%%", *t.Coverage)
+        }
+        out = append(out, fmt.Sprintf("%s of this score's variants could be scored from a %s kit, so the result may differ substantially from the published score.",
+            strings.ToUpper(cov[:1])+cov[1:], kitType))
+    }
+    if t.Unscored != nil && *t.Unscored >= highUnscoredShare {
+        out = append(out, fmt.Sprintf("The variants that could not be scored are expected to account for about %.0f%% of this score's variation between people, so the percentile is a rough estimate.", *t.Unscored*100))
+    }
+    if t.Z == nil {
+        out = append(out, "No reference distribution was available for this score, so no z-score or percentile is given.")
+    }
+    if len(gwas) > 0 && gwas[0].pct >= 90 {
+        out = append(out, fmt.Sprintf("The score was developed mostly in %s-ancestry samples; scores usually predict less well in people of other ancestries.", gwas[0].region))
+    }
+    if t.Z != nil {
+        out = append(out, "The percentile compares against all 1000 Genomes reference samples, which span several ancestries.")
+    }
+    if t.PctAdj != nil {
+        out = append(out, fmt.Sprintf("Adjusted for genetic ancestry (the reference distribution's drift along principal components), the score is at percentile %.0f.", *t.PctAdj))
+    }
+    if t.GroupPct != nil {
+        out = append(out, fmt.Sprintf("Among the %d %s reference samples, the superpopulation this kit's genetic ancestry was matched to, the score is at percentile %.0f.", t.GroupN, t.Group, *t.GroupPct))
+    }
+    return out
+}
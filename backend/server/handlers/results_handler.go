@@ -2,271 +2,157 @@
 package handlers
 
 import (
-    "bufio"
+    "context"
     "encoding/json"
     "errors"
-    "io"
-    "math"
     "net/http"
-    "os"
-    "path/filepath"
-    "strconv"
+    "log"
+    "sort"
     "strings"
     "sync"
+    "time"
+
+    "github.com/google/uuid"
 
     "github.com/adamwestgate/easy-pgs/backend/data"
-    "github.com/adamwestgate/easy-pgs/backend/config"
+    "github.com/adamwestgate/easy-pgs/backend/events"
+    "github.com/adamwestgate/easy-pgs/backend/pipeline"
     "github.com/adamwestgate/easy-pgs/backend/preprocessing/scoring"
+    "github.com/adamwestgate/easy-pgs/backend/store"
 )
 
-type ScoringResults struct {
-    User map[string]scoring.BatchResult `json:"user"`
-    Pop  map[string]scoring.BatchResult `json:"pop"`
-}
+// ScoringResults holds the user and population outputs of one scoring run.
+type ScoringResults = pipeline.Results
 
-type flatResults struct {
-    Population    map[string]float64 `json:"population"`
-    User          map[string]float64 `json:"user"`
-    Z             map[string]float64 `json:"z"`
-    Pct           map[string]float64 `json:"pct"`
-    Trait         map[string]string  `json:"trait"`
-    PctSnpsScored map[string]float64 `json:"pct_snps_scored"`
-}
+type flatResults = store.Results
 
+// resultsByID caches results in memory; resultStore is the persistent copy.
 var (
     resultsMu   sync.RWMutex
     resultsByID = make(map[string]flatResults)
 )
 
-type stats struct{ mean, sd float64 }
-
-// parseSscoreStats reads a .sscore file at the given path and computes the mean and SD of per-variant averages.
-// It looks for columns SCORE1_AVG (preferred) or SCORE1_SUM/ALLELE_CT.
-func parseSscoreStats(path string) (stats, error) {
-    f, err := os.Open(path)
-    if err != nil {
-        return stats{}, err
-    }
-    defer f.Close()
-
-    sc := bufio.NewScanner(f)
-    if !sc.Scan() {
-        return stats{}, errors.New("empty .sscore")
-    }
-    header := strings.Fields(sc.Text())
-
-    idxAvg, idxSum, idxCt := -1, -1, -1
-    for i, h := range header {
-        switch h {
-        case "SCORE1_AVG":
-            idxAvg = i
-        case "SCORE1_SUM":
-            idxSum = i
-        case "ALLELE_CT":
-            idxCt = i
-        }
-    }
-    if idxAvg == -1 && (idxSum == -1 || idxCt == -1) {
-        return stats{}, errors.New("missing columns")
-    }
-
-    var n int
-    var sum, sumSq float64
-    for sc.Scan() {
-        fields := strings.Fields(sc.Text())
-        var avg float64
-        if idxAvg != -1 {
-            v, err := strconv.ParseFloat(fields[idxAvg], 64)
-            if err != nil {
-                continue
-            }
-            avg = v
-        } else {
-            s, err1 := strconv.ParseFloat(fields[idxSum], 64)
-            c, err2 := strconv.ParseFloat(fields[idxCt], 64)
-            if err1 != nil || err2 != nil || c == 0 {
-                continue
-            }
-            avg = s / c
-        }
-        n++
-        sum += avg
-        sumSq += avg * avg
-    }
-    if err := sc.Err(); err != nil {
-        return stats{}, err
-    }
-    if n == 0 {
-        return stats{}, errors.New("no rows")
-    }
-
-    mean := sum / float64(n)
-    var sd float64
-    if n > 1 {
-        variance := (sumSq - sum*sum/float64(n)) / float64(n-1)
-        if variance > 0 {
-            sd = math.Sqrt(variance)
-        }
-    }
-    return stats{mean, sd}, nil
-}
-
 // ScoreKitWithPGS performs batch scoring of a kit against given PGS weight files.
 // 1. Lookup kit from kitStore
 // 2. Score user data & get list of snps scored
 // 3. Score population on list of snps scored in the user kit
 // Returns ScoringResults containing user and population BatchResult maps.
+// Missing genotypes are mean-imputed from the reference panel. The outputs
+// live in a fresh workspace, left for pipeline.SweepWorkspaces.
 func ScoreKitWithPGS(kitID string, norm []string) (*ScoringResults, error) {
-    prefix, kitType, ok := kitStore.Lookup(kitID)
-    if !ok {
-        return nil, errors.New("kit not found")
-    }
-
-    // 1️⃣ User kit scoring
-    userRaw := scoring.BatchScore(prefix, kitType, norm, "")
-    user := make(map[string]scoring.BatchResult, len(userRaw))
-    for k, v := range userRaw {
-        user[canonicalID(k)] = v
-    }
-
-    // 2️⃣ Choose reference panel
-    popRoot := config.ReferenceAncestryDir
-    if strings.ToLower(kitType) == "23andme" {
-        popRoot = config.Reference23andmeDir
+    ctx := context.Background()
+    ws, err := pipeline.NewWorkspace(kitID)
+    if err != nil {
+        return nil, err
     }
-
-    // 3️⃣ Move .vars → .snplist and gather .rsid.score files
-    varsDst := filepath.Join(popRoot, "scores")
-    _ = os.MkdirAll(varsDst, 0o755)
-    var popWeights []string
-
-    for id, br := range user {
-        if br.Err != nil || br.ScorePath == "" {
-            continue
-        }
-        // .vars → .snplist
-        srcVars := br.ScorePath + ".vars"
-        if _, err := os.Stat(srcVars); err == nil {
-            dst := filepath.Join(varsDst, id+".snplist")
-            if err := os.Rename(srcVars, dst); err != nil {
-                if in, e1 := os.Open(srcVars); e1 == nil {
-                    defer in.Close()
-                    if out, e2 := os.Create(dst); e2 == nil {
-                        defer out.Close()
-                        _, _ = io.Copy(out, in)
-                        _ = os.Remove(srcVars)
-                    }
-                }
-            }
-        }
-        // copy .norm.rsid.score
-        dir := filepath.Dir(br.ScorePath)
-        srcScore := filepath.Join(dir, id+".norm.rsid.score")
-        if _, err := os.Stat(srcScore); err == nil {
-            dst := filepath.Join(varsDst, filepath.Base(srcScore))
-            if _, err2 := os.Stat(dst); err2 != nil {
-                if in, e1 := os.Open(srcScore); e1 == nil {
-                    defer in.Close()
-                    if out, e2 := os.Create(dst); e2 == nil {
-                        defer out.Close()
-                        _, _ = io.Copy(out, in)
-                    }
-                }
-            }
-            popWeights = append(popWeights, dst)
-        }
+    var imp pipeline.Imputation
+    user, kitType, err := scoreKitUser(ctx, kitID, norm, imp, ws, nil)
+    if err != nil {
+        return nil, err
     }
+    return &ScoringResults{User: user, Pop: pipeline.ScorePopulation(ctx, kitType, user, imp, ws, nil), Imputation: imp}, nil
+}
 
-    // 4️⃣ Population scoring on that snplist
-    popRaw := scoring.BatchScore(popRoot, kitType, popWeights, "")
-    pop := make(map[string]scoring.BatchResult, len(popRaw))
-    for k, v := range popRaw {
-        pop[canonicalID(k)] = v
+// scoreKitUser scores the kit itself against the normalized weight files,
+// handling missing genotypes as imp says and writing to ws. Returns the
+// per-PGS results keyed by canonical ID, plus the kit type. Scoring stops
+// when ctx is done; progress is reported to ev (may be nil).
+func scoreKitUser(ctx context.Context, kitID string, norm []string, imp pipeline.Imputation, ws *pipeline.Workspace, ev events.Emitter) (map[string]scoring.BatchResult, string, error) {
+    prefix, kitType, ok := kitStore.Lookup(kitID)
+    if !ok {
+        return nil, "", errors.New("kit not found")
     }
-
-    return &ScoringResults{User: user, Pop: pop}, nil
+    return pipeline.ScoreUser(ctx, prefix, kitType, norm, imp, ws, ev), kitType, nil
 }
 
-// storeResults flattens ScoringResults and caches them in memory under the given kitID.
+// storeResults flattens ScoringResults, caches them in memory under the given
+// kitID and persists them (with the raw stats and input fingerprints) to
+// resultStore as a new run.
 func storeResults(kitID string, r *ScoringResults) {
-    // Determine chip panel
-    _, kitType, ok := kitStore.Lookup(kitID)
-    popRoot := config.ReferenceAncestryDir
-    if ok && strings.ToLower(kitType) == "23andme" {
-        popRoot = config.Reference23andmeDir
+    kitDir, kitType, _ := kitStore.Lookup(kitID)
+    r.KitDir, r.KitType = kitDir, kitType
+    if a, ok := kitAncestry(kitID); ok {
+        r.KitPCs = a.PCs
     }
+    flat, userStats, popStats := pipeline.Flatten(r)
 
-    flat := flatResults{
-        Population:    map[string]float64{},
-        User:          map[string]float64{},
-        Z:             map[string]float64{},
-        Pct:           map[string]float64{},
-        Trait:         map[string]string{},
-        PctSnpsScored: map[string]float64{}, // "Coverage" on the results page
-    }
+    resultsMu.Lock()
+    resultsByID[kitID] = flat
+    resultsMu.Unlock()
 
-    // a) population means & SDs
-    popStats := map[string]stats{}
-    for id, br := range r.Pop {
-        if br.Err == nil && br.ScorePath != "" {
-            if st, err := parseSscoreStats(br.ScorePath); err == nil {
-                popStats[id] = st
-                flat.Population[id] = st.mean
-            }
-        }
-        if _, exist := flat.Trait[id]; !exist {
-            flat.Trait[id] = getTraitLabel(id)
-        }
+    // e) persist
+    if resultStore == nil {
+        return
     }
-
-    // b) user + z / pct
-    for id, br := range r.User {
-        if br.Err == nil && br.ScorePath != "" {
-            if stU, err := parseSscoreStats(br.ScorePath); err == nil {
-                flat.User[id] = stU.mean
-                if stP, ex := popStats[id]; ex && stP.sd > 0 {
-                    z := (stU.mean - stP.mean) / stP.sd
-                    flat.Z[id] = z
-                    flat.Pct[id] = 0.5 * (1 + math.Erf(z/math.Sqrt2))
-                }
-            }
-        }
-        if _, exist := flat.Trait[id]; !exist {
-            flat.Trait[id] = getTraitLabel(id)
-        }
+    ids := scoredIDs(r)
+    rec := store.ResultRecord{
+        RunID:          uuid.NewString(),
+        KitID:          kitID,
+        PGSIDs:         ids,
+        CatalogVersion: data.CatalogVersion,
+        Created:        time.Now().UTC(),
+        Inputs:         runInputs(kitType, ids, r.Imputation),
+        Results:        flat,
+        UserStats:      userStats,
+        PopStats:       popStats,
+    }
+    if err := resultStore.SaveResults(rec); err != nil {
+        log.Printf("storeResults: persisting %s failed: %v", kitID, err)
     }
+}
 
-    // c) cleanup NaN/Inf
-    for _, m := range []map[string]float64{flat.Population, flat.User, flat.Z, flat.Pct} {
-        for k, v := range m {
-            if math.IsNaN(v) || math.IsInf(v, 0) {
-                delete(m, k)
+// scoredIDs lists every PGS ID with a user or population result, sorted.
+func scoredIDs(r *ScoringResults) []string {
+    seen := map[string]bool{}
+    var ids []string
+    for _, m := range []map[string]scoring.BatchResult{r.User, r.Pop} {
+        for id := range m {
+            if !seen[id] {
+                seen[id] = true
+                ids = append(ids, id)
             }
         }
     }
+    sort.Strings(ids)
+    return ids
+}
 
-    // d) percent SNPs scored
-    for id := range flat.User {
-        snpList := filepath.Join(popRoot, "scores", id+".snplist")
-        tsv := filepath.Join(config.PGSFilesDir, id, id+".norm.tsv")
-        flat.PctSnpsScored[id] = snpRetentionPercent(snpList, tsv)
+// fetchResults retrieves results from the memory cache, falling back to
+// resultStore (and caching what it finds) after a restart.
+func fetchResults(kitID string) (flatResults, bool) {
+    resultsMu.RLock()
+    r, ok := resultsByID[kitID]
+    resultsMu.RUnlock()
+    if ok || resultStore == nil {
+        return r, ok
     }
 
+    rec, ok, err := resultStore.LoadResults(kitID)
+    if err != nil {
+        log.Printf("fetchResults: loading %s failed: %v", kitID, err)
+        return r, false
+    }
+    if !ok {
+        return r, false
+    }
     resultsMu.Lock()
-    resultsByID[kitID] = flat
+    resultsByID[kitID] = rec.Results
     resultsMu.Unlock()
+    return rec.Results, true
 }
 
-// fetchResults retrieves cached results
-func fetchResults(kitID string) (flatResults, bool) {
-    resultsMu.RLock()
-    defer resultsMu.RUnlock()
-    r, ok := resultsByID[kitID]
-    return r, ok
+// forgetResults drops the cached results of kitID.
+func forgetResults(kitID string) {
+    resultsMu.Lock()
+    delete(resultsByID, kitID)
+    resultsMu.Unlock()
 }
 
-// ResultsHandler handles HTTP GET requests to /results?kitId=<id>.
+// ResultsHandler handles HTTP GET requests to /results?kitId=<id>[&group=<group>].
 // It returns cached scoring results in JSON, or an error if not found or wrong method.
+// group (e.g. "EUR" or "EUR:female") marks the reader's comparison group; it
+// defaults to the kit's inferred superpopulation when that is confident, and
+// to every reference sample pooled otherwise.
 func ResultsHandler(w http.ResponseWriter, r *http.Request) {
     if r.Method == http.MethodOptions {
         w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
@@ -282,70 +168,37 @@ func ResultsHandler(w http.ResponseWriter, r *http.Request) {
         http.Error(w, "kitId query param required", http.StatusBadRequest)
         return
     }
-    if res, ok := fetchResults(kitID); ok {
-        w.Header().Set("Content-Type", "application/json")
-        _ = json.NewEncoder(w).Encode(res)
+    res, ok := fetchResults(kitID)
+    if !ok {
+        http.Error(w, "results not ready", http.StatusNotFound)
         return
     }
-    http.Error(w, "results not ready", http.StatusNotFound)
-}
-
-// canonicalID strips any suffix after the first "." in a PGS ID string.
-func canonicalID(raw string) string {
-    if dot := strings.IndexByte(raw, '.'); dot >= 0 {
-        return raw[:dot]
-    }
-    return raw
-}
-
-// getTraitLabel looks up a PGS ID in loaded data to find its reported trait label.
-func getTraitLabel(pgsID string) string {
-    idClean := canonicalID(pgsID)
-    for _, m := range data.LoadedScores {
-        if id, _ := m["Polygenic Score (PGS) ID"].(string); id == idClean {
-            if trait, ok := m["Reported Trait"].(string); ok {
-                return trait
-            }
-            if trait, ok := m["Reported trait"].(string); ok {
-                return trait
-            }
+    if q := r.URL.Query().Get("group"); q != "" {
+        group, ok := comparisonGroup(res, q)
+        if !ok {
+            http.Error(w, "unknown comparison group "+q, http.StatusBadRequest)
+            return
         }
-    }
-    for _, tr := range data.LoadedTraits {
-        for _, pid := range tr.PGSFiles {
-            if pid == idClean {
-                return tr.Label
-            }
+        res.Comparison = group
+    } else if a, ok := kitAncestry(kitID); ok {
+        // default to the kit's inferred superpopulation when it is clear
+        if group, ok := comparisonGroup(res, pipeline.ConfidentSuperPop(a)); ok && group != pipeline.ComparisonAll {
+            res.Comparison = group
         }
     }
-    return ""
+    w.Header().Set("Content-Type", "application/json")
+    _ = json.NewEncoder(w).Encode(res)
 }
 
-// snpRetentionPercent calculates the percentage of SNPs in snpList versus lines in TSV (minus header). This is to get the Coverage stat for the results page.
-func snpRetentionPercent(snplistPath, tsvPath string) float64 {
-    snpF, err := os.Open(snplistPath)
-    if err != nil {
-        return 0
-    }
-    defer snpF.Close()
-    tsvF, err := os.Open(tsvPath)
-    if err != nil {
-        return 0
-    }
-    defer tsvF.Close()
-
-    count := func(sc *bufio.Scanner) int {
-        n := 0
-        for sc.Scan() {
-            n++
+// comparisonGroup canonicalises a group query such as "eur:Female" and
+// reports whether any score of res has that group.
+func comparisonGroup(res flatResults, q string) (string, bool) {
+    pop, sex, _ := strings.Cut(q, ":")
+    group := pipeline.ComparisonGroup(strings.ToUpper(pop), strings.ToLower(sex))
+    for _, groups := range res.Groups {
+        if _, ok := groups[group]; ok {
+            return group, true
         }
-        return n
-    }
-
-    snpCount := count(bufio.NewScanner(snpF))
-    tsvCount := count(bufio.NewScanner(tsvF))
-    if tsvCount <= 1 {
-        return 0
     }
-    return float64(snpCount) / float64(tsvCount-1) * 100.0
+    return "", false
 }
@@ -0,0 +1,314 @@
+// backend/server/handlers/runs_handler.go
+package handlers
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "path/filepath"
+    "runtime/debug"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/gorilla/mux"
+
+    "github.com/adamwestgate/easy-pgs/backend/config"
+    "github.com/adamwestgate/easy-pgs/backend/pipeline"
+    "github.com/adamwestgate/easy-pgs/backend/store"
+)
+
+// RunSummary is one entry of GET /kits/{id}/runs.
+type RunSummary struct {
+    RunID          string          `json:"runId"`
+    Created        time.Time       `json:"created"`
+    PGSIDs         []string        `json:"pgsIds"`
+    CatalogVersion string          `json:"catalogVersion"`
+    Inputs         store.RunInputs `json:"inputs"`
+}
+
+// ValueChange is one metric in run a and run b; nil where a run lacks it.
+type ValueChange struct {
+    A     *float64 `json:"a"`
+    B     *float64 `json:"b"`
+    Delta *float64 `json:"delta,omitempty"`
+}
+
+// PGSDiff compares one score between two runs.
+type PGSDiff struct {
+    PGSID            string      `json:"pgsId"`
+    Trait            string      `json:"trait"`
+    Z                ValueChange `json:"z"`
+    Pct              ValueChange `json:"pct"`
+    Coverage         ValueChange `json:"coverage"`
+    ScoreFileChanged bool        `json:"scoreFileChanged"`
+}
+
+// InputChanges flags which inputs differ between two runs.
+type InputChanges struct {
+    Catalog     bool     `json:"catalog"`
+    Panel       bool     `json:"panel"`
+    Manifest    bool     `json:"manifest"`
+    CodeVersion bool     `json:"codeVersion"`
+    Engine      bool     `json:"engine"`
+    Imputation  bool     `json:"imputation"`
+    ScoreFiles  []string `json:"scoreFiles"` // PGS IDs whose scoring file checksum changed
+}
+
+// RunDiff is the response of GET /kits/{id}/runs/{a}/diff/{b}.
+type RunDiff struct {
+    A       RunSummary   `json:"a"`
+    B       RunSummary   `json:"b"`
+    Changed InputChanges `json:"changed"`
+    Scores  []PGSDiff    `json:"scores"`
+}
+
+// RunsHandler handles GET /kits/{id}/runs and lists every scoring run of
+// the kit, newest first.
+func RunsHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusOK)
+        return
+    }
+    if resultStore == nil {
+        http.Error(w, "server mis-config: resultStore not set", http.StatusInternalServerError)
+        return
+    }
+    runs, err := resultStore.ListRuns(mux.Vars(r)["id"])
+    if err != nil {
+        http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+        return
+    }
+    out := make([]RunSummary, 0, len(runs))
+    for i := len(runs) - 1; i >= 0; i-- {
+        out = append(out, runSummary(runs[i]))
+    }
+    writeJSON(w, out)
+}
+
+// RunDiffHandler handles GET /kits/{id}/runs/{a}/diff/{b}. It reports the
+// per-PGS change in z-score, percentile and coverage from run a to run b,
+// and which inputs changed in between.
+func RunDiffHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusOK)
+        return
+    }
+    if resultStore == nil {
+        http.Error(w, "server mis-config: resultStore not set", http.StatusInternalServerError)
+        return
+    }
+    vars := mux.Vars(r)
+    a, okA, errA := resultStore.LoadRun(vars["id"], vars["a"])
+    b, okB, errB := resultStore.LoadRun(vars["id"], vars["b"])
+    if errA != nil || errB != nil {
+        http.Error(w, "store error", http.StatusInternalServerError)
+        return
+    }
+    if !okA || !okB {
+        http.Error(w, "run not found", http.StatusNotFound)
+        return
+    }
+    writeJSON(w, diffRuns(a, b))
+}
+
+// diffRuns compares run a against run b.
+func diffRuns(a, b store.ResultRecord) RunDiff {
+    d := RunDiff{
+        A: runSummary(a),
+        B: runSummary(b),
+        Changed: InputChanges{
+            Catalog:     a.CatalogVersion != b.CatalogVersion,
+            Panel:       a.Inputs.Panel != b.Inputs.Panel,
+            Manifest:    a.Inputs.Manifest != b.Inputs.Manifest,
+            CodeVersion: a.Inputs.CodeVersion != b.Inputs.CodeVersion,
+            Engine:      a.Inputs.Engine != b.Inputs.Engine,
+            Imputation:  imputationOf(a) != imputationOf(b),
+            ScoreFiles:  []string{},
+        },
+    }
+
+    ids := map[string]bool{}
+    for _, id := range a.PGSIDs {
+        ids[id] = true
+    }
+    for _, id := range b.PGSIDs {
+        ids[id] = true
+    }
+    sorted := make([]string, 0, len(ids))
+    for id := range ids {
+        sorted = append(sorted, id)
+    }
+    sort.Strings(sorted)
+
+    for _, id := range sorted {
+        sumA, inA := a.Inputs.ScoreFiles[id]
+        sumB, inB := b.Inputs.ScoreFiles[id]
+        fileChanged := inA && inB && sumA != sumB
+        if fileChanged {
+            d.Changed.ScoreFiles = append(d.Changed.ScoreFiles, id)
+        }
+        trait := b.Results.Trait[id]
+        if trait == "" {
+            trait = a.Results.Trait[id]
+        }
+        d.Scores = append(d.Scores, PGSDiff{
+            PGSID:            id,
+            Trait:            trait,
+            Z:                valueChange(a.Results.Z, b.Results.Z, id),
+            Pct:              valueChange(a.Results.Pct, b.Results.Pct, id),
+            Coverage:         valueChange(a.Results.PctSnpsScored, b.Results.PctSnpsScored, id),
+            ScoreFileChanged: fileChanged,
+        })
+    }
+    return d
+}
+
+// imputationOf returns how rec handled missing genotypes; runs recorded
+// before the choice existed mean-imputed from the reference panel.
+func imputationOf(rec store.ResultRecord) string {
+    if rec.Inputs.Imputation == "" {
+        return pipeline.ImputeReference
+    }
+    return rec.Inputs.Imputation
+}
+
+// valueChange picks id from both maps and computes b − a when both exist.
+func valueChange(a, b map[string]float64, id string) ValueChange {
+    var c ValueChange
+    if v, ok := a[id]; ok {
+        c.A = &v
+    }
+    if v, ok := b[id]; ok {
+        c.B = &v
+    }
+    if c.A != nil && c.B != nil {
+        delta := *c.B - *c.A
+        c.Delta = &delta
+    }
+    return c
+}
+
+func runSummary(rec store.ResultRecord) RunSummary {
+    return RunSummary{
+        RunID:          rec.RunID,
+        Created:        rec.Created,
+        PGSIDs:         rec.PGSIDs,
+        CatalogVersion: rec.CatalogVersion,
+        Inputs:         rec.Inputs,
+    }
+}
+
+// runInputs fingerprints the inputs of a run of a kitType kit on pgsIDs.
+func runInputs(kitType string, pgsIDs []string, imp pipeline.Imputation) store.RunInputs {
+    in := store.RunInputs{
+        ScoreFiles:  make(map[string]string, len(pgsIDs)),
+        Panel:       dirFingerprint(pipeline.PanelDir(kitType)),
+        Manifest:    dirFingerprint(pipeline.ManifestDir(kitType)),
+        CodeVersion: codeVersion(),
+        Engine:      fmt.Sprintf("user=%s pop=%s", engineName(config.NativeUserScoring), engineName(config.NativePopulationScoring)),
+        Imputation:  imp.String(),
+    }
+    for _, id := range pgsIDs {
+        if gz := pipeline.ScoreFileGz(id); gz != "" {
+            if sum, err := fileChecksum(gz); err == nil {
+                in.ScoreFiles[id] = sum
+            }
+        }
+    }
+    return in
+}
+
+func engineName(native bool) string {
+    if native {
+        return "native"
+    }
+    return "plink2"
+}
+
+// codeVersion returns the VCS revision the binary was built from, if known.
+func codeVersion() string {
+    info, ok := debug.ReadBuildInfo()
+    if !ok {
+        return "unknown"
+    }
+    rev, dirty := "", false
+    for _, s := range info.Settings {
+        switch s.Key {
+        case "vcs.revision":
+            rev = s.Value
+        case "vcs.modified":
+            dirty = s.Value == "true"
+        }
+    }
+    if rev == "" {
+        return info.Main.Version
+    }
+    if dirty {
+        rev += "-dirty"
+    }
+    return rev
+}
+
+// dirFingerprint hashes the names, sizes and modification times of the
+// regular files directly inside dir. Cheap enough for multi-GB panels, and
+// changes whenever a panel or manifest is rebuilt.
+func dirFingerprint(dir string) string {
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        return ""
+    }
+    h := sha256.New()
+    for _, e := range entries {
+        info, err := e.Info()
+        if err != nil || !info.Mode().IsRegular() {
+            continue
+        }
+        fmt.Fprintf(h, "%s\t%d\t%d\n", e.Name(), info.Size(), info.ModTime().UnixNano())
+    }
+    return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+var (
+    checksumMu    sync.Mutex
+    checksumCache = map[string]cachedChecksum{}
+)
+
+type cachedChecksum struct {
+    size, mtime int64
+    sum         string
+}
+
+// fileChecksum returns the SHA-256 of path, cached by size and mtime.
+func fileChecksum(path string) (string, error) {
+    st, err := os.Stat(path)
+    if err != nil {
+        return "", err
+    }
+    path = filepath.Clean(path)
+    checksumMu.Lock()
+    c, ok := checksumCache[path]
+    checksumMu.Unlock()
+    if ok && c.size == st.Size() && c.mtime == st.ModTime().UnixNano() {
+        return c.sum, nil
+    }
+
+    f, err := os.Open(path)
+    if err != nil {
+        return "", err
+    }
+    defer f.Close()
+    h := sha256.New()
+    if _, err := io.Copy(h, f); err != nil {
+        return "", err
+    }
+    sum := hex.EncodeToString(h.Sum(nil))
+
+    checksumMu.Lock()
+    checksumCache[path] = cachedChecksum{st.Size(), st.ModTime().UnixNano(), sum}
+    checksumMu.Unlock()
+    return sum, nil
+}
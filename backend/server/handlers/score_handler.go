@@ -0,0 +1,361 @@
+// backend/server/handlers/score_handler.go
+package handlers
+
+import (
+    "bufio"
+    "compress/gzip"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+
+    "github.com/gorilla/mux"
+
+    "github.com/adamwestgate/easy-pgs/backend/config"
+    "github.com/adamwestgate/easy-pgs/backend/data"
+    "github.com/adamwestgate/easy-pgs/backend/pipeline"
+    "github.com/adamwestgate/easy-pgs/backend/preprocessing/pgen"
+    "github.com/adamwestgate/easy-pgs/backend/preprocessing/pgs_convert"
+)
+
+// ScoreDetail is the shape returned by GET /scores/{pgsId}.
+type ScoreDetail struct {
+    ID              string                   `json:"id"`
+    Metadata        map[string]interface{}   `json:"metadata"`
+    Citation        string                   `json:"citation"`
+    Publication     map[string]interface{}   `json:"publication,omitempty"`
+    Performance     []map[string]interface{} `json:"performance"`
+    Downloaded      bool                     `json:"downloaded"`
+    Header          map[string]string        `json:"header,omitempty"`
+    VariantCount    int                      `json:"variantCount"`
+    VariantsByChrom map[string]int           `json:"variantsByChrom,omitempty"`
+    ChipCoverage    map[string]float64       `json:"chipCoverage,omitempty"`
+}
+
+// supportedChips lists the chip manifests coverage is predicted for.
+var supportedChips = []struct {
+    Name        string // key in ScoreDetail.ChipCoverage
+    KitType     string
+    ManifestDir string
+    PanelDir    string
+}{
+    {"23andme_v5", "23andme", config.ChipManifestV5Dir, config.Reference23andmeDir},
+    {"ancestry_v2", "ancestry", config.ChipManifestAncestryDir, config.ReferenceAncestryDir},
+}
+
+// ScoreDetailHandler handles GET /scores/{pgsId}.
+// It returns catalog metadata, publication and performance metrics for a single score.
+// If the scoring file is already downloaded it also returns the scoring-file header,
+// variant counts per chromosome and the fraction of the score's variants present on
+// each supported chip, so coverage can be predicted before scoring.
+// It never downloads anything; POST /scores/{pgsId}/fetch does.
+func ScoreDetailHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusOK)
+        return
+    }
+    serveScoreDetail(w, mux.Vars(r)["pgsId"], false)
+}
+
+// ScoreFetchHandler handles POST /scores/{pgsId}/fetch: it downloads and
+// normalizes the scoring file if needed and returns the same body as
+// GET /scores/{pgsId}, including the scoring-file details.
+func ScoreFetchHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusOK)
+        return
+    }
+    serveScoreDetail(w, mux.Vars(r)["pgsId"], true)
+}
+
+// serveScoreDetail writes the ScoreDetail of pgsID. The scoring-file
+// details are filled in when the file is local, or after fetching it if fetch is set.
+func serveScoreDetail(w http.ResponseWriter, pgsID string, fetch bool) {
+    meta := pipeline.FindScoreMeta(pgsID)
+    if meta == nil {
+        http.Error(w, "score not found", http.StatusNotFound)
+        return
+    }
+
+    detail := ScoreDetail{
+        ID:          pgsID,
+        Metadata:    meta,
+        Performance: []map[string]interface{}{},
+    }
+    detail.Publication = findPublication(meta)
+    detail.Citation = formatCitation(meta, detail.Publication)
+    for _, pm := range data.LoadedPerformance {
+        if id, _ := pm["Evaluated Score"].(string); id == pgsID {
+            detail.Performance = append(detail.Performance, pm)
+        }
+    }
+
+    // Scoring-file details only when the file is local or explicitly fetched
+    gzPath := pipeline.ScoreFileGz(pgsID)
+    if gzPath == "" && !fetch {
+        writeJSON(w, detail)
+        return
+    }
+    normPath, err := ensureNormalized(pgsID)
+    if err != nil {
+        log.Printf("serveScoreDetail: %s: %v", pgsID, err)
+        if fetch {
+            http.Error(w, "fetch failed: "+err.Error(), http.StatusBadGateway)
+            return
+        }
+        writeJSON(w, detail)
+        return
+    }
+    detail.Downloaded = true
+
+    if hdr, err := readScoreHeader(pipeline.ScoreFileGz(pgsID)); err == nil {
+        detail.Header = hdr
+    }
+
+    chips := make([]*chipVariants, len(supportedChips))
+    for i, c := range supportedChips {
+        chips[i] = loadChipVariants(c.Name, c.KitType, c.ManifestDir, c.PanelDir)
+    }
+    onChip := make([]int, len(chips))
+    detail.VariantsByChrom = map[string]int{}
+    err = scanScoreVariants(normPath, func(chr, pos, rsid string) {
+        detail.VariantCount++
+        if chr == "" {
+            for _, c := range chips {
+                if ch, ok := c.chromOf(rsid); ok {
+                    chr = ch
+                    break
+                }
+            }
+        }
+        if chr == "" {
+            chr = "unknown"
+        }
+        detail.VariantsByChrom[chr]++
+        for i, c := range chips {
+            if c.has(chr, pos, rsid) {
+                onChip[i]++
+            }
+        }
+    })
+    if err != nil {
+        log.Printf("serveScoreDetail: scanning %s: %v", normPath, err)
+    }
+
+    if detail.VariantCount > 0 {
+        detail.ChipCoverage = map[string]float64{}
+        for i, c := range supportedChips {
+            if chips[i].loaded() {
+                detail.ChipCoverage[c.Name] = float64(onChip[i]) / float64(detail.VariantCount)
+            }
+        }
+    }
+    writeJSON(w, detail)
+}
+
+// writeJSON encodes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(v)
+}
+
+// ensureNormalized returns the .norm.tsv for pgsID, downloading and
+// normalizing it first if needed.
+func ensureNormalized(pgsID string) (string, error) {
+    if norm := pipeline.NormalizedFile(pgsID); norm != "" {
+        return norm, nil
+    }
+    return pipeline.EnsureScoreFile(context.Background(), pgsID, nil)
+}
+
+// readScoreHeader parses the "#key=value" header of a downloaded scoring file.
+func readScoreHeader(gzPath string) (map[string]string, error) {
+    f, err := os.Open(gzPath)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    gz, err := gzip.NewReader(f)
+    if err != nil {
+        return nil, err
+    }
+    defer gz.Close()
+    return pgs_convert.ReadHeader(gz)
+}
+
+// scanScoreVariants calls fn for every variant row of a normalized score file.
+// For 4-column files rsid is empty; for 3-column rsID files chr and pos are empty.
+func scanScoreVariants(normPath string, fn func(chr, pos, rsid string)) error {
+    f, err := os.Open(normPath)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    sc := bufio.NewScanner(f)
+    if !sc.Scan() {
+        return fmt.Errorf("empty score file")
+    }
+    byPos := strings.HasPrefix(sc.Text(), "chr_name")
+    for sc.Scan() {
+        cols := strings.Split(sc.Text(), "\t")
+        if byPos && len(cols) >= 2 {
+            fn(strings.TrimPrefix(cols[0], "chr"), cols[1], "")
+        } else if !byPos && len(cols) >= 1 {
+            fn("", "", cols[0])
+        }
+    }
+    return sc.Err()
+}
+
+// findPublication returns the publications.json row referenced by a score, or nil.
+func findPublication(meta map[string]interface{}) map[string]interface{} {
+    pgp, _ := meta["PGS Publication (PGP) ID"].(string)
+    if pgp == "" {
+        return nil
+    }
+    for _, p := range data.LoadedPublications {
+        if id, _ := p["PGS Publication/Study (PGP) ID"].(string); id == pgp {
+            return p
+        }
+    }
+    return nil
+}
+
+// formatCitation builds a short "Author et al. (date) Title. Journal. doi:…" string,
+// falling back to the PMID/DOI columns of the score metadata.
+func formatCitation(meta, pub map[string]interface{}) string {
+    str := func(m map[string]interface{}, k string) string {
+        v, _ := m[k].(string)
+        return strings.TrimSpace(v)
+    }
+    doi := str(meta, "Publication (doi)")
+    pmid := str(meta, "Publication (PMID)")
+    if pub == nil {
+        var parts []string
+        if doi != "" {
+            parts = append(parts, "doi:"+doi)
+        }
+        if pmid != "" {
+            parts = append(parts, "PMID:"+pmid)
+        }
+        return strings.Join(parts, " ")
+    }
+
+    var b strings.Builder
+    if a := str(pub, "First Author"); a != "" {
+        b.WriteString(a + " et al. ")
+    }
+    if d := str(pub, "Publication Date"); d != "" {
+        b.WriteString("(" + d + ") ")
+    }
+    if t := str(pub, "Title"); t != "" {
+        b.WriteString(strings.TrimSuffix(t, ".") + ". ")
+    }
+    if j := str(pub, "Journal Name"); j != "" {
+        b.WriteString(j + ". ")
+    }
+    if doi != "" {
+        b.WriteString("doi:" + doi)
+    } else if pmid != "" {
+        b.WriteString("PMID:" + pmid)
+    }
+    return strings.TrimSpace(b.String())
+}
+
+// chipVariants holds the variant identifiers known to be on one chip.
+type chipVariants struct {
+    ids   map[string]struct{} // rsIDs from the chip manifest
+    panel *pgen.Index         // the chip's reference panel variants; nil if missing
+}
+
+// has reports whether the variant is on the chip, by rsID or by position.
+func (c *chipVariants) has(chr, pos, rsid string) bool {
+    if rsid != "" {
+        if _, ok := c.ids[rsid]; ok {
+            return true
+        }
+        _, ok := c.chromOf(rsid)
+        return ok
+    }
+    if c.panel == nil {
+        return false
+    }
+    p, err := strconv.Atoi(pos)
+    return err == nil && len(c.panel.Lookup(chr, p)) > 0
+}
+
+// chromOf returns the chromosome of rsid in the reference panel.
+func (c *chipVariants) chromOf(rsid string) (string, bool) {
+    if c.panel == nil {
+        return "", false
+    }
+    v, ok := c.panel.ByID(rsid)
+    return v.Chr, ok
+}
+
+// loaded reports whether any manifest or panel data was found for the chip.
+func (c *chipVariants) loaded() bool {
+    return len(c.ids) > 0 || (c.panel != nil && c.panel.Len() > 0)
+}
+
+// chipEntry caches one chip's variants. Its own mutex keeps a cold load of
+// one chip from blocking lookups of the others.
+type chipEntry struct {
+    mu sync.Mutex
+    c  *chipVariants
+}
+
+var (
+    chipCacheMu sync.Mutex // guards the map only, never held while loading
+    chipCache   = map[string]*chipEntry{}
+)
+
+// loadChipVariants reads (once) the manifest .snplist and the variant index
+// of the chip's reference panel. Missing files yield an empty set rather than
+// an error, and are retried on the next call.
+func loadChipVariants(name, kitType, manifestDir, panelDir string) *chipVariants {
+    chipCacheMu.Lock()
+    e, ok := chipCache[name]
+    if !ok {
+        e = &chipEntry{}
+        chipCache[name] = e
+    }
+    chipCacheMu.Unlock()
+
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    if e.c != nil {
+        return e.c
+    }
+
+    c := &chipVariants{ids: map[string]struct{}{}}
+    if f, err := os.Open(filepath.Join(manifestDir, kitType+".snplist")); err == nil {
+        sc := bufio.NewScanner(f)
+        for sc.Scan() {
+            if id := strings.TrimSpace(sc.Text()); id != "" {
+                c.ids[id] = struct{}{}
+            }
+        }
+        f.Close()
+    }
+    pvars, _ := filepath.Glob(filepath.Join(panelDir, "*.pvar"))
+    if len(pvars) > 0 {
+        if idx, err := pgen.LoadIndex(strings.TrimSuffix(pvars[0], ".pvar")); err == nil {
+            c.panel = idx
+        } else {
+            log.Printf("loadChipVariants: %s panel: %v", name, err)
+        }
+    }
+
+    if c.loaded() {
+        e.c = c
+    }
+    return c
+}
@@ -20,6 +20,8 @@ type TraitResult struct {
 
 // SearchHandler returns ontology traits plus PGS metadata from the catalog json files.
 // Omits GRCh38 files for now as consumer dna kits use an older build format.
+// With ?descendants=true a matching parent trait also lists the scores of all its descendant traits;
+// that mode needs a non-empty q, since an empty query would match the whole ontology.
 func SearchHandler(w http.ResponseWriter, r *http.Request) {
     // CORS pre‑flight
     if r.Method == http.MethodOptions {
@@ -28,30 +30,45 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
     }
 
     q := strings.TrimSpace(strings.ToLower(r.URL.Query().Get("q")))
+    includeDescendants := r.URL.Query().Get("descendants") == "true"
+    if includeDescendants && q == "" {
+        http.Error(w, "q is required with descendants=true", http.StatusBadRequest)
+        return
+    }
     var results []TraitResult
+    seen := map[string]bool{}
+
+    // With descendants the query is resolved against the ontology first, so
+    // parent terms without scores of their own are still found
+    if includeDescendants {
+        for _, term := range data.MatchOntologyTerms(q) {
+            pgsIDs := data.RolledUpPGS(term.ID)
+            if len(pgsIDs) == 0 {
+                continue
+            }
+            res := TraitResult{ID: term.ID, Label: term.Label, Metadata: collectMetadata(pgsIDs)}
+            if trait, ok := data.TraitByID(term.ID); ok {
+                res.Label, res.Description, res.URL = trait.Label, trait.Description, trait.URL
+            }
+            seen[term.ID] = true
+            results = append(results, res)
+        }
+    }
 
     for _, trait := range data.LoadedTraits {
+        if seen[trait.ID] {
+            continue
+        }
         if strings.Contains(strings.ToLower(trait.Label), q) ||
             strings.Contains(strings.ToLower(trait.Description), q) ||
             strings.Contains(strings.ToLower(trait.ID), q) {
 
-            var metas []map[string]interface{}
-
-            // Collect all PGS metadata rows linked to this trait, skipping GRCh38 builds
-            for _, pgsID := range trait.PGSFiles {
-                for _, meta := range data.LoadedScores {
-                    if id, ok := meta["Polygenic Score (PGS) ID"].(string); ok && id == pgsID {
-                        // If the metadata explicitly states GRCh38, skip it --> Ancestry and 23andMe only use GRCh37
-                        if build, ok := meta["Original Genome Build"].(string); ok && strings.EqualFold(build, "GRCh38") {
-                            continue
-                        }
-                        if build, ok := meta["Genome Build"].(string); ok && strings.EqualFold(build, "GRCh38") {
-                            continue
-                        }
-                        metas = append(metas, meta)
-                    }
-                }
+            // Optionally roll up scores from descendant traits in the hierarchy
+            pgsIDs := trait.PGSFiles
+            if includeDescendants {
+                pgsIDs = data.RolledUpPGS(trait.ID)
             }
+            metas := collectMetadata(pgsIDs)
 
             results = append(results, TraitResult{
                 ID:          trait.ID,
@@ -66,3 +83,23 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
 }
+
+// collectMetadata gathers the catalog metadata rows for the given PGS IDs, skipping GRCh38 builds.
+func collectMetadata(pgsIDs []string) []map[string]interface{} {
+    var metas []map[string]interface{}
+    for _, pgsID := range pgsIDs {
+        for _, meta := range data.LoadedScores {
+            if id, ok := meta["Polygenic Score (PGS) ID"].(string); ok && id == pgsID {
+                // If the metadata explicitly states GRCh38, skip it --> Ancestry and 23andMe only use GRCh37
+                if build, ok := meta["Original Genome Build"].(string); ok && strings.EqualFold(build, "GRCh38") {
+                    continue
+                }
+                if build, ok := meta["Genome Build"].(string); ok && strings.EqualFold(build, "GRCh38") {
+                    continue
+                }
+                metas = append(metas, meta)
+            }
+        }
+    }
+    return metas
+}
@@ -2,32 +2,62 @@
 package handlers
 
 import (
-    "encoding/json"
     "net/http"
-    "sync"
 )
 
-// serverStatus holds the current processing stage.
-var (
-    statusMu     sync.RWMutex
-    currentStage = "downloading"
-)
+// StatusHandler reports a job's stage in JSON. It is a view over the job
+// registry: ?jobId= selects a job and ?kitId= the kit's most recent job; one
+// of them is required, so a caller only sees the jobs of its own kit. The
+// "jobs" list summarises that kit's known jobs, newest first.
+func StatusHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusOK)
+        return
+    }
+    q := r.URL.Query()
 
-// SetStatus can be called by other packages to update the stage.
-func SetStatus(stage string) {
-    statusMu.Lock()
-    defer statusMu.Unlock()
-    currentStage = stage
-}
+    var current *JobStatus
+    kitID := q.Get("kitId")
+    if id := q.Get("jobId"); id != "" {
+        j, ok := lookupJob(id)
+        if !ok || (kitID != "" && j.KitID != kitID) {
+            http.Error(w, "job not found", http.StatusNotFound)
+            return
+        }
+        snap := j.snapshot()
+        current = &snap
+        kitID = snap.KitID
+    } else if kitID == "" {
+        http.Error(w, "kitId or jobId is required", http.StatusBadRequest)
+        return
+    }
 
-// StatusHandler returns the server's current processing stage in JSON.
-func StatusHandler(w http.ResponseWriter, r *http.Request) {
-    statusMu.RLock()
-    stage := currentStage
-    statusMu.RUnlock()
+    summary := []map[string]string{}
+    for _, j := range recentJobs() {
+        if j.KitID != kitID {
+            continue
+        }
+        if current == nil {
+            j := j
+            current = &j
+        }
+        summary = append(summary, map[string]string{
+            "id":    j.ID,
+            "kitId": j.KitID,
+            "stage": j.Stage,
+        })
+    }
 
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]string{
-        "stage": stage,
-    })
+    resp := map[string]interface{}{
+        "stage": StageQueued, // nothing submitted yet
+        "jobs":  summary,
+    }
+    if current != nil {
+        resp["stage"] = current.Stage
+        resp["jobId"] = current.ID
+        if current.Error != "" {
+            resp["error"] = current.Error
+        }
+    }
+    writeJSON(w, resp)
 }
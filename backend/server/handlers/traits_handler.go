@@ -0,0 +1,88 @@
+// backend/server/handlers/traits_handler.go
+package handlers
+
+import (
+    "encoding/json"
+    "net/http"
+
+    "github.com/gorilla/mux"
+
+    "github.com/adamwestgate/easy-pgs/backend/data"
+)
+
+// TraitNode is a compact reference to a neighbouring trait in the hierarchy.
+type TraitNode struct {
+    ID            string `json:"id"`
+    Label         string `json:"label"`
+    PGSCount      int    `json:"pgsCount"`
+    RolledUpCount int    `json:"rolledUpPgsCount"`
+}
+
+// TraitDetail is the shape returned by GET /traits/{id}.
+type TraitDetail struct {
+    ID            string      `json:"id"`
+    Label         string      `json:"label"`
+    Description   string      `json:"description"`
+    URL           string      `json:"url"`
+    PGSFiles      []string    `json:"pgsIds"`
+    RolledUpPGS   []string    `json:"rolledUpPgsIds"`
+    RolledUpCount int         `json:"rolledUpPgsCount"`
+    Ancestors     []TraitNode `json:"ancestors"`
+    Children      []TraitNode `json:"children"`
+}
+
+// TraitHandler handles GET /traits/{id}.
+// It returns the trait with its ancestors, direct children and PGS counts
+// rolled up over all descendant traits. Traits that only exist in the
+// ontology hierarchy (no scores of their own) are still returned.
+func TraitHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusOK)
+        return
+    }
+
+    id := mux.Vars(r)["id"]
+    trait, inCatalog := data.TraitByID(id)
+    term, inOntology := data.OntologyTermByID(id)
+    if !inCatalog && !inOntology {
+        http.Error(w, "trait not found", http.StatusNotFound)
+        return
+    }
+
+    detail := TraitDetail{
+        ID:          trait.ID,
+        Label:       trait.Label,
+        Description: trait.Description,
+        URL:         trait.URL,
+        PGSFiles:    trait.PGSFiles,
+        RolledUpPGS: data.RolledUpPGS(id),
+        Ancestors:   []TraitNode{},
+        Children:    []TraitNode{},
+    }
+    if !inCatalog {
+        detail.ID, detail.Label, detail.PGSFiles = term.ID, term.Label, []string{}
+    }
+    detail.RolledUpCount = len(detail.RolledUpPGS)
+
+    for _, aid := range data.Ancestors(id) {
+        detail.Ancestors = append(detail.Ancestors, traitNode(aid))
+    }
+    if inOntology {
+        for _, cid := range term.Children {
+            detail.Children = append(detail.Children, traitNode(cid))
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(detail)
+}
+
+// traitNode builds the summary entry for a related trait.
+func traitNode(id string) TraitNode {
+    node := TraitNode{ID: id, Label: data.TermLabel(id)}
+    if t, ok := data.TraitByID(id); ok {
+        node.PGSCount = len(t.PGSFiles)
+    }
+    node.RolledUpCount = len(data.RolledUpPGS(id))
+    return node
+}
@@ -18,6 +18,7 @@ import (
 
     "github.com/adamwestgate/easy-pgs/backend/preprocessing/kit_convert"
     "github.com/adamwestgate/easy-pgs/backend/config"
+    "github.com/adamwestgate/easy-pgs/backend/pipeline"
 )
 
 // Directories for user kits
@@ -95,6 +96,17 @@ func UploadKitHandler(w http.ResponseWriter, r *http.Request) {
     }
     log.Printf("✓  stored mapping %s → %s (type=%s)\n", kitKey, processedDir, kitType)
 
+    // 6b. Infer genetic ancestry; the kit stays usable without it
+    superPop := ""
+    if anc, err := pipeline.InferAncestry(processedDir, kitType); err != nil {
+        log.Printf("•  ancestry not inferred: %v\n", err)
+    } else if err := kitStore.SetAncestry(kitKey, anc); err != nil {
+        log.Printf("•  ancestry not stored: %v\n", err)
+    } else {
+        superPop = anc.SuperPop
+        log.Printf("✓  ancestry %s (p=%.2f)\n", anc.SuperPop, anc.Probabilities[anc.SuperPop])
+    }
+
 
 	// 7. JSON response
 	w.Header().Set("Content-Type", "application/json")
@@ -102,6 +114,7 @@ func UploadKitHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{
 		"kit_id":        kitKey,
 		"kit_type":      kitType,
+		"superpop":      superPop,
 	})
 	log.Printf("⇠  upload complete, kit_id=%s\n", kitKey)
 
@@ -0,0 +1,154 @@
+// backend/server/handlers/variants_handler.go
+package handlers
+
+import (
+    "context"
+    "log"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+
+    "github.com/gorilla/mux"
+
+    "github.com/adamwestgate/easy-pgs/backend/config"
+    "github.com/adamwestgate/easy-pgs/backend/data"
+    "github.com/adamwestgate/easy-pgs/backend/pipeline"
+    "github.com/adamwestgate/easy-pgs/backend/store"
+)
+
+// VariantScore is one score weighting a looked-up variant.
+type VariantScore struct {
+    store.IndexedVariant
+    Trait string `json:"trait"`
+}
+
+// VariantScoresHandler handles GET /variants/{id}/scores.
+// id is an rsID (rs429358) or a GRCh37 position (19:45411941).
+// It returns every downloaded score that weights the variant.
+func VariantScoresHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusOK)
+        return
+    }
+    if scoreIndex == nil {
+        http.Error(w, "server mis-config: scoreIndex not set", http.StatusInternalServerError)
+        return
+    }
+
+    id := mux.Vars(r)["id"]
+    var (
+        hits []store.IndexedVariant
+        err  error
+    )
+    if chr, posStr, ok := strings.Cut(id, ":"); ok {
+        pos, perr := strconv.Atoi(posStr)
+        if perr != nil {
+            http.Error(w, "invalid position", http.StatusBadRequest)
+            return
+        }
+        hits, err = scoreIndex.ByPosition(strings.TrimPrefix(chr, "chr"), pos)
+    } else {
+        hits, err = scoreIndex.ByRSID(id)
+    }
+    if err != nil {
+        http.Error(w, "index error: "+err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    writeJSON(w, map[string]interface{}{
+        "variant": id,
+        "scores":  withTraits(hits),
+    })
+}
+
+// GeneScoresHandler handles GET /genes/{name}/scores[?flank=<bp>].
+// It resolves the gene through the local annotation table and returns every
+// downloaded score weight for variants inside the gene (± flank bases).
+func GeneScoresHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusOK)
+        return
+    }
+    if scoreIndex == nil {
+        http.Error(w, "server mis-config: scoreIndex not set", http.StatusInternalServerError)
+        return
+    }
+
+    gene, ok := data.GeneByName(mux.Vars(r)["name"])
+    if !ok {
+        http.Error(w, "gene not found", http.StatusNotFound)
+        return
+    }
+    flank, _ := strconv.Atoi(r.URL.Query().Get("flank"))
+    start := gene.Start - flank
+    if start < 1 {
+        start = 1
+    }
+
+    hits, err := scoreIndex.InRange(gene.Chr, start, gene.End+flank)
+    if err != nil {
+        http.Error(w, "index error: "+err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    perScore := map[string]int{}
+    for _, h := range hits {
+        perScore[h.PGSID]++
+    }
+    writeJSON(w, map[string]interface{}{
+        "gene":     gene,
+        "flank":    flank,
+        "scores":   withTraits(hits),
+        "perScore": perScore,
+    })
+}
+
+// withTraits attaches trait labels and sorts hits by position, then PGS ID.
+func withTraits(hits []store.IndexedVariant) []VariantScore {
+    out := make([]VariantScore, 0, len(hits))
+    for _, h := range hits {
+        out = append(out, VariantScore{IndexedVariant: h, Trait: pipeline.TraitLabel(h.PGSID)})
+    }
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].Pos != out[j].Pos {
+            return out[i].Pos < out[j].Pos
+        }
+        return out[i].PGSID < out[j].PGSID
+    })
+    return out
+}
+
+// IndexDownloadedScores adds every already-downloaded scoring file that is
+// not in the score index yet. Intended to run once at startup, in the background.
+// Each file goes through pipeline.NormalizeScoreFile, so the backfill shares
+// the per-PGS normalization flight with scoring jobs and never interleaves
+// its index writes with theirs.
+func IndexDownloadedScores() {
+    if scoreIndex == nil {
+        return
+    }
+    entries, err := os.ReadDir(config.PGSDownloadDir)
+    if err != nil {
+        return
+    }
+    for _, e := range entries {
+        pgsID := e.Name()
+        if !e.IsDir() || scoreIndex.IsIndexed(pgsID) {
+            continue
+        }
+        gzPath := pipeline.ScoreFileGz(pgsID)
+        if gzPath == "" {
+            gzs, _ := filepath.Glob(filepath.Join(config.PGSDownloadDir, pgsID, "*.txt.gz"))
+            if len(gzs) == 0 {
+                continue
+            }
+            gzPath = gzs[0]
+        }
+        if _, err := pipeline.NormalizeScoreFile(context.Background(), pgsID, gzPath, nil); err != nil {
+            log.Printf("IndexDownloadedScores: %s: %v", pgsID, err)
+        }
+    }
+}
@@ -17,13 +17,30 @@ func main() {
     if err := data.LoadMetadata(); err != nil {
         log.Fatalf("could not load scores metadata: %v", err)
     }
+    // Trait hierarchy is optional; without it traits are browsed flat
+    if err := data.LoadOntology(); err != nil {
+        log.Printf("ontology hierarchy unavailable: %v", err)
+    }
+    // Publications/performance metrics only enrich GET /scores/{pgsId}
+    if err := data.LoadPublications(); err != nil {
+        log.Printf("publication metadata unavailable: %v", err)
+    }
+
+    // Gene table is optional; only used by GET /genes/{name}/scores
+    if err := data.LoadGenes(); err != nil {
+        log.Printf("gene annotation unavailable: %v", err)
+    }
 
-    // 2) Initialise the kit‑mapping store (embedded BoltDB)
-    kitStore, err := boltstore.Open(config.DataDir)
+    // 2) Initialise the kit‑mapping store, score index and results (embedded BoltDB)
+    db, err := boltstore.Open(config.DataDir)
     if err != nil {
         log.Fatalf("could not open kit store: %v", err)
     }
-    handlers.SetKitStore(kitStore)
+    handlers.SetKitStore(db)
+    handlers.SetScoreIndex(db)
+    handlers.SetResultStore(db)
+    go handlers.IndexDownloadedScores()
+    handlers.StartJobWorkers()
 
     // 3) Build HTTP router and start server
     router := NewRouter()
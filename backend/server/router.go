@@ -14,10 +14,10 @@ import (
 func NewRouter() http.Handler {
     r := mux.NewRouter().StrictSlash(true)
 
-    // Allow GET for search/status and POST for downloads
+    // Allow GET for search/status, POST for downloads and DELETE for kits
     corsOpts := handlers.CORS(
         handlers.AllowedOrigins([]string{config.FrontendOrigin}),
-        handlers.AllowedMethods([]string{"GET", "POST", "OPTIONS"}),
+        handlers.AllowedMethods([]string{"GET", "POST", "DELETE", "OPTIONS"}),
         handlers.AllowedHeaders([]string{"Content-Type"}),
         handlers.AllowCredentials(),
     )
@@ -26,11 +26,40 @@ func NewRouter() http.Handler {
     r.HandleFunc("/search", apihandlers.SearchHandler).
         Methods("GET", "OPTIONS")
 
+    // trait detail with ontology ancestors/children and rolled-up PGS counts
+    r.HandleFunc("/traits/{id}", apihandlers.TraitHandler).
+        Methods("GET", "OPTIONS")
+
+    // single score detail: metadata, publication, performance and predicted chip coverage
+    r.HandleFunc("/scores/{pgsId}", apihandlers.ScoreDetailHandler).
+        Methods("GET", "OPTIONS")
+    // download and normalize a score's file, then return the same detail
+    r.HandleFunc("/scores/{pgsId}/fetch", apihandlers.ScoreFetchHandler).
+        Methods("POST", "OPTIONS")
+
+    // reverse index: downloaded scores weighting a variant or any variant in a gene
+    r.HandleFunc("/variants/{id}/scores", apihandlers.VariantScoresHandler).
+        Methods("GET", "OPTIONS")
+    r.HandleFunc("/genes/{name}/scores", apihandlers.GeneScoresHandler).
+        Methods("GET", "OPTIONS")
+
     // bulk-download endpoint (expects JSON { pgsIds: [...] })
     r.HandleFunc("/download", apihandlers.DownloadHandler).
         Methods("POST", "OPTIONS")
 
-    // status endpoint returns current server stage
+    // asynchronous scoring jobs: POST returns a job ID, GET reports its progress
+    r.HandleFunc("/jobs", apihandlers.CreateJobHandler).
+        Methods("POST", "OPTIONS")
+    r.HandleFunc("/jobs/{id}", apihandlers.JobHandler).
+        Methods("GET", "OPTIONS")
+    r.HandleFunc("/jobs/{id}/cancel", apihandlers.CancelJobHandler).
+        Methods("POST", "OPTIONS")
+
+    // Server-Sent Events stream of fine-grained pipeline progress (optionally ?jobId=)
+    r.HandleFunc("/events", apihandlers.EventsHandler).
+        Methods("GET", "OPTIONS")
+
+    // status endpoint returns the stage of a kit's latest (?kitId=) or a given (?jobId=) job
     r.HandleFunc("/status", apihandlers.StatusHandler).
         Methods("GET", "OPTIONS")
 
@@ -38,6 +67,38 @@ func NewRouter() http.Handler {
     r.HandleFunc("/upload-kit", apihandlers.UploadKitHandler).
         Methods("POST", "OPTIONS")
 
+    // delete a kit together with its results and processed files
+    r.HandleFunc("/kits/{id}", apihandlers.DeleteKitHandler).
+        Methods("DELETE", "OPTIONS")
+
+    // inferred genetic ancestry of a kit
+    r.HandleFunc("/kits/{id}/ancestry", apihandlers.KitAncestryHandler).
+        Methods("GET", "OPTIONS")
+
+    // scoring history of a kit and the differences between two runs
+    r.HandleFunc("/kits/{id}/runs", apihandlers.RunsHandler).
+        Methods("GET", "OPTIONS")
+    r.HandleFunc("/kits/{id}/runs/{a}/diff/{b}", apihandlers.RunDiffHandler).
+        Methods("GET", "OPTIONS")
+
+    // printable report of a kit's latest results (HTML, or ?format=pdf)
+    r.HandleFunc("/kits/{id}/report", apihandlers.ReportHandler).
+        Methods("GET", "OPTIONS")
+
+    // FHIR R4 bundle of a kit's latest results for personal health records
+    r.HandleFunc("/kits/{id}/fhir", apihandlers.FHIRHandler).
+        Methods("GET", "OPTIONS")
+
+    // spreadsheet (summary + per-PGS variant sheets) and CSV exports of a kit's latest results
+    r.HandleFunc("/kits/{id}/results.xlsx", apihandlers.ResultsXLSXHandler).
+        Methods("GET", "OPTIONS")
+    r.HandleFunc("/kits/{id}/results.csv", apihandlers.ResultsCSVHandler).
+        Methods("GET", "OPTIONS")
+
+    // compare several kits on the same scores over their shared variants
+    r.HandleFunc("/compare", apihandlers.CompareHandler).
+        Methods("POST", "OPTIONS")
+
     // retrieve results for results page
     r.HandleFunc("/results", apihandlers.ResultsHandler).
         Methods("GET",  "OPTIONS")
@@ -4,6 +4,7 @@ package boltstore
 
 import (
     "encoding/json"
+    "fmt"
     "path/filepath"
 
     "go.etcd.io/bbolt"
@@ -16,20 +17,35 @@ import (
 type kitRecord struct {
     Path string `json:"path"`
     Type string `json:"type"` // e.g. "ancestry" or "23andme"
+
+    Ancestry *store.Ancestry `json:"ancestry,omitempty"`
 }
 
 // Store is a Bolt-backed KitStore.
 type Store struct{ db *bbolt.DB }
 
+// Compile-time checks that Store satisfies the store interfaces.
+var (
+    _ store.KitStore   = (*Store)(nil)
+    _ store.ScoreIndex  = (*Store)(nil)
+    _ store.ResultStore = (*Store)(nil)
+)
+
 // Open opens (or creates) backend/data/kits.db and ensures the “kits” bucket.
-func Open(dir string) (store.KitStore, error) {
+// The returned Store implements every interface in the store package, since
+// a bbolt file can only be opened once per process.
+func Open(dir string) (*Store, error) {
     db, err := bbolt.Open(filepath.Join(dir, config.BoltDBName), 0o600, nil)
     if err != nil {
         return nil, err
     }
     if err := db.Update(func(tx *bbolt.Tx) error {
-        _, e := tx.CreateBucketIfNotExists([]byte(config.BoltBucketName))
-        return e
+        for _, name := range []string{config.BoltBucketName, config.BoltScoreIndexBucket, config.BoltResultsBucket, config.BoltRunsBucket} {
+            if _, e := tx.CreateBucketIfNotExists([]byte(name)); e != nil {
+                return e
+            }
+        }
+        return nil
     }); err != nil {
         return nil, err
     }
@@ -65,9 +81,49 @@ func (s *Store) Lookup(id string) (string, string, bool) {
     return rec.Path, rec.Type, true
 }
 
-// Delete removes the record for this kitID.
+// SetAncestry adds a to the kit record of id.
+func (s *Store) SetAncestry(id string, a store.Ancestry) error {
+    return s.db.Update(func(tx *bbolt.Tx) error {
+        b := tx.Bucket([]byte(config.BoltBucketName))
+        data := b.Get([]byte(id))
+        if data == nil {
+            return fmt.Errorf("kit %s not found", id)
+        }
+        var rec kitRecord
+        if err := json.Unmarshal(data, &rec); err != nil {
+            return err
+        }
+        rec.Ancestry = &a
+        data, err := json.Marshal(rec)
+        if err != nil {
+            return err
+        }
+        return b.Put([]byte(id), data)
+    })
+}
+
+// Ancestry returns the ancestry recorded for id, if any.
+func (s *Store) Ancestry(id string) (store.Ancestry, bool) {
+    var rec kitRecord
+    ok := false
+    _ = s.db.View(func(tx *bbolt.Tx) error {
+        if data := tx.Bucket([]byte(config.BoltBucketName)).Get([]byte(id)); data != nil {
+            ok = json.Unmarshal(data, &rec) == nil && rec.Ancestry != nil
+        }
+        return nil
+    })
+    if !ok {
+        return store.Ancestry{}, false
+    }
+    return *rec.Ancestry, true
+}
+
+// Delete removes the record for this kitID together with its results and runs.
 func (s *Store) Delete(id string) error {
     return s.db.Update(func(tx *bbolt.Tx) error {
+        if err := deleteResults(tx, id); err != nil {
+            return err
+        }
         return tx.Bucket([]byte(config.BoltBucketName)).Delete([]byte(id))
     })
 }
@@ -0,0 +1,291 @@
+package boltstore
+
+import (
+    "fmt"
+    "reflect"
+    "sort"
+    "testing"
+    "time"
+
+    "github.com/adamwestgate/easy-pgs/backend/store"
+)
+
+// openTestStore opens a store on a fresh bbolt file in a temp directory.
+func openTestStore(t *testing.T) *Store {
+    t.Helper()
+    s, err := Open(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { s.db.Close() })
+    return s
+}
+
+func TestKitRoundTrip(t *testing.T) {
+    s := openTestStore(t)
+
+    if _, _, ok := s.Lookup("kit1"); ok {
+        t.Fatal("empty store has kit1")
+    }
+    if err := s.Insert("kit1", "/data/kit1/kit", "23andme"); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.Insert("kit2", "/data/kit2/kit", "ancestry"); err != nil {
+        t.Fatal(err)
+    }
+    path, kitType, ok := s.Lookup("kit1")
+    if !ok || path != "/data/kit1/kit" || kitType != "23andme" {
+        t.Fatalf("Lookup(kit1) = %q, %q, %v", path, kitType, ok)
+    }
+
+    if _, ok := s.Ancestry("kit1"); ok {
+        t.Error("ancestry before SetAncestry")
+    }
+    a := store.Ancestry{
+        SuperPop:      "EUR",
+        Probabilities: map[string]float64{"EUR": 0.9, "AMR": 0.1},
+        PCs:           []float64{0.01, -0.02},
+        Variants:      950,
+        Loadings:      1000,
+    }
+    if err := s.SetAncestry("kit1", a); err != nil {
+        t.Fatal(err)
+    }
+    if got, ok := s.Ancestry("kit1"); !ok || !reflect.DeepEqual(got, a) {
+        t.Errorf("Ancestry(kit1) = %+v, %v", got, ok)
+    }
+    // the ancestry is added to the record, not in place of it
+    if path, _, ok := s.Lookup("kit1"); !ok || path != "/data/kit1/kit" {
+        t.Errorf("Lookup after SetAncestry = %q, %v", path, ok)
+    }
+    if err := s.SetAncestry("missing", a); err == nil {
+        t.Error("SetAncestry on a missing kit succeeded")
+    }
+
+    if err := s.Delete("kit1"); err != nil {
+        t.Fatal(err)
+    }
+    if _, _, ok := s.Lookup("kit1"); ok {
+        t.Error("kit1 still there after Delete")
+    }
+    if _, ok := s.Ancestry("kit1"); ok {
+        t.Error("kit1 ancestry still there after Delete")
+    }
+    if _, _, ok := s.Lookup("kit2"); !ok {
+        t.Error("Delete(kit1) removed kit2")
+    }
+}
+
+// testRun returns a run of kitID created at minute min.
+func testRun(kitID, runID string, min int) store.ResultRecord {
+    return store.ResultRecord{
+        RunID:   runID,
+        KitID:   kitID,
+        PGSIDs:  []string{"PGS000001"},
+        Created: time.Date(2024, 1, 1, 0, min, 0, 0, time.UTC),
+        Inputs: store.RunInputs{
+            ScoreFiles: map[string]string{"PGS000001": "abc123"},
+            Panel:      "panel-1",
+            Imputation: "reference",
+        },
+        Results: store.Results{
+            User: map[string]float64{"PGS000001": float64(min)},
+            Z:    map[string]float64{"PGS000001": 0.5},
+        },
+        UserStats: map[string]store.ScoreStats{"PGS000001": {Mean: 1, SD: 0.5}},
+    }
+}
+
+func runIDs(runs []store.ResultRecord) []string {
+    ids := make([]string, len(runs))
+    for i, r := range runs {
+        ids[i] = r.RunID
+    }
+    return ids
+}
+
+func TestResultsRoundTrip(t *testing.T) {
+    s := openTestStore(t)
+
+    if _, ok, err := s.LoadResults("a"); ok || err != nil {
+        t.Fatalf("LoadResults on empty store = %v, %v", ok, err)
+    }
+    // saved out of creation order; "ab" shares a prefix with "a"
+    for _, rec := range []store.ResultRecord{
+        testRun("a", "r2", 2),
+        testRun("a", "r1", 1),
+        testRun("ab", "r3", 3),
+        testRun("a", "r4", 4),
+    } {
+        if err := s.SaveResults(rec); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    latest, ok, err := s.LoadResults("a")
+    if err != nil || !ok {
+        t.Fatalf("LoadResults(a) = %v, %v", ok, err)
+    }
+    if want := testRun("a", "r4", 4); !reflect.DeepEqual(latest, want) {
+        t.Errorf("LoadResults(a) = %+v, want %+v", latest, want)
+    }
+
+    runs, err := s.ListRuns("a")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got, want := runIDs(runs), []string{"r1", "r2", "r4"}; !reflect.DeepEqual(got, want) {
+        t.Errorf("ListRuns(a) = %v, want %v", got, want)
+    }
+    if runs, _ := s.ListRuns("ab"); !reflect.DeepEqual(runIDs(runs), []string{"r3"}) {
+        t.Errorf("ListRuns(ab) = %v", runIDs(runs))
+    }
+
+    run, ok, err := s.LoadRun("a", "r2")
+    if err != nil || !ok || !reflect.DeepEqual(run, testRun("a", "r2", 2)) {
+        t.Errorf("LoadRun(a, r2) = %+v, %v, %v", run, ok, err)
+    }
+    if _, ok, _ := s.LoadRun("ab", "r2"); ok {
+        t.Error("LoadRun found a run under the wrong kit")
+    }
+
+    if err := s.DeleteResults("a"); err != nil {
+        t.Fatal(err)
+    }
+    if _, ok, _ := s.LoadResults("a"); ok {
+        t.Error("latest results of a survived DeleteResults")
+    }
+    if runs, _ := s.ListRuns("a"); len(runs) != 0 {
+        t.Errorf("runs of a survived DeleteResults: %v", runIDs(runs))
+    }
+    if _, ok, _ := s.LoadResults("ab"); !ok {
+        t.Error("DeleteResults(a) removed the results of ab")
+    }
+    if runs, _ := s.ListRuns("ab"); len(runs) != 1 {
+        t.Errorf("DeleteResults(a) touched the runs of ab: %v", runIDs(runs))
+    }
+}
+
+func TestDeleteKitCascades(t *testing.T) {
+    s := openTestStore(t)
+
+    for _, id := range []string{"a", "b"} {
+        if err := s.Insert(id, "/data/"+id, "23andme"); err != nil {
+            t.Fatal(err)
+        }
+        for i := 1; i <= 3; i++ {
+            if err := s.SaveResults(testRun(id, fmt.Sprintf("%s-r%d", id, i), i)); err != nil {
+                t.Fatal(err)
+            }
+        }
+    }
+
+    if err := s.Delete("a"); err != nil {
+        t.Fatal(err)
+    }
+    if _, ok, _ := s.LoadResults("a"); ok {
+        t.Error("latest results of a survived Delete")
+    }
+    if runs, _ := s.ListRuns("a"); len(runs) != 0 {
+        t.Errorf("runs of a survived Delete: %v", runIDs(runs))
+    }
+    if _, ok, _ := s.LoadRun("a", "a-r1"); ok {
+        t.Error("LoadRun(a, a-r1) after Delete")
+    }
+
+    if runs, _ := s.ListRuns("b"); !reflect.DeepEqual(runIDs(runs), []string{"b-r1", "b-r2", "b-r3"}) {
+        t.Errorf("Delete(a) touched the runs of b: %v", runIDs(runs))
+    }
+}
+
+// weights returns the "pgsID:weight" of each variant, sorted, for comparison.
+func weights(vs []store.IndexedVariant) []string {
+    out := make([]string, len(vs))
+    for i, v := range vs {
+        out[i] = fmt.Sprintf("%s:%g", v.PGSID, v.Weight)
+    }
+    sort.Strings(out)
+    return out
+}
+
+func TestScoreIndexRoundTrip(t *testing.T) {
+    s := openTestStore(t)
+
+    if s.IsIndexed("PGS000001") {
+        t.Fatal("empty index has PGS000001")
+    }
+    if err := s.IndexScore("PGS000001", "stamp-1", []store.IndexedVariant{
+        {RSID: "rs1", Chr: "1", Pos: 100, EffectAllele: "A", Weight: 0.1},
+        // a second allele at the same site must not overwrite the first
+        {RSID: "rs1", Chr: "1", Pos: 100, EffectAllele: "G", Weight: 0.2},
+        {RSID: "rs2", Chr: "1", Pos: 200, EffectAllele: "C", Weight: 0.3},
+        {RSID: ".", Chr: "10", Pos: 150, EffectAllele: "T", Weight: 0.4},
+        {RSID: "rs5", EffectAllele: "A", Weight: 0.5}, // no position
+    }); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.IndexScore("PGS000002", "stamp-2", []store.IndexedVariant{
+        {RSID: "rs1", Chr: "1", Pos: 100, EffectAllele: "A", Weight: 1.1},
+    }); err != nil {
+        t.Fatal(err)
+    }
+
+    if stamp, ok := s.IndexStamp("PGS000001"); !ok || stamp != "stamp-1" {
+        t.Errorf("IndexStamp(PGS000001) = %q, %v", stamp, ok)
+    }
+    for _, tc := range []struct {
+        name string
+        get  func() ([]store.IndexedVariant, error)
+        want []string
+    }{
+        {"ByRSID(rs1)", func() ([]store.IndexedVariant, error) { return s.ByRSID("rs1") },
+            []string{"PGS000001:0.1", "PGS000001:0.2", "PGS000002:1.1"}},
+        {"ByRSID(rs5)", func() ([]store.IndexedVariant, error) { return s.ByRSID("rs5") },
+            []string{"PGS000001:0.5"}},
+        {"ByRSID(.)", func() ([]store.IndexedVariant, error) { return s.ByRSID(".") }, []string{}},
+        {"ByPosition(1:100)", func() ([]store.IndexedVariant, error) { return s.ByPosition("1", 100) },
+            []string{"PGS000001:0.1", "PGS000001:0.2", "PGS000002:1.1"}},
+        {"ByPosition(10:150)", func() ([]store.IndexedVariant, error) { return s.ByPosition("10", 150) },
+            []string{"PGS000001:0.4"}},
+        // chromosome 10 sorts next to 1 but is not part of its range
+        {"InRange(1:100-200)", func() ([]store.IndexedVariant, error) { return s.InRange("1", 100, 200) },
+            []string{"PGS000001:0.1", "PGS000001:0.2", "PGS000001:0.3", "PGS000002:1.1"}},
+        {"InRange(1:101-199)", func() ([]store.IndexedVariant, error) { return s.InRange("1", 101, 199) }, []string{}},
+    } {
+        got, err := tc.get()
+        if err != nil {
+            t.Fatalf("%s: %v", tc.name, err)
+        }
+        if w := weights(got); !reflect.DeepEqual(w, tc.want) {
+            t.Errorf("%s = %v, want %v", tc.name, w, tc.want)
+        }
+    }
+
+    // re-indexing replaces every earlier row of the score, across batches
+    many := make([]store.IndexedVariant, 2*indexBatchSize+5)
+    for i := range many {
+        many[i] = store.IndexedVariant{Chr: "2", Pos: i + 1, EffectAllele: "A", Weight: 1}
+    }
+    if err := s.IndexScore("PGS000001", "stamp-3", many); err != nil {
+        t.Fatal(err)
+    }
+    if got, _ := s.InRange("2", 1, len(many)); len(got) != len(many) {
+        t.Errorf("InRange(2) after re-index = %d rows, want %d", len(got), len(many))
+    }
+    if got, _ := s.ByRSID("rs1"); !reflect.DeepEqual(weights(got), []string{"PGS000002:1.1"}) {
+        t.Errorf("ByRSID(rs1) after re-index = %v", weights(got))
+    }
+    if got, _ := s.InRange("1", 0, 1000); !reflect.DeepEqual(weights(got), []string{"PGS000002:1.1"}) {
+        t.Errorf("InRange(1) after re-index = %v", weights(got))
+    }
+    if stamp, _ := s.IndexStamp("PGS000001"); stamp != "stamp-3" {
+        t.Errorf("IndexStamp after re-index = %q", stamp)
+    }
+
+    if err := s.IndexScore("PGS000001", "stamp-4", many[:3]); err != nil {
+        t.Fatal(err)
+    }
+    if got, _ := s.InRange("2", 1, len(many)); len(got) != 3 {
+        t.Errorf("InRange(2) after shrinking re-index = %d rows, want 3", len(got))
+    }
+}
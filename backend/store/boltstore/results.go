@@ -0,0 +1,112 @@
+// backend/store/boltstore/results.go
+package boltstore
+
+import (
+    "bytes"
+    "encoding/json"
+    "sort"
+
+    "go.etcd.io/bbolt"
+
+    "github.com/adamwestgate/easy-pgs/backend/config"
+    "github.com/adamwestgate/easy-pgs/backend/store"
+)
+
+// Layout:
+//
+//  results/<kitID>            → latest ResultRecord
+//  runs/<kitID>\x00<runID>    → every ResultRecord, never overwritten
+
+func runKey(kitID, runID string) []byte {
+    return []byte(kitID + "\x00" + runID)
+}
+
+// SaveResults stores rec as the latest results of rec.KitID and appends it
+// to the kit's run history in one transaction.
+func (s *Store) SaveResults(rec store.ResultRecord) error {
+    data, err := json.Marshal(rec)
+    if err != nil {
+        return err
+    }
+    return s.db.Update(func(tx *bbolt.Tx) error {
+        if err := tx.Bucket([]byte(config.BoltResultsBucket)).Put([]byte(rec.KitID), data); err != nil {
+            return err
+        }
+        return tx.Bucket([]byte(config.BoltRunsBucket)).Put(runKey(rec.KitID, rec.RunID), data)
+    })
+}
+
+// LoadResults returns the latest record for kitID, if any.
+func (s *Store) LoadResults(kitID string) (store.ResultRecord, bool, error) {
+    return s.get(config.BoltResultsBucket, []byte(kitID))
+}
+
+// LoadRun returns one run of kitID.
+func (s *Store) LoadRun(kitID, runID string) (store.ResultRecord, bool, error) {
+    return s.get(config.BoltRunsBucket, runKey(kitID, runID))
+}
+
+// ListRuns returns every run of kitID, oldest first.
+func (s *Store) ListRuns(kitID string) ([]store.ResultRecord, error) {
+    var runs []store.ResultRecord
+    err := s.db.View(func(tx *bbolt.Tx) error {
+        prefix := runKey(kitID, "")
+        c := tx.Bucket([]byte(config.BoltRunsBucket)).Cursor()
+        for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+            var rec store.ResultRecord
+            if err := json.Unmarshal(v, &rec); err != nil {
+                return err
+            }
+            runs = append(runs, rec)
+        }
+        return nil
+    })
+    sort.Slice(runs, func(i, j int) bool { return runs[i].Created.Before(runs[j].Created) })
+    return runs, err
+}
+
+// DeleteResults removes the latest record and all runs of kitID.
+func (s *Store) DeleteResults(kitID string) error {
+    return s.db.Update(func(tx *bbolt.Tx) error {
+        return deleteResults(tx, kitID)
+    })
+}
+
+// deleteResults is DeleteResults inside an existing write transaction.
+func deleteResults(tx *bbolt.Tx, kitID string) error {
+    if err := tx.Bucket([]byte(config.BoltResultsBucket)).Delete([]byte(kitID)); err != nil {
+        return err
+    }
+    prefix := runKey(kitID, "")
+    runs := tx.Bucket([]byte(config.BoltRunsBucket))
+    var keys [][]byte
+    c := runs.Cursor()
+    for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+        keys = append(keys, append([]byte(nil), k...))
+    }
+    for _, k := range keys {
+        if err := runs.Delete(k); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// get decodes the record stored at key in bucket.
+func (s *Store) get(bucket string, key []byte) (store.ResultRecord, bool, error) {
+    var rec store.ResultRecord
+    var data []byte
+    _ = s.db.View(func(tx *bbolt.Tx) error {
+        if v := tx.Bucket([]byte(bucket)).Get(key); v != nil {
+            data = append([]byte(nil), v...)
+        }
+        return nil
+    })
+    if data == nil {
+        return rec, false, nil
+    }
+    if err := json.Unmarshal(data, &rec); err != nil {
+        return rec, false, err
+    }
+    return rec, true, nil
+}
@@ -0,0 +1,262 @@
+// backend/store/boltstore/scoreindex.go
+package boltstore
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+
+    "go.etcd.io/bbolt"
+
+    "github.com/adamwestgate/easy-pgs/backend/config"
+    "github.com/adamwestgate/easy-pgs/backend/store"
+)
+
+// Layout of the score index bucket:
+//
+//  pos/<chr>:<pos %010d>\x00<pgsID>\x00<row %08d>  → IndexedVariant
+//  rsid/<rsID>\x00<pgsID>\x00<row %08d>            → IndexedVariant
+//  pgs/<pgsID>/<key>                               → "p" or "r" (which bucket key lives in)
+//  stamp/<pgsID>                                   → stamp of the indexed source file
+//
+// Zero-padded positions keep one chromosome's keys in positional order so
+// range lookups are a single cursor walk. The row number keeps multi-allelic
+// rows of one score at the same site or rsID from overwriting each other.
+// A score counts as indexed once its stamp is written, after all its rows.
+var (
+    posBucket   = []byte("pos")
+    rsidBucket  = []byte("rsid")
+    pgsBucket   = []byte("pgs")
+    stampBucket = []byte("stamp")
+)
+
+// indexBatchSize is the number of score rows written or removed per
+// transaction, so large scores do not build one huge transaction.
+const indexBatchSize = 10000
+
+func posKey(chr string, pos int) []byte {
+    return []byte(fmt.Sprintf("%s:%010d", chr, pos))
+}
+
+// indexBuckets returns the sub-buckets, creating them in write transactions.
+func indexBuckets(tx *bbolt.Tx) (pos, rsid, pgs, stamp *bbolt.Bucket, err error) {
+    root := tx.Bucket([]byte(config.BoltScoreIndexBucket))
+    if !tx.Writable() {
+        return root.Bucket(posBucket), root.Bucket(rsidBucket), root.Bucket(pgsBucket), root.Bucket(stampBucket), nil
+    }
+    if pos, err = root.CreateBucketIfNotExists(posBucket); err != nil {
+        return
+    }
+    if rsid, err = root.CreateBucketIfNotExists(rsidBucket); err != nil {
+        return
+    }
+    if pgs, err = root.CreateBucketIfNotExists(pgsBucket); err != nil {
+        return
+    }
+    stamp, err = root.CreateBucketIfNotExists(stampBucket)
+    return
+}
+
+// IndexScore replaces all indexed variants of pgsID with variants, recording
+// stamp as the version of the source they came from. Rows are written in
+// batches; the score reads as not indexed until the last batch is done.
+func (s *Store) IndexScore(pgsID, stamp string, variants []store.IndexedVariant) error {
+    if err := s.unindexScore(pgsID); err != nil {
+        return err
+    }
+
+    for start := 0; start < len(variants); start += indexBatchSize {
+        end := start + indexBatchSize
+        if end > len(variants) {
+            end = len(variants)
+        }
+        if err := s.db.Update(func(tx *bbolt.Tx) error {
+            pos, rsid, pgs, _, err := indexBuckets(tx)
+            if err != nil {
+                return err
+            }
+            keys, err := pgs.CreateBucketIfNotExists([]byte(pgsID))
+            if err != nil {
+                return err
+            }
+            for row := start; row < end; row++ {
+                v := variants[row]
+                v.PGSID = pgsID
+                val, err := json.Marshal(v)
+                if err != nil {
+                    return err
+                }
+                suffix := []byte(fmt.Sprintf("\x00%s\x00%08d", pgsID, row))
+                if v.Chr != "" && v.Pos > 0 {
+                    k := append(posKey(v.Chr, v.Pos), suffix...)
+                    if err := pos.Put(k, val); err != nil {
+                        return err
+                    }
+                    if err := keys.Put(k, []byte("p")); err != nil {
+                        return err
+                    }
+                }
+                if v.RSID != "" && v.RSID != "." {
+                    k := append([]byte(v.RSID), suffix...)
+                    if err := rsid.Put(k, val); err != nil {
+                        return err
+                    }
+                    if err := keys.Put(k, []byte("r")); err != nil {
+                        return err
+                    }
+                }
+            }
+            return nil
+        }); err != nil {
+            return err
+        }
+    }
+
+    return s.db.Update(func(tx *bbolt.Tx) error {
+        _, _, pgs, stamps, err := indexBuckets(tx)
+        if err != nil {
+            return err
+        }
+        if _, err := pgs.CreateBucketIfNotExists([]byte(pgsID)); err != nil {
+            return err
+        }
+        return stamps.Put([]byte(pgsID), []byte(stamp))
+    })
+}
+
+// unindexScore removes the stamp and then every entry of pgsID, a batch of
+// keys per transaction.
+func (s *Store) unindexScore(pgsID string) error {
+    if err := s.db.Update(func(tx *bbolt.Tx) error {
+        _, _, _, stamps, err := indexBuckets(tx)
+        if err != nil {
+            return err
+        }
+        return stamps.Delete([]byte(pgsID))
+    }); err != nil {
+        return err
+    }
+
+    for done := false; !done; {
+        if err := s.db.Update(func(tx *bbolt.Tx) error {
+            pos, rsid, pgs, _, err := indexBuckets(tx)
+            if err != nil {
+                return err
+            }
+            old := pgs.Bucket([]byte(pgsID))
+            if old == nil {
+                done = true
+                return nil
+            }
+            var batch [][]byte
+            c := old.Cursor()
+            for k, v := c.First(); k != nil && len(batch) < indexBatchSize; k, v = c.Next() {
+                b := pos
+                if string(v) == "r" {
+                    b = rsid
+                }
+                if err := b.Delete(k); err != nil {
+                    return err
+                }
+                batch = append(batch, append([]byte(nil), k...))
+            }
+            if len(batch) == 0 {
+                done = true
+                return pgs.DeleteBucket([]byte(pgsID))
+            }
+            for _, k := range batch {
+                if err := old.Delete(k); err != nil {
+                    return err
+                }
+            }
+            return nil
+        }); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// IsIndexed reports whether pgsID has been fully indexed.
+func (s *Store) IsIndexed(pgsID string) bool {
+    _, ok := s.IndexStamp(pgsID)
+    return ok
+}
+
+// IndexStamp returns the stamp pgsID was last indexed with.
+func (s *Store) IndexStamp(pgsID string) (string, bool) {
+    var (
+        stamp string
+        found bool
+    )
+    _ = s.db.View(func(tx *bbolt.Tx) error {
+        _, _, _, stamps, _ := indexBuckets(tx)
+        if stamps == nil {
+            return nil
+        }
+        if v := stamps.Get([]byte(pgsID)); v != nil {
+            stamp, found = string(v), true
+        }
+        return nil
+    })
+    return stamp, found
+}
+
+// ByRSID returns every score weight recorded for an rsID.
+func (s *Store) ByRSID(rsid string) ([]store.IndexedVariant, error) {
+    return s.scanPrefix(func(_, r *bbolt.Bucket) *bbolt.Bucket { return r }, append([]byte(rsid), 0))
+}
+
+// ByPosition returns every score weight recorded at chr:pos.
+func (s *Store) ByPosition(chr string, pos int) ([]store.IndexedVariant, error) {
+    return s.scanPrefix(func(p, _ *bbolt.Bucket) *bbolt.Bucket { return p }, append(posKey(chr, pos), 0))
+}
+
+// InRange returns every score weight for positioned variants on chr within [start, end].
+func (s *Store) InRange(chr string, start, end int) ([]store.IndexedVariant, error) {
+    var out []store.IndexedVariant
+    err := s.db.View(func(tx *bbolt.Tx) error {
+        pos, _, _, _, _ := indexBuckets(tx)
+        if pos == nil {
+            return nil
+        }
+        stop := append(posKey(chr, end), 0xff)
+        c := pos.Cursor()
+        for k, v := c.Seek(posKey(chr, start)); k != nil && bytes.Compare(k, stop) <= 0; k, v = c.Next() {
+            var iv store.IndexedVariant
+            if err := json.Unmarshal(v, &iv); err != nil {
+                return err
+            }
+            out = append(out, iv)
+        }
+        return nil
+    })
+    return out, err
+}
+
+// scanPrefix collects the entries under prefix in the bucket chosen by pick.
+func (s *Store) scanPrefix(pick func(pos, rsid *bbolt.Bucket) *bbolt.Bucket, prefix []byte) ([]store.IndexedVariant, error) {
+    var out []store.IndexedVariant
+    err := s.db.View(func(tx *bbolt.Tx) error {
+        b := pick(indexBucketsRO(tx))
+        if b == nil {
+            return nil
+        }
+        c := b.Cursor()
+        for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+            var iv store.IndexedVariant
+            if err := json.Unmarshal(v, &iv); err != nil {
+                return err
+            }
+            out = append(out, iv)
+        }
+        return nil
+    })
+    return out, err
+}
+
+// indexBucketsRO returns the variant buckets, for read-only transactions.
+func indexBucketsRO(tx *bbolt.Tx) (pos, rsid *bbolt.Bucket) {
+    pos, rsid, _, _, _ = indexBuckets(tx)
+    return
+}
@@ -1,11 +1,148 @@
 package store
 
+import "time"
+
 // KitStore persists a mapping <kitID → (path, type)>.
 type KitStore interface {
     // Insert saves the processedPath and kitType for this kitID.
     Insert(id, processedPath, kitType string) error
     // Lookup returns (processedPath, kitType, true) if found.
     Lookup(id string) (processedPath, kitType string, ok bool)
-    // Delete removes the record for this kitID.
+    // Delete removes the record for this kitID, and anything else stored
+    // against it (e.g. scoring results) by the same backend.
     Delete(id string) error
+    // SetAncestry records the inferred genetic ancestry of kit id.
+    SetAncestry(id string, a Ancestry) error
+    // Ancestry returns the recorded ancestry of kit id, if any.
+    Ancestry(id string) (Ancestry, bool)
+}
+
+// Ancestry is a kit's genetic ancestry, inferred by projecting it onto the
+// principal components of its reference panel.
+type Ancestry struct {
+    SuperPop      string             `json:"superPop"`      // most probable 1000G superpopulation
+    Probabilities map[string]float64 `json:"probabilities"` // superpopulation → posterior probability
+    PCs           []float64          `json:"pcs"`
+    Variants      int                `json:"variants"` // PCA variants the kit has a call for
+    Loadings      int                `json:"loadings"` // PCA variants in the model
+}
+
+// IndexedVariant is one weight of one score, as stored in the reverse index.
+type IndexedVariant struct {
+    PGSID        string  `json:"pgsId"`
+    RSID         string  `json:"rsid,omitempty"`
+    Chr          string  `json:"chr,omitempty"`
+    Pos          int     `json:"pos,omitempty"`
+    EffectAllele string  `json:"effectAllele"`
+    Weight       float64 `json:"weight"`
+}
+
+// ScoreIndex is a reverse index from variants (rsID or chr:pos) to the
+// downloaded scores that weight them.
+type ScoreIndex interface {
+    // IndexScore replaces all indexed variants of pgsID with variants;
+    // stamp identifies the version of the source file they were read from.
+    IndexScore(pgsID, stamp string, variants []IndexedVariant) error
+    // IsIndexed reports whether pgsID has been fully indexed.
+    IsIndexed(pgsID string) bool
+    // IndexStamp returns the stamp pgsID was last indexed with.
+    IndexStamp(pgsID string) (string, bool)
+    // ByRSID returns every score weight recorded for an rsID.
+    ByRSID(rsid string) ([]IndexedVariant, error)
+    // ByPosition returns every score weight recorded at chr:pos.
+    ByPosition(chr string, pos int) ([]IndexedVariant, error)
+    // InRange returns every score weight for positioned variants on chr within [start, end].
+    InRange(chr string, start, end int) ([]IndexedVariant, error)
+}
+
+// Results is the flattened per-PGS outcome of scoring one kit, in the shape
+// served by GET /results.
+type Results struct {
+    Population    map[string]float64 `json:"population"`
+    User          map[string]float64 `json:"user"`
+    Z             map[string]float64 `json:"z"`
+    Pct           map[string]float64 `json:"pct"`
+    Trait         map[string]string  `json:"trait"`
+    PctSnpsScored map[string]float64 `json:"pct_snps_scored"`
+
+    // Expected contribution of the score variants the kit could not use, on
+    // the User scale, and their share (0-1) of the score's expected variance.
+    UnscoredMean  map[string]float64 `json:"unscored_mean,omitempty"`
+    UnscoredSD    map[string]float64 `json:"unscored_sd,omitempty"`
+    UnscoredShare map[string]float64 `json:"unscored_share,omitempty"`
+    Imputation    string             `json:"imputation,omitempty"` // see pipeline.ParseImputation; "" is "reference"
+
+    // Empirical percentiles of User among the reference samples, per PGS
+    // and comparison group ("ALL", "EUR", "EUR:female", ...; see
+    // pipeline.ComparisonGroup). Comparison is the group the reader chose.
+    Groups     map[string]map[string]GroupStats `json:"pct_by_group,omitempty"`
+    Comparison string                           `json:"comparison,omitempty"`
+
+    // Z and Pct after regressing the reference scores' mean and variance
+    // on their principal components, evaluated at the kit's projected PCs.
+    ZAdjusted   map[string]float64 `json:"z_adj,omitempty"`
+    PctAdjusted map[string]float64 `json:"pct_adj,omitempty"`
+}
+
+// GroupStats is the reference distribution of one comparison group and the
+// user's empirical percentile (0–1) in it.
+type GroupStats struct {
+    N    int     `json:"n"`
+    Mean float64 `json:"mean"`
+    SD   float64 `json:"sd"`
+    Pct  float64 `json:"pct"`
+}
+
+// ScoreStats summarises one .sscore file.
+type ScoreStats struct {
+    Mean float64 `json:"mean"`
+    SD   float64 `json:"sd"`
+    // Hist counts the samples in equal-width bins of z = (score-Mean)/SD
+    // across ±HistRange, the outermost bins also holding everything beyond.
+    // Empty for single-sample files and in runs saved before it existed.
+    Hist []int `json:"hist,omitempty"`
+}
+
+// HistRange is the span of ScoreStats.Hist, in SDs either side of the mean.
+const HistRange = 4.0
+
+// RunInputs fingerprints what a scoring run depended on, so two runs can
+// tell which input changed between them.
+type RunInputs struct {
+    ScoreFiles  map[string]string `json:"scoreFiles"` // PGS ID → SHA-256 of the downloaded scoring file
+    Panel       string            `json:"panel"`      // reference panel fingerprint
+    Manifest    string            `json:"manifest"`   // chip manifest fingerprint
+    CodeVersion string            `json:"codeVersion"`
+    Engine      string            `json:"engine"` // user/population scoring engines
+    // missing-genotype handling, see pipeline.ParseImputation; "" is "reference"
+    Imputation string `json:"imputation,omitempty"`
+}
+
+// ResultRecord is one persisted scoring run of one kit. Runs are immutable
+// once saved.
+type ResultRecord struct {
+    RunID          string                `json:"runId"`
+    KitID          string                `json:"kitId"`
+    PGSIDs         []string              `json:"pgsIds"`
+    CatalogVersion string                `json:"catalogVersion"`
+    Created        time.Time             `json:"created"`
+    Inputs         RunInputs             `json:"inputs"`
+    Results        Results               `json:"results"`
+    UserStats      map[string]ScoreStats `json:"userStats"`
+    PopStats       map[string]ScoreStats `json:"popStats"`
+}
+
+// ResultStore persists scoring results per kit: the latest run plus the
+// full history of runs.
+type ResultStore interface {
+    // SaveResults appends rec to the history of rec.KitID and makes it the latest run.
+    SaveResults(rec ResultRecord) error
+    // LoadResults returns the latest run for kitID, if any.
+    LoadResults(kitID string) (ResultRecord, bool, error)
+    // ListRuns returns every run of kitID, oldest first.
+    ListRuns(kitID string) ([]ResultRecord, error)
+    // LoadRun returns one run of kitID.
+    LoadRun(kitID, runID string) (ResultRecord, bool, error)
+    // DeleteResults removes the latest results and the history of kitID.
+    DeleteResults(kitID string) error
 }
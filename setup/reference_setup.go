@@ -25,6 +25,9 @@ import (
 	"runtime"
 	"sort"
 	"strings"
+
+	"github.com/adamwestgate/easy-pgs/backend/preprocessing/ancestry"
+	"github.com/adamwestgate/easy-pgs/backend/preprocessing/pgen"
 )
 
 // ───────────────────────────── Configurable paths ─────────────────────────────
@@ -39,6 +42,7 @@ var (
 	chipFlag = flag.String("chip", "all", "Chip to build: 23andme | ancestry | all")
 	threads  = flag.Int("threads", runtime.NumCPU(), "CPU threads for PLINK")
 	memory   = flag.Int("mem-mb", 32000, "Memory limit (MB) for PLINK")
+	pcs      = flag.Int("pcs", 10, "Principal components of the ancestry model")
 )
 
 // ────────────────────────────── main ─────────────────────────────────────────
@@ -103,6 +107,7 @@ func buildPanel(exe, prefix, rangeFile, outDir, tag string) {
 	run(exe, []string{
 		"--pfile", prefix,
 		"--extract", "range", rangeFile,
+		"--max-alleles", "2",
 		"--make-pgen",
 		"--out", tmp + "_step1",
 		"--threads", itoa(*threads),
@@ -128,8 +133,46 @@ func buildPanel(exe, prefix, rangeFile, outDir, tag string) {
 		"--memory", itoa(*memory),
 	})
 
+	// variant index read by the backend instead of the .pvar text
+	if _, err := pgen.BuildIndex(final); err != nil {
+		log.Fatalf("variant index: %v", err)
+	}
+
+	buildAncestryModel(exe, final, tmp)
+
 	cleanup(tmp + "_step1")
-	log.Printf("✔ Done: %s.[pgen|pvar|psam] (+ .afreq)", final)
+	log.Printf("✔ Done: %s.[pgen|pvar|psam] (+ .afreq, .vidx, ancestry model)", final)
+}
+
+// buildAncestryModel computes principal components on an LD-pruned set of
+// common autosomal variants of the panel and turns their allele weights into
+// the ancestry model kits are projected onto at upload.
+func buildAncestryModel(exe, final, tmp string) {
+	run(exe, []string{
+		"--pfile", final,
+		"--autosome",
+		"--maf", "0.05",
+		"--indep-pairwise", "1000kb", "1", "0.1",
+		"--out", tmp + "_prune",
+		"--threads", itoa(*threads),
+		"--memory", itoa(*memory),
+	})
+	run(exe, []string{
+		"--pfile", final,
+		"--extract", tmp + "_prune.prune.in",
+		"--pca", itoa(*pcs), "allele-wts",
+		"--out", final + ".pca",
+		"--threads", itoa(*threads),
+		"--memory", itoa(*memory),
+	})
+	m, err := ancestry.Build(final)
+	if err != nil {
+		log.Fatalf("ancestry model: %v", err)
+	}
+	for _, ext := range []string{".prune.in", ".prune.out", ".log"} {
+		os.Remove(tmp + "_prune" + ext)
+	}
+	log.Printf("✔ Ancestry model: %d PCs over %d variants, %d reference samples", m.PCs, len(m.Loadings), len(m.References))
 }
 
 // ───────────────────────────── helper functions ─────────────────────────────
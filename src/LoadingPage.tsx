@@ -11,12 +11,19 @@ const LoadingPage: React.FC = () => {
   const navigate            = useNavigate();
 
   useEffect(() => {
+    const jobId = localStorage.getItem('jobId');
+    if (!jobId) {
+      setError('No scoring job submitted yet');
+      return;
+    }
+    const url = `${ENDPOINTS.status}?jobId=${encodeURIComponent(jobId)}`;
+
     const iv = setInterval(async () => {
       try {
-        const res = await fetch(ENDPOINTS.status, { credentials: 'include' });
+        const res = await fetch(url, { credentials: 'include' });
         if (!res.ok) throw new Error(`Status API returned ${res.status}`);
 
-        const data: { stage: string } = await res.json();
+        const data: { stage: string; error?: string } = await res.json();
         setStage(data.stage);
 
         // When backend marks work “ready” → stop polling & show results page
@@ -25,10 +32,10 @@ const LoadingPage: React.FC = () => {
           navigate('/results');
         }
 
-        // Basic fail-fast handling for explicit error states
-        if (data.stage === 'error' || data.stage === 'failed') {
+        // Terminal states other than ready end the polling
+        if (data.stage === 'failed' || data.stage === 'cancelled') {
           clearInterval(iv);
-          setError(`Backend reported ${data.stage}`);
+          setError(data.error || `Scoring ${data.stage}`);
         }
       } catch (err: any) {
         clearInterval(iv);
@@ -42,17 +49,21 @@ const LoadingPage: React.FC = () => {
   if (error)        return <div className="p-4 text-red-600">Error&nbsp;• {error}</div>;
   if (!stage)       return <div className="p-4">Initializing…</div>;
 
-  // Friendly copy for the stages we know about
+  // Friendly copy for every job stage the backend reports
   const messages: Record<string, string> = {
-    downloading : 'Downloading PGS files…',
-    normalizing : 'Normalizing score files…',
-    scoring     : 'Scoring your kit…',
-    ready       : 'Wrapping up…',
+    queued             : 'Waiting for a free scoring slot…',
+    downloading        : 'Downloading PGS files…',
+    normalizing        : 'Normalizing score files…',
+    scoring_user       : 'Scoring your kit…',
+    scoring_population : 'Scoring the reference population…',
+    ready              : 'Wrapping up…',
+    failed             : 'Scoring failed.',
+    cancelled          : 'Scoring was cancelled.',
   };
 
   return (
     <div className="p-4 text-center text-lg">
-      {messages[stage] ?? `Current status: ${stage}`}
+      {messages[stage] ?? 'Working…'}
     </div>
   );
 };
@@ -3,6 +3,12 @@ import { Disclosure, Transition } from "@headlessui/react";
 import { ENDPOINTS } from "./config";
 
 type ScoreMap = Record<string, number>;
+interface GroupStats {
+  n: number;
+  mean: number;
+  sd: number;
+  pct: number;
+}
 interface ApiResponse {
   population: ScoreMap;
   user: ScoreMap;
@@ -11,6 +17,23 @@ interface ApiResponse {
   z?: ScoreMap;
   pct?: ScoreMap;
   pct_snps_scored?: ScoreMap;
+  unscored_share?: ScoreMap;
+  imputation?: string;
+  pct_by_group?: Record<string, Record<string, GroupStats>>;
+  comparison?: string;
+  z_adj?: ScoreMap;
+  pct_adj?: ScoreMap;
+}
+
+// "ALL" first, then superpopulations, each followed by its sex strata
+function sortGroups(groups: string[]): string[] {
+  const rank = (g: string) => (g.startsWith("ALL") ? "" : g);
+  return [...groups].sort((a, b) => rank(a).localeCompare(rank(b)) || a.localeCompare(b));
+}
+function groupLabel(g: string): string {
+  const [pop, sex] = g.split(":");
+  const name = pop === "ALL" ? "All 1000G" : pop;
+  return sex ? `${name}, ${sex}` : name;
 }
 
 function fmtScore(v: number): string {
@@ -29,11 +52,17 @@ function fmtCov(c: number | undefined): string {
   if (c === undefined || isNaN(c)) return "—";
   return c.toFixed(1) + "%";
 }
+function covTitle(share: number | undefined, imputation: string | undefined): string {
+  const how = `Missing genotypes: ${imputation || "reference"} imputation`;
+  if (share === undefined || isNaN(share)) return how;
+  return `${how}. Unscored variants carry ~${(share * 100).toFixed(0)}% of this score's expected variance.`;
+}
 
 export default function ResultsPage() {
   const [data, setData] = useState<ApiResponse | null>(null);
   const [error, setError] = useState("");
   const [show, setShow] = useState(false);
+  const [group, setGroup] = useState("ALL");
   const kitId = localStorage.getItem("kitId") || "";
 
   useEffect(() => {
@@ -47,7 +76,9 @@ export default function ResultsPage() {
             ENDPOINTS.results(kitId),
           );
         if (!res.ok) throw new Error(`Results API returned ${res.status}`);
-        setData((await res.json()) as ApiResponse);
+        const body = (await res.json()) as ApiResponse;
+        setData(body);
+        setGroup(body.comparison || "ALL");
         setTimeout(() => setShow(true), 50); // Fade in after short delay
       } catch (err: any) {
         setError(err.message || "Could not load results");
@@ -72,6 +103,12 @@ export default function ResultsPage() {
       </div>
     );
 
+  const groups = sortGroups(
+    Array.from(
+      new Set(Object.values(data.pct_by_group ?? {}).flatMap((g) => Object.keys(g)))
+    )
+  );
+
   const rows = Object.keys(data.population).map((rawId) => {
     const cleanId = rawId.split(".")[0];
     return {
@@ -81,8 +118,12 @@ export default function ResultsPage() {
       user: data.user[rawId] ?? NaN,
       pop: data.population[rawId] ?? NaN,
       z: data.z?.[rawId],
-      pct: data.pct?.[rawId],
+      pct: data.pct_by_group?.[rawId]?.[group]?.pct ?? data.pct?.[rawId],
+      groups: data.pct_by_group?.[rawId] ?? {},
+      zAdj: data.z_adj?.[rawId],
+      pctAdj: data.pct_adj?.[rawId],
       coverage: data.pct_snps_scored?.[rawId],
+      unscored: data.unscored_share?.[rawId],
     };
   });
 
@@ -99,6 +140,23 @@ export default function ResultsPage() {
           <h1 className="text-2xl font-serif font-medium mb-8 text-center text-gray-700 tracking-tight">
             Polygenic-Score Results
           </h1>
+          {groups.length > 0 && (
+            <div className="flex items-center justify-end gap-2 mb-4 text-sm text-gray-600">
+              <label htmlFor="comparison-group">Compare with</label>
+              <select
+                id="comparison-group"
+                value={group}
+                onChange={(e) => setGroup(e.target.value)}
+                className="rounded-lg border border-gray-200 bg-white px-2 py-1"
+              >
+                {groups.map((g) => (
+                  <option key={g} value={g}>
+                    {groupLabel(g)}
+                  </option>
+                ))}
+              </select>
+            </div>
+          )}
           <div className="overflow-x-auto">
             <table className="w-full rounded-xl overflow-hidden">
               <thead>
@@ -144,6 +202,7 @@ export default function ResultsPage() {
                             {fmtPct(r.pct)}
                           </td>
                           <td
+                            title={covTitle(r.unscored, data.imputation)}
                             className={
                               "px-4 py-3 text-center font-semibold " +
                               ((r.coverage ?? 100) < 25
@@ -196,7 +255,35 @@ export default function ResultsPage() {
                                     {fmtZ(r.z)}
                                   </div>
                                 </div>
+                                {r.zAdj !== undefined && (
+                                  <div title="z-score and percentile after adjusting the reference distribution for genetic ancestry (principal components)">
+                                    <div className="text-xs text-gray-500 font-mono mb-1">
+                                      Ancestry-adjusted
+                                    </div>
+                                    <div className="font-semibold text-gray-700">
+                                      {fmtZ(r.zAdj)} · {fmtPct(r.pctAdj)}
+                                    </div>
+                                  </div>
+                                )}
                               </div>
+                              {Object.keys(r.groups).length > 0 && (
+                                <div className="flex flex-wrap justify-center gap-2 px-6 pb-6 bg-gray-50 text-xs font-mono">
+                                  {sortGroups(Object.keys(r.groups)).map((g) => (
+                                    <span
+                                      key={g}
+                                      title={`n = ${r.groups[g].n}`}
+                                      className={
+                                        "rounded px-2 py-1 " +
+                                        (g === group
+                                          ? "bg-blue-100 text-blue-700 font-semibold"
+                                          : "bg-white text-gray-600")
+                                      }
+                                    >
+                                      {groupLabel(g)}: {fmtPct(r.groups[g].pct)}
+                                    </span>
+                                  ))}
+                                </div>
+                              )}
                             </td>
                           </Disclosure.Panel>
                         </Transition>
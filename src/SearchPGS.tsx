@@ -45,15 +45,14 @@ const SearchPage: React.FC = () => {
 const navigate = useNavigate();
 
 const handleDownload = (selectedIds: string[]) => {
-  setLoading(true);
-  navigate('/loading');   // ← this triggers your loading route!
   const kitId = localStorage.getItem('kitId');
   if (!kitId) {
     alert('No kitId in storage—please upload a kit first');
-    setLoading(false);
     return;
   }
-  fetch(ENDPOINTS.download, {
+  setLoading(true);
+  // queue the job; the loading page polls its progress by jobId
+  fetch(ENDPOINTS.jobs, {
     method: 'POST',
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify({ kitId, pgsIds: selectedIds }),
@@ -62,12 +61,14 @@ const handleDownload = (selectedIds: string[]) => {
       if (!res.ok) throw new Error(`HTTP ${res.status}`);
       return res.json();
     })
-    .then(data => {
-      // Optionally navigate again, e.g., to /results
-      // navigate('/results');
-      console.log('Download result:', data);
+    .then((data: { jobId: string }) => {
+      localStorage.setItem('jobId', data.jobId);
+      navigate('/loading');
+    })
+    .catch(err => {
+      console.error('Job submission failed:', err);
+      setError(err.message || 'Job submission failed');
     })
-    .catch(err => console.error('Download request failed:', err))
     .finally(() => setLoading(false));
 };
 
@@ -8,6 +8,7 @@ export const ENDPOINTS = {
   upload:   `${API_BASE}/upload-kit`,
   search:   (q: string) => `${API_BASE}/search?q=${encodeURIComponent(q)}`,
   status:   `${API_BASE}/status`,
+  jobs:     `${API_BASE}/jobs`,
   download: `${API_BASE}/download`,
   results:  (kitId: string) =>
     `${API_BASE}/results?kitId=${encodeURIComponent(kitId)}`,